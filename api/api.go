@@ -8,12 +8,20 @@ import (
 	"strconv"
 
 	"github.com/ONSdigital/dp-census-alpha-api-proxy/cantabular"
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/config"
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/disclosure"
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/events"
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/jobs"
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/openapi"
 	filterModel "github.com/ONSdigital/dp-filter-api/models"
 	"github.com/ONSdigital/dp-code-list-api/models"
 	"github.com/ONSdigital/log.go/log"
 	"github.com/gorilla/mux"
+	"github.com/graphql-go/graphql"
 )
 
+const staleWarning = `110 - "Response is Stale: flexible table builder unavailable"`
+
 type SimpleEntity struct {
 	Message string
 }
@@ -22,50 +30,142 @@ type SimpleEntity struct {
 type API struct {
 	Store  DataStore
 	Router *mux.Router
+	Config *config.Config
+
+	// Disclosure holds the disclosure control rules applied to query
+	// results, if any
+	Disclosure *disclosure.RuleSet
+
+	// Spec is the OpenAPI document describing the routes
+	Spec *openapi.Document
+
+	// GraphQL is the schema served at /graphql
+	GraphQL *graphql.Schema
+
+	// Jobs runs the queries posted to /v6/jobs, if they are enabled
+	Jobs *jobs.Queue
+
+	// JobQuerier makes the FTB queries of jobs, which may take far longer
+	// than those made while a client waits. Jobs use Store if it is nil.
+	JobQuerier Querier
+
+	// Events is streamed from /v6/events, if it is enabled
+	Events *events.Hub
 }
 
 type DataStore interface {
 	GetData(ctx context.Context, url string) (cantabular.Entity, error)
+	Query(ctx context.Context, dataset string, variables []string) (*cantabular.Table, error)
 	GetDatasetCodebook(ctx context.Context, dataset string) (*cantabular.Codebook, error)
+	GetDatasets(ctx context.Context) (*cantabular.Datasets, error)
+	Digests(ctx context.Context, dataset string) ([]string, error)
+	Snapshot(ctx context.Context, dataset, digest string) (*cantabular.Codebook, error)
+}
+
+// Querier runs a query against the FTB
+type Querier interface {
+	Query(ctx context.Context, dataset string, variables []string) (*cantabular.Table, error)
 }
 
 type Authenticator func(http.Handler) http.Handler
 
-func Setup(ctx context.Context, r *mux.Router, auth Authenticator, client DataStore) *API {
+func Setup(ctx context.Context, r *mux.Router, cfg *config.Config, auth Authenticator, client DataStore) *API {
 	api := &API{
 		Store:  client,
 		Router: r,
+		Config: cfg,
 	}
 
-	r.Handle("/v6/datasets/{dataset}/filter/dimensions/{name}/options", auth(api.GetFilterDimensions())).Methods(http.MethodGet)
+	r.Handle("/v6/datasets/{dataset}/filter/dimensions/{name}/options", auth(api.GetFilterDimensions())).Methods(http.MethodGet).Name("filter-options")
+
+	r.Handle("/v6/datasets/{dataset}/dimensions", auth(api.GetDatasetDimensions())).Methods(http.MethodGet).Name("dimensions")
+	r.Handle("/v6/datasets/{dataset}/dimensions/{name}", auth(api.GetDatasetDimension())).Methods(http.MethodGet).Name("dimension")
+	r.Handle("/v6/datasets/{dataset}/dimensions/{name}/codes", auth(api.GetDatasetDimensionCodes())).Methods(http.MethodGet).Name("codes")
+	r.Handle("/v6/datasets/{dataset}/dimensions/{name}/index/{index}", auth(api.GetDatasetDimensionByIndex())).Methods(http.MethodGet).Name("dimension-index")
 
-	r.Handle("/v6/datasets/{dataset}/dimensions", auth(api.GetDatasetDimensions())).Methods(http.MethodGet)
-	r.Handle("/v6/datasets/{dataset}/dimensions/{name}", auth(api.GetDatasetDimension())).Methods(http.MethodGet)
-	r.Handle("/v6/datasets/{dataset}/dimensions/{name}/codes", auth(api.GetDatasetDimensionCodes())).Methods(http.MethodGet)
-	r.Handle("/v6/datasets/{dataset}/dimensions/{name}/index/{index}", auth(api.GetDatasetDimensionByIndex())).Methods(http.MethodGet)
+	r.Handle("/v6/datasets/{dataset}/validate", auth(api.GetValidation())).Methods(http.MethodGet).Name("validate")
+	r.Handle("/v6/datasets/{dataset}/codebook/diff", auth(api.GetCodebookDiff())).Methods(http.MethodGet).Name("codebook-diff")
 
-	r.Handle("/v6/datasets/{dataset}/hierarchies/{name}", auth(api.GetHierarchy())).Methods(http.MethodGet)
-	r.Handle("/v6/datasets/{dataset}/hierarchies/{name}/full", auth(api.BuildFullHierarchy())).Methods(http.MethodGet)
-	r.Handle("/v6/datasets/{dataset}/hierarchies/{name}/code/{code}", auth(api.GetHierarchyForCode())).Methods(http.MethodGet)
+	r.Handle("/v6/datasets/{dataset}/hierarchies/{name}", auth(api.GetHierarchy())).Methods(http.MethodGet).Name("hierarchy")
+	r.Handle("/v6/datasets/{dataset}/hierarchies/{name}/full", auth(api.BuildFullHierarchy())).Methods(http.MethodGet).Name("hierarchy-full")
+	r.Handle("/v6/datasets/{dataset}/hierarchies/{name}/code/{code}", auth(api.GetHierarchyForCode())).Methods(http.MethodGet).Name("hierarchy-code")
+	r.Handle("/v6/datasets/{dataset}/hierarchies/{name}/code/{code}/parents", auth(api.GetHierarchyParents())).Methods(http.MethodGet).Name("hierarchy-parents")
 
 	r.PathPrefix("/v6/datasets").Handler(auth(api.Handler())).Methods(http.MethodGet)
 	r.PathPrefix("/v6/datasets").HandlerFunc(api.preflightRequestHandler).Methods(http.MethodOptions)
 
+	r.Handle("/v6/codebook/{dataset}", auth(api.GetCodebook())).Methods(http.MethodGet).Name("codebook")
 	r.PathPrefix("/v6/codebook").Handler(auth(api.Handler())).Methods(http.MethodGet)
 	r.PathPrefix("/v6/codebook").HandlerFunc(api.preflightRequestHandler).Methods(http.MethodOptions)
 
-	r.PathPrefix("/v6/query").Handler(auth(api.Handler())).Methods(http.MethodGet)
+	r.Handle("/v6/compare", auth(api.GetComparison())).Methods(http.MethodGet).Name("compare")
+	r.HandleFunc("/v6/compare", api.preflightRequestHandler).Methods(http.MethodOptions)
+
+	r.Handle("/datasets", auth(api.GetCMDDatasets())).Methods(http.MethodGet).Name("cmd-datasets")
+	r.Handle("/datasets/{id}", auth(api.GetCMDDataset())).Methods(http.MethodGet).Name("cmd-dataset")
+	r.Handle("/datasets/{id}/editions", auth(api.GetCMDEditions())).Methods(http.MethodGet).Name("cmd-editions")
+	r.Handle("/datasets/{id}/editions/{edition}", auth(api.GetCMDEdition())).Methods(http.MethodGet).Name("cmd-edition")
+	r.Handle("/datasets/{id}/editions/{edition}/versions", auth(api.GetCMDVersions())).Methods(http.MethodGet).Name("cmd-versions")
+	r.Handle("/datasets/{id}/editions/{edition}/versions/{version}", auth(api.GetCMDVersion())).Methods(http.MethodGet).Name("cmd-version")
+	r.Handle("/datasets/{id}/editions/{edition}/versions/{version}/metadata", auth(api.GetCMDMetadata())).Methods(http.MethodGet).Name("cmd-metadata")
+	r.Handle("/datasets/{id}/editions/{edition}/versions/{version}/dimensions", auth(api.GetCMDDimensions())).Methods(http.MethodGet).Name("cmd-dimensions")
+	r.Handle("/datasets/{id}/editions/{edition}/versions/{version}/dimensions/{dimension}/options", auth(api.GetCMDOptions())).Methods(http.MethodGet).Name("cmd-options")
+	r.PathPrefix("/datasets").HandlerFunc(api.preflightRequestHandler).Methods(http.MethodOptions)
+
+	r.Handle("/code-lists", auth(api.GetCodeLists())).Methods(http.MethodGet).Name("code-lists")
+	r.Handle("/code-lists/{id}", auth(api.GetCodeList())).Methods(http.MethodGet).Name("code-list")
+	r.Handle("/code-lists/{id}/editions", auth(api.GetCodeListEditions())).Methods(http.MethodGet).Name("code-list-editions")
+	r.Handle("/code-lists/{id}/editions/{edition}", auth(api.GetCodeListEdition())).Methods(http.MethodGet).Name("code-list-edition")
+	r.Handle("/code-lists/{id}/editions/{edition}/codes", auth(api.GetCodeListCodes())).Methods(http.MethodGet).Name("code-list-codes")
+	r.Handle("/code-lists/{id}/editions/{edition}/codes/{code}", auth(api.GetCodeListCode())).Methods(http.MethodGet).Name("code-list-code")
+	r.Handle("/code-lists/{id}/editions/{edition}/codes/{code}/datasets", auth(api.GetCodeDatasets())).Methods(http.MethodGet).Name("code-datasets")
+	r.PathPrefix("/code-lists").HandlerFunc(api.preflightRequestHandler).Methods(http.MethodOptions)
+
+	r.Handle("/v6/query/{dataset}", auth(api.GetQuery())).Methods(http.MethodGet).Name("query")
+	// anything else under /v6/query would reach the FTB without disclosure control
+	r.PathPrefix("/v6/query").HandlerFunc(api.notFoundHandler).Methods(http.MethodGet)
 	r.PathPrefix("/v6/query").HandlerFunc(api.preflightRequestHandler).Methods(http.MethodOptions)
+
+	r.Handle("/graphql", auth(api.GetGraphQL())).Methods(http.MethodGet, http.MethodPost).Name("graphql")
+	r.HandleFunc("/graphql", api.preflightPostHandler).Methods(http.MethodOptions)
+
+	r.Handle("/v6/batch", auth(api.PostBatch())).Methods(http.MethodPost).Name("batch")
+	r.HandleFunc("/v6/batch", api.preflightPostHandler).Methods(http.MethodOptions)
+
+	r.Handle("/v6/jobs", auth(api.PostJob())).Methods(http.MethodPost).Name("jobs")
+	r.Handle("/v6/jobs/{job}", auth(api.GetJob())).Methods(http.MethodGet).Name("job")
+	r.Handle("/v6/jobs/{job}/result", auth(api.GetJobResult())).Methods(http.MethodGet).Name("job-result")
+	r.PathPrefix("/v6/jobs").HandlerFunc(api.preflightPostHandler).Methods(http.MethodOptions)
+
+	r.Handle("/v6/events", auth(api.GetEvents())).Methods(http.MethodGet).Name("events")
+	r.HandleFunc("/v6/events", api.preflightRequestHandler).Methods(http.MethodOptions)
+
+	r.Handle("/v6/openapi.json", api.GetOpenAPI()).Methods(http.MethodGet).Name("openapi")
+	r.Handle("/v6/docs", api.GetSwaggerUI()).Methods(http.MethodGet).Name("docs")
+
+	schema, err := api.newGraphQLSchema()
+	if err != nil {
+		// the schema does not depend on the config or the data, so this is a
+		// programming error
+		panic(err)
+	}
+	api.GraphQL = &schema
+
+	api.Spec = api.buildSpec()
 	return api
 }
 
 func (api *API) preflightRequestHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", http.MethodGet)
-	w.Header().Set("Access-Control-Allow-Headers", "Authorization")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, If-None-Match, Last-Event-ID")
 	w.WriteHeader(http.StatusNoContent)
 }
 
+func (api *API) notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	WriteBody(r.Context(), w, SimpleEntity{Message: "not found"}, http.StatusNotFound)
+}
+
 func (api *API) Handler() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
@@ -82,15 +182,33 @@ func (api *API) Handler() http.Handler {
 	})
 }
 
+// GetCodebook serves the codebook of a dataset from the store, so it is
+// answered from the snapshot like the other dataset routes if the FTB is down
+func (api *API) GetCodebook() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		dataset := mux.Vars(r)["dataset"]
+
+		codebook, ok := api.getCodebook(ctx, w, dataset)
+		if !ok {
+			return
+		}
+
+		if api.notModified(w, r, codebook.Dataset.Digest) {
+			return
+		}
+
+		WriteBody(ctx, w, codebook, http.StatusOK)
+	})
+}
+
 func (api *API) GetDatasetDimensions() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		dataset := mux.Vars(r)["dataset"]
 
-		codebook, err := api.Store.GetDatasetCodebook(ctx, dataset)
-		if err != nil {
-			errEntity, status := getErrorResponse(ctx, err)
-			WriteBody(ctx, w, errEntity, status)
+		codebook, ok := api.getCodebook(ctx, w, dataset)
+		if !ok {
 			return
 		}
 
@@ -99,6 +217,10 @@ func (api *API) GetDatasetDimensions() http.Handler {
 			dims = append(dims, cb.Label+": "+cb.Name)
 		}
 
+		if api.notModified(w, r, codebook.Dataset.Digest) {
+			return
+		}
+
 		WriteBody(ctx, w, GetDimensionsResponse{Dimensions: dims}, http.StatusOK)
 	})
 }
@@ -109,10 +231,8 @@ func (api *API) GetDatasetDimension() http.Handler {
 		dataset := mux.Vars(r)["dataset"]
 		dimension := mux.Vars(r)["name"]
 
-		codebook, err := api.Store.GetDatasetCodebook(ctx, dataset)
-		if err != nil {
-			errEntity, status := getErrorResponse(ctx, err)
-			WriteBody(ctx, w, errEntity, status)
+		codebook, ok := api.getCodebook(ctx, w, dataset)
+		if !ok {
 			return
 		}
 
@@ -130,6 +250,10 @@ func (api *API) GetDatasetDimension() http.Handler {
 			return
 		}
 
+		if api.notModified(w, r, codebook.Dataset.Digest) {
+			return
+		}
+
 		WriteBody(ctx, w, result, http.StatusOK)
 	})
 }
@@ -140,10 +264,8 @@ func (api *API) GetDatasetDimensionCodes() http.Handler {
 		dataset := mux.Vars(r)["dataset"]
 		dimension := mux.Vars(r)["name"]
 
-		codebook, err := api.Store.GetDatasetCodebook(ctx, dataset)
-		if err != nil {
-			errEntity, status := getErrorResponse(ctx, err)
-			WriteBody(ctx, w, errEntity, status)
+		codebook, ok := api.getCodebook(ctx, w, dataset)
+		if !ok {
 			return
 		}
 
@@ -153,29 +275,27 @@ func (api *API) GetDatasetDimensionCodes() http.Handler {
 			return
 		}
 
-		codelist := mapToCMDCodeList(dim)
+		pg, ok := getPage(ctx, w, r)
+		if !ok {
+			return
+		}
+
+		if api.notModified(w, r, codebook.Dataset.Digest) {
+			return
+		}
+
+		codelist := mapToCMDCodeList(dim, pg)
 		WriteBody(ctx, w, codelist, http.StatusOK)
 	})
 }
 
-func mapToCMDCodeList(dimension *cantabular.Dimension) *models.CodeResults {
+func mapToCMDCodeList(dimension *cantabular.Dimension, pg page) *models.CodeResults {
 	codes := make([]models.Code, 0)
 	for i, c := range dimension.Codes {
-		codes = append(codes, models.Code{
-			ID:    c,
-			Label: dimension.Labels[i],
-			Links: nil,
-		})
+		codes = append(codes, cmdCode(dimension.Name, c, dimension.LabelAt(i)))
 	}
 
-	length := len(codes)
-	return &models.CodeResults{
-		Items:      codes,
-		Count:      length,
-		Offset:     0,
-		Limit:      length,
-		TotalCount: length,
-	}
+	return pg.codes(codes)
 }
 
 func (api *API) GetFilterDimensions() http.Handler {
@@ -184,10 +304,8 @@ func (api *API) GetFilterDimensions() http.Handler {
 		dataset := mux.Vars(r)["dataset"]
 		dimensionName := mux.Vars(r)["name"]
 
-		codebook, err := api.Store.GetDatasetCodebook(ctx, dataset)
-		if err != nil {
-			errEntity, status := getErrorResponse(ctx, err)
-			WriteBody(ctx, w, errEntity, status)
+		codebook, ok := api.getCodebook(ctx, w, dataset)
+		if !ok {
 			return
 		}
 
@@ -202,6 +320,10 @@ func (api *API) GetFilterDimensions() http.Handler {
 			})
 		}
 
+		if api.notModified(w, r, codebook.Dataset.Digest) {
+			return
+		}
+
 		WriteBody(ctx, w, options, http.StatusOK)
 	})
 }
@@ -218,10 +340,8 @@ func (api *API) GetDatasetDimensionByIndex() http.Handler {
 			return
 		}
 
-		codebook, err := api.Store.GetDatasetCodebook(ctx, dataset)
-		if err != nil {
-			errEntity, status := getErrorResponse(ctx, err)
-			WriteBody(ctx, w, errEntity, status)
+		codebook, ok := api.getCodebook(ctx, w, dataset)
+		if !ok {
 			return
 		}
 
@@ -248,11 +368,33 @@ func (api *API) GetDatasetDimensionByIndex() http.Handler {
 			Code:  label,
 		}
 
+		if api.notModified(w, r, codebook.Dataset.Digest) {
+			return
+		}
+
 		WriteBody(ctx, w, d, http.StatusOK)
 	})
 }
 
 
+// getCodebook fetches the codebook for a dataset, writing the error response
+// if it cannot be retrieved. A Warning header is added when the codebook is a
+// stale snapshot. It returns false if the response is complete.
+func (api *API) getCodebook(ctx context.Context, w http.ResponseWriter, dataset string) (*cantabular.Codebook, bool) {
+	codebook, err := api.Store.GetDatasetCodebook(ctx, dataset)
+	if err != nil {
+		errEntity, status := getErrorResponse(ctx, err)
+		WriteBody(ctx, w, errEntity, status)
+		return nil, false
+	}
+
+	if codebook.Stale {
+		w.Header().Set("Warning", staleWarning)
+	}
+
+	return codebook, true
+}
+
 func getErrorResponse(ctx context.Context, err error) (SimpleEntity, int) {
 	log.Event(ctx, "returning http error response", log.ERROR, log.Error(err))
 
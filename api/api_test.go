@@ -0,0 +1,2788 @@
+package api_test
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ONSdigital/dp-api-clients-go/dataset"
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/api"
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/api/apitest"
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/cantabular"
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/cantabular/fake"
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/disclosure"
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/events"
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/jobs"
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/middleware"
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/openapi"
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/store"
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/webhooks"
+	"github.com/ONSdigital/dp-code-list-api/models"
+	hierarchy "github.com/ONSdigital/dp-hierarchy-api/models"
+	dphttp "github.com/ONSdigital/dp-net/http"
+	"github.com/gorilla/mux"
+	"github.com/justinas/alice"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const testToken = apitest.Authorization
+
+func TestMain(m *testing.M) {
+	apitest.Main(m)
+}
+
+type proxy struct {
+	base      *apitest.Proxy
+	ftb       *fake.FTB
+	app       *api.API
+	handler   http.Handler
+	codebooks *store.Codebooks
+	dir       string
+
+	mu         sync.Mutex
+	violations []error
+}
+
+// newProxy wires the api router, codebook store and middleware together the
+// way main does, in front of a fake FTB serving the example dataset, checking
+// every response against the OpenAPI document.
+func newProxy(ttl time.Duration) *proxy {
+	shared, err := apitest.New(ttl)
+	So(err, ShouldBeNil)
+
+	p := &proxy{base: shared, ftb: shared.FTB, app: shared.App, codebooks: shared.Codebooks, dir: shared.Dir}
+	p.handler = shared.Handler(middleware.Validate(shared.App.Spec, p.report))
+	return p
+}
+
+// report records a request or response that does not match the OpenAPI
+// document
+func (p *proxy) report(r *http.Request, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.violations = append(p.violations, err)
+}
+
+// responseViolations returns the responses that did not match the OpenAPI
+// document since it was last called. Requests are not checked here as tests
+// make invalid ones on purpose.
+func (p *proxy) responseViolations() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var found []string
+	for _, err := range p.violations {
+		var respErr *openapi.ResponseError
+		if errors.As(err, &respErr) {
+			found = append(found, err.Error())
+		}
+	}
+	p.violations = nil
+	return found
+}
+
+func (p *proxy) close() {
+	p.base.Close()
+}
+
+func (p *proxy) get(path string, headers map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	r.Header.Set("Authorization", testToken)
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+
+	w := httptest.NewRecorder()
+	p.handler.ServeHTTP(w, r)
+	So(p.responseViolations(), ShouldBeEmpty)
+	return w
+}
+
+// post sends entity as the JSON body of a POST
+func (p *proxy) post(path string, entity interface{}) *httptest.ResponseRecorder {
+	body, err := json.Marshal(entity)
+	So(err, ShouldBeNil)
+
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(string(body)))
+	r.Header.Set("Authorization", testToken)
+	r.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	p.handler.ServeHTTP(w, r)
+	So(p.responseViolations(), ShouldBeEmpty)
+	return w
+}
+
+func decode(w *httptest.ResponseRecorder, entity interface{}) {
+	So(json.NewDecoder(w.Body).Decode(entity), ShouldBeNil)
+}
+
+func TestDimensions(t *testing.T) {
+	Convey("Given a proxy in front of a flexible table builder", t, func() {
+		p := newProxy(0)
+		defer p.close()
+
+		Convey("When the dimensions of a dataset are requested", func() {
+			w := p.get("/v6/datasets/Example/dimensions", nil)
+
+			Convey("Then every dimension in the codebook is listed", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+
+				var resp api.GetDimensionsResponse
+				decode(w, &resp)
+				So(resp.Dimensions, ShouldResemble, []string{
+					"Country: country",
+					"Region: region",
+					"Local Authority: la",
+					"Sex: sex",
+				})
+			})
+		})
+
+		Convey("When the request has no auth token", func() {
+			r := httptest.NewRequest(http.MethodGet, "/v6/datasets/Example/dimensions", nil)
+			w := httptest.NewRecorder()
+			p.handler.ServeHTTP(w, r)
+
+			Convey("Then it is rejected", func() {
+				So(w.Code, ShouldEqual, http.StatusUnauthorized)
+				So(p.ftb.Requests("/v6/codebook/Example"), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When a dimension is requested", func() {
+			w := p.get("/v6/datasets/Example/dimensions/region", nil)
+
+			Convey("Then the codebook entry is returned", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+
+				var dim cantabular.Dimension
+				decode(w, &dim)
+				So(dim.Name, ShouldEqual, "region")
+				So(dim.Codes, ShouldResemble, []string{"E12000001", "E12000002"})
+			})
+		})
+
+		Convey("When an unknown dimension is requested", func() {
+			w := p.get("/v6/datasets/Example/dimensions/ethnicity", nil)
+
+			Convey("Then a 404 is returned", func() {
+				So(w.Code, ShouldEqual, http.StatusNotFound)
+			})
+		})
+
+		Convey("When the codes of a dimension are requested", func() {
+			w := p.get("/v6/datasets/Example/dimensions/la/codes", nil)
+
+			Convey("Then the codes are returned as a code list", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(w.Header().Get("Cache-Control"), ShouldEqual, "max-age=3600")
+
+				var codes models.CodeResults
+				decode(w, &codes)
+				So(codes.TotalCount, ShouldEqual, 4)
+				So(codes.Items[0].ID, ShouldEqual, "E06000001")
+				So(codes.Items[0].Label, ShouldEqual, "Hartlepool")
+			})
+		})
+
+		Convey("When a page of the codes of a dimension is requested", func() {
+			w := p.get("/v6/datasets/Example/dimensions/la/codes?offset=1&limit=2", nil)
+
+			Convey("Then only the codes of the page are returned", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+
+				var codes models.CodeResults
+				decode(w, &codes)
+				So(codes.Count, ShouldEqual, 2)
+				So(codes.Offset, ShouldEqual, 1)
+				So(codes.Limit, ShouldEqual, 2)
+				So(codes.TotalCount, ShouldEqual, 4)
+				So(codes.Items[0].ID, ShouldEqual, "E06000002")
+			})
+
+			Convey("Then an invalid offset is a bad request", func() {
+				So(p.get("/v6/datasets/Example/dimensions/la/codes?offset=-1", nil).Code, ShouldEqual, http.StatusBadRequest)
+			})
+		})
+
+		Convey("When an unknown dataset is requested", func() {
+			w := p.get("/v6/datasets/Unknown/dimensions", nil)
+
+			Convey("Then the FTB 404 is passed on", func() {
+				So(w.Code, ShouldEqual, http.StatusNotFound)
+			})
+		})
+	})
+}
+
+func TestHierarchies(t *testing.T) {
+	Convey("Given a proxy in front of a flexible table builder", t, func() {
+		p := newProxy(0)
+		defer p.close()
+
+		Convey("When the children of a region are requested", func() {
+			w := p.get("/v6/datasets/Example/hierarchies/region/code/E12000001", nil)
+
+			Convey("Then its local authorities are returned", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+
+				var h hierarchy.Response
+				decode(w, &h)
+				So(h.Label, ShouldEqual, "Local Authority")
+				So(h.NoOfChildren, ShouldEqual, 2)
+				So(h.Children[0].Links["code"].ID, ShouldEqual, "E06000001")
+				So(h.Children[1].Links["code"].ID, ShouldEqual, "E06000002")
+			})
+		})
+
+		Convey("When the hierarchy of a dimension with several branches is requested", func() {
+			w := p.get("/v6/datasets/Example/hierarchies/country", nil)
+
+			Convey("Then the first branch is used and every branch is linked", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+
+				var h hierarchy.Response
+				decode(w, &h)
+				So(h.Children[0].NoOfChildren, ShouldEqual, 2)
+				So(h.Links, ShouldContainKey, "branch:region")
+				So(h.Links, ShouldContainKey, "branch:la")
+			})
+		})
+
+		Convey("When a code's children are requested through another branch", func() {
+			w := p.get("/v6/datasets/Example/hierarchies/country/code/E92000001?branch=la", nil)
+
+			Convey("Then the children come from that branch", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+
+				var h hierarchy.Response
+				decode(w, &h)
+				So(h.Label, ShouldEqual, "Local Authority")
+				So(h.NoOfChildren, ShouldEqual, 4)
+			})
+		})
+
+		Convey("When an unknown branch is requested", func() {
+			w := p.get("/v6/datasets/Example/hierarchies/country/full?branch=sex", nil)
+
+			Convey("Then a 400 is returned", func() {
+				So(w.Code, ShouldEqual, http.StatusBadRequest)
+			})
+		})
+
+		Convey("When the hierarchy of a leaf dimension is requested", func() {
+			w := p.get("/v6/datasets/Example/hierarchies/la", nil)
+
+			Convey("Then a 404 is returned instead of a panic", func() {
+				So(w.Code, ShouldEqual, http.StatusNotFound)
+			})
+		})
+
+		Convey("When the parents of a local authority are requested", func() {
+			w := p.get("/v6/datasets/Example/hierarchies/la/code/E06000008/parents", nil)
+
+			Convey("Then its region and country are returned nearest first", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+
+				var resp api.ParentsResponse
+				decode(w, &resp)
+				So(resp.Label, ShouldEqual, "Blackburn with Darwen")
+				So(resp.Parent.Code, ShouldEqual, "E12000002")
+				So(resp.Parent.Dimension, ShouldEqual, "region")
+				So(resp.Ancestors, ShouldHaveLength, 2)
+				So(resp.Ancestors[1].Code, ShouldEqual, "E92000001")
+				So(resp.Ancestors[1].Label, ShouldEqual, "England")
+			})
+
+			Convey("Then its country is also reached straight from local authorities", func() {
+				var resp api.ParentsResponse
+				decode(w, &resp)
+				So(resp.Paths, ShouldHaveLength, 2)
+
+				So(resp.Paths[0], ShouldHaveLength, 2)
+				So(resp.Paths[0][0].Code, ShouldEqual, "E12000002")
+				So(resp.Paths[0][1].Code, ShouldEqual, "E92000001")
+
+				So(resp.Paths[1], ShouldHaveLength, 1)
+				So(resp.Paths[1][0].Dimension, ShouldEqual, "country")
+				So(resp.Paths[1][0].Code, ShouldEqual, "E92000001")
+				So(resp.Paths[1][0].Links["parents"].HRef, ShouldEndWith, "/v6/datasets/Example/hierarchies/country/code/E92000001/parents")
+			})
+		})
+
+		Convey("When the parents of a root code are requested", func() {
+			w := p.get("/v6/datasets/Example/hierarchies/country/code/E92000001/parents", nil)
+
+			Convey("Then there are none", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+
+				var resp api.ParentsResponse
+				decode(w, &resp)
+				So(resp.Parent, ShouldBeNil)
+				So(resp.Ancestors, ShouldBeEmpty)
+				So(resp.Paths, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When the parents of an unknown code are requested", func() {
+			w := p.get("/v6/datasets/Example/hierarchies/la/code/W06000001/parents", nil)
+
+			Convey("Then a 404 is returned", func() {
+				So(w.Code, ShouldEqual, http.StatusNotFound)
+			})
+		})
+
+		Convey("When the full hierarchy is requested", func() {
+			w := p.get("/v6/datasets/Example/hierarchies/country/full?depth=3", nil)
+
+			Convey("Then the tree is built down to the requested depth", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+
+				var h cantabular.Hierarchy
+				decode(w, &h)
+				So(h.Children, ShouldHaveLength, 1)
+				So(h.Children[0].Children, ShouldHaveLength, 2)
+				So(h.Children[0].Children[1].Children[0].Name, ShouldEqual, "Blackburn with Darwen")
+			})
+		})
+
+		Convey("When the full hierarchy is requested with a depth of 0", func() {
+			w := p.get("/v6/datasets/Example/hierarchies/region/full?depth=0", nil)
+
+			Convey("Then only the top level is returned", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+
+				var h cantabular.Hierarchy
+				decode(w, &h)
+				So(h.Children, ShouldHaveLength, 2)
+				So(h.Children[0].Children, ShouldBeEmpty)
+			})
+		})
+	})
+}
+
+func TestHierarchyExport(t *testing.T) {
+	Convey("Given a proxy in front of a flexible table builder", t, func() {
+		p := newProxy(0)
+		defer p.close()
+
+		Convey("When the full hierarchy is requested as SKOS Turtle", func() {
+			w := p.get("/v6/datasets/Example/hierarchies/region/full", map[string]string{"Accept": "text/turtle"})
+
+			Convey("Then concepts are linked with broader and narrower", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(w.Header().Get("Content-Type"), ShouldEqual, "text/turtle")
+
+				body := w.Body.String()
+				So(body, ShouldContainSubstring, "a skos:ConceptScheme")
+				So(body, ShouldContainSubstring, `skos:prefLabel "Hartlepool"@en`)
+				So(body, ShouldContainSubstring, "skos:broader <http://127.0.0.1:10100/v6/datasets/Example/hierarchies/region/code/E12000001>")
+				So(body, ShouldContainSubstring, "skos:narrower <http://127.0.0.1:10100/v6/datasets/Example/hierarchies/la/code/E06000001>, <http://127.0.0.1:10100/v6/datasets/Example/hierarchies/la/code/E06000002>")
+			})
+		})
+
+		Convey("When the full hierarchy is requested as JSON-LD", func() {
+			w := p.get("/v6/datasets/Example/hierarchies/region/full?format=jsonld", nil)
+
+			Convey("Then the scheme and every concept are in the graph", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(w.Header().Get("Content-Type"), ShouldEqual, "application/ld+json")
+
+				var doc map[string]interface{}
+				decode(w, &doc)
+				So(doc["@graph"], ShouldHaveLength, 7)
+			})
+		})
+
+		Convey("When the full hierarchy is requested as a parent-child CSV", func() {
+			w := p.get("/v6/datasets/Example/hierarchies/region/full", map[string]string{"Accept": "text/csv"})
+
+			Convey("Then each code is listed with its parent", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(w.Body.String(), ShouldStartWith, "dimension,code,label,parent_dimension,parent_code\nregion,E12000001,North East,,\nla,E06000001,Hartlepool,region,E12000001\n")
+			})
+		})
+
+		Convey("When the full hierarchy is requested as an outline CSV", func() {
+			w := p.get("/v6/datasets/Example/hierarchies/region/full", map[string]string{"Accept": "text/csv; layout=outline"})
+
+			Convey("Then labels are indented by level", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(w.Body.String(), ShouldStartWith, "code,level_1,level_2\nE12000001,North East,\nE06000001,,Hartlepool\n")
+			})
+		})
+
+		Convey("When a hierarchy more than two levels deep is exported", func() {
+			w := p.get("/v6/datasets/Example/hierarchies/country/full", map[string]string{"Accept": "text/csv; layout=outline"})
+
+			Convey("Then every level is exported", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(w.Body.String(), ShouldStartWith, "code,level_1,level_2,level_3\n")
+				So(w.Body.String(), ShouldContainSubstring, "E06000001,,,Hartlepool\n")
+			})
+		})
+
+		Convey("When a hierarchy with a code that is not a URL path segment is exported", func() {
+			escaped := fake.Example()
+			escaped.Codebook.Dataset.Name = "Escaped"
+			escaped.Codebook.CodeBook[2].Codes[0] = "E06/001 A"
+			p.ftb.AddDataset(escaped)
+
+			w := p.get("/v6/datasets/Escaped/hierarchies/region/full", map[string]string{"Accept": "text/turtle"})
+
+			Convey("Then its concept URI escapes it", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(w.Body.String(), ShouldContainSubstring, "<http://127.0.0.1:10100/v6/datasets/Escaped/hierarchies/la/code/E06%2F001%20A>")
+			})
+		})
+
+		Convey("When an unsupported format is requested", func() {
+			w := p.get("/v6/datasets/Example/hierarchies/region/full", map[string]string{"Accept": "application/xml"})
+
+			Convey("Then a 406 is returned", func() {
+				So(w.Code, ShouldEqual, http.StatusNotAcceptable)
+			})
+		})
+	})
+}
+
+func TestHierarchyStreaming(t *testing.T) {
+	Convey("Given a proxy in front of a flexible table builder", t, func() {
+		p := newProxy(0)
+		defer p.close()
+
+		Convey("When the full hierarchy is streamed as NDJSON", func() {
+			w := p.get("/v6/datasets/Example/hierarchies/country/full", map[string]string{"Accept": "application/x-ndjson"})
+
+			Convey("Then every code down to the leaves is written a line at a time", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(w.Header().Get("Content-Type"), ShouldEqual, "application/x-ndjson")
+
+				lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+				So(lines, ShouldHaveLength, 7)
+				So(lines[0], ShouldEqual, `{"type":"country","name":"England","code":"E92000001","depth":1}`)
+				So(lines[2], ShouldEqual, `{"type":"la","name":"Hartlepool","code":"E06000001","parent_type":"region","parent_code":"E12000001","depth":3}`)
+			})
+		})
+
+		Convey("When the full hierarchy is streamed as JSON", func() {
+			streamed := p.get("/v6/datasets/Example/hierarchies/country/full?stream=true", nil)
+			built := p.get("/v6/datasets/Example/hierarchies/country/full?depth=3", nil)
+
+			Convey("Then it is the same tree as the one built in memory", func() {
+				So(streamed.Code, ShouldEqual, http.StatusOK)
+				So(built.Code, ShouldEqual, http.StatusOK)
+
+				var fromStream, fromMemory cantabular.Hierarchy
+				decode(streamed, &fromStream)
+				decode(built, &fromMemory)
+				So(fromStream, ShouldResemble, fromMemory)
+				So(fromStream.Children[0].Children[1].Children, ShouldHaveLength, 2)
+			})
+		})
+
+		Convey("When every level is asked for as JSON without streaming", func() {
+			w := p.get("/v6/datasets/Example/hierarchies/country/full?depth=all", nil)
+
+			Convey("Then it is streamed down to the leaves", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+
+				var h cantabular.Hierarchy
+				decode(w, &h)
+				So(h.Children[0].Children[1].Children, ShouldHaveLength, 2)
+			})
+		})
+
+		Convey("When a hierarchy that maps cyclically is walked to the leaves", func() {
+			cyclic := fake.Example()
+			cyclic.Codebook.Dataset.Name = "Cyclic"
+			cyclic.Codebook.CodeBook[2].MapFrom = []string{"region"}
+			cyclic.Codebook.CodeBook[2].MapFromCodes = []string{"E06000001", "E06000008"}
+			p.ftb.AddDataset(cyclic)
+
+			streamed := p.get("/v6/datasets/Cyclic/hierarchies/region/full", map[string]string{"Accept": "application/x-ndjson"})
+			exported := p.get("/v6/datasets/Cyclic/hierarchies/region/full", map[string]string{"Accept": "text/csv"})
+			built := p.get("/v6/datasets/Cyclic/hierarchies/region/full?depth=100000", nil)
+
+			Convey("Then the walk stops where the mapping loops back", func() {
+				So(streamed.Code, ShouldEqual, http.StatusOK)
+				So(strings.Split(strings.TrimSpace(streamed.Body.String()), "\n"), ShouldHaveLength, 6)
+
+				So(exported.Code, ShouldEqual, http.StatusOK)
+				So(strings.Split(strings.TrimSpace(exported.Body.String()), "\n"), ShouldHaveLength, 7)
+
+				So(built.Code, ShouldEqual, http.StatusOK)
+			})
+		})
+
+		Convey("When the client goes away partway through a stream", func() {
+			p.ftb.AddDataset(wideHierarchy(1200))
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			r := httptest.NewRequest(http.MethodGet, "/v6/datasets/Wide/hierarchies/region/full", nil).WithContext(ctx)
+			r.Header.Set("Authorization", testToken)
+			r.Header.Set("Accept", "application/x-ndjson")
+
+			// the client goes away once the first lines have reached it
+			w := &cancellingRecorder{ResponseRecorder: httptest.NewRecorder(), cancel: cancel}
+
+			done := make(chan struct{})
+			go func() {
+				p.app.Router.ServeHTTP(w, r)
+				close(done)
+			}()
+
+			returned := false
+			select {
+			case <-done:
+				returned = true
+			case <-time.After(5 * time.Second):
+			}
+
+			Convey("Then the handler returns without writing the rest", func() {
+				So(returned, ShouldBeTrue)
+				So(w.Code, ShouldEqual, http.StatusOK)
+
+				lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+				// only the lines flushed before the client went away
+				So(lines, ShouldHaveLength, 500)
+			})
+		})
+	})
+}
+
+// wideHierarchy is a dataset with a single region of n local authorities, enough codes
+// for a hierarchy stream to be flushed partway through
+func wideHierarchy(n int) *fake.Dataset {
+	las := make([]string, n)
+	mapFrom := make([]string, n)
+	for i := range las {
+		las[i] = fmt.Sprintf("E%08d", i)
+	}
+	mapFrom[0] = "E12000001"
+
+	return &fake.Dataset{Codebook: &cantabular.Codebook{
+		Dataset: cantabular.Dataset{Name: "Wide", Digest: "wide-digest-1"},
+		CodeBook: []cantabular.Dimension{
+			{Name: "region", Codes: []string{"E12000001"}, MapFrom: []string{"la"}, MapFromCodes: mapFrom},
+			{Name: "la", Codes: las},
+		},
+	}}
+}
+
+// cancellingRecorder cancels the request when the response is first flushed
+type cancellingRecorder struct {
+	*httptest.ResponseRecorder
+	cancel func()
+}
+
+func (c *cancellingRecorder) Flush() {
+	c.ResponseRecorder.Flush()
+	c.cancel()
+}
+
+func TestValidation(t *testing.T) {
+	Convey("Given a proxy in front of a flexible table builder", t, func() {
+		p := newProxy(0)
+		defer p.close()
+
+		Convey("When a well formed codebook is validated", func() {
+			w := p.get("/v6/datasets/Example/validate", nil)
+
+			Convey("Then the report has no issues", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+
+				var report cantabular.ValidationReport
+				decode(w, &report)
+				So(report.Valid, ShouldBeTrue)
+				So(report.Dimensions, ShouldEqual, 4)
+				So(report.Issues, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When a corrupt codebook is validated", func() {
+			corrupt := fake.Example()
+			corrupt.Codebook.Dataset.Name = "Corrupt"
+			region := &corrupt.Codebook.CodeBook[1]
+			region.Labels = region.Labels[:1]
+			region.MapFromCodes = []string{"", "E12000001", "E12000009"}
+			la := &corrupt.Codebook.CodeBook[2]
+			la.Codes[3] = la.Codes[0]
+			p.ftb.AddDataset(corrupt)
+
+			w := p.get("/v6/datasets/Corrupt/validate", nil)
+
+			Convey("Then every problem is reported", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+
+				var report cantabular.ValidationReport
+				decode(w, &report)
+				So(report.Valid, ShouldBeFalse)
+
+				checks := make(map[string]int)
+				for _, issue := range report.Issues {
+					checks[issue.Check]++
+				}
+				So(checks, ShouldResemble, map[string]int{
+					cantabular.CheckLengths:   2,
+					cantabular.CheckDuplicate: 1,
+					cantabular.CheckOrphan:    2,
+					cantabular.CheckCoverage:  1,
+				})
+				So(report.Errors, ShouldEqual, 5)
+				So(report.Warnings, ShouldEqual, 1)
+			})
+
+			Convey("Then its hierarchy can still be built", func() {
+				h := p.get("/v6/datasets/Corrupt/hierarchies/region/full?depth=all", nil)
+				So(h.Code, ShouldEqual, http.StatusOK)
+
+				level := p.get("/v6/datasets/Corrupt/hierarchies/region", nil)
+				So(level.Code, ShouldEqual, http.StatusOK)
+
+				code := p.get("/v6/datasets/Corrupt/hierarchies/country/code/E92000001", nil)
+				So(code.Code, ShouldEqual, http.StatusOK)
+			})
+		})
+	})
+}
+
+func TestQuery(t *testing.T) {
+	Convey("Given a proxy in front of a flexible table builder", t, func() {
+		p := newProxy(0)
+		defer p.close()
+
+		Convey("When a query is made", func() {
+			w := p.get("/v6/query/Example?v=region&v=sex", nil)
+
+			Convey("Then the FTB table is passed through", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+
+				var table cantabular.Table
+				decode(w, &table)
+				So(table.Dimensions, ShouldHaveLength, 2)
+				So(table.Counts, ShouldHaveLength, 4)
+			})
+
+			Convey("And the same query is repeated with the ETag", func() {
+				etag := w.Header().Get("ETag")
+				So(etag, ShouldNotBeEmpty)
+
+				repeat := p.get("/v6/query/Example?v=region&v=sex", map[string]string{"If-None-Match": etag})
+
+				Convey("Then a 304 is returned without querying the FTB again", func() {
+					So(repeat.Code, ShouldEqual, http.StatusNotModified)
+					So(p.ftb.Requests("/v6/query/Example"), ShouldEqual, 1)
+				})
+			})
+
+			Convey("And the dataset digest changes", func() {
+				etag := w.Header().Get("ETag")
+
+				reloaded := fake.Example()
+				reloaded.Codebook.Dataset.Digest = "example-digest-2"
+				p.ftb.AddDataset(reloaded)
+
+				repeat := p.get("/v6/query/Example?v=region&v=sex", map[string]string{"If-None-Match": etag})
+
+				Convey("Then the table is returned with a new ETag", func() {
+					So(repeat.Code, ShouldEqual, http.StatusOK)
+					So(repeat.Header().Get("ETag"), ShouldNotEqual, etag)
+				})
+			})
+		})
+
+		Convey("When a query is aggregated up the geography hierarchy", func() {
+			w := p.get("/v6/query/Example?v=la&v=sex&aggregate=region,country", nil)
+
+			Convey("Then the derived totals follow the queried ones", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(p.ftb.Requests("/v6/query/Example"), ShouldEqual, 1)
+
+				var table cantabular.Table
+				decode(w, &table)
+				So(table.Dimensions, ShouldHaveLength, 2)
+
+				geography := table.Dimensions[0]
+				So(geography.Codes, ShouldHaveLength, 7)
+				So(geography.Codes[4:], ShouldResemble, []string{"E12000001", "E12000002", "E92000001"})
+				So(geography.Levels, ShouldResemble, []cantabular.TableLevel{
+					{Name: "la", Offset: 0, Count: 4},
+					{Name: "region", Offset: 4, Count: 2, Derived: true},
+					{Name: "country", Offset: 6, Count: 1, Derived: true},
+				})
+				So(table.Counts, ShouldHaveLength, 14)
+
+				Convey("And they match the FTB's own totals", func() {
+					regions, err := fake.Example().Query([]string{"region", "sex"})
+					So(err, ShouldBeNil)
+					So(table.Counts[8:12], ShouldResemble, regions.Counts)
+
+					country, err := fake.Example().Query([]string{"country", "sex"})
+					So(err, ShouldBeNil)
+					So(table.Counts[12:], ShouldResemble, country.Counts)
+				})
+			})
+		})
+
+		Convey("When a query is aggregated to a dimension it does not map into", func() {
+			w := p.get("/v6/query/Example?v=region&aggregate=sex", nil)
+
+			Convey("Then a 400 is returned", func() {
+				So(w.Code, ShouldEqual, http.StatusBadRequest)
+				So(w.Header().Get("ETag"), ShouldBeEmpty)
+			})
+		})
+
+		Convey("When a query is aggregated to a dimension sharing a code with the one queried", func() {
+			overlapping := fake.Example()
+			overlapping.Codebook.Dataset.Name = "Overlapping"
+			region := &overlapping.Codebook.CodeBook[1]
+			region.Codes[0] = "E06000001"
+			region.MapFromCodes[0] = "E06000001"
+			p.ftb.AddDataset(overlapping)
+
+			w := p.get("/v6/query/Overlapping?v=la&aggregate=region", nil)
+
+			Convey("Then a 400 is returned rather than a table with an ambiguous code", func() {
+				So(w.Code, ShouldEqual, http.StatusBadRequest)
+
+				var body api.SimpleEntity
+				decode(w, &body)
+				So(body.Message, ShouldContainSubstring, "code E06000001 is already in dimension la")
+			})
+		})
+
+		Convey("When a query names an unknown variable", func() {
+			w := p.get("/v6/query/Example?v=ethnicity", nil)
+
+			Convey("Then the FTB 400 is passed on", func() {
+				So(w.Code, ShouldEqual, http.StatusBadRequest)
+			})
+		})
+	})
+}
+
+func TestDisclosureControl(t *testing.T) {
+	Convey("Given a dataset with one small count", t, func() {
+		p := newProxy(0)
+		defer p.close()
+
+		small := fake.Example()
+		small.Codebook.Dataset.Name = "Small"
+		small.Count = func(codes map[string]string) int {
+			if codes["la"] == "E06000001" && codes["sex"] == "1" {
+				return 2
+			}
+			return 12
+		}
+		p.ftb.AddDataset(small)
+
+		Convey("And disclosure control rules for it", func() {
+			p.app.Disclosure = &disclosure.RuleSet{
+				Datasets: map[string]*disclosure.Rules{
+					"Small": {Threshold: 5, Marker: "x", RoundingBase: 5, SecondarySuppression: true},
+				},
+			}
+
+			Convey("When the dataset is queried", func() {
+				w := p.get("/v6/query/Small?v=la&v=sex", nil)
+
+				Convey("Then the small count and the cells it could be recovered from are suppressed", func() {
+					So(w.Code, ShouldEqual, http.StatusOK)
+
+					var table cantabular.Table
+					decode(w, &table)
+					So(table.Counts, ShouldResemble, []int{0, 0, 0, 0, 10, 10, 10, 10})
+					So(table.Status, ShouldResemble, []string{"x", "x", "x", "x", "", "", "", ""})
+					So(table.DisclosureControl, ShouldResemble, &cantabular.DisclosureControl{
+						Rules:                "Small",
+						Threshold:            5,
+						Marker:               "x",
+						RoundingBase:         5,
+						SecondarySuppression: true,
+						Suppressed:           1,
+						SecondarySuppressed:  3,
+					})
+				})
+			})
+
+			Convey("When the dataset is queried on a path beside the query route", func() {
+				for _, path := range []string{"/v6/query/Small/", "/v6/query/Small/x"} {
+					w := p.get(path+"?v=la&v=sex", nil)
+
+					Convey("Then the unsuppressed FTB table is not passed through for "+path, func() {
+						So(w.Code, ShouldEqual, http.StatusNotFound)
+						So(p.ftb.Requests(path), ShouldEqual, 0)
+					})
+				}
+			})
+
+			Convey("When another dataset is queried", func() {
+				w := p.get("/v6/query/Example?v=sex", nil)
+
+				Convey("Then it is passed through untouched", func() {
+					var table cantabular.Table
+					decode(w, &table)
+					So(table.DisclosureControl, ShouldBeNil)
+				})
+			})
+		})
+
+		Convey("And default rules that only round", func() {
+			p.app.Disclosure = &disclosure.RuleSet{Default: &disclosure.Rules{RoundingBase: 10}}
+
+			Convey("When the dataset is aggregated", func() {
+				w := p.get("/v6/query/Small?v=la&aggregate=country", nil)
+
+				Convey("Then the derived total is rounded too", func() {
+					So(w.Code, ShouldEqual, http.StatusOK)
+
+					var table cantabular.Table
+					decode(w, &table)
+					So(table.Counts, ShouldResemble, []int{10, 20, 20, 20, 90})
+					So(table.Status, ShouldBeNil)
+					So(table.DisclosureControl.Rules, ShouldEqual, "default")
+				})
+			})
+		})
+	})
+
+	Convey("Given a dataset with a small count inside a regional subtotal", t, func() {
+		p := newProxy(0)
+		defer p.close()
+
+		counts := map[string]int{"E06000001": 2, "E06000002": 80, "E06000008": 60, "E06000009": 70}
+		small := fake.Example()
+		small.Codebook.Dataset.Name = "Small"
+		small.Count = func(codes map[string]string) int {
+			if codes["sex"] != "1" {
+				return 0
+			}
+			return counts[codes["la"]]
+		}
+		p.ftb.AddDataset(small)
+
+		p.app.Disclosure = &disclosure.RuleSet{
+			Datasets: map[string]*disclosure.Rules{
+				"Small": {Threshold: 10, SecondarySuppression: true},
+			},
+		}
+
+		Convey("When it is aggregated to the regions and the country", func() {
+			w := p.get("/v6/query/Small?v=la&aggregate=region,country", nil)
+			So(w.Code, ShouldEqual, http.StatusOK)
+
+			var table cantabular.Table
+			decode(w, &table)
+			So(table.Dimensions[0].Codes, ShouldResemble, []string{
+				"E06000001", "E06000002", "E06000008", "E06000009", "E12000001", "E12000002", "E92000001",
+			})
+
+			Convey("Then no suppressed count can be recovered from any subtotal", func() {
+				So(table.Status[0], ShouldEqual, "c")
+
+				// each subtotal followed by the categories it adds up
+				subtotals := [][]int{
+					{4, 0, 1},
+					{5, 2, 3},
+					{6, 0, 1, 2, 3},
+					{6, 4, 5},
+				}
+				for _, group := range subtotals {
+					hidden := 0
+					for _, i := range group {
+						if table.Status[i] != "" {
+							hidden++
+						}
+					}
+					So(hidden, ShouldNotEqual, 1)
+				}
+			})
+		})
+	})
+}
+
+func TestMarginsAndPercentages(t *testing.T) {
+	Convey("Given a dataset with one small count", t, func() {
+		p := newProxy(0)
+		defer p.close()
+
+		small := fake.Example()
+		small.Codebook.Dataset.Name = "Small"
+		small.Count = func(codes map[string]string) int {
+			if codes["la"] == "E06000001" && codes["sex"] == "1" {
+				return 2
+			}
+			return 12
+		}
+		p.ftb.AddDataset(small)
+
+		Convey("When a query asks for every margin and row percentages", func() {
+			w := p.get("/v6/query/Small?v=region&v=sex&margins=total&percent=row", nil)
+
+			Convey("Then totals are appended to each dimension", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+
+				var table cantabular.Table
+				decode(w, &table)
+				So(table.Dimensions[0].Codes, ShouldResemble, []string{"E12000001", "E12000002", "total"})
+				So(table.Dimensions[1].Codes, ShouldResemble, []string{"1", "2", "total"})
+				So(table.Dimensions[1].Levels[1], ShouldResemble, cantabular.TableLevel{Name: "total", Offset: 2, Count: 1, Derived: true, Margin: true})
+				So(table.Counts, ShouldResemble, []int{14, 24, 38, 24, 24, 48, 38, 48, 86})
+
+				Convey("And each count is given as a percentage of its row", func() {
+					So(table.PercentageOf, ShouldEqual, "row")
+
+					percentages := make([]float64, 0)
+					for _, p := range table.Percentages {
+						So(p, ShouldNotBeNil)
+						percentages = append(percentages, *p)
+					}
+					So(percentages, ShouldResemble, []float64{36.84, 63.16, 100, 50, 50, 100, 44.19, 55.81, 100})
+				})
+			})
+		})
+
+		Convey("When a margin or percentage is not recognised", func() {
+			margins := p.get("/v6/query/Small?v=region&margins=diagonal", nil)
+			percent := p.get("/v6/query/Small?v=region&percent=median", nil)
+
+			Convey("Then a 400 is returned", func() {
+				So(margins.Code, ShouldEqual, http.StatusBadRequest)
+				So(percent.Code, ShouldEqual, http.StatusBadRequest)
+			})
+		})
+
+		Convey("And disclosure control that suppresses the small count", func() {
+			p.app.Disclosure = &disclosure.RuleSet{Default: &disclosure.Rules{Threshold: 20}}
+
+			Convey("When the table is requested as CSV", func() {
+				w := p.get("/v6/query/Small?v=region&v=sex&margins=row&percent=row", map[string]string{"Accept": "text/csv"})
+
+				Convey("Then the suppressed count and its percentage are left blank", func() {
+					So(w.Code, ShouldEqual, http.StatusOK)
+					So(w.Header().Get("Content-Type"), ShouldEqual, "text/csv")
+					So(w.Body.String(), ShouldEqual, strings.Join([]string{
+						"region_code,region_label,sex_code,sex_label,count,status,percentage_of_row",
+						"E12000001,North East,1,Male,,c,",
+						"E12000001,North East,2,Female,24,,63.16",
+						"E12000001,North East,total,Total,38,,100",
+						"E12000002,North West,1,Male,24,,50",
+						"E12000002,North West,2,Female,24,,50",
+						"E12000002,North West,total,Total,48,,100",
+						"",
+					}, "\n"))
+				})
+			})
+
+			Convey("When the table is requested as JSON-stat", func() {
+				w := p.get("/v6/query/Small?v=region&v=sex&percent=column&format=jsonstat", nil)
+
+				Convey("Then the suppressed count is null with its marker", func() {
+					So(w.Code, ShouldEqual, http.StatusOK)
+
+					var ds struct {
+						Version string
+						ID      []string
+						Size    []int
+						Value   []*float64
+						Status  map[string]string
+					}
+					decode(w, &ds)
+					So(ds.Version, ShouldEqual, "2.0")
+					So(ds.ID, ShouldResemble, []string{"region", "sex", "measure"})
+					So(ds.Size, ShouldResemble, []int{2, 2, 2})
+					So(ds.Value, ShouldHaveLength, 8)
+					So(ds.Value[0], ShouldBeNil)
+					So(ds.Value[1], ShouldBeNil)
+					So(*ds.Value[2], ShouldEqual, 24)
+					So(*ds.Value[3], ShouldEqual, 50)
+					So(ds.Value[5], ShouldBeNil)
+					So(ds.Status, ShouldResemble, map[string]string{"0": "c", "1": "c"})
+				})
+			})
+		})
+	})
+}
+
+func TestCompare(t *testing.T) {
+	Convey("Given two versions of a dataset where a local authority has been replaced", t, func() {
+		p := newProxy(0)
+		defer p.close()
+
+		before := fake.Example()
+		before.Codebook.Dataset.Name = "Before"
+		before.Count = func(map[string]string) int { return 10 }
+
+		after := fake.Example()
+		after.Codebook.Dataset.Name = "After"
+		after.Count = func(map[string]string) int { return 12 }
+		la := &after.Codebook.CodeBook[2]
+		la.Codes = []string{"E06000001", "E06000002", "E06000008", "E06000010"}
+		la.Labels = []string{"Hartlepool", "Middlesbrough", "Blackburn with Darwen", "Kingston upon Hull"}
+
+		p.ftb.AddDataset(before)
+		p.ftb.AddDataset(after)
+
+		Convey("When the same variables are compared", func() {
+			w := p.get("/v6/compare?left=Before&right=After&v=la", nil)
+
+			Convey("Then counts are aligned by code with their differences", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+
+				var c cantabular.Comparison
+				decode(w, &c)
+				So(c.Left, ShouldEqual, "Before")
+				So(c.Right, ShouldEqual, "After")
+				So(c.Dimensions[0].Codes, ShouldResemble, []string{"E06000001", "E06000002", "E06000008", "E06000009", "E06000010"})
+				So(c.Dimensions[0].Presence, ShouldResemble, []string{"both", "both", "both", "left", "right"})
+
+				So(*c.LeftCounts[0], ShouldEqual, 20)
+				So(*c.RightCounts[0], ShouldEqual, 24)
+				So(*c.Differences[0], ShouldEqual, 4)
+				So(*c.PercentageDifferences[0], ShouldEqual, 20)
+
+				Convey("And categories found in one dataset only have no difference", func() {
+					So(*c.LeftCounts[3], ShouldEqual, 20)
+					So(c.RightCounts[3], ShouldBeNil)
+					So(c.Differences[3], ShouldBeNil)
+					So(c.LeftCounts[4], ShouldBeNil)
+					So(*c.RightCounts[4], ShouldEqual, 24)
+					So(c.PercentageDifferences[4], ShouldBeNil)
+				})
+			})
+		})
+
+		Convey("When a variable is missing from one of the datasets", func() {
+			w := p.get("/v6/compare?left=Before&right=After&v=ethnicity", nil)
+
+			Convey("Then a 400 is returned", func() {
+				So(w.Code, ShouldEqual, http.StatusBadRequest)
+			})
+		})
+
+		Convey("When a dataset is not given", func() {
+			w := p.get("/v6/compare?left=Before&v=la", nil)
+
+			Convey("Then a 400 is returned", func() {
+				So(w.Code, ShouldEqual, http.StatusBadRequest)
+			})
+		})
+	})
+}
+
+func TestCodebookDiff(t *testing.T) {
+	Convey("Given a proxy that has seen a dataset codebook", t, func() {
+		p := newProxy(0)
+		defer p.close()
+
+		So(p.get("/v6/datasets/Example/dimensions", nil).Code, ShouldEqual, http.StatusOK)
+
+		Convey("When the FTB is reloaded with a changed codebook", func() {
+			reloaded := fake.Example()
+			cb := reloaded.Codebook
+			cb.Dataset.Digest = "example-digest-2"
+
+			region := &cb.CodeBook[1]
+			region.Codes = append(region.Codes, "E12000003")
+			region.Labels = append(region.Labels, "Yorkshire and The Humber")
+			region.MapFromCodes = []string{"E12000001", "", "", "E12000002"}
+
+			cb.CodeBook[2].Labels[0] = "Hartlepool UA"
+			cb.CodeBook[3] = cantabular.Dimension{Name: "age", Label: "Age", Codes: []string{"1", "2"}, Labels: []string{"0 to 15", "16 and over"}}
+			p.ftb.AddDataset(reloaded)
+
+			So(p.get("/v6/datasets/Example/dimensions", nil).Code, ShouldEqual, http.StatusOK)
+
+			Convey("Then the diff from the previous digest lists what changed", func() {
+				w := p.get("/v6/datasets/Example/codebook/diff", nil)
+				So(w.Code, ShouldEqual, http.StatusOK)
+
+				var diff cantabular.CodebookDiff
+				decode(w, &diff)
+				So(diff.From, ShouldEqual, "example-digest-1")
+				So(diff.To, ShouldEqual, "example-digest-2")
+				So(diff.AddedDimensions, ShouldResemble, []string{"age"})
+				So(diff.RemovedDimensions, ShouldResemble, []string{"sex"})
+				So(diff.ChangedDimensions, ShouldResemble, []*cantabular.DimensionDiff{
+					{
+						Name:       "region",
+						AddedCodes: []string{"E12000003"},
+						MapFrom: &cantabular.MapFromChange{
+							From:            []string{"la"},
+							To:              []string{"la"},
+							ChangedBranches: []string{"la"},
+						},
+					},
+					{
+						Name:       "la",
+						Relabelled: []*cantabular.CodeRelabel{{Code: "E06000001", From: "Hartlepool", To: "Hartlepool UA"}},
+					},
+				})
+			})
+
+			Convey("Then the diff between explicit digests can be reversed", func() {
+				w := p.get("/v6/datasets/Example/codebook/diff?from=example-digest-2&to=example-digest-1", nil)
+				So(w.Code, ShouldEqual, http.StatusOK)
+
+				var diff cantabular.CodebookDiff
+				decode(w, &diff)
+				So(diff.AddedDimensions, ShouldResemble, []string{"sex"})
+				So(diff.RemovedDimensions, ShouldResemble, []string{"age"})
+			})
+
+			Convey("Then an unknown digest is not found", func() {
+				w := p.get("/v6/datasets/Example/codebook/diff?from=example-digest-0", nil)
+				So(w.Code, ShouldEqual, http.StatusNotFound)
+			})
+		})
+
+		Convey("When there is no earlier version", func() {
+			w := p.get("/v6/datasets/Example/codebook/diff", nil)
+
+			Convey("Then a 404 is returned", func() {
+				So(w.Code, ShouldEqual, http.StatusNotFound)
+			})
+		})
+	})
+}
+
+func TestCMDDatasets(t *testing.T) {
+	Convey("Given a proxy in front of a flexible table builder", t, func() {
+		p := newProxy(0)
+		defer p.close()
+
+		Convey("When the datasets are listed", func() {
+			w := p.get("/datasets", nil)
+
+			Convey("Then they are returned in the shape of the dataset API", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+
+				var list struct {
+					Items      []dataset.DatasetDetails `json:"items"`
+					TotalCount int                      `json:"total_count"`
+				}
+				decode(w, &list)
+				So(list.TotalCount, ShouldBeGreaterThan, 0)
+
+				var example *dataset.DatasetDetails
+				for i := range list.Items {
+					if list.Items[i].ID == "Example" {
+						example = &list.Items[i]
+					}
+				}
+				So(example, ShouldNotBeNil)
+				So(example.Description, ShouldEqual, "Example dataset for testing")
+				So(example.Links.Editions.URL, ShouldEndWith, "/datasets/Example/editions")
+			})
+		})
+
+		Convey("When the first version is requested", func() {
+			w := p.get("/datasets/Example/editions/2021/versions/1", nil)
+
+			Convey("Then it is built from the current codebook", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(w.Header().Get("ETag"), ShouldNotBeEmpty)
+
+				var version dataset.Version
+				decode(w, &version)
+				So(version.ID, ShouldEqual, "example-digest-1")
+				So(version.Version, ShouldEqual, 1)
+				So(version.NumberOfObservations, ShouldEqual, 1000)
+				So(version.Dimensions, ShouldHaveLength, 4)
+				So(version.Dimensions[2].Links.CodeList.URL, ShouldEndWith, "/v6/datasets/Example/dimensions/la/codes")
+				So(version.Downloads["csv"].URL, ShouldContainSubstring, "/v6/query/Example?")
+				So(version.Downloads["csv"].URL, ShouldContainSubstring, "v=la")
+				So(version.Downloads["csv"].URL, ShouldNotContainSubstring, "v=region")
+			})
+		})
+
+		Convey("When the options of a dimension are requested", func() {
+			w := p.get("/datasets/Example/editions/2021/versions/1/dimensions/region/options", nil)
+
+			Convey("Then each code is returned", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+
+				var list struct {
+					Items []dataset.Option `json:"items"`
+				}
+				decode(w, &list)
+				So(list.Items, ShouldHaveLength, 2)
+				So(list.Items[0].Option, ShouldEqual, "E12000001")
+				So(list.Items[0].Label, ShouldEqual, "North East")
+			})
+		})
+
+		Convey("When an unknown edition or version is requested", func() {
+			Convey("Then a 404 is returned", func() {
+				So(p.get("/datasets/Example/editions/2011", nil).Code, ShouldEqual, http.StatusNotFound)
+				So(p.get("/datasets/Example/editions/2021/versions/2", nil).Code, ShouldEqual, http.StatusNotFound)
+				So(p.get("/datasets/Example/editions/2021/versions/x", nil).Code, ShouldEqual, http.StatusNotFound)
+			})
+		})
+
+		Convey("When the codebook changes", func() {
+			So(p.get("/datasets/Example", nil).Code, ShouldEqual, http.StatusOK)
+
+			reloaded := fake.Example()
+			reloaded.Codebook.Dataset.Digest = "example-digest-2"
+			reloaded.Codebook.CodeBook[3] = cantabular.Dimension{Name: "age", Label: "Age", Codes: []string{"1", "2"}, Labels: []string{"0 to 15", "16 and over"}}
+			p.ftb.AddDataset(reloaded)
+
+			So(p.get("/datasets/Example", nil).Code, ShouldEqual, http.StatusOK)
+
+			Convey("Then the new digest is the latest version", func() {
+				w := p.get("/datasets/Example/editions/2021/versions", nil)
+				So(w.Code, ShouldEqual, http.StatusOK)
+
+				var list struct {
+					Items []dataset.Version `json:"items"`
+				}
+				decode(w, &list)
+				So(list.Items, ShouldHaveLength, 2)
+				So(list.Items[0].ID, ShouldEqual, "example-digest-1")
+				So(list.Items[1].ID, ShouldEqual, "example-digest-2")
+				So(list.Items[0].Links.LatestVersion.ID, ShouldEqual, "2")
+			})
+
+			Convey("Then the first version keeps its dimensions", func() {
+				w := p.get("/datasets/Example/editions/2021/versions/1/dimensions", nil)
+				So(w.Code, ShouldEqual, http.StatusOK)
+
+				var dims dataset.VersionDimensions
+				decode(w, &dims)
+				So(dims.Items[3].Name, ShouldEqual, "sex")
+			})
+
+			Convey("Then the edition links to the latest version", func() {
+				w := p.get("/datasets/Example/editions/2021", nil)
+				So(w.Code, ShouldEqual, http.StatusOK)
+
+				var edition dataset.Edition
+				decode(w, &edition)
+				So(edition.Links.LatestVersion.URL, ShouldEndWith, "/datasets/Example/editions/2021/versions/2")
+			})
+		})
+	})
+}
+
+func TestCodeLists(t *testing.T) {
+	Convey("Given a proxy in front of a flexible table builder with two datasets", t, func() {
+		p := newProxy(0)
+		defer p.close()
+
+		p.ftb.AddDataset(&fake.Dataset{Codebook: &cantabular.Codebook{
+			Dataset: cantabular.Dataset{Name: "Wales", Size: 10, Digest: "wales-digest-1"},
+			CodeBook: []cantabular.Dimension{
+				{Name: "la", Label: "Local authority", Codes: []string{"W06000001"}, Labels: []string{"Isle of Anglesey"}},
+				{Name: "sex", Label: "Sex", Codes: []string{"1", "2"}, Labels: []string{"Male", "Female"}},
+			},
+		}})
+
+		Convey("When the code lists are requested", func() {
+			w := p.get("/code-lists", nil)
+
+			Convey("Then each census variable is a code list", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+
+				var lists models.CodeListResults
+				decode(w, &lists)
+				So(lists.TotalCount, ShouldEqual, 4)
+				So(lists.Items[2].Links.Self.ID, ShouldEqual, "la")
+				So(lists.Items[2].Links.Editions.Href, ShouldEqual, "http://127.0.0.1:10100/code-lists/la/editions")
+			})
+		})
+
+		Convey("When the codes of a code list are requested", func() {
+			w := p.get("/code-lists/la/editions/2021/codes", nil)
+
+			Convey("Then the codes of every dataset are returned with links", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+
+				var codes models.CodeResults
+				decode(w, &codes)
+				So(codes.TotalCount, ShouldEqual, 5)
+				So(codes.Items[4].ID, ShouldEqual, "W06000001")
+				So(codes.Items[4].Label, ShouldEqual, "Isle of Anglesey")
+				So(codes.Items[4].Links.Self.Href, ShouldEqual, "http://127.0.0.1:10100/code-lists/la/editions/2021/codes/W06000001")
+				So(codes.Items[4].Links.Datasets.Href, ShouldEndWith, "/codes/W06000001/datasets")
+			})
+		})
+
+		Convey("When a code is requested", func() {
+			w := p.get("/code-lists/la/editions/2021/codes/E06000001", nil)
+
+			Convey("Then it is returned", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(w.Header().Get("ETag"), ShouldNotBeEmpty)
+
+				var code models.Code
+				decode(w, &code)
+				So(code.Label, ShouldEqual, "Hartlepool")
+				So(code.Links.CodeList.Href, ShouldEndWith, "/code-lists/la")
+			})
+		})
+
+		Convey("When the datasets using a code are requested", func() {
+			Convey("Then every dataset with the code is listed", func() {
+				w := p.get("/code-lists/sex/editions/2021/codes/1/datasets", nil)
+				So(w.Code, ShouldEqual, http.StatusOK)
+
+				var datasets models.Datasets
+				decode(w, &datasets)
+				So(datasets.TotalCount, ShouldEqual, 2)
+				So(datasets.Items[0].ID, ShouldEqual, "Example")
+				So(datasets.Items[1].ID, ShouldEqual, "Wales")
+			})
+
+			Convey("Then only the datasets with the code are listed, linking to the dataset API", func() {
+				w := p.get("/code-lists/la/editions/2021/codes/W06000001/datasets", nil)
+				So(w.Code, ShouldEqual, http.StatusOK)
+
+				var datasets models.Datasets
+				decode(w, &datasets)
+				So(datasets.Items, ShouldHaveLength, 1)
+				So(datasets.Items[0].DimensionLabel, ShouldEqual, "Local authority")
+				So(datasets.Items[0].Links.Self.Href, ShouldEqual, "http://127.0.0.1:10100/datasets/Wales")
+
+				edition := datasets.Items[0].Editions[0]
+				So(edition.LatestVersion, ShouldEqual, 1)
+				So(edition.Links.LatestVersion.Href, ShouldEndWith, "/datasets/Wales/editions/2021/versions/1")
+				So(edition.Links.DatasetDimension.Href, ShouldEndWith, "/datasets/Wales/editions/2021/versions/1/dimensions/la")
+				So(p.get(strings.TrimPrefix(edition.Links.DatasetDimension.Href, "http://127.0.0.1:10100")+"/options", nil).Code, ShouldEqual, http.StatusOK)
+			})
+		})
+
+		Convey("When the codes of a dataset dimension are requested", func() {
+			w := p.get("/v6/datasets/Example/dimensions/la/codes", nil)
+
+			Convey("Then they link to the code list", func() {
+				var codes models.CodeResults
+				decode(w, &codes)
+				So(codes.Items[0].Links.Self.Href, ShouldEndWith, "/code-lists/la/editions/2021/codes/E06000001")
+			})
+		})
+
+		Convey("When an unknown code list, edition or code is requested", func() {
+			Convey("Then a 404 is returned", func() {
+				So(p.get("/code-lists/unknown", nil).Code, ShouldEqual, http.StatusNotFound)
+				So(p.get("/code-lists/la/editions/2011/codes", nil).Code, ShouldEqual, http.StatusNotFound)
+				So(p.get("/code-lists/la/editions/2021/codes/X", nil).Code, ShouldEqual, http.StatusNotFound)
+				So(p.get("/code-lists/la/editions/2021/codes/X/datasets", nil).Code, ShouldEqual, http.StatusNotFound)
+			})
+		})
+	})
+}
+
+func TestCompression(t *testing.T) {
+	Convey("Given a proxy in front of a flexible table builder", t, func() {
+		p := newProxy(0)
+		defer p.close()
+
+		Convey("When a client accepts gzip", func() {
+			w := p.get("/v6/datasets/Example/dimensions/la/codes", map[string]string{"Accept-Encoding": "gzip"})
+
+			Convey("Then the response is compressed", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(w.Header().Get("Content-Encoding"), ShouldEqual, "gzip")
+
+				gz, err := gzip.NewReader(w.Body)
+				So(err, ShouldBeNil)
+
+				var codes models.CodeResults
+				So(json.NewDecoder(gz).Decode(&codes), ShouldBeNil)
+				So(codes.TotalCount, ShouldEqual, 4)
+			})
+
+			Convey("Then revalidating it returns the same ETag with a 304", func() {
+				etag := w.Header().Get("ETag")
+				So(etag, ShouldEndWith, `-gzip"`)
+
+				repeat := p.get("/v6/datasets/Example/dimensions/la/codes", map[string]string{"Accept-Encoding": "gzip", "If-None-Match": etag})
+				So(repeat.Code, ShouldEqual, http.StatusNotModified)
+				So(repeat.Header().Get("ETag"), ShouldEqual, etag)
+			})
+		})
+	})
+}
+
+func TestUpstreamFailures(t *testing.T) {
+	Convey("Given a proxy in front of a flexible table builder", t, func() {
+		p := newProxy(0)
+		defer p.close()
+
+		Convey("When the FTB fails before a codebook has been seen", func() {
+			p.ftb.Inject(fake.Fault{PathPrefix: "/v6/codebook", Status: http.StatusServiceUnavailable})
+			w := p.get("/v6/datasets/Example/dimensions", nil)
+
+			Convey("Then an internal server error is returned", func() {
+				So(w.Code, ShouldEqual, http.StatusInternalServerError)
+			})
+		})
+
+		Convey("When the FTB fails after a codebook has been seen", func() {
+			So(p.get("/v6/datasets/Example/dimensions", nil).Code, ShouldEqual, http.StatusOK)
+
+			p.ftb.Inject(fake.Fault{PathPrefix: "/v6/codebook", Drop: true})
+			w := p.get("/v6/datasets/Example/dimensions", nil)
+
+			Convey("Then the snapshot is served with a warning", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(w.Header().Get("Warning"), ShouldStartWith, "110")
+			})
+		})
+
+		Convey("When the FTB fails after a codebook has been seen through /v6/codebook", func() {
+			So(p.get("/v6/codebook/Example", nil).Code, ShouldEqual, http.StatusOK)
+
+			p.ftb.Inject(fake.Fault{PathPrefix: "/v6/codebook", Drop: true})
+			w := p.get("/v6/codebook/Example", nil)
+
+			Convey("Then the snapshot is served with a warning", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(w.Header().Get("Warning"), ShouldStartWith, "110")
+
+				var codebook cantabular.Codebook
+				decode(w, &codebook)
+				So(codebook.Dataset.Name, ShouldEqual, "Example")
+				So(codebook.CodeBook, ShouldNotBeEmpty)
+			})
+		})
+
+		Convey("When a caller gives up on refreshing a codebook that has been seen", func() {
+			p.codebooks.TTL = time.Hour
+			So(p.get("/v6/datasets/Example/dimensions", nil).Code, ShouldEqual, http.StatusOK)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			_, err := p.codebooks.Refresh(ctx, "Example")
+
+			Convey("Then the cached codebook is not marked stale", func() {
+				So(err, ShouldEqual, context.Canceled)
+
+				w := p.get("/v6/datasets/Example/dimensions", nil)
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(w.Header().Get("Warning"), ShouldBeEmpty)
+			})
+		})
+
+		Convey("When the FTB returns a client error once", func() {
+			p.ftb.Inject(fake.Fault{PathPrefix: "/v6/codebook", Status: http.StatusBadRequest, Body: "bad dataset", Times: 1})
+			first := p.get("/v6/datasets/Example/dimensions", nil)
+			second := p.get("/v6/datasets/Example/dimensions", nil)
+
+			Convey("Then only the first request fails", func() {
+				So(first.Code, ShouldEqual, http.StatusBadRequest)
+				So(second.Code, ShouldEqual, http.StatusOK)
+			})
+		})
+
+		Convey("When the FTB is slower than the caller is willing to wait", func() {
+			p.ftb.Inject(fake.Fault{PathPrefix: "/v6/codebook", Latency: time.Second})
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			r := httptest.NewRequest(http.MethodGet, "/v6/datasets/Example/dimensions", nil).WithContext(ctx)
+			r.Header.Set("Authorization", testToken)
+			w := httptest.NewRecorder()
+			p.handler.ServeHTTP(w, r)
+
+			Convey("Then the request fails rather than hanging", func() {
+				So(w.Code, ShouldEqual, http.StatusInternalServerError)
+			})
+		})
+	})
+}
+
+func TestRefresher(t *testing.T) {
+	Convey("Given a proxy with a refresher", t, func() {
+		p := newProxy(time.Hour)
+		defer p.close()
+
+		refresher := &store.Refresher{
+			Codebooks:   p.codebooks,
+			Lister:      &cantabular.Client{Host: p.ftb.URL, HttpCli: dphttp.NewClient()},
+			Concurrency: 2,
+		}
+
+		Convey("When the cache is warmed", func() {
+			refresher.RefreshAll(context.Background())
+
+			Convey("Then requests are answered without fetching the codebook again", func() {
+				So(p.ftb.Requests("/v6/codebook/Example"), ShouldEqual, 1)
+				So(p.get("/v6/datasets/Example/dimensions", nil).Code, ShouldEqual, http.StatusOK)
+				So(p.ftb.Requests("/v6/codebook/Example"), ShouldEqual, 1)
+			})
+		})
+
+		Convey("And a list of datasets to prefetch", func() {
+			other := fake.Example()
+			other.Codebook.Dataset.Name = "Other"
+			p.ftb.AddDataset(other)
+			refresher.Datasets = []string{"Other"}
+
+			Convey("When a dataset not on the list has been cached by a request", func() {
+				So(p.get("/v6/datasets/Example/dimensions", nil).Code, ShouldEqual, http.StatusOK)
+				refresher.RefreshAll(context.Background())
+
+				Convey("Then it is refreshed along with those listed", func() {
+					So(p.ftb.Requests("/v6/codebook/Other"), ShouldEqual, 1)
+					So(p.ftb.Requests("/v6/codebook/Example"), ShouldEqual, 2)
+				})
+			})
+		})
+	})
+}
+
+func TestOpenAPI(t *testing.T) {
+	Convey("Given a proxy in front of a flexible table builder", t, func() {
+		p := newProxy(0)
+		defer p.close()
+
+		Convey("When the OpenAPI document is requested without a token", func() {
+			r := httptest.NewRequest(http.MethodGet, "/v6/openapi.json", nil)
+			w := httptest.NewRecorder()
+			p.handler.ServeHTTP(w, r)
+
+			Convey("Then it is returned", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(p.responseViolations(), ShouldBeEmpty)
+
+				var doc openapi.Document
+				decode(w, &doc)
+				So(doc.OpenAPI, ShouldEqual, openapi.Version)
+				So(doc.Components.Schemas, ShouldContainKey, "DimensionResponse")
+				So(doc.Components.Schemas, ShouldContainKey, "GetDimensionsResponse")
+				So(doc.Components.Schemas, ShouldContainKey, "Response")
+				So(doc.Components.Schemas, ShouldContainKey, "SimpleEntity")
+			})
+		})
+
+		Convey("Then every named route is documented", func() {
+			err := p.app.Router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+				if route.GetName() == "" {
+					return nil
+				}
+				path, err := route.GetPathTemplate()
+				So(err, ShouldBeNil)
+				So(p.app.Spec.Paths, ShouldContainKey, path)
+				return nil
+			})
+			So(err, ShouldBeNil)
+		})
+
+		Convey("When the Swagger UI is requested", func() {
+			w := p.get("/v6/docs", nil)
+
+			Convey("Then a page loading the document is returned", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(w.Header().Get("Content-Type"), ShouldEqual, "text/html")
+				So(w.Body.String(), ShouldContainSubstring, "swagger-ui-bundle.js")
+				So(w.Body.String(), ShouldContainSubstring, "/v6/openapi.json")
+			})
+		})
+
+		Convey("When the Swagger UI is requested with integrity hashes configured", func() {
+			p.app.Config.SwaggerUIURL = "https://unpkg.com/swagger-ui-dist@3.52.5/"
+			p.app.Config.SwaggerUICSSIntegrity = "sha384-css"
+			p.app.Config.SwaggerUIJSIntegrity = "sha384-js"
+			w := p.get("/v6/docs", nil)
+
+			Convey("Then the browser is told to check the assets against them", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(w.Body.String(), ShouldContainSubstring, `href="https://unpkg.com/swagger-ui-dist@3.52.5/swagger-ui.css" integrity="sha384-css" crossorigin="anonymous"`)
+				So(w.Body.String(), ShouldContainSubstring, `src="https://unpkg.com/swagger-ui-dist@3.52.5/swagger-ui-bundle.js" integrity="sha384-js" crossorigin="anonymous"`)
+			})
+		})
+
+		Convey("When a request is missing a required parameter", func() {
+			r := httptest.NewRequest(http.MethodGet, "/v6/compare?left=Before&v=la", nil)
+			r.Header.Set("Authorization", testToken)
+			p.handler.ServeHTTP(httptest.NewRecorder(), r)
+
+			Convey("Then the validator reports it", func() {
+				So(p.violations, ShouldHaveLength, 1)
+
+				var reqErr *openapi.RequestError
+				So(errors.As(p.violations[0], &reqErr), ShouldBeTrue)
+				So(reqErr.Reason, ShouldEqual, "missing required query parameter right")
+			})
+		})
+
+		Convey("When a response does not match its schema", func() {
+			r := httptest.NewRequest(http.MethodGet, "/v6/datasets/Example/dimensions/region", nil)
+			r.Header.Set("Authorization", testToken)
+			op, err := p.app.Spec.ValidateRequest(r)
+			So(err, ShouldBeNil)
+
+			header := http.Header{"Content-Type": []string{"application/json"}}
+			err = p.app.Spec.ValidateResponse(r, op, http.StatusOK, header, []byte(`{"dimension": {"name": 1}}`))
+
+			Convey("Then the validator reports it", func() {
+				var respErr *openapi.ResponseError
+				So(errors.As(err, &respErr), ShouldBeTrue)
+				So(respErr.Status, ShouldEqual, http.StatusOK)
+			})
+		})
+	})
+}
+
+// graphQL posts a query to /graphql, decoding its data into data and
+// returning the messages of any errors
+func (p *proxy) graphQL(query string, variables map[string]interface{}, data interface{}) (*httptest.ResponseRecorder, []string) {
+	w := p.post("/graphql", map[string]interface{}{"query": query, "variables": variables})
+
+	var result struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	So(json.Unmarshal(w.Body.Bytes(), &result), ShouldBeNil)
+
+	if data != nil && len(result.Data) > 0 {
+		So(json.Unmarshal(result.Data, data), ShouldBeNil)
+	}
+
+	messages := make([]string, 0)
+	for _, e := range result.Errors {
+		messages = append(messages, e.Message)
+	}
+	return w, messages
+}
+
+func TestGraphQL(t *testing.T) {
+	Convey("Given a proxy in front of a flexible table builder", t, func() {
+		p := newProxy(0)
+		defer p.close()
+
+		type code struct {
+			Code       string
+			Label      string
+			ChildCount int
+			Parent     *code
+			Children   []code
+		}
+
+		Convey("When the dimensions and codes of a dataset are queried together", func() {
+			var data struct {
+				Dataset struct {
+					Digest     string
+					Dimensions []struct {
+						Name      string
+						CodeCount int
+					}
+					Dimension struct {
+						Codes []code
+					}
+				}
+			}
+			w, errs := p.graphQL(`{
+				dataset(name: "Example") {
+					digest
+					dimensions { name codeCount }
+					dimension(name: "region") {
+						codes { code label childCount parent { code label } }
+					}
+				}
+			}`, nil, &data)
+
+			Convey("Then they are answered from one read of the codebook", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(errs, ShouldBeEmpty)
+				So(p.ftb.Requests("/v6/codebook/Example"), ShouldEqual, 1)
+
+				So(data.Dataset.Digest, ShouldEqual, "example-digest-1")
+				So(data.Dataset.Dimensions, ShouldHaveLength, 4)
+				So(data.Dataset.Dimensions[2].Name, ShouldEqual, "la")
+				So(data.Dataset.Dimensions[2].CodeCount, ShouldEqual, 4)
+
+				regions := data.Dataset.Dimension.Codes
+				So(regions, ShouldHaveLength, 2)
+				So(regions[0].Label, ShouldEqual, "North East")
+				So(regions[0].ChildCount, ShouldEqual, 2)
+				So(regions[0].Parent, ShouldResemble, &code{Code: "E92000001", Label: "England"})
+			})
+		})
+
+		Convey("When a hierarchy is walked through a branch", func() {
+			var data struct {
+				Dataset struct {
+					Hierarchy struct {
+						Branch   string
+						Branches []string
+						Codes    []code
+					}
+				}
+			}
+			_, errs := p.graphQL(`query($branch: String) {
+				dataset(name: "Example") {
+					hierarchy(dimension: "country", branch: $branch) {
+						branch branches
+						codes { code children { code label } }
+					}
+				}
+			}`, map[string]interface{}{"branch": "la"}, &data)
+
+			Convey("Then the children are found in that branch", func() {
+				So(errs, ShouldBeEmpty)
+
+				h := data.Dataset.Hierarchy
+				So(h.Branch, ShouldEqual, "la")
+				So(h.Branches, ShouldResemble, []string{"region", "la"})
+				So(h.Codes, ShouldHaveLength, 1)
+				So(h.Codes[0].Children, ShouldHaveLength, 4)
+				So(h.Codes[0].Children[3], ShouldResemble, code{Code: "E06000009", Label: "Blackpool"})
+			})
+		})
+
+		Convey("When a hierarchy is asked for through a dimension it does not map from", func() {
+			var data struct {
+				Dataset struct {
+					Hierarchy *struct{ Branch string }
+				}
+			}
+			w, errs := p.graphQL(`{ dataset(name: "Example") { hierarchy(dimension: "country", branch: "sex") { branch } } }`, nil, &data)
+
+			Convey("Then the field is null with an error naming the branches", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(data.Dataset.Hierarchy, ShouldBeNil)
+				So(errs, ShouldHaveLength, 1)
+				So(errs[0], ShouldContainSubstring, "available branches: region, la")
+			})
+		})
+
+		Convey("When a table is queried", func() {
+			var data struct {
+				Dataset struct {
+					Table struct {
+						Dimensions []struct{ Name string }
+						Counts     []int
+					}
+				}
+			}
+			_, errs := p.graphQL(`{
+				dataset(name: "Example") {
+					table(variables: ["la", "sex"], aggregate: ["region"]) { dimensions { name } counts }
+				}
+			}`, nil, &data)
+
+			Convey("Then it is aggregated as it would be by the query route", func() {
+				So(errs, ShouldBeEmpty)
+				So(data.Dataset.Table.Dimensions, ShouldHaveLength, 2)
+				So(data.Dataset.Table.Counts, ShouldHaveLength, 12)
+			})
+		})
+
+		Convey("When a dataset that does not exist is queried", func() {
+			var data struct {
+				Dataset *struct{ Name string }
+			}
+			w, errs := p.graphQL(`{ dataset(name: "Missing") { name } }`, nil, &data)
+
+			Convey("Then it is null", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(errs, ShouldBeEmpty)
+				So(data.Dataset, ShouldBeNil)
+			})
+		})
+
+		Convey("When a query is made with GET", func() {
+			w := p.get("/graphql?query="+url.QueryEscape(`{ datasets { name } }`), nil)
+
+			Convey("Then the datasets are listed", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+				So(w.Body.String(), ShouldContainSubstring, `{"name":"Example"}`)
+			})
+		})
+
+		Convey("When a query asks for a field that is not in the schema", func() {
+			w, errs := p.graphQL(`{ dataset(name: "Example") { rows } }`, nil, nil)
+
+			Convey("Then it is rejected before it is run", func() {
+				So(w.Code, ShouldEqual, http.StatusBadRequest)
+				So(errs, ShouldHaveLength, 1)
+				So(p.ftb.Requests("/v6/codebook/Example"), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When a query is deeper than the limit", func() {
+			p.app.Config.GraphQLMaxDepth = 4
+			w, errs := p.graphQL(`{
+				dataset(name: "Example") {
+					dimension(name: "country") { code(code: "E92000001") { children { children { code } } } }
+				}
+			}`, nil, nil)
+
+			Convey("Then it is rejected before it is run", func() {
+				So(w.Code, ShouldEqual, http.StatusBadRequest)
+				So(errs, ShouldResemble, []string{"query depth 6 exceeds the limit of 4"})
+				So(p.ftb.Requests("/v6/codebook/Example"), ShouldEqual, 0)
+			})
+		})
+
+		Convey("And a dataset with more codes than a list is assumed to hold", func() {
+			wide := &fake.Dataset{Codebook: &cantabular.Codebook{
+				Dataset: cantabular.Dataset{Name: "Wide", Digest: "wide-digest-1"},
+				CodeBook: []cantabular.Dimension{
+					{Name: "country", Label: "Country", Codes: []string{"E92000001"}, Labels: []string{"England"}, MapFrom: []string{"la"}, MapFromCodes: make([]string, 30)},
+					{Name: "la", Label: "Local Authority", Codes: make([]string, 30), Labels: make([]string, 30)},
+				},
+			}}
+			wide.Codebook.CodeBook[0].MapFromCodes[0] = "E92000001"
+			for i := range wide.Codebook.CodeBook[1].Codes {
+				wide.Codebook.CodeBook[1].Codes[i] = fmt.Sprintf("E060000%02d", i)
+			}
+			p.ftb.AddDataset(wide)
+
+			type codes struct {
+				Codes []struct {
+					Code     string
+					Children []struct{ Code string }
+				}
+			}
+
+			Convey("When its lists are asked for without a limit", func() {
+				var data struct {
+					Dataset struct {
+						Dimension codes
+						Hierarchy codes
+					}
+				}
+				w, errs := p.graphQL(`{
+					dataset(name: "Wide") {
+						dimension(name: "la") { codes { code } }
+						hierarchy(dimension: "country") { codes { code children { code } } }
+					}
+				}`, nil, &data)
+
+				Convey("Then each returns as many codes as its complexity was worked out with", func() {
+					So(w.Code, ShouldEqual, http.StatusOK)
+					So(errs, ShouldBeEmpty)
+					So(data.Dataset.Dimension.Codes, ShouldHaveLength, 20)
+					So(data.Dataset.Hierarchy.Codes, ShouldHaveLength, 1)
+					So(data.Dataset.Hierarchy.Codes[0].Children, ShouldHaveLength, 20)
+				})
+			})
+
+			Convey("When its lists are paged through", func() {
+				var data struct {
+					Dataset struct {
+						Hierarchy codes
+					}
+				}
+				w, errs := p.graphQL(`{
+					dataset(name: "Wide") {
+						hierarchy(dimension: "country") { codes(limit: 5000) { children(offset: 20, limit: 50) { code } } }
+					}
+				}`, nil, &data)
+
+				Convey("Then the rest of the codes are returned", func() {
+					So(w.Code, ShouldEqual, http.StatusOK)
+					So(errs, ShouldBeEmpty)
+					So(data.Dataset.Hierarchy.Codes, ShouldHaveLength, 1)
+					So(data.Dataset.Hierarchy.Codes[0].Children, ShouldHaveLength, 10)
+					So(data.Dataset.Hierarchy.Codes[0].Children[0].Code, ShouldEqual, "E06000020")
+				})
+			})
+		})
+
+		Convey("And a dataset with more dimensions than a list is assumed to hold", func() {
+			many := &fake.Dataset{Codebook: &cantabular.Codebook{
+				Dataset:  cantabular.Dataset{Name: "Many", Digest: "many-digest-1"},
+				CodeBook: make([]cantabular.Dimension, 30),
+			}}
+			for i := range many.Codebook.CodeBook {
+				many.Codebook.CodeBook[i] = cantabular.Dimension{Name: fmt.Sprintf("dim%02d", i), Codes: []string{"1"}}
+			}
+			p.ftb.AddDataset(many)
+
+			var data struct {
+				Datasets []struct{ Name string }
+				Dataset  struct {
+					Dimensions []struct{ Name string }
+				}
+			}
+
+			Convey("When its dimensions are paged through", func() {
+				w, errs := p.graphQL(`{
+					datasets(limit: 1) { name }
+					dataset(name: "Many") { dimensions(offset: 25) { name } }
+				}`, nil, &data)
+
+				Convey("Then only the page asked for is returned", func() {
+					So(w.Code, ShouldEqual, http.StatusOK)
+					So(errs, ShouldBeEmpty)
+					So(data.Datasets, ShouldHaveLength, 1)
+					So(data.Dataset.Dimensions, ShouldHaveLength, 5)
+					So(data.Dataset.Dimensions[0].Name, ShouldEqual, "dim25")
+				})
+			})
+
+			Convey("When all of its dimensions are asked for with their codes", func() {
+				p.app.Config.GraphQLMaxComplexity = 10000
+				w, errs := p.graphQL(`{
+					dataset(name: "Many") { dimensions(limit: 1000) { codes(limit: 1000) { code ancestors(limit: 5) { code } } } }
+				}`, nil, nil)
+
+				Convey("Then the complexity counts every dimension asked for", func() {
+					So(w.Code, ShouldEqual, http.StatusBadRequest)
+					So(errs, ShouldHaveLength, 1)
+					So(errs[0], ShouldStartWith, "query complexity")
+				})
+			})
+		})
+
+		Convey("When a query is more complex than the limit", func() {
+			p.app.Config.GraphQLMaxComplexity = 100
+			query := `query($limit: Int) {
+				dataset(name: "Example") {
+					dimension(name: "region") { codes(limit: $limit) { code children { code } } }
+				}
+			}`
+
+			w, errs := p.graphQL(query, nil, nil)
+
+			Convey("Then it is rejected", func() {
+				So(w.Code, ShouldEqual, http.StatusBadRequest)
+				So(errs, ShouldResemble, []string{"query complexity 443 exceeds the limit of 100"})
+			})
+
+			Convey("And the lists are limited", func() {
+				w, errs := p.graphQL(query, map[string]interface{}{"limit": 1}, nil)
+
+				Convey("Then it is run", func() {
+					So(w.Code, ShouldEqual, http.StatusOK)
+					So(errs, ShouldBeEmpty)
+				})
+			})
+		})
+	})
+}
+
+func TestBatch(t *testing.T) {
+	Convey("Given a proxy in front of a flexible table builder", t, func() {
+		p := newProxy(0)
+		defer p.close()
+
+		type result struct {
+			Status  int
+			Headers map[string]string
+			Body    json.RawMessage
+		}
+
+		Convey("When several requests are batched", func() {
+			w := p.post("/v6/batch", []map[string]interface{}{
+				{"path": "/v6/datasets/Example/dimensions"},
+				{"method": "GET", "path": "/v6/datasets/Example/hierarchies/region/code/E12000001"},
+				{"path": "/v6/datasets/Example/dimensions/ethnicity"},
+				{"path": "/v6/query/Example?v=region&format=csv"},
+				{"method": "POST", "path": "/graphql", "body": map[string]string{"query": `{ dataset(name: "Example") { digest } }`}},
+			})
+
+			Convey("Then their responses are returned in the same order", func() {
+				So(w.Code, ShouldEqual, http.StatusOK)
+
+				var results []result
+				decode(w, &results)
+				So(results, ShouldHaveLength, 5)
+
+				So(results[0].Status, ShouldEqual, http.StatusOK)
+				So(results[0].Headers["Etag"], ShouldNotBeEmpty)
+				var dims api.GetDimensionsResponse
+				So(json.Unmarshal(results[0].Body, &dims), ShouldBeNil)
+				So(dims.Dimensions, ShouldContain, "Region: region")
+
+				So(results[1].Status, ShouldEqual, http.StatusOK)
+				var h hierarchy.Response
+				So(json.Unmarshal(results[1].Body, &h), ShouldBeNil)
+				So(h.Children, ShouldHaveLength, 2)
+
+				So(results[2].Status, ShouldEqual, http.StatusNotFound)
+
+				So(results[3].Status, ShouldEqual, http.StatusOK)
+				So(results[3].Headers["Content-Type"], ShouldStartWith, "text/csv")
+				var csv string
+				So(json.Unmarshal(results[3].Body, &csv), ShouldBeNil)
+				So(csv, ShouldContainSubstring, "E12000001")
+
+				So(results[4].Status, ShouldEqual, http.StatusOK)
+				So(string(results[4].Body), ShouldEqual, `{"data":{"dataset":{"digest":"example-digest-1"}}}`)
+			})
+		})
+
+		Convey("When a request of a batch gives its own token", func() {
+			w := p.post("/v6/batch", []map[string]interface{}{
+				{"path": "/v6/datasets/Example/dimensions", "headers": map[string]string{"Authorization": "Bearer other"}},
+			})
+
+			Convey("Then it is made with the token of the batch", func() {
+				var results []result
+				decode(w, &results)
+				So(results[0].Status, ShouldEqual, http.StatusOK)
+			})
+		})
+
+		Convey("When a batch is made without a token", func() {
+			r := httptest.NewRequest(http.MethodPost, "/v6/batch", strings.NewReader(`[{"path": "/v6/datasets/Example/dimensions"}]`))
+			w := httptest.NewRecorder()
+			p.handler.ServeHTTP(w, r)
+
+			Convey("Then none of its requests are made", func() {
+				So(w.Code, ShouldEqual, http.StatusUnauthorized)
+				So(p.ftb.Requests("/v6/codebook/Example"), ShouldEqual, 0)
+			})
+		})
+
+		Convey("When a batch has more requests than allowed", func() {
+			p.app.Config.BatchMaxRequests = 1
+			w := p.post("/v6/batch", []map[string]interface{}{
+				{"path": "/v6/datasets/Example/dimensions"},
+				{"path": "/v6/datasets/Example/dimensions/region"},
+			})
+
+			Convey("Then it is rejected", func() {
+				So(w.Code, ShouldEqual, http.StatusBadRequest)
+				So(w.Body.String(), ShouldContainSubstring, "at most 1 requests")
+			})
+		})
+
+		Convey("When a batch contains a batch", func() {
+			w := p.post("/v6/batch", []map[string]interface{}{
+				{"method": "POST", "path": "/v6/batch", "body": []interface{}{}},
+			})
+
+			Convey("Then it is rejected", func() {
+				So(w.Code, ShouldEqual, http.StatusBadRequest)
+				So(w.Body.String(), ShouldContainSubstring, "batches cannot be nested")
+			})
+		})
+
+		Convey("When a batch asks for a streamed response", func() {
+			events := p.post("/v6/batch", []map[string]interface{}{{"path": "/v6/events"}})
+			stream := p.post("/v6/batch", []map[string]interface{}{{"path": "/v6/datasets/Example/hierarchies/la?stream=true"}})
+
+			Convey("Then it is rejected rather than held open", func() {
+				So(events.Code, ShouldEqual, http.StatusBadRequest)
+				So(stream.Code, ShouldEqual, http.StatusBadRequest)
+			})
+		})
+
+		Convey("When a request of a batch has a relative path", func() {
+			w := p.post("/v6/batch", []map[string]interface{}{{"path": "v6/datasets"}})
+
+			Convey("Then it is rejected", func() {
+				So(w.Code, ShouldEqual, http.StatusBadRequest)
+			})
+		})
+	})
+}
+
+// startJobs gives the proxy a queue of query jobs kept under its temporary
+// directory, with its workers running until ctx is cancelled
+func (p *proxy) startJobs(ctx context.Context, workers, size int) *jobs.Store {
+	jobStore, err := jobs.NewStore(p.dir + "/jobs")
+	So(err, ShouldBeNil)
+
+	p.app.Jobs, err = jobs.NewQueue(jobStore, p.app.RunQueryJob, workers, size, time.Hour)
+	So(err, ShouldBeNil)
+
+	if ctx != nil {
+		go p.app.Jobs.Run(ctx)
+	}
+	return jobStore
+}
+
+// waitForJob polls a job until it has finished
+func (p *proxy) waitForJob(self string) *api.JobResponse {
+	path := strings.TrimPrefix(self, "http://127.0.0.1:10100")
+
+	var job api.JobResponse
+	for i := 0; i < 200; i++ {
+		w := p.get(path, nil)
+		So(w.Code, ShouldEqual, http.StatusOK)
+
+		job = api.JobResponse{}
+		decode(w, &job)
+		if job.Done() {
+			return &job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	So(job.Status, ShouldEqual, jobs.StatusSucceeded)
+	return &job
+}
+
+func TestJobs(t *testing.T) {
+	Convey("Given a proxy running query jobs", t, func() {
+		p := newProxy(0)
+		defer p.close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		jobStore := p.startJobs(ctx, 2, 10)
+
+		query := jobs.Query{
+			Dataset:   "Example",
+			Variables: []string{"la", "sex"},
+			Aggregate: []string{"region"},
+			Margins:   []string{"total"},
+		}
+
+		Convey("When a query job is posted", func() {
+			w := p.post("/v6/jobs", query)
+
+			So(w.Code, ShouldEqual, http.StatusAccepted)
+
+			var posted api.JobResponse
+			decode(w, &posted)
+			So(posted.ID, ShouldNotBeEmpty)
+			So(posted.Query, ShouldResemble, query)
+			So(w.Header().Get("Location"), ShouldEqual, posted.Links.Self)
+			So(posted.Links.Self, ShouldEndWith, "/v6/jobs/"+posted.ID)
+
+			Convey("Then it runs through each stage to success", func() {
+				job := p.waitForJob(posted.Links.Self)
+
+				So(job.Status, ShouldEqual, jobs.StatusSucceeded)
+				So(job.Progress, ShouldResemble, jobs.Progress{Stage: "margins", Step: 3, Steps: 3})
+				So(job.Started, ShouldNotBeNil)
+				So(job.Finished, ShouldNotBeNil)
+				So(job.Expires, ShouldNotBeNil)
+				So(job.Expires.Sub(*job.Finished), ShouldEqual, time.Hour)
+				So(job.Links.Results, ShouldHaveLength, 3)
+
+				Convey("And its result is the table the query route builds", func() {
+					sync := p.get("/v6/query/Example?v=la&v=sex&aggregate=region&margins=total", nil)
+					So(sync.Code, ShouldEqual, http.StatusOK)
+
+					result := p.get("/v6/jobs/"+job.ID+"/result", nil)
+					So(result.Code, ShouldEqual, http.StatusOK)
+					So(result.Header().Get("Content-Disposition"), ShouldEqual, `attachment; filename="Example-`+job.ID+`.json"`)
+					So(result.Body.String(), ShouldEqual, sync.Body.String())
+				})
+
+				Convey("And its result can be downloaded as CSV and JSON-stat", func() {
+					csv := p.get("/v6/jobs/"+job.ID+"/result?format=csv", nil)
+					So(csv.Code, ShouldEqual, http.StatusOK)
+					So(csv.Header().Get("Content-Type"), ShouldStartWith, "text/csv")
+					So(csv.Body.String(), ShouldContainSubstring, "Hartlepool")
+
+					stat := p.get("/v6/jobs/"+job.ID+"/result?format=jsonstat", nil)
+					So(stat.Code, ShouldEqual, http.StatusOK)
+
+					var dataset map[string]interface{}
+					decode(stat, &dataset)
+					So(dataset["class"], ShouldEqual, "dataset")
+				})
+
+				Convey("And it survives a restart", func() {
+					restarted, err := jobs.NewQueue(jobStore, p.app.RunQueryJob, 1, 1, time.Hour)
+					So(err, ShouldBeNil)
+
+					found, ok := restarted.Get(job.ID)
+					So(ok, ShouldBeTrue)
+					So(found.Status, ShouldEqual, jobs.StatusSucceeded)
+
+					_, err = restarted.Result(job.ID)
+					So(err, ShouldBeNil)
+				})
+
+				Convey("And once it has expired it is removed with its result", func() {
+					p.app.Jobs.Prune(job.Expires.Add(time.Second))
+
+					So(p.get("/v6/jobs/"+job.ID, nil).Code, ShouldEqual, http.StatusNotFound)
+					So(p.get("/v6/jobs/"+job.ID+"/result", nil).Code, ShouldEqual, http.StatusNotFound)
+
+					_, err := jobStore.LoadResult(job.ID)
+					So(err, ShouldEqual, jobs.ErrNotFound)
+				})
+			})
+		})
+
+		Convey("When the result of an unfinished job is requested", func() {
+			p.ftb.Inject(fake.Fault{PathPrefix: "/v6/query", Latency: 200 * time.Millisecond})
+
+			var posted api.JobResponse
+			decode(p.post("/v6/jobs", query), &posted)
+
+			w := p.get("/v6/jobs/"+posted.ID+"/result", nil)
+
+			Convey("Then a 409 is returned until it has finished", func() {
+				So(w.Code, ShouldEqual, http.StatusConflict)
+
+				So(p.waitForJob(posted.Links.Self).Status, ShouldEqual, jobs.StatusSucceeded)
+				So(p.get("/v6/jobs/"+posted.ID+"/result", nil).Code, ShouldEqual, http.StatusOK)
+			})
+		})
+
+		Convey("When the FTB fails a job's query", func() {
+			p.ftb.Inject(fake.Fault{PathPrefix: "/v6/query", Status: http.StatusInternalServerError})
+
+			var posted api.JobResponse
+			decode(p.post("/v6/jobs", query), &posted)
+			job := p.waitForJob(posted.Links.Self)
+
+			Convey("Then the job fails with the error and has no result", func() {
+				So(job.Status, ShouldEqual, jobs.StatusFailed)
+				So(job.Error, ShouldNotBeEmpty)
+				So(job.Links.Results, ShouldBeEmpty)
+
+				w := p.get("/v6/jobs/"+job.ID+"/result", nil)
+				So(w.Code, ShouldEqual, http.StatusConflict)
+
+				var body api.SimpleEntity
+				decode(w, &body)
+				So(body.Message, ShouldEqual, "job is failed")
+			})
+		})
+
+		Convey("When a job names an unknown variable", func() {
+			w := p.post("/v6/jobs", jobs.Query{Dataset: "Example", Variables: []string{"ethnicity"}})
+
+			Convey("Then a 400 is returned without queueing it", func() {
+				So(w.Code, ShouldEqual, http.StatusBadRequest)
+
+				stored, err := jobStore.List()
+				So(err, ShouldBeNil)
+				So(stored, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When a job has no variables", func() {
+			w := p.post("/v6/jobs", jobs.Query{Dataset: "Example"})
+
+			Convey("Then a 400 is returned", func() {
+				So(w.Code, ShouldEqual, http.StatusBadRequest)
+			})
+		})
+
+		Convey("When a job asks for a margin or percentage that is not recognised", func() {
+			margins := p.post("/v6/jobs", jobs.Query{Dataset: "Example", Variables: []string{"sex"}, Margins: []string{"diagonal"}})
+			percent := p.post("/v6/jobs", jobs.Query{Dataset: "Example", Variables: []string{"sex"}, Percent: "median"})
+
+			Convey("Then a 400 is returned without queueing it", func() {
+				So(margins.Code, ShouldEqual, http.StatusBadRequest)
+				So(percent.Code, ShouldEqual, http.StatusBadRequest)
+
+				stored, err := jobStore.List()
+				So(err, ShouldBeNil)
+				So(stored, ShouldBeEmpty)
+			})
+		})
+
+		Convey("And a separate FTB client for the queries of jobs", func() {
+			jobFTB := fake.New(fake.Example())
+			defer jobFTB.Close()
+
+			httpCli := dphttp.NewClient()
+			httpCli.SetMaxRetries(0)
+			p.app.JobQuerier = &cantabular.Client{Host: jobFTB.URL, HttpCli: httpCli}
+
+			Convey("When a query job is run", func() {
+				w := p.post("/v6/jobs", query)
+				So(w.Code, ShouldEqual, http.StatusAccepted)
+
+				var posted api.JobResponse
+				decode(w, &posted)
+				job := p.waitForJob(posted.Links.Self)
+
+				Convey("Then its query is made with that client", func() {
+					So(job.Status, ShouldEqual, jobs.StatusSucceeded)
+					So(jobFTB.Requests("/v6/query/Example"), ShouldEqual, 1)
+					So(p.ftb.Requests("/v6/query/Example"), ShouldEqual, 0)
+				})
+			})
+		})
+
+		Convey("When a job is for an unknown dataset", func() {
+			w := p.post("/v6/jobs", jobs.Query{Dataset: "Unknown", Variables: []string{"sex"}})
+
+			Convey("Then a 404 is returned", func() {
+				So(w.Code, ShouldEqual, http.StatusNotFound)
+			})
+		})
+
+		Convey("When an unknown job is requested", func() {
+			w := p.get("/v6/jobs/0123456789abcdef", nil)
+
+			Convey("Then a 404 is returned", func() {
+				So(w.Code, ShouldEqual, http.StatusNotFound)
+			})
+		})
+
+		Convey("When the result is asked for in an unsupported format", func() {
+			w := p.get("/v6/jobs/0123456789abcdef/result?format=xlsx", nil)
+
+			Convey("Then a 406 is returned", func() {
+				So(w.Code, ShouldEqual, http.StatusNotAcceptable)
+			})
+		})
+	})
+
+	Convey("Given a proxy whose job queue has no room", t, func() {
+		p := newProxy(0)
+		defer p.close()
+
+		p.startJobs(nil, 1, 0)
+
+		Convey("When a query job is posted", func() {
+			w := p.post("/v6/jobs", jobs.Query{Dataset: "Example", Variables: []string{"sex"}})
+
+			Convey("Then a 503 is returned", func() {
+				So(w.Code, ShouldEqual, http.StatusServiceUnavailable)
+			})
+		})
+	})
+
+	Convey("Given a proxy restarted with a job still queued", t, func() {
+		p := newProxy(0)
+		defer p.close()
+
+		jobStore := p.startJobs(nil, 1, 1)
+
+		var posted api.JobResponse
+		decode(p.post("/v6/jobs", jobs.Query{Dataset: "Example", Variables: []string{"sex"}}), &posted)
+		So(posted.Status, ShouldEqual, jobs.StatusQueued)
+
+		restarted, err := jobs.NewQueue(jobStore, p.app.RunQueryJob, 1, 1, time.Hour)
+		So(err, ShouldBeNil)
+
+		Convey("Then the job is failed as interrupted", func() {
+			job, ok := restarted.Get(posted.ID)
+			So(ok, ShouldBeTrue)
+			So(job.Status, ShouldEqual, jobs.StatusFailed)
+			So(job.Error, ShouldEqual, "interrupted by a restart")
+		})
+	})
+}
+
+// webhookReceiver records the deliveries made to it, failing the first
+// failures of them with status
+type webhookReceiver struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	deliveries []*http.Request
+	bodies     [][]byte
+	failures   int
+	status     int
+	received   chan struct{}
+}
+
+func newWebhookReceiver(failures, status int) *webhookReceiver {
+	wr := &webhookReceiver{failures: failures, status: status, received: make(chan struct{}, 10)}
+	wr.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		wr.mu.Lock()
+		wr.deliveries = append(wr.deliveries, r)
+		wr.bodies = append(wr.bodies, body)
+		fail := len(wr.deliveries) <= wr.failures
+		wr.mu.Unlock()
+
+		if fail {
+			w.WriteHeader(wr.status)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		wr.received <- struct{}{}
+	}))
+	return wr
+}
+
+func (wr *webhookReceiver) attempts() int {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	return len(wr.deliveries)
+}
+
+// readDeadLetters waits for the dead-letter log to hold n lines
+func readDeadLetters(path string, n int) []*webhooks.DeadLetter {
+	var letters []*webhooks.DeadLetter
+	for i := 0; i < 200; i++ {
+		letters = nil
+		b, _ := ioutil.ReadFile(path)
+		for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+			if line == "" {
+				continue
+			}
+			var letter webhooks.DeadLetter
+			So(json.Unmarshal([]byte(line), &letter), ShouldBeNil)
+			letters = append(letters, &letter)
+		}
+		if len(letters) >= n {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return letters
+}
+
+func TestWebhooks(t *testing.T) {
+	Convey("Given a proxy notifying webhooks of digest changes", t, func() {
+		p := newProxy(0)
+		defer p.close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		deadLetters := p.dir + "/dead-letters.log"
+		start := func(wr *webhookReceiver) {
+			notifier := webhooks.NewNotifier([]string{wr.URL}, "webhook-secret")
+			notifier.MaxAttempts = 3
+			notifier.Backoff = time.Millisecond
+			notifier.DeadLetters = deadLetters
+
+			p.codebooks.OnDigestChange(notifier.Notify)
+			go notifier.Run(ctx)
+
+			So(p.get("/v6/datasets/Example/dimensions", nil).Code, ShouldEqual, http.StatusOK)
+		}
+
+		reload := func() {
+			reloaded := fake.Example()
+			reloaded.Codebook.Dataset.Digest = "example-digest-2"
+			reloaded.Codebook.CodeBook[3].Labels = []string{"Males", "Females"}
+			p.ftb.AddDataset(reloaded)
+
+			So(p.get("/v6/datasets/Example/dimensions", nil).Code, ShouldEqual, http.StatusOK)
+		}
+
+		Convey("When the digest of a dataset changes", func() {
+			wr := newWebhookReceiver(0, 0)
+			defer wr.Close()
+
+			start(wr)
+			reload()
+
+			Convey("Then a signed event summarising the diff is delivered", func() {
+				select {
+				case <-wr.received:
+				case <-time.After(5 * time.Second):
+				}
+				So(wr.attempts(), ShouldEqual, 1)
+
+				r, body := wr.deliveries[0], wr.bodies[0]
+				So(r.Header.Get(webhooks.EventTypeHeader), ShouldEqual, webhooks.EventDigestChanged)
+				So(webhooks.Verify("webhook-secret", body, r.Header.Get(webhooks.SignatureHeader)), ShouldBeTrue)
+				So(webhooks.Verify("wrong-secret", body, r.Header.Get(webhooks.SignatureHeader)), ShouldBeFalse)
+
+				var event webhooks.Event
+				So(json.Unmarshal(body, &event), ShouldBeNil)
+				So(event.ID, ShouldEqual, r.Header.Get(webhooks.EventIDHeader))
+				So(event.Dataset, ShouldEqual, "Example")
+				So(event.OldDigest, ShouldEqual, "example-digest-1")
+				So(event.NewDigest, ShouldEqual, "example-digest-2")
+				So(event.Changes, ShouldResemble, cantabular.DiffSummary{ChangedDimensions: 1, RelabelledCodes: 2})
+				So(event.ChangedDimensions, ShouldResemble, []string{"sex"})
+			})
+
+			Convey("And the unchanged digest is fetched again", func() {
+				<-wr.received
+				So(p.get("/v6/datasets/Example/dimensions", nil).Code, ShouldEqual, http.StatusOK)
+
+				Convey("Then nothing more is delivered", func() {
+					select {
+					case <-wr.received:
+					case <-time.After(50 * time.Millisecond):
+					}
+					So(wr.attempts(), ShouldEqual, 1)
+				})
+			})
+		})
+
+		Convey("When a webhook fails before accepting the event", func() {
+			wr := newWebhookReceiver(2, http.StatusServiceUnavailable)
+			defer wr.Close()
+
+			start(wr)
+			reload()
+
+			Convey("Then the same event is retried until it is delivered", func() {
+				select {
+				case <-wr.received:
+				case <-time.After(5 * time.Second):
+				}
+				So(wr.attempts(), ShouldEqual, 3)
+				So(wr.bodies[2], ShouldResemble, wr.bodies[0])
+				So(readDeadLetters(deadLetters, 0), ShouldBeEmpty)
+			})
+		})
+
+		Convey("When a webhook keeps failing", func() {
+			wr := newWebhookReceiver(100, http.StatusInternalServerError)
+			defer wr.Close()
+
+			start(wr)
+			reload()
+
+			Convey("Then the event is dead-lettered after the last attempt", func() {
+				letters := readDeadLetters(deadLetters, 1)
+				So(letters, ShouldHaveLength, 1)
+				So(letters[0].URL, ShouldEqual, wr.URL)
+				So(letters[0].Attempts, ShouldEqual, 3)
+				So(letters[0].Error, ShouldEqual, "webhook responded with 500")
+				So(letters[0].Event.NewDigest, ShouldEqual, "example-digest-2")
+				So(wr.attempts(), ShouldEqual, 3)
+			})
+		})
+
+		Convey("When a webhook rejects the event", func() {
+			wr := newWebhookReceiver(100, http.StatusBadRequest)
+			defer wr.Close()
+
+			start(wr)
+			reload()
+
+			Convey("Then it is dead-lettered without a retry", func() {
+				letters := readDeadLetters(deadLetters, 1)
+				So(letters, ShouldHaveLength, 1)
+				So(letters[0].Attempts, ShouldEqual, 1)
+				So(wr.attempts(), ShouldEqual, 1)
+			})
+		})
+	})
+}
+
+// eventStream reads server-sent events from a response
+type eventStream struct {
+	resp   *http.Response
+	reader *bufio.Reader
+}
+
+// openEvents connects to /v6/events on a server in front of the proxy's
+// router, as the validation middleware would hold back the stream
+func openEvents(server *httptest.Server, headers map[string]string) *eventStream {
+	r, err := http.NewRequest(http.MethodGet, server.URL+"/v6/events", nil)
+	So(err, ShouldBeNil)
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(r)
+	So(err, ShouldBeNil)
+	return &eventStream{resp: resp, reader: bufio.NewReader(resp.Body)}
+}
+
+func (s *eventStream) close() {
+	s.resp.Body.Close()
+}
+
+// next returns the fields of the next event, skipping comments and the retry
+// hint. The comments are returned instead if the stream ends first.
+func (s *eventStream) next() map[string]string {
+	fields := make(map[string]string)
+	for {
+		line, err := s.reader.ReadString('\n')
+		line = strings.TrimSuffix(line, "\n")
+
+		if err != nil {
+			return fields
+		}
+		if line == "" {
+			if _, ok := fields["event"]; ok {
+				return fields
+			}
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			fields["comment"] += line
+			continue
+		}
+
+		parts := strings.SplitN(line, ": ", 2)
+		if len(parts) == 2 {
+			fields[parts[0]] = parts[1]
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	Convey("Given a proxy streaming events", t, func() {
+		p := newProxy(0)
+		defer p.close()
+
+		hub := events.NewHub(4, 2)
+		p.codebooks.OnDigestChange(hub.DigestChanged)
+		p.codebooks.OnHealthChange(hub.HealthChanged)
+		p.app.Events = hub
+
+		server := httptest.NewServer(alice.New(middleware.RequestID).Then(p.app.Router))
+		defer server.Close()
+
+		auth := map[string]string{"Authorization": testToken}
+
+		Convey("When the stream is opened without a token", func() {
+			stream := openEvents(server, nil)
+			defer stream.close()
+
+			Convey("Then a 401 is returned", func() {
+				So(stream.resp.StatusCode, ShouldEqual, http.StatusUnauthorized)
+			})
+		})
+
+		Convey("When the stream is open", func() {
+			stream := openEvents(server, auth)
+			defer stream.close()
+
+			So(stream.resp.StatusCode, ShouldEqual, http.StatusOK)
+			So(stream.resp.Header.Get("Content-Type"), ShouldEqual, "text/event-stream")
+			So(stream.resp.Header.Get("Access-Control-Allow-Origin"), ShouldEqual, "*")
+
+			Convey("Then the digest changing is sent", func() {
+				So(p.get("/v6/datasets/Example/dimensions", nil).Code, ShouldEqual, http.StatusOK)
+
+				reloaded := fake.Example()
+				reloaded.Codebook.Dataset.Digest = "example-digest-2"
+				p.ftb.AddDataset(reloaded)
+				So(p.get("/v6/datasets/Example/dimensions", nil).Code, ShouldEqual, http.StatusOK)
+
+				e := stream.next()
+				So(e["id"], ShouldEqual, "1")
+				So(e["event"], ShouldEqual, events.TypeDigestChanged)
+
+				var change events.DigestChange
+				So(json.Unmarshal([]byte(e["data"]), &change), ShouldBeNil)
+				So(change.Dataset, ShouldEqual, "Example")
+				So(change.OldDigest, ShouldEqual, "example-digest-1")
+				So(change.NewDigest, ShouldEqual, "example-digest-2")
+			})
+
+			Convey("Then the FTB becoming unavailable and recovering is sent", func() {
+				So(p.get("/v6/datasets/Example/dimensions", nil).Code, ShouldEqual, http.StatusOK)
+
+				p.ftb.Inject(fake.Fault{PathPrefix: "/v6/codebook", Status: http.StatusServiceUnavailable})
+				So(p.get("/v6/datasets/Example/dimensions", nil).Code, ShouldEqual, http.StatusOK)
+				So(p.get("/v6/datasets/Example/dimensions", nil).Code, ShouldEqual, http.StatusOK)
+
+				p.ftb.Reset()
+				So(p.get("/v6/datasets/Example/dimensions", nil).Code, ShouldEqual, http.StatusOK)
+
+				down := stream.next()
+				So(down["event"], ShouldEqual, events.TypeUpstreamState)
+
+				var state events.UpstreamState
+				So(json.Unmarshal([]byte(down["data"]), &state), ShouldBeNil)
+				So(state.Available, ShouldBeFalse)
+				So(state.Error, ShouldNotBeEmpty)
+
+				up := stream.next()
+				So(up["id"], ShouldEqual, "2")
+				So(up["data"], ShouldEqual, `{"upstream":"ftb","available":true}`)
+			})
+
+			Convey("Then a refresh of the cache is sent", func() {
+				refresher := &store.Refresher{
+					Codebooks: p.codebooks,
+					Lister:    &cantabular.Client{Host: p.ftb.URL, HttpCli: dphttp.NewClient()},
+					OnRefresh: hub.Refreshed,
+				}
+				refresher.RefreshAll(context.Background())
+
+				e := stream.next()
+				So(e["event"], ShouldEqual, events.TypeCacheRefresh)
+
+				var refresh events.CacheRefresh
+				So(json.Unmarshal([]byte(e["data"]), &refresh), ShouldBeNil)
+				So(refresh.Datasets, ShouldBeGreaterThan, 0)
+				So(refresh.Failed, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When a client reconnects with the ID of the last event it saw", func() {
+			for i := 1; i <= 6; i++ {
+				hub.Publish("test", i)
+			}
+
+			stream := openEvents(server, map[string]string{"Authorization": testToken, "Last-Event-ID": "4"})
+			defer stream.close()
+
+			Convey("Then the events since are replayed", func() {
+				So(stream.next()["id"], ShouldEqual, "5")
+				So(stream.next()["id"], ShouldEqual, "6")
+
+				hub.Publish("test", 7)
+				So(stream.next()["data"], ShouldEqual, "7")
+			})
+		})
+
+		Convey("When a client reconnects with an ID older than the buffer", func() {
+			for i := 1; i <= 6; i++ {
+				hub.Publish("test", i)
+			}
+
+			stream := openEvents(server, map[string]string{"Authorization": testToken, "Last-Event-ID": "1"})
+			defer stream.close()
+
+			Convey("Then the buffered events are replayed", func() {
+				So(stream.next()["id"], ShouldEqual, "3")
+				So(stream.next()["id"], ShouldEqual, "4")
+				So(stream.next()["id"], ShouldEqual, "5")
+				So(stream.next()["id"], ShouldEqual, "6")
+			})
+		})
+
+		Convey("When a browser sends a preflight request for the stream", func() {
+			r, err := http.NewRequest(http.MethodOptions, server.URL+"/v6/events", nil)
+			So(err, ShouldBeNil)
+
+			resp, err := http.DefaultClient.Do(r)
+			So(err, ShouldBeNil)
+			resp.Body.Close()
+
+			Convey("Then it may reconnect with its Last-Event-ID", func() {
+				So(resp.StatusCode, ShouldEqual, http.StatusNoContent)
+				So(resp.Header.Get("Access-Control-Allow-Origin"), ShouldEqual, "*")
+				So(resp.Header.Get("Access-Control-Allow-Headers"), ShouldContainSubstring, "Last-Event-ID")
+			})
+		})
+
+		Convey("When a client reconnects with an invalid ID", func() {
+			stream := openEvents(server, map[string]string{"Authorization": testToken, "Last-Event-ID": "abc"})
+			defer stream.close()
+
+			Convey("Then a 400 is returned", func() {
+				So(stream.resp.StatusCode, ShouldEqual, http.StatusBadRequest)
+			})
+		})
+
+		Convey("When a subscriber falls behind", func() {
+			sub, _, err := hub.Subscribe("")
+			So(err, ShouldBeNil)
+
+			for i := 1; i <= 3; i++ {
+				hub.Publish("test", i)
+			}
+
+			Convey("Then it is sent what fits in its buffer and disconnected", func() {
+				So((<-sub.C).ID, ShouldEqual, 1)
+				So((<-sub.C).ID, ShouldEqual, 2)
+
+				_, open := <-sub.C
+				So(open, ShouldBeFalse)
+
+				hub.Unsubscribe(sub)
+			})
+		})
+	})
+}
@@ -0,0 +1,96 @@
+// Package apitest wires the proxy together the way main does, in front of a
+// fake flexible table builder, for the tests of the api and of the packages
+// that call the proxy over HTTP.
+package apitest
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/api"
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/cantabular"
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/cantabular/fake"
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/config"
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/middleware"
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/store"
+	dphttp "github.com/ONSdigital/dp-net/http"
+	"github.com/gorilla/mux"
+	"github.com/justinas/alice"
+)
+
+// Token is the token the proxy accepts, and Authorization the header carrying
+// it
+const (
+	Token         = "test-token"
+	Authorization = "Bearer " + Token
+)
+
+// Main runs the tests of a package, setting the AUTH_TOKEN the global config
+// requires as hierarchy links are built from it. Call it from TestMain.
+func Main(m *testing.M) {
+	os.Setenv("AUTH_TOKEN", Token)
+	os.Exit(m.Run())
+}
+
+// Proxy is the api router and codebook store in front of a fake FTB serving
+// the example dataset. Dir is a temporary directory holding the snapshots,
+// that tests can write their own files to.
+type Proxy struct {
+	FTB       *fake.FTB
+	App       *api.API
+	Codebooks *store.Codebooks
+	Dir       string
+}
+
+// New starts a proxy caching codebooks for ttl
+func New(ttl time.Duration) (*Proxy, error) {
+	ftb := fake.New(fake.Example())
+
+	httpCli := dphttp.NewClient()
+	httpCli.SetMaxRetries(0)
+
+	dir, err := ioutil.TempDir("", "apitest")
+	if err != nil {
+		ftb.Close()
+		return nil, err
+	}
+
+	snapshots, err := store.NewSnapshots(filepath.Join(dir, "snapshots"))
+	if err != nil {
+		ftb.Close()
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	codebooks := store.NewCodebooks(&cantabular.Client{Host: ftb.URL, HttpCli: httpCli}, snapshots, ttl)
+
+	cfg := &config.Config{
+		BindAddr:           ":10100",
+		IPAddr:             "127.0.0.1",
+		DefaultCacheMaxAge: time.Minute,
+		CacheMaxAge:        map[string]time.Duration{"codes": time.Hour},
+	}
+
+	app := api.Setup(context.Background(), mux.NewRouter(), cfg, middleware.Auth(Authorization), codebooks)
+	return &Proxy{FTB: ftb, App: app, Codebooks: codebooks, Dir: dir}, nil
+}
+
+// Handler returns the router behind the middleware main uses, followed by
+// any more given
+func (p *Proxy) Handler(more ...alice.Constructor) http.Handler {
+	return alice.New(
+		middleware.RequestID,
+		middleware.Compress(64, []string{"application/json"}),
+	).Append(more...).Then(p.App.Router)
+}
+
+// Close stops the fake FTB and removes the temporary directory
+func (p *Proxy) Close() {
+	p.FTB.Close()
+	os.RemoveAll(p.Dir)
+}
@@ -0,0 +1,224 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/openapi"
+	"github.com/ONSdigital/log.go/log"
+	"github.com/gorilla/mux"
+)
+
+// maxBatchBody is the largest request body read from a POST to /v6/batch
+const maxBatchBody = 1 << 20
+
+// batchRequest is one of the requests of a batch. A body that is a JSON
+// string is sent as it is, and any other body is sent as JSON.
+type batchRequest struct {
+	Method  string            `json:"method,omitempty"`
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    interface{}       `json:"body,omitempty"`
+}
+
+// batchResponse is the response to a request of a batch. A JSON body is given
+// as it is, any other body as a string, and an empty body as null.
+type batchResponse struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers"`
+	Body    interface{}       `json:"body"`
+}
+
+// PostBatch runs a list of requests through the router, with the caller's
+// Authorization header, and returns their responses in the same order. Up to
+// BATCH_CONCURRENCY of them are run at once.
+func (api *API) PostBatch() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		var batch []batchRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchBody)).Decode(&batch); err != nil {
+			WriteBody(ctx, w, SimpleEntity{Message: "invalid batch: " + err.Error()}, http.StatusBadRequest)
+			return
+		}
+
+		if max := api.Config.BatchMaxRequests; max > 0 && len(batch) > max {
+			WriteBody(ctx, w, SimpleEntity{Message: fmt.Sprintf("a batch can have at most %d requests", max)}, http.StatusBadRequest)
+			return
+		}
+
+		batchPath := r.URL.Path
+		requests := make([]*http.Request, len(batch))
+		for i, b := range batch {
+			sub, err := newBatchSubRequest(r, b)
+			if err == nil && sub.URL.Path == batchPath {
+				err = fmt.Errorf("batches cannot be nested")
+			}
+			if err == nil && api.isStreamed(sub) {
+				err = fmt.Errorf("streamed responses cannot be batched")
+			}
+			if err != nil {
+				WriteBody(ctx, w, SimpleEntity{Message: fmt.Sprintf("invalid request %d: %s", i, err)}, http.StatusBadRequest)
+				return
+			}
+			requests[i] = sub
+		}
+
+		concurrency := api.Config.BatchConcurrency
+		if concurrency < 1 {
+			concurrency = 1
+		}
+
+		responses := make([]*batchResponse, len(requests))
+		sem := make(chan struct{}, concurrency)
+		var wg sync.WaitGroup
+
+		for i, sub := range requests {
+			sem <- struct{}{}
+			wg.Add(1)
+			go func(i int, sub *http.Request) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+
+				rec := newBatchResponseWriter()
+				api.Router.ServeHTTP(rec, sub)
+				responses[i] = rec.response()
+			}(i, sub)
+		}
+		wg.Wait()
+
+		log.Event(ctx, "batch complete", log.INFO, log.Data{"requests": len(requests)})
+		WriteBody(ctx, w, responses, http.StatusOK)
+	})
+}
+
+// newBatchSubRequest builds the request for an item of a batch, in the
+// context of the batch and carrying its Authorization header
+func newBatchSubRequest(r *http.Request, b batchRequest) (*http.Request, error) {
+	method := strings.ToUpper(b.Method)
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	if !strings.HasPrefix(b.Path, "/") {
+		return nil, fmt.Errorf("path %q must start with /", b.Path)
+	}
+
+	var body io.Reader
+	contentType := ""
+	switch v := b.Body.(type) {
+	case nil:
+	case string:
+		body = strings.NewReader(v)
+	default:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(encoded)
+		contentType = mediaTypeJSON
+	}
+
+	sub, err := http.NewRequest(method, b.Path, body)
+	if err != nil {
+		return nil, err
+	}
+	sub = sub.WithContext(r.Context())
+	sub.Host = r.Host
+	sub.RemoteAddr = r.RemoteAddr
+	sub.RequestURI = b.Path
+
+	for k, v := range b.Headers {
+		sub.Header.Set(k, v)
+	}
+	if contentType != "" && sub.Header.Get("Content-Type") == "" {
+		sub.Header.Set("Content-Type", contentType)
+	}
+
+	sub.Header.Del("Authorization")
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		sub.Header.Set("Authorization", auth)
+	}
+
+	return sub, nil
+}
+
+// isStreamed reports whether a request is for a streamed response, which a
+// batch would have to hold until it ended, and for events never would
+func (api *API) isStreamed(r *http.Request) bool {
+	if _, ok := r.URL.Query()["stream"]; ok {
+		return true
+	}
+
+	var match mux.RouteMatch
+	return api.Router.Match(r, &match) && match.Route != nil && match.Route.GetName() == "events"
+}
+
+// batchResponseWriter holds the response to a request of a batch
+type batchResponseWriter struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func newBatchResponseWriter() *batchResponseWriter {
+	return &batchResponseWriter{header: make(http.Header), status: http.StatusOK}
+}
+
+func (rw *batchResponseWriter) Header() http.Header {
+	return rw.header
+}
+
+func (rw *batchResponseWriter) WriteHeader(status int) {
+	if rw.wroteHeader {
+		return
+	}
+	rw.status = status
+	rw.wroteHeader = true
+}
+
+func (rw *batchResponseWriter) Write(b []byte) (int, error) {
+	rw.WriteHeader(http.StatusOK)
+	return rw.body.Write(b)
+}
+
+// Flush does nothing, as the response is written once the batch is complete
+func (rw *batchResponseWriter) Flush() {}
+
+func (rw *batchResponseWriter) response() *batchResponse {
+	resp := &batchResponse{Status: rw.status, Headers: make(map[string]string)}
+	for k, v := range rw.header {
+		resp.Headers[k] = strings.Join(v, ", ")
+	}
+
+	b := rw.body.Bytes()
+	if len(bytes.TrimSpace(b)) == 0 {
+		return resp
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(rw.header.Get("Content-Type"))
+	if openapi.IsJSON(mediaType) && json.Valid(b) {
+		resp.Body = json.RawMessage(bytes.TrimSpace(b))
+	} else {
+		resp.Body = string(b)
+	}
+	return resp
+}
+
+// preflightPostHandler answers preflight requests for routes that are posted
+// a JSON body
+func (api *API) preflightPostHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", http.MethodGet+", "+http.MethodPost)
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+	w.WriteHeader(http.StatusNoContent)
+}
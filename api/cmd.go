@@ -0,0 +1,444 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/ONSdigital/dp-api-clients-go/dataset"
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/cantabular"
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/store"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// cmdEdition is the only edition of each dataset, as the FTB has one
+	// current version of a dataset and the proxy keeps the history of it
+	cmdEdition = "2021"
+
+	cmdStatePublished = "published"
+)
+
+// CMDList is a page of a dataset-api collection
+type CMDList struct {
+	Items      interface{} `json:"items"`
+	Count      int         `json:"count"`
+	Offset     int         `json:"offset"`
+	Limit      int         `json:"limit"`
+	TotalCount int         `json:"total_count"`
+}
+
+func newCMDList(items interface{}, count int) *CMDList {
+	return &CMDList{Items: items, Count: count, Limit: count, TotalCount: count}
+}
+
+// GetCMDDatasets lists the datasets of the FTB in the shape of the dataset-api
+func (api *API) GetCMDDatasets() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		datasets, err := api.Store.GetDatasets(ctx)
+		if err != nil {
+			errEntity, status := getErrorResponse(ctx, err)
+			WriteBody(ctx, w, errEntity, status)
+			return
+		}
+
+		items := make([]dataset.DatasetDetails, 0, len(datasets.Items))
+		for _, d := range datasets.Items {
+			items = append(items, cmdDataset(d))
+		}
+
+		WriteBody(ctx, w, newCMDList(items, len(items)), http.StatusOK)
+	})
+}
+
+// GetCMDDataset returns a dataset in the shape of the dataset-api
+func (api *API) GetCMDDataset() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		codebook, ok := api.getCodebook(ctx, w, mux.Vars(r)["id"])
+		if !ok {
+			return
+		}
+
+		if api.notModified(w, r, codebook.Dataset.Digest) {
+			return
+		}
+
+		WriteBody(ctx, w, cmdDataset(&codebook.Dataset), http.StatusOK)
+	})
+}
+
+// GetCMDEditions lists the single edition of a dataset
+func (api *API) GetCMDEditions() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		codebook, ok := api.getCodebook(ctx, w, mux.Vars(r)["id"])
+		if !ok {
+			return
+		}
+
+		versions, ok := api.getDigests(ctx, w, codebook)
+		if !ok {
+			return
+		}
+
+		if api.notModified(w, r, codebook.Dataset.Digest) {
+			return
+		}
+
+		items := []dataset.Edition{cmdEditionFor(codebook.Dataset.Name, len(versions))}
+		WriteBody(ctx, w, newCMDList(items, len(items)), http.StatusOK)
+	})
+}
+
+// GetCMDEdition returns the edition of a dataset
+func (api *API) GetCMDEdition() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		codebook, ok := api.getCodebook(ctx, w, mux.Vars(r)["id"])
+		if !ok {
+			return
+		}
+
+		if !checkEdition(ctx, w, r) {
+			return
+		}
+
+		versions, ok := api.getDigests(ctx, w, codebook)
+		if !ok {
+			return
+		}
+
+		if api.notModified(w, r, codebook.Dataset.Digest) {
+			return
+		}
+
+		WriteBody(ctx, w, cmdEditionFor(codebook.Dataset.Name, len(versions)), http.StatusOK)
+	})
+}
+
+// GetCMDVersions lists a version of the dataset for each codebook digest the
+// proxy has seen, oldest first.
+func (api *API) GetCMDVersions() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		codebook, ok := api.getCodebook(ctx, w, mux.Vars(r)["id"])
+		if !ok {
+			return
+		}
+
+		if !checkEdition(ctx, w, r) {
+			return
+		}
+
+		digests, ok := api.getDigests(ctx, w, codebook)
+		if !ok {
+			return
+		}
+
+		if api.notModified(w, r, codebook.Dataset.Digest) {
+			return
+		}
+
+		items := make([]dataset.Version, 0, len(digests))
+		for i, digest := range digests {
+			version, err := api.getVersionCodebook(ctx, codebook, digest)
+			if err != nil {
+				errEntity, status := getErrorResponse(ctx, err)
+				WriteBody(ctx, w, errEntity, status)
+				return
+			}
+			items = append(items, cmdVersion(version, i+1, len(digests)))
+		}
+
+		WriteBody(ctx, w, newCMDList(items, len(items)), http.StatusOK)
+	})
+}
+
+// GetCMDVersion returns a version of a dataset, which is the codebook with
+// the digest at that position in the history of the dataset.
+func (api *API) GetCMDVersion() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		version, number, latest, ok := api.getVersion(ctx, w, r)
+		if !ok {
+			return
+		}
+
+		if api.notModified(w, r, version.Dataset.Digest) {
+			return
+		}
+
+		WriteBody(ctx, w, cmdVersion(version, number, latest), http.StatusOK)
+	})
+}
+
+// GetCMDMetadata returns the metadata of a version of a dataset
+func (api *API) GetCMDMetadata() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		version, number, latest, ok := api.getVersion(ctx, w, r)
+		if !ok {
+			return
+		}
+
+		if api.notModified(w, r, version.Dataset.Digest) {
+			return
+		}
+
+		WriteBody(ctx, w, dataset.Metadata{
+			Version:        cmdVersion(version, number, latest),
+			DatasetDetails: cmdDataset(&version.Dataset),
+		}, http.StatusOK)
+	})
+}
+
+// GetCMDDimensions lists the dimensions of a version of a dataset
+func (api *API) GetCMDDimensions() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		version, number, _, ok := api.getVersion(ctx, w, r)
+		if !ok {
+			return
+		}
+
+		if api.notModified(w, r, version.Dataset.Digest) {
+			return
+		}
+
+		WriteBody(ctx, w, dataset.VersionDimensions{Items: cmdDimensions(version, number)}, http.StatusOK)
+	})
+}
+
+// GetCMDOptions lists the codes of a dimension of a version of a dataset
+func (api *API) GetCMDOptions() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		version, number, _, ok := api.getVersion(ctx, w, r)
+		if !ok {
+			return
+		}
+
+		dim := version.GetDimension(mux.Vars(r)["dimension"])
+		if dim == nil {
+			WriteBody(ctx, w, SimpleEntity{Message: "dimension not found"}, http.StatusNotFound)
+			return
+		}
+
+		if api.notModified(w, r, version.Dataset.Digest) {
+			return
+		}
+
+		name := version.Dataset.Name
+		items := make([]dataset.Option, 0, len(dim.Codes))
+		for i, code := range dim.Codes {
+			items = append(items, dataset.Option{
+				DimensionID: dim.Name,
+				Label:       dim.LabelAt(i),
+				Option:      code,
+				Links: dataset.Links{
+					Code:    cmdLink(code, "/v6/datasets/%s/hierarchies/%s/code/%s", name, dim.Name, code),
+					Version: cmdLink(strconv.Itoa(number), "%s", versionPath(name, number)),
+				},
+			})
+		}
+
+		WriteBody(ctx, w, newCMDList(items, len(items)), http.StatusOK)
+	})
+}
+
+// getDigests returns the digests of every version of the dataset seen, which
+// always ends with the current one. It returns false if the response is
+// complete.
+func (api *API) getDigests(ctx context.Context, w http.ResponseWriter, current *cantabular.Codebook) ([]string, bool) {
+	digests, err := api.versionDigests(ctx, current)
+	if err != nil {
+		errEntity, status := getErrorResponse(ctx, err)
+		WriteBody(ctx, w, errEntity, status)
+		return nil, false
+	}
+
+	return digests, true
+}
+
+// versionDigests lists the digest of each version of a dataset, oldest first
+func (api *API) versionDigests(ctx context.Context, current *cantabular.Codebook) ([]string, error) {
+	digests, err := api.Store.Digests(ctx, current.Dataset.Name)
+	if err != nil && !errors.Is(err, store.ErrSnapshotNotFound) {
+		return nil, err
+	}
+
+	if len(digests) == 0 || digests[len(digests)-1] != current.Dataset.Digest {
+		history := make([]string, 0, len(digests)+1)
+		for _, d := range digests {
+			if d != current.Dataset.Digest {
+				history = append(history, d)
+			}
+		}
+		digests = append(history, current.Dataset.Digest)
+	}
+
+	return digests, nil
+}
+
+// getVersion resolves the edition and version of the request to the codebook
+// of that version, returning its number and the number of the latest version.
+// It returns false if the response is complete.
+func (api *API) getVersion(ctx context.Context, w http.ResponseWriter, r *http.Request) (*cantabular.Codebook, int, int, bool) {
+	current, ok := api.getCodebook(ctx, w, mux.Vars(r)["id"])
+	if !ok {
+		return nil, 0, 0, false
+	}
+
+	if !checkEdition(ctx, w, r) {
+		return nil, 0, 0, false
+	}
+
+	digests, ok := api.getDigests(ctx, w, current)
+	if !ok {
+		return nil, 0, 0, false
+	}
+
+	number, err := strconv.Atoi(mux.Vars(r)["version"])
+	if err != nil || number < 1 || number > len(digests) {
+		WriteBody(ctx, w, SimpleEntity{Message: "version not found"}, http.StatusNotFound)
+		return nil, 0, 0, false
+	}
+
+	version, err := api.getVersionCodebook(ctx, current, digests[number-1])
+	if errors.Is(err, store.ErrSnapshotNotFound) {
+		WriteBody(ctx, w, SimpleEntity{Message: "version not found"}, http.StatusNotFound)
+		return nil, 0, 0, false
+	}
+	if err != nil {
+		errEntity, status := getErrorResponse(ctx, err)
+		WriteBody(ctx, w, errEntity, status)
+		return nil, 0, 0, false
+	}
+
+	return version, number, len(digests), true
+}
+
+func (api *API) getVersionCodebook(ctx context.Context, current *cantabular.Codebook, digest string) (*cantabular.Codebook, error) {
+	if digest == current.Dataset.Digest {
+		return current, nil
+	}
+	return api.Store.Snapshot(ctx, current.Dataset.Name, digest)
+}
+
+// checkEdition writes a 404 for any edition but the one every dataset has,
+// returning false if it did.
+func checkEdition(ctx context.Context, w http.ResponseWriter, r *http.Request) bool {
+	if mux.Vars(r)["edition"] == cmdEdition {
+		return true
+	}
+
+	WriteBody(ctx, w, SimpleEntity{Message: "edition not found"}, http.StatusNotFound)
+	return false
+}
+
+func cmdDataset(d *cantabular.Dataset) dataset.DatasetDetails {
+	return dataset.DatasetDetails{
+		ID:          d.Name,
+		Title:       d.Name,
+		Description: d.Description,
+		State:       cmdStatePublished,
+		Links: dataset.Links{
+			Self:     cmdLink(d.Name, "/datasets/%s", d.Name),
+			Editions: cmdLink("", "/datasets/%s/editions", d.Name),
+		},
+	}
+}
+
+func cmdEditionFor(name string, latest int) dataset.Edition {
+	return dataset.Edition{
+		ID:      name,
+		Edition: cmdEdition,
+		State:   cmdStatePublished,
+		Links: dataset.Links{
+			Dataset:       cmdLink(name, "/datasets/%s", name),
+			Self:          cmdLink(cmdEdition, "/datasets/%s/editions/%s", name, cmdEdition),
+			Versions:      cmdLink("", "/datasets/%s/editions/%s/versions", name, cmdEdition),
+			LatestVersion: cmdLink(strconv.Itoa(latest), "%s", versionPath(name, latest)),
+		},
+	}
+}
+
+// cmdVersion describes a version of a dataset. Its downloads are queries of
+// the dataset by every dimension that is not mapped from a finer one.
+func cmdVersion(cb *cantabular.Codebook, number, latest int) dataset.Version {
+	name := cb.Dataset.Name
+	path := versionPath(name, number)
+
+	query := url.Values{}
+	for _, d := range cb.CodeBook {
+		if len(d.MapFrom) == 0 {
+			query.Add("v", d.Name)
+		}
+	}
+
+	downloads := make(map[string]dataset.Download)
+	for _, format := range []string{formatCSV, formatJSONStat} {
+		query.Set("format", format)
+		downloads[format] = dataset.Download{URL: absoluteURL(fmt.Sprintf("/v6/query/%s?%s", url.PathEscape(name), query.Encode()))}
+	}
+
+	return dataset.Version{
+		ID:                   cb.Dataset.Digest,
+		Edition:              cmdEdition,
+		Version:              number,
+		State:                cmdStatePublished,
+		NumberOfObservations: int64(cb.Dataset.Size),
+		Dimensions:           cmdDimensions(cb, number),
+		Downloads:            downloads,
+		Links: dataset.Links{
+			Dataset:       cmdLink(name, "/datasets/%s", name),
+			Edition:       cmdLink(cmdEdition, "/datasets/%s/editions/%s", name, cmdEdition),
+			Self:          cmdLink(strconv.Itoa(number), "%s", path),
+			Dimensions:    cmdLink("", "%s/dimensions", path),
+			LatestVersion: cmdLink(strconv.Itoa(latest), "%s", versionPath(name, latest)),
+		},
+	}
+}
+
+// cmdDimensions lists the dimensions of a version, linking to the dimension
+// and codes routes of the dataset
+func cmdDimensions(cb *cantabular.Codebook, number int) []dataset.VersionDimension {
+	name := cb.Dataset.Name
+	dims := make([]dataset.VersionDimension, 0, len(cb.CodeBook))
+	for _, d := range cb.CodeBook {
+		dims = append(dims, dataset.VersionDimension{
+			ID:    d.Name,
+			Name:  d.Name,
+			Label: d.Label,
+			URL:   absoluteURL(fmt.Sprintf("/v6/datasets/%s/dimensions/%s", name, d.Name)),
+			Links: dataset.Links{
+				CodeList: cmdLink(d.Name, "/v6/datasets/%s/dimensions/%s/codes", name, d.Name),
+				Options:  cmdLink("", "%s/dimensions/%s/options", versionPath(name, number), d.Name),
+				Version:  cmdLink(strconv.Itoa(number), "%s", versionPath(name, number)),
+			},
+		})
+	}
+	return dims
+}
+
+func versionPath(name string, number int) string {
+	return fmt.Sprintf("/datasets/%s/editions/%s/versions/%d", name, cmdEdition, number)
+}
+
+func cmdLink(id, format string, args ...interface{}) dataset.Link {
+	return dataset.Link{ID: id, URL: absoluteURL(fmt.Sprintf(format, args...))}
+}
@@ -0,0 +1,347 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/cantabular"
+	"github.com/ONSdigital/dp-code-list-api/models"
+	"github.com/gorilla/mux"
+)
+
+// codeList is a census variable as a code list. Its codes are the union of the
+// codes of the variable across every dataset that has it, in the order they
+// are first found.
+type codeList struct {
+	ID       string
+	Label    string
+	Codes    []string
+	Labels   map[string]string
+	Datasets []*cantabular.Codebook
+}
+
+// codeLists gathers the variables of every codebook into code lists, keeping
+// the order of the datasets and of their codebooks. The digest identifies the
+// codebooks the code lists were built from.
+type codeLists struct {
+	Digest string
+	Items  []*codeList
+	byID   map[string]*codeList
+}
+
+// GetCodeLists lists the census variables in the shape of the code-list-api
+func (api *API) GetCodeLists() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		lists, ok := api.getCodeLists(ctx, w)
+		if !ok {
+			return
+		}
+
+		if api.notModified(w, r, lists.Digest) {
+			return
+		}
+
+		items := make([]models.CodeList, 0, len(lists.Items))
+		for _, l := range lists.Items {
+			items = append(items, cmdCodeList(l))
+		}
+
+		length := len(items)
+		WriteBody(ctx, w, &models.CodeListResults{
+			Items:      items,
+			Count:      length,
+			Limit:      length,
+			TotalCount: length,
+		}, http.StatusOK)
+	})
+}
+
+// GetCodeList returns a census variable as a code list
+func (api *API) GetCodeList() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		list, digest, ok := api.getCodeList(ctx, w, r)
+		if !ok {
+			return
+		}
+
+		if api.notModified(w, r, digest) {
+			return
+		}
+
+		WriteBody(ctx, w, cmdCodeList(list), http.StatusOK)
+	})
+}
+
+// GetCodeListEditions lists the single edition of a code list
+func (api *API) GetCodeListEditions() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		list, digest, ok := api.getCodeList(ctx, w, r)
+		if !ok {
+			return
+		}
+
+		if api.notModified(w, r, digest) {
+			return
+		}
+
+		WriteBody(ctx, w, &models.Editions{
+			Items:      []models.Edition{cmdCodeListEdition(list)},
+			Count:      1,
+			Limit:      1,
+			TotalCount: 1,
+		}, http.StatusOK)
+	})
+}
+
+// GetCodeListEdition returns the edition of a code list
+func (api *API) GetCodeListEdition() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		list, digest, ok := api.getCodeList(ctx, w, r)
+		if !ok {
+			return
+		}
+
+		if !checkEdition(ctx, w, r) {
+			return
+		}
+
+		if api.notModified(w, r, digest) {
+			return
+		}
+
+		WriteBody(ctx, w, cmdCodeListEdition(list), http.StatusOK)
+	})
+}
+
+// GetCodeListCodes lists the codes of a code list
+func (api *API) GetCodeListCodes() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		list, digest, ok := api.getCodeList(ctx, w, r)
+		if !ok {
+			return
+		}
+
+		if !checkEdition(ctx, w, r) {
+			return
+		}
+
+		pg, ok := getPage(ctx, w, r)
+		if !ok {
+			return
+		}
+
+		if api.notModified(w, r, digest) {
+			return
+		}
+
+		codes := make([]models.Code, 0, len(list.Codes))
+		for _, code := range list.Codes {
+			codes = append(codes, cmdCode(list.ID, code, list.Labels[code]))
+		}
+
+		WriteBody(ctx, w, pg.codes(codes), http.StatusOK)
+	})
+}
+
+// GetCodeListCode returns a code of a code list
+func (api *API) GetCodeListCode() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		list, digest, ok := api.getCodeList(ctx, w, r)
+		if !ok {
+			return
+		}
+
+		if !checkEdition(ctx, w, r) {
+			return
+		}
+
+		code := mux.Vars(r)["code"]
+		label, found := list.Labels[code]
+		if !found {
+			WriteBody(ctx, w, SimpleEntity{Message: "code not found"}, http.StatusNotFound)
+			return
+		}
+
+		if api.notModified(w, r, digest) {
+			return
+		}
+
+		WriteBody(ctx, w, cmdCode(list.ID, code, label), http.StatusOK)
+	})
+}
+
+// GetCodeDatasets lists the datasets whose codebook has a code of a code
+// list, with links to the dataset-api routes of each.
+func (api *API) GetCodeDatasets() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		list, digest, ok := api.getCodeList(ctx, w, r)
+		if !ok {
+			return
+		}
+
+		if !checkEdition(ctx, w, r) {
+			return
+		}
+
+		code := mux.Vars(r)["code"]
+		if _, found := list.Labels[code]; !found {
+			WriteBody(ctx, w, SimpleEntity{Message: "code not found"}, http.StatusNotFound)
+			return
+		}
+
+		if api.notModified(w, r, digest) {
+			return
+		}
+
+		datasets := &models.Datasets{Items: make([]models.Dataset, 0)}
+		for _, cb := range list.Datasets {
+			dim := cb.GetDimension(list.ID)
+			if !hasCode(dim, code) {
+				continue
+			}
+
+			versions, err := api.versionDigests(ctx, cb)
+			if err != nil {
+				errEntity, status := getErrorResponse(ctx, err)
+				WriteBody(ctx, w, errEntity, status)
+				return
+			}
+
+			datasets.Items = append(datasets.Items, models.Dataset{
+				ID:             cb.Dataset.Name,
+				DimensionLabel: dim.Label,
+				Editions:       []models.DatasetEdition{{ID: cmdEdition, LatestVersion: len(versions)}},
+			})
+		}
+
+		if err := datasets.UpdateLinks(absoluteURL(""), list.ID); err != nil {
+			errEntity, status := getErrorResponse(ctx, err)
+			WriteBody(ctx, w, errEntity, status)
+			return
+		}
+
+		length := len(datasets.Items)
+		datasets.Count = length
+		datasets.Limit = length
+		datasets.TotalCount = length
+
+		WriteBody(ctx, w, datasets, http.StatusOK)
+	})
+}
+
+// getCodeLists builds the code lists from the codebook of every dataset,
+// writing the error response if any cannot be retrieved. It returns false if
+// the response is complete.
+func (api *API) getCodeLists(ctx context.Context, w http.ResponseWriter) (*codeLists, bool) {
+	datasets, err := api.Store.GetDatasets(ctx)
+	if err != nil {
+		errEntity, status := getErrorResponse(ctx, err)
+		WriteBody(ctx, w, errEntity, status)
+		return nil, false
+	}
+
+	codebooks := make([]*cantabular.Codebook, 0, len(datasets.Items))
+	for _, d := range datasets.Items {
+		codebook, ok := api.getCodebook(ctx, w, d.Name)
+		if !ok {
+			return nil, false
+		}
+		codebooks = append(codebooks, codebook)
+	}
+
+	return newCodeLists(codebooks), true
+}
+
+// getCodeList resolves the code list of the request along with the digest of
+// the codebooks it was built from. It returns false if the response is
+// complete.
+func (api *API) getCodeList(ctx context.Context, w http.ResponseWriter, r *http.Request) (*codeList, string, bool) {
+	lists, ok := api.getCodeLists(ctx, w)
+	if !ok {
+		return nil, "", false
+	}
+
+	list, found := lists.byID[mux.Vars(r)["id"]]
+	if !found {
+		WriteBody(ctx, w, SimpleEntity{Message: "code list not found"}, http.StatusNotFound)
+		return nil, "", false
+	}
+
+	return list, lists.Digest, true
+}
+
+func newCodeLists(codebooks []*cantabular.Codebook) *codeLists {
+	lists := &codeLists{byID: make(map[string]*codeList)}
+
+	digests := make([]string, 0, len(codebooks))
+	for _, cb := range codebooks {
+		digests = append(digests, cb.Dataset.Digest)
+
+		for i := range cb.CodeBook {
+			dim := &cb.CodeBook[i]
+
+			list, found := lists.byID[dim.Name]
+			if !found {
+				list = &codeList{ID: dim.Name, Label: dim.Label, Labels: make(map[string]string)}
+				lists.byID[dim.Name] = list
+				lists.Items = append(lists.Items, list)
+			}
+
+			list.Datasets = append(list.Datasets, cb)
+			for j, code := range dim.Codes {
+				if _, seen := list.Labels[code]; !seen {
+					list.Codes = append(list.Codes, code)
+					list.Labels[code] = dim.LabelAt(j)
+				}
+			}
+		}
+	}
+
+	lists.Digest = strings.Join(digests, "/")
+	return lists
+}
+
+func cmdCodeList(l *codeList) models.CodeList {
+	list := models.CodeList{ID: l.ID}
+	list.UpdateLinks(absoluteURL(""))
+	return list
+}
+
+func cmdCodeListEdition(l *codeList) models.Edition {
+	edition := models.Edition{ID: cmdEdition, Label: l.Label}
+	edition.UpdateLinks(l.ID, absoluteURL(""))
+	return edition
+}
+
+func cmdCode(codeListID, code, label string) models.Code {
+	c := models.Code{ID: code, Label: label}
+	c.UpdateLinks(absoluteURL(""), codeListID, cmdEdition)
+	return c
+}
+
+func hasCode(dim *cantabular.Dimension, code string) bool {
+	if dim == nil {
+		return false
+	}
+	for _, c := range dim.Codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/cantabular"
+)
+
+// GetComparison runs the same query against two datasets and returns their
+// counts side by side, aligned by code. Each table is tabulated as
+// /v6/query would, with the disclosure control rules of its dataset, before
+// they are compared, so a suppressed count has no difference.
+func (api *API) GetComparison() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		query := r.URL.Query()
+		datasets := []string{query.Get("left"), query.Get("right")}
+		variables := query["v"]
+
+		if datasets[0] == "" || datasets[1] == "" || len(variables) == 0 {
+			WriteBody(ctx, w, SimpleEntity{Message: "left and right datasets and at least one variable v are required"}, http.StatusBadRequest)
+			return
+		}
+
+		codebooks := make([]*cantabular.Codebook, len(datasets))
+		for i, dataset := range datasets {
+			codebook, ok := api.getCodebook(ctx, w, dataset)
+			if !ok {
+				return
+			}
+
+			for _, v := range variables {
+				if codebook.GetDimension(v) == nil {
+					WriteBody(ctx, w, SimpleEntity{Message: fmt.Sprintf("variable %s not found in dataset %s", v, dataset)}, http.StatusBadRequest)
+					return
+				}
+			}
+			codebooks[i] = codebook
+		}
+
+		if api.notModified(w, r, codebooks[0].Dataset.Digest+"/"+codebooks[1].Dataset.Digest) {
+			return
+		}
+
+		tables := make([]*cantabular.Table, len(datasets))
+		errs := make([]error, len(datasets))
+
+		var wg sync.WaitGroup
+		for i := range datasets {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				tables[i], errs[i] = api.tabulate(ctx, api.Store, codebooks[i], variables, nil, nil, "", nil)
+			}(i)
+		}
+		wg.Wait()
+
+		for _, err := range errs {
+			if err != nil {
+				writeQueryError(ctx, w, err)
+				return
+			}
+		}
+
+		comparison, err := cantabular.Compare(tables[0], tables[1])
+		if err != nil {
+			writeQueryError(ctx, w, err)
+			return
+		}
+
+		WriteBody(ctx, w, comparison, http.StatusOK)
+	})
+}
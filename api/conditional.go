@@ -0,0 +1,95 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// notModified sets the ETag and Cache-Control headers for a response derived
+// from the dataset with the given digest, and writes a 304 if the ETag matches
+// the one the client already holds. It returns true if the response is complete.
+func (api *API) notModified(w http.ResponseWriter, r *http.Request, digest string) bool {
+	if len(digest) == 0 {
+		return false
+	}
+
+	etag := datasetETag(digest, r)
+	w.Header().Set("ETag", etag)
+	w.Header().Add("Vary", "Accept")
+	w.Header().Set("Access-Control-Expose-Headers", "ETag")
+
+	if maxAge := api.maxAge(r); maxAge > 0 {
+		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int(maxAge.Seconds())))
+	}
+
+	if !etagMatches(r.Header.Get("If-None-Match"), etag) {
+		return false
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// clearValidators removes the caching headers set by notModified, for when a
+// response turns out to be an error after all.
+func clearValidators(w http.ResponseWriter) {
+	w.Header().Del("ETag")
+	w.Header().Del("Cache-Control")
+}
+
+// datasetETag builds a strong entity tag from the dataset digest, the request
+// path and parameters, and the representation asked for, so each distinct
+// view of a codebook version has its own tag.
+func datasetETag(digest string, r *http.Request) string {
+	h := sha256.New()
+	h.Write([]byte(digest))
+	h.Write([]byte{0})
+	h.Write([]byte(r.URL.Path))
+	h.Write([]byte{0})
+	h.Write([]byte(r.URL.Query().Encode()))
+	h.Write([]byte{0})
+	h.Write([]byte(r.Header.Get("Accept")))
+
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// etagMatches implements the weak comparison If-None-Match requires.
+func etagMatches(ifNoneMatch, etag string) bool {
+	if len(ifNoneMatch) == 0 {
+		return false
+	}
+
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+
+		if strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (api *API) maxAge(r *http.Request) time.Duration {
+	if api.Config == nil {
+		return 0
+	}
+
+	if route := mux.CurrentRoute(r); route != nil {
+		if maxAge, ok := api.Config.CacheMaxAge[route.GetName()]; ok {
+			return maxAge
+		}
+	}
+
+	return api.Config.DefaultCacheMaxAge
+}
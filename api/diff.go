@@ -0,0 +1,79 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/cantabular"
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/store"
+	"github.com/gorilla/mux"
+)
+
+// GetCodebookDiff lists the changes between two versions of a dataset
+// codebook, identified by digest. to defaults to the current version and from
+// to the version seen before it.
+func (api *API) GetCodebookDiff() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		dataset := mux.Vars(r)["dataset"]
+		from := r.URL.Query().Get("from")
+		to := r.URL.Query().Get("to")
+
+		if to == "" {
+			current, ok := api.getCodebook(ctx, w, dataset)
+			if !ok {
+				return
+			}
+			to = current.Dataset.Digest
+		}
+
+		digests, err := api.Store.Digests(ctx, dataset)
+		if err != nil && !errors.Is(err, store.ErrSnapshotNotFound) {
+			errEntity, status := getErrorResponse(ctx, err)
+			WriteBody(ctx, w, errEntity, status)
+			return
+		}
+
+		if from == "" {
+			from = previousDigest(digests, to)
+			if from == "" {
+				WriteBody(ctx, w, SimpleEntity{Message: fmt.Sprintf("no version of %s before %s", dataset, to)}, http.StatusNotFound)
+				return
+			}
+		}
+
+		versions := make([]*cantabular.Codebook, 0, 2)
+		for _, digest := range []string{from, to} {
+			cb, err := api.Store.Snapshot(ctx, dataset, digest)
+			if errors.Is(err, store.ErrSnapshotNotFound) {
+				WriteBody(ctx, w, SimpleEntity{Message: fmt.Sprintf("no version of %s with digest %s, known digests: %s", dataset, digest, strings.Join(digests, ", "))}, http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				errEntity, status := getErrorResponse(ctx, err)
+				WriteBody(ctx, w, errEntity, status)
+				return
+			}
+			versions = append(versions, cb)
+		}
+
+		if api.notModified(w, r, from+"/"+to) {
+			return
+		}
+
+		WriteBody(ctx, w, cantabular.Diff(versions[0], versions[1]), http.StatusOK)
+	})
+}
+
+// previousDigest returns the digest seen before the given one, or "" if there
+// was none
+func previousDigest(digests []string, digest string) string {
+	for i, d := range digests {
+		if d == digest && i > 0 {
+			return digests[i-1]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/ONSdigital/log.go/log"
+)
+
+const (
+	mediaTypeEventStream = "text/event-stream"
+
+	// eventsRetry is the reconnection delay given to clients, in milliseconds
+	eventsRetry = 3000
+)
+
+// GetEvents streams dataset digest changes, cache refreshes and FTB health
+// transitions as server-sent events. A client reconnecting with Last-Event-ID
+// is first sent the events it missed that are still buffered. A client too
+// slow to keep up is disconnected, and can catch up the same way.
+func (api *API) GetEvents() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		if api.Events == nil {
+			WriteBody(ctx, w, SimpleEntity{Message: "events are not enabled"}, http.StatusServiceUnavailable)
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			WriteBody(ctx, w, SimpleEntity{Message: "streaming is not supported"}, http.StatusInternalServerError)
+			return
+		}
+
+		sub, replay, err := api.Events.Subscribe(r.Header.Get("Last-Event-ID"))
+		if err != nil {
+			WriteBody(ctx, w, SimpleEntity{Message: err.Error()}, http.StatusBadRequest)
+			return
+		}
+		defer api.Events.Unsubscribe(sub)
+
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Content-Type", mediaTypeEventStream)
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+
+		fmt.Fprintf(w, "retry: %d\n\n", eventsRetry)
+		for _, e := range replay {
+			if _, err := e.WriteTo(w); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+
+		var heartbeat <-chan time.Time
+		if api.Config.EventsHeartbeat > 0 {
+			ticker := time.NewTicker(api.Config.EventsHeartbeat)
+			defer ticker.Stop()
+			heartbeat = ticker.C
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-heartbeat:
+				if _, err := io.WriteString(w, ": keepalive\n\n"); err != nil {
+					return
+				}
+			case e, ok := <-sub.C:
+				if !ok {
+					log.Event(ctx, "event stream closed for slow client", log.WARN)
+					io.WriteString(w, ": disconnected for falling behind, reconnect with Last-Event-ID\n\n")
+					flusher.Flush()
+					return
+				}
+				if _, err := e.WriteTo(w); err != nil {
+					return
+				}
+			}
+			flusher.Flush()
+		}
+	})
+}
@@ -0,0 +1,337 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/cantabular"
+	"github.com/ONSdigital/log.go/log"
+)
+
+const (
+	skosNamespace = "http://www.w3.org/2004/02/skos/core#"
+
+	formatJSON          = "json"
+	formatTurtle        = "turtle"
+	formatJSONLD        = "jsonld"
+	formatCSV           = "csv"
+	formatOutlineCSV    = "outline-csv"
+	outlineCSVLayout    = "outline"
+	mediaTypeJSON       = "application/json"
+	mediaTypeTurtle     = "text/turtle"
+	mediaTypeJSONLD     = "application/ld+json"
+	mediaTypeCSV        = "text/csv"
+	mediaTypeOutlineCSV = "text/csv; layout=outline"
+)
+
+// hierarchyFormats maps the format query parameter to the media type served
+var hierarchyFormats = map[string]string{
+	formatJSON:       mediaTypeJSON,
+	formatTurtle:     mediaTypeTurtle,
+	formatJSONLD:     mediaTypeJSONLD,
+	formatCSV:        mediaTypeCSV,
+	formatOutlineCSV: mediaTypeOutlineCSV,
+	formatNDJSON:     mediaTypeNDJSON,
+}
+
+// negotiateHierarchyFormat picks the representation of a hierarchy from the
+// format query parameter, or failing that the Accept header. It returns false
+// if no supported representation was asked for.
+func negotiateHierarchyFormat(r *http.Request) (string, bool) {
+	if format := r.URL.Query().Get("format"); format != "" {
+		_, ok := hierarchyFormats[format]
+		return format, ok
+	}
+
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return formatJSON, true
+	}
+
+	best := ""
+	bestQ := 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if v, err := strconv.ParseFloat(params["q"], 64); err == nil {
+			q = v
+		}
+
+		format := ""
+		switch mediaType {
+		case mediaTypeJSON, "application/*", "*/*":
+			format = formatJSON
+		case mediaTypeJSONLD:
+			format = formatJSONLD
+		case mediaTypeNDJSON:
+			format = formatNDJSON
+		case mediaTypeTurtle:
+			format = formatTurtle
+		case mediaTypeCSV, "text/*":
+			format = formatCSV
+			if params["layout"] == outlineCSVLayout {
+				format = formatOutlineCSV
+			}
+		}
+
+		if format != "" && q > bestQ {
+			best = format
+			bestQ = q
+		}
+	}
+
+	return best, best != ""
+}
+
+// writeHierarchy exports the hierarchy in the negotiated format as it is
+// walked, so that exports of every level need not build the tree in memory
+func writeHierarchy(ctx context.Context, w http.ResponseWriter, format, scheme string, rootDim *cantabular.Dimension, branch *cantabular.Branch, cb *cantabular.Codebook, maxDepth int) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", hierarchyFormats[format])
+	w.WriteHeader(http.StatusOK)
+
+	out := &flushWriter{Writer: bufio.NewWriter(w), w: w}
+
+	// walk calls fn as each code is entered, flushing the output as it goes
+	walk := func(fn func(e *cantabular.WalkEvent) error) error {
+		return cantabular.WalkHierarchy(ctx, rootDim, branch, cb, maxDepth, func(e *cantabular.WalkEvent) error {
+			if e.Leave {
+				return nil
+			}
+			if err := fn(e); err != nil {
+				return err
+			}
+			return out.visited()
+		})
+	}
+
+	var err error
+	switch format {
+	case formatTurtle:
+		err = writeTurtle(out, scheme, rootDim, walk)
+	case formatJSONLD:
+		err = writeJSONLD(out, scheme, rootDim, walk)
+	case formatCSV:
+		err = writeParentChildCSV(out, walk)
+	case formatOutlineCSV:
+		err = writeOutlineCSV(out, walk)
+	}
+
+	if err != nil {
+		// the status has already been sent, so all that can be done is to stop
+		log.Event(ctx, "failed to write hierarchy to response body", log.Error(err), log.ERROR, log.Data{"format": format})
+		return
+	}
+
+	out.Flush()
+}
+
+// hierarchyWalk walks the hierarchy being exported, calling fn as each code
+// is entered
+type hierarchyWalk func(fn func(e *cantabular.WalkEvent) error) error
+
+// conceptURI identifies a code as a SKOS concept by its hierarchy code URL
+func conceptURI(scheme, dimension, code string) string {
+	return fmt.Sprintf("%s/%s/code/%s", scheme, url.PathEscape(dimension), url.PathEscape(code))
+}
+
+// conceptURIs returns the concept URIs of the codes of a dimension
+func conceptURIs(scheme, dimension string, codes []string) []string {
+	uris := make([]string, 0, len(codes))
+	for _, code := range codes {
+		uris = append(uris, conceptURI(scheme, dimension, code))
+	}
+	return uris
+}
+
+func writeTurtle(w io.Writer, scheme string, rootDim *cantabular.Dimension, walk hierarchyWalk) error {
+	schemeURI := scheme + "/" + url.PathEscape(rootDim.Name)
+
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "@prefix skos: <%s> .\n\n", skosNamespace)
+	fmt.Fprintf(b, "<%s> a skos:ConceptScheme ;\n", schemeURI)
+	fmt.Fprintf(b, "    skos:prefLabel %s", turtleString(rootDim.Name))
+	if len(rootDim.Codes) > 0 {
+		fmt.Fprintf(b, " ;\n    skos:hasTopConcept %s", turtleIRIs(conceptURIs(scheme, rootDim.Name, rootDim.Codes)))
+	}
+	b.WriteString(" .\n")
+
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		return err
+	}
+
+	return walk(func(e *cantabular.WalkEvent) error {
+		b := &strings.Builder{}
+		fmt.Fprintf(b, "\n<%s> a skos:Concept ;\n", conceptURI(scheme, e.Type, e.Code))
+		fmt.Fprintf(b, "    skos:inScheme <%s> ;\n", schemeURI)
+		fmt.Fprintf(b, "    skos:notation %s ;\n", turtleString(e.Code))
+		fmt.Fprintf(b, "    skos:prefLabel %s", turtleString(e.Name))
+
+		if e.ParentCode == "" {
+			fmt.Fprintf(b, " ;\n    skos:topConceptOf <%s>", schemeURI)
+		} else {
+			fmt.Fprintf(b, " ;\n    skos:broader <%s>", conceptURI(scheme, e.ParentType, e.ParentCode))
+		}
+
+		if len(e.Children) > 0 {
+			fmt.Fprintf(b, " ;\n    skos:narrower %s", turtleIRIs(conceptURIs(scheme, e.ChildType, e.Children)))
+		}
+
+		b.WriteString(" .\n")
+		_, err := io.WriteString(w, b.String())
+		return err
+	})
+}
+
+// turtleIRIs writes a list of IRIs as the objects of a Turtle predicate
+func turtleIRIs(uris []string) string {
+	return "<" + strings.Join(uris, ">, <") + ">"
+}
+
+// turtleString quotes a literal as an English language string
+func turtleString(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+	return `"` + r.Replace(s) + `"@en`
+}
+
+// jsonLDContext maps the terms of the JSON-LD export to SKOS
+var jsonLDContext = map[string]interface{}{
+	"skos":          skosNamespace,
+	"prefLabel":     map[string]string{"@id": "skos:prefLabel", "@language": "en"},
+	"notation":      "skos:notation",
+	"inScheme":      idRef("skos:inScheme"),
+	"broader":       idRef("skos:broader"),
+	"narrower":      idRef("skos:narrower"),
+	"hasTopConcept": idRef("skos:hasTopConcept"),
+	"topConceptOf":  idRef("skos:topConceptOf"),
+}
+
+// writeJSONLD writes the scheme and its concepts as a JSON-LD graph, a
+// concept at a time
+func writeJSONLD(w io.Writer, scheme string, rootDim *cantabular.Dimension, walk hierarchyWalk) error {
+	schemeURI := scheme + "/" + url.PathEscape(rootDim.Name)
+
+	terms, err := json.Marshal(jsonLDContext)
+	if err != nil {
+		return err
+	}
+
+	node, err := json.Marshal(map[string]interface{}{
+		"@id":           schemeURI,
+		"@type":         "skos:ConceptScheme",
+		"prefLabel":     rootDim.Name,
+		"hasTopConcept": conceptURIs(scheme, rootDim.Name, rootDim.Codes),
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintf(w, `{"@context":%s,"@graph":[%s`, terms, node); err != nil {
+		return err
+	}
+
+	err = walk(func(e *cantabular.WalkEvent) error {
+		concept := map[string]interface{}{
+			"@id":       conceptURI(scheme, e.Type, e.Code),
+			"@type":     "skos:Concept",
+			"inScheme":  schemeURI,
+			"notation":  e.Code,
+			"prefLabel": e.Name,
+		}
+
+		if e.ParentCode == "" {
+			concept["topConceptOf"] = schemeURI
+		} else {
+			concept["broader"] = conceptURI(scheme, e.ParentType, e.ParentCode)
+		}
+
+		if len(e.Children) > 0 {
+			concept["narrower"] = conceptURIs(scheme, e.ChildType, e.Children)
+		}
+
+		b, err := json.Marshal(concept)
+		if err != nil {
+			return err
+		}
+
+		_, err = fmt.Fprintf(w, ",%s", b)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, "]}\n")
+	return err
+}
+
+// idRef defines a JSON-LD term whose values are IRIs
+func idRef(id string) map[string]string {
+	return map[string]string{"@id": id, "@type": "@id"}
+}
+
+// writeParentChildCSV writes one row per code with the code it belongs to
+func writeParentChildCSV(w io.Writer, walk hierarchyWalk) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"dimension", "code", "label", "parent_dimension", "parent_code"})
+
+	err := walk(func(e *cantabular.WalkEvent) error {
+		return out.Write([]string{e.Type, e.Code, e.Name, e.ParentType, e.ParentCode})
+	})
+	if err != nil {
+		return err
+	}
+
+	out.Flush()
+	return out.Error()
+}
+
+// writeOutlineCSV writes one row per code with its label indented into the
+// column for its level of the hierarchy. The hierarchy is walked twice, first
+// to find how many levels there are.
+func writeOutlineCSV(w io.Writer, walk hierarchyWalk) error {
+	levels := 0
+	err := walk(func(e *cantabular.WalkEvent) error {
+		if e.Depth > levels {
+			levels = e.Depth
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	header := []string{"code"}
+	for i := 1; i <= levels; i++ {
+		header = append(header, fmt.Sprintf("level_%d", i))
+	}
+
+	out := csv.NewWriter(w)
+	out.Write(header)
+
+	err = walk(func(e *cantabular.WalkEvent) error {
+		row := make([]string, levels+1)
+		row[0] = e.Code
+		row[e.Depth] = e.Name
+		return out.Write(row)
+	})
+	if err != nil {
+		return err
+	}
+
+	out.Flush()
+	return out.Error()
+}
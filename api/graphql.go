@@ -0,0 +1,316 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/cantabular"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+)
+
+const (
+	// maxGraphQLBody is the largest request body read from a POST to /graphql
+	maxGraphQLBody = 1 << 20
+
+	// graphQLListSize is the number of items a list field returns, and is
+	// assumed to when working out the complexity of a query, unless it is
+	// given a limit
+	graphQLListSize = 20
+
+	// graphQLMaxListSize is the most items a list field returns whatever its
+	// limit
+	graphQLMaxListSize = 1000
+
+	// graphQLTableCost is the complexity of a table field on top of the
+	// fields selected from it, as it runs a query against the FTB
+	graphQLTableCost = 100
+)
+
+// graphQLRequest is a query read from the body of a POST or the parameters of
+// a GET
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// GetGraphQL answers GraphQL queries over the datasets, dimensions, codes,
+// hierarchies and tables of the FTB. Queries deeper or more complex than the
+// limits in the config are rejected before any field is resolved.
+func (api *API) GetGraphQL() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		req, err := readGraphQLRequest(w, r)
+		if err != nil {
+			WriteBody(ctx, w, &graphql.Result{Errors: gqlerrors.FormatErrors(err)}, http.StatusBadRequest)
+			return
+		}
+
+		loader := &codebookLoader{store: api.Store, codebooks: make(map[string]*cantabular.Codebook)}
+		result, status := api.executeGraphQL(context.WithValue(ctx, codebookLoaderKey{}, loader), req)
+
+		if loader.stale {
+			w.Header().Set("Warning", staleWarning)
+		}
+
+		WriteBody(ctx, w, result, status)
+	})
+}
+
+func readGraphQLRequest(w http.ResponseWriter, r *http.Request) (*graphQLRequest, error) {
+	req := &graphQLRequest{}
+
+	if r.Method == http.MethodPost {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxGraphQLBody)).Decode(req); err != nil {
+			return nil, fmt.Errorf("invalid request body: %w", err)
+		}
+	} else {
+		query := r.URL.Query()
+		req.Query = query.Get("query")
+		req.OperationName = query.Get("operationName")
+
+		if v := query.Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				return nil, fmt.Errorf("invalid variables: %w", err)
+			}
+		}
+	}
+
+	if strings.TrimSpace(req.Query) == "" {
+		return nil, fmt.Errorf("a query is required")
+	}
+	return req, nil
+}
+
+// executeGraphQL parses, validates and checks the limits of a query before
+// running it, returning the result with the status to give it
+func (api *API) executeGraphQL(ctx context.Context, req *graphQLRequest) (*graphql.Result, int) {
+	doc, err := parser.Parse(parser.ParseParams{
+		Source: source.NewSource(&source.Source{Body: []byte(req.Query), Name: "GraphQL request"}),
+	})
+	if err != nil {
+		return &graphql.Result{Errors: gqlerrors.FormatErrors(err)}, http.StatusBadRequest
+	}
+
+	validation := graphql.ValidateDocument(api.GraphQL, doc, nil)
+	if !validation.IsValid {
+		return &graphql.Result{Errors: validation.Errors}, http.StatusBadRequest
+	}
+
+	if err := api.checkGraphQLLimits(doc, req); err != nil {
+		return &graphql.Result{Errors: gqlerrors.FormatErrors(err)}, http.StatusBadRequest
+	}
+
+	return graphql.Execute(graphql.ExecuteParams{
+		Schema:        *api.GraphQL,
+		AST:           doc,
+		OperationName: req.OperationName,
+		Args:          req.Variables,
+		Context:       ctx,
+	}), http.StatusOK
+}
+
+// codebookLoaderKey holds the codebookLoader of a GraphQL query in its context
+type codebookLoaderKey struct{}
+
+// codebookLoader holds the codebooks read while resolving one GraphQL query,
+// so each is read from the store once however many fields need it and every
+// field sees the same version.
+type codebookLoader struct {
+	store DataStore
+
+	mu        sync.Mutex
+	codebooks map[string]*cantabular.Codebook
+	stale     bool
+}
+
+func loaderFrom(ctx context.Context) *codebookLoader {
+	return ctx.Value(codebookLoaderKey{}).(*codebookLoader)
+}
+
+func (l *codebookLoader) get(ctx context.Context, dataset string) (*cantabular.Codebook, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if cb, ok := l.codebooks[dataset]; ok {
+		return cb, nil
+	}
+
+	cb, err := l.store.GetDatasetCodebook(ctx, dataset)
+	if err != nil {
+		return nil, err
+	}
+
+	l.codebooks[dataset] = cb
+	l.stale = l.stale || cb.Stale
+	return cb, nil
+}
+
+// checkGraphQLLimits works out the depth and complexity of the operation to
+// be run, rejecting it if either is over its limit. Every field costs one,
+// plus the cost of the fields selected from each item it returns. Fields of
+// the introspection schema are free.
+func (api *API) checkGraphQLLimits(doc *ast.Document, req *graphQLRequest) error {
+	c := &graphQLCost{
+		schema:    api.GraphQL,
+		fragments: make(map[string]*ast.FragmentDefinition),
+		variables: req.Variables,
+	}
+
+	var op *ast.OperationDefinition
+	for _, def := range doc.Definitions {
+		switch def := def.(type) {
+		case *ast.FragmentDefinition:
+			c.fragments[def.Name.Value] = def
+		case *ast.OperationDefinition:
+			if req.OperationName == "" || (def.Name != nil && def.Name.Value == req.OperationName) {
+				op = def
+			}
+		}
+	}
+	if op == nil {
+		// left for Execute to report
+		return nil
+	}
+
+	depth, complexity := c.measure(op.SelectionSet, api.GraphQL.QueryType(), 1)
+
+	if max := api.Config.GraphQLMaxDepth; max > 0 && depth > max {
+		return fmt.Errorf("query depth %d exceeds the limit of %d", depth, max)
+	}
+	if max := api.Config.GraphQLMaxComplexity; max > 0 && complexity > max {
+		return fmt.Errorf("query complexity %d exceeds the limit of %d", complexity, max)
+	}
+	return nil
+}
+
+type graphQLCost struct {
+	schema    *graphql.Schema
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]interface{}
+}
+
+// measure returns the depth and complexity of the fields selected from an
+// object. Fragments have been checked for cycles by validation.
+func (c *graphQLCost) measure(set *ast.SelectionSet, parent *graphql.Object, depth int) (int, int) {
+	maxDepth, complexity := 0, 0
+	if set == nil || parent == nil {
+		return maxDepth, complexity
+	}
+
+	add := func(d, cost int) {
+		if d > maxDepth {
+			maxDepth = d
+		}
+		complexity += cost
+	}
+
+	for _, sel := range set.Selections {
+		switch sel := sel.(type) {
+		case *ast.Field:
+			name := sel.Name.Value
+			if strings.HasPrefix(name, "__") {
+				continue
+			}
+
+			def, ok := parent.Fields()[name]
+			if !ok {
+				continue
+			}
+
+			cost := 1
+			if name == "table" {
+				cost += graphQLTableCost
+			}
+
+			object, list := unwrapOutput(def.Type)
+			if object == nil || sel.SelectionSet == nil {
+				add(depth, cost)
+				continue
+			}
+
+			d, childCost := c.measure(sel.SelectionSet, object, depth+1)
+			if list {
+				childCost *= c.listSize(sel)
+			}
+			add(d, cost+childCost)
+
+		case *ast.InlineFragment:
+			object := parent
+			if sel.TypeCondition != nil {
+				object, _ = c.schema.Type(sel.TypeCondition.Name.Value).(*graphql.Object)
+			}
+			add(c.measure(sel.SelectionSet, object, depth))
+
+		case *ast.FragmentSpread:
+			fragment, ok := c.fragments[sel.Name.Value]
+			if !ok {
+				continue
+			}
+			object, _ := c.schema.Type(fragment.TypeCondition.Name.Value).(*graphql.Object)
+			add(c.measure(fragment.SelectionSet, object, depth))
+		}
+	}
+
+	return maxDepth, complexity
+}
+
+// listSize returns the number of items a list field is assumed to return:
+// its limit argument if it has one, up to graphQLMaxListSize, or
+// graphQLListSize
+func (c *graphQLCost) listSize(field *ast.Field) int {
+	for _, arg := range field.Arguments {
+		if arg.Name.Value != "limit" {
+			continue
+		}
+
+		n := -1
+		switch v := arg.Value.(type) {
+		case *ast.IntValue:
+			if i, err := strconv.Atoi(v.Value); err == nil {
+				n = i
+			}
+		case *ast.Variable:
+			if f, ok := c.variables[v.Name.Value].(float64); ok {
+				n = int(f)
+			}
+		}
+
+		if n > graphQLMaxListSize {
+			return graphQLMaxListSize
+		}
+		if n >= 0 {
+			return n
+		}
+	}
+	return graphQLListSize
+}
+
+// unwrapOutput returns the object type a field resolves to, if it is one, and
+// whether it is a list of them
+func unwrapOutput(t graphql.Output) (*graphql.Object, bool) {
+	list := false
+	for {
+		switch typ := t.(type) {
+		case *graphql.NonNull:
+			t = typ.OfType
+		case *graphql.List:
+			list = true
+			t = typ.OfType
+		case *graphql.Object:
+			return typ, list
+		default:
+			return nil, list
+		}
+	}
+}
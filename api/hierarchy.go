@@ -2,8 +2,10 @@ package api
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
 
 	"github.com/ONSdigital/dp-census-alpha-api-proxy/cantabular"
@@ -19,10 +21,8 @@ func (api *API) GetHierarchy() http.Handler {
 		dataset := mux.Vars(r)["dataset"]
 		h := mux.Vars(r)["name"]
 
-		cb, err := api.Store.GetDatasetCodebook(ctx, dataset)
-		if err != nil {
-			errEntity, status := getErrorResponse(ctx, err)
-			WriteBody(ctx, w, errEntity, status)
+		cb, ok := api.getCodebook(ctx, w, dataset)
+		if !ok {
 			return
 		}
 
@@ -32,43 +32,82 @@ func (api *API) GetHierarchy() http.Handler {
 			return
 		}
 
-		hierarchyCodes := getHierarchyLevel(dataset, dim.Name, cb)
+		branch, ok := getBranch(ctx, w, r, dim)
+		if !ok {
+			return
+		}
+
+		if api.notModified(w, r, cb.Dataset.Digest) {
+			return
+		}
+
+		hierarchyCodes := getHierarchyLevel(dataset, dim, branch)
 		WriteBody(ctx, w, hierarchyCodes, http.StatusOK)
 	})
 }
 
-func getHierarchyLevel(dataset, rootDim string, cb *cantabular.Codebook) *hierarchy.Response {
-	dim := cb.GetDimension(rootDim)
-
+func getHierarchyLevel(dataset string, dim *cantabular.Dimension, branch *cantabular.Branch) *hierarchy.Response {
 	elements := make([]*hierarchy.Element, 0)
 	for i, code := range dim.Codes {
-		index, found := dim.GetDescendantCodeIndices(code)
+		index, found := branch.GetDescendantCodeIndices(code)
 
 		el := &hierarchy.Element{
-			Label:        dim.Labels[i],
-			NoOfChildren: int64(index.Count),
+			Label: dim.LabelAt(i),
 			Links: map[string]hierarchy.Link{
-				"code":     newLink(code, fmt.Sprintf("/v6/datasets/%s/hierarchies/%s/code/%s", dataset, dim.Name, code)),
-				"self":     newLink(dim.Name, fmt.Sprintf("/v6/datasets/%s/hierarchies/%s", dataset, dim.Name)),
-				"children": newLink(dim.MapFrom[0], fmt.Sprintf("/v6/datasets/%s/hierarchies/%s", dataset, dim.MapFrom[0])),
+				"code":     newLink(code, fmt.Sprintf("/v6/datasets/%s/hierarchies/%s/code/%s?branch=%s", dataset, dim.Name, code, branch.Child)),
+				"self":     newLink(dim.Name, fmt.Sprintf("/v6/datasets/%s/hierarchies/%s?branch=%s", dataset, dim.Name, branch.Child)),
+				"children": newLink(branch.Child, fmt.Sprintf("/v6/datasets/%s/hierarchies/%s", dataset, branch.Child)),
 			},
 			HasData: found,
 		}
 
+		if found {
+			el.NoOfChildren = int64(index.Count)
+		}
+
 		elements = append(elements, el)
 	}
 
 	return &hierarchy.Response{
-		ID:           dim.MapFrom[0],
+		ID:           branch.Child,
 		Label:        dim.Label,
 		Children:     elements,
 		NoOfChildren: int64(len(elements)),
-		Links:        nil,
+		Links:        branchLinks(dataset, dim),
 		HasData:      len(elements) > 0,
 		Breadcrumbs:  nil,
 	}
 }
 
+// branchLinks links to the hierarchy through each dimension dim maps from
+func branchLinks(dataset string, dim *cantabular.Dimension) map[string]hierarchy.Link {
+	links := make(map[string]hierarchy.Link)
+	for _, b := range dim.Branches() {
+		links["branch:"+b.Child] = newLink(b.Child, fmt.Sprintf("/v6/datasets/%s/hierarchies/%s?branch=%s", dataset, dim.Name, b.Child))
+	}
+	return links
+}
+
+// getBranch resolves the branch of a dimension named by the branch query
+// parameter, defaulting to the first. It writes a 404 for a dimension with no
+// hierarchy below it and a 400 for an unknown branch, returning false if the
+// response is complete.
+func getBranch(ctx context.Context, w http.ResponseWriter, r *http.Request, dim *cantabular.Dimension) (*cantabular.Branch, bool) {
+	branch, err := dim.Branch(r.URL.Query().Get("branch"))
+	if err == nil {
+		return branch, true
+	}
+
+	var notFound cantabular.BranchNotFoundError
+	if errors.As(err, &notFound) {
+		WriteBody(ctx, w, SimpleEntity{Message: err.Error()}, http.StatusBadRequest)
+		return nil, false
+	}
+
+	WriteBody(ctx, w, SimpleEntity{Message: fmt.Sprintf("dimension %s is not mapped from any other dimension", dim.Name)}, http.StatusNotFound)
+	return nil, false
+}
+
 func (api *API) GetHierarchyForCode() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
@@ -76,10 +115,8 @@ func (api *API) GetHierarchyForCode() http.Handler {
 		d := mux.Vars(r)["name"]
 		dimensionCode := mux.Vars(r)["code"]
 
-		codebook, err := api.Store.GetDatasetCodebook(ctx, dataset)
-		if err != nil {
-			errEntity, status := getErrorResponse(ctx, err)
-			WriteBody(ctx, w, errEntity, status)
+		codebook, ok := api.getCodebook(ctx, w, dataset)
+		if !ok {
 			return
 		}
 
@@ -89,13 +126,32 @@ func (api *API) GetHierarchyForCode() http.Handler {
 			return
 		}
 
-		h := getHierarchyEntry(dataset, dimensionCode, rootDim, codebook)
+		var branch *cantabular.Branch
+		if len(rootDim.MapFrom) > 0 {
+			branch, ok = getBranch(ctx, w, r, rootDim)
+			if !ok {
+				return
+			}
+		}
+
+		if api.notModified(w, r, codebook.Dataset.Digest) {
+			return
+		}
+
+		h := getHierarchyEntry(dataset, dimensionCode, branch, codebook)
 		WriteBody(ctx, w, h, http.StatusOK)
 	})
 }
 
-func getHierarchyEntry(dataset, dimensionCode string, rootDim *cantabular.Dimension, cb *cantabular.Codebook) *hierarchy.Response {
-	if len(rootDim.MapFrom) == 0 {
+// getHierarchyEntry lists the children of a code through a branch of its
+// dimension, or returns an entry with no children if branch is nil.
+func getHierarchyEntry(dataset, dimensionCode string, branch *cantabular.Branch, cb *cantabular.Codebook) *hierarchy.Response {
+	var childDim *cantabular.Dimension
+	if branch != nil {
+		childDim = cb.GetDimension(branch.Child)
+	}
+
+	if childDim == nil {
 		return &hierarchy.Response{
 			ID:           dimensionCode,
 			Label:        dimensionCode,
@@ -109,13 +165,11 @@ func getHierarchyEntry(dataset, dimensionCode string, rootDim *cantabular.Dimens
 
 	elements := make([]*hierarchy.Element, 0)
 
-	childName := rootDim.MapFrom[0]
-	childDim := cb.GetDimension(childName)
-
-	index, found := rootDim.GetDescendantCodeIndices(dimensionCode)
+	childName := childDim.Name
+	index, found := branch.GetDescendantCodeIndices(dimensionCode)
 
 	if found {
-		for i := index.Start; i <= index.End; i++ {
+		for i := index.Start; i <= index.End && i < len(childDim.Codes); i++ {
 			descendentCode := childDim.Codes[i]
 			descendentIndex, descendentsExist := childDim.GetDescendantCodeIndices(descendentCode)
 
@@ -129,7 +183,7 @@ func getHierarchyEntry(dataset, dimensionCode string, rootDim *cantabular.Dimens
 			}
 
 			if descendentsExist {
-				el.Label = childDim.Labels[i]
+				el.Label = childDim.LabelAt(i)
 				el.NoOfChildren = int64(descendentIndex.Count)
 			}
 
@@ -148,35 +202,175 @@ func getHierarchyEntry(dataset, dimensionCode string, rootDim *cantabular.Dimens
 	}
 }
 
+// GetHierarchyParents returns the codes of coarser dimensions that a code is
+// mapped into, from its immediate parent up to the root of the hierarchy,
+// both through the nearest parent dimension and through every one.
+func (api *API) GetHierarchyParents() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		dataset := mux.Vars(r)["dataset"]
+		dimensionName := mux.Vars(r)["name"]
+		code := mux.Vars(r)["code"]
+
+		codebook, ok := api.getCodebook(ctx, w, dataset)
+		if !ok {
+			return
+		}
+
+		ancestors, found := codebook.GetAncestors(dimensionName, code)
+		if !found {
+			WriteBody(ctx, w, SimpleEntity{Message: "not found"}, http.StatusNotFound)
+			return
+		}
+
+		if api.notModified(w, r, codebook.Dataset.Digest) {
+			return
+		}
+
+		dim := codebook.GetDimension(dimensionName)
+		label := code
+		for i, c := range dim.Codes {
+			if c == code {
+				label = dim.LabelAt(i)
+				break
+			}
+		}
+
+		resp := ParentsResponse{
+			Dimension: dim.Name,
+			Code:      code,
+			Label:     label,
+			Ancestors: make([]*HierarchyCode, 0, len(ancestors)),
+		}
+
+		for _, a := range ancestors {
+			resp.Ancestors = append(resp.Ancestors, hierarchyCode(dataset, a))
+		}
+
+		if len(resp.Ancestors) > 0 {
+			resp.Parent = resp.Ancestors[0]
+		}
+
+		paths, _ := codebook.GetAncestorPaths(dimensionName, code)
+		resp.Paths = make([][]*HierarchyCode, 0, len(paths))
+		for _, path := range paths {
+			codes := make([]*HierarchyCode, 0, len(path))
+			for _, a := range path {
+				codes = append(codes, hierarchyCode(dataset, a))
+			}
+			resp.Paths = append(resp.Paths, codes)
+		}
+
+		WriteBody(ctx, w, resp, http.StatusOK)
+	})
+}
+
+// hierarchyCode links to an ancestor of a code and its own parents
+func hierarchyCode(dataset string, a *cantabular.Ancestor) *HierarchyCode {
+	code := a.Dimension.Codes[a.Index]
+	return &HierarchyCode{
+		Dimension: a.Dimension.Name,
+		Code:      code,
+		Label:     a.Dimension.LabelAt(a.Index),
+		Links: map[string]hierarchy.Link{
+			"code":    newLink(code, fmt.Sprintf("/v6/datasets/%s/hierarchies/%s/code/%s", dataset, a.Dimension.Name, code)),
+			"parents": newLink(code, fmt.Sprintf("/v6/datasets/%s/hierarchies/%s/code/%s/parents", dataset, a.Dimension.Name, code)),
+		},
+	}
+}
+
 func (api *API) BuildFullHierarchy() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		dataset := mux.Vars(r)["dataset"]
 		dimensionName := mux.Vars(r)["name"]
 
-		depth, err := strconv.Atoi(r.URL.Query().Get("depth"))
-		if err != nil {
-			log.Event(ctx, "invalid depth provided applying default", log.WARN)
-			depth = 2 // default to a sensible value
+		format, ok := negotiateHierarchyFormat(r)
+		if !ok {
+			WriteBody(ctx, w, SimpleEntity{Message: "unsupported hierarchy format"}, http.StatusNotAcceptable)
+			return
 		}
 
-		codebook, err := api.Store.GetDatasetCodebook(context.Background(), dataset)
-		if err != nil {
-			errEntity, status := getErrorResponse(ctx, err)
-			WriteBody(ctx, w, errEntity, status)
+		stream := format == formatNDJSON || r.URL.Query().Get("stream") == "true"
+		walked := stream || format != formatJSON
+		depth := getDepth(ctx, r, walked)
+
+		codebook, ok := api.getCodebook(ctx, w, dataset)
+		if !ok {
 			return
 		}
 
 		rootDimension := codebook.GetDimension(dimensionName)
-		h := cantabular.BuildHierarchyFrom(rootDimension, codebook, depth)
-		WriteBody(ctx, w, h, http.StatusOK)
+		if rootDimension == nil {
+			WriteBody(ctx, w, SimpleEntity{Message: "not found"}, http.StatusNotFound)
+			return
+		}
+
+		branch, ok := getBranch(ctx, w, r, rootDimension)
+		if !ok {
+			return
+		}
+
+		if api.notModified(w, r, codebook.Dataset.Digest) {
+			return
+		}
+
+		// only the walk, with its explicit stack, goes down to the leaves; the
+		// tree built in memory is always given a depth
+		if format == formatJSON && !stream && depth == allLevels {
+			stream = true
+		}
+
+		switch {
+		case format == formatJSON && !stream:
+			if depth > len(codebook.CodeBook) {
+				// no hierarchy is deeper than the codebook has dimensions,
+				// unless it maps cyclically
+				depth = len(codebook.CodeBook)
+			}
+			WriteBody(ctx, w, cantabular.BuildHierarchyFrom(rootDimension, branch, codebook, depth), http.StatusOK)
+		case format == formatJSON || format == formatNDJSON:
+			streamHierarchy(ctx, w, format, rootDimension, branch, codebook, depth)
+		default:
+			scheme := absoluteURL(fmt.Sprintf("/v6/datasets/%s/hierarchies", url.PathEscape(dataset)))
+			writeHierarchy(ctx, w, format, scheme, rootDimension, branch, codebook, depth)
+		}
 	})
 }
 
+// allLevels is the depth that walks a hierarchy down to its leaves
+const allLevels = 0
+
+// getDepth reads the depth query parameter, where "all" means down to the
+// leaves and 0, as ever, the top level only. Without it a walked hierarchy,
+// streamed or exported, goes down to the leaves, while the JSON one built in
+// memory defaults to two levels.
+func getDepth(ctx context.Context, r *http.Request, walked bool) int {
+	param := r.URL.Query().Get("depth")
+	if param == "all" || (param == "" && walked) {
+		return allLevels
+	}
+
+	depth, err := strconv.Atoi(param)
+	if err != nil || depth < 0 {
+		log.Event(ctx, "invalid depth provided applying default", log.WARN)
+		return 2 // default to a sensible value
+	}
+
+	if depth == 0 {
+		return 1
+	}
+	return depth
+}
+
 func newLink(id, path string) hierarchy.Link {
-	cfg, _ := config.Get()
 	return hierarchy.Link{
 		ID:   id,
-		HRef: fmt.Sprintf("http://%s%s%s", cfg.IPAddr, cfg.BindAddr, path),
+		HRef: absoluteURL(path),
 	}
 }
+
+func absoluteURL(path string) string {
+	cfg, _ := config.Get()
+	return fmt.Sprintf("http://%s%s%s", cfg.IPAddr, cfg.BindAddr, path)
+}
@@ -0,0 +1,192 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/cantabular"
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/jobs"
+	"github.com/gorilla/mux"
+)
+
+// maxJobBody is the largest request body read from a POST to /v6/jobs
+const maxJobBody = 1 << 20
+
+// JobResponse is a query job with links to itself and, once it has
+// succeeded, to its result in each format
+type JobResponse struct {
+	jobs.Job
+	Links JobLinks `json:"links"`
+}
+
+type JobLinks struct {
+	Self    string            `json:"self"`
+	Results map[string]string `json:"results,omitempty"`
+}
+
+// PostJob queues a query to be run in the background, for tables too slow to
+// build within a request. The query is checked against the codebook first so
+// a mistake is reported straight away rather than as a failed job.
+func (api *API) PostJob() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		if api.Jobs == nil {
+			WriteBody(ctx, w, SimpleEntity{Message: "query jobs are not enabled"}, http.StatusServiceUnavailable)
+			return
+		}
+
+		var query jobs.Query
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxJobBody)).Decode(&query); err != nil {
+			WriteBody(ctx, w, SimpleEntity{Message: "invalid query: " + err.Error()}, http.StatusBadRequest)
+			return
+		}
+
+		if query.Dataset == "" || len(query.Variables) == 0 {
+			WriteBody(ctx, w, SimpleEntity{Message: "a dataset and at least one variable are required"}, http.StatusBadRequest)
+			return
+		}
+
+		if err := cantabular.CheckOptions(query.Margins, query.Percent); err != nil {
+			WriteBody(ctx, w, SimpleEntity{Message: err.Error()}, http.StatusBadRequest)
+			return
+		}
+
+		codebook, ok := api.getCodebook(ctx, w, query.Dataset)
+		if !ok {
+			return
+		}
+
+		for _, names := range [][]string{query.Variables, query.Aggregate} {
+			for _, name := range names {
+				if codebook.GetDimension(name) == nil {
+					WriteBody(ctx, w, SimpleEntity{Message: fmt.Sprintf("variable %s not found in dataset %s", name, query.Dataset)}, http.StatusBadRequest)
+					return
+				}
+			}
+		}
+
+		job, err := api.Jobs.Submit(ctx, query)
+		if err == jobs.ErrQueueFull {
+			WriteBody(ctx, w, SimpleEntity{Message: err.Error()}, http.StatusServiceUnavailable)
+			return
+		}
+		if err != nil {
+			errEntity, status := getErrorResponse(ctx, err)
+			WriteBody(ctx, w, errEntity, status)
+			return
+		}
+
+		resp := newJobResponse(job)
+		w.Header().Set("Location", resp.Links.Self)
+		WriteBody(ctx, w, resp, http.StatusAccepted)
+	})
+}
+
+// GetJob reports the status and progress of a query job
+func (api *API) GetJob() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		job, ok := api.getJob(ctx, w, mux.Vars(r)["job"])
+		if !ok {
+			return
+		}
+
+		WriteBody(ctx, w, newJobResponse(job), http.StatusOK)
+	})
+}
+
+// GetJobResult downloads the table a query job produced, in any of the
+// formats of /v6/query/{dataset}
+func (api *API) GetJobResult() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		format, ok := negotiateTableFormat(r)
+		if !ok {
+			WriteBody(ctx, w, SimpleEntity{Message: "unsupported table format"}, http.StatusNotAcceptable)
+			return
+		}
+
+		job, ok := api.getJob(ctx, w, mux.Vars(r)["job"])
+		if !ok {
+			return
+		}
+
+		table, err := api.Jobs.Result(job.ID)
+		switch err {
+		case nil:
+		case jobs.ErrNotFound:
+			WriteBody(ctx, w, SimpleEntity{Message: err.Error()}, http.StatusNotFound)
+			return
+		case jobs.ErrNotReady:
+			WriteBody(ctx, w, SimpleEntity{Message: fmt.Sprintf("job is %s", job.Status)}, http.StatusConflict)
+			return
+		default:
+			errEntity, status := getErrorResponse(ctx, err)
+			WriteBody(ctx, w, errEntity, status)
+			return
+		}
+
+		ext := format
+		if format == formatJSONStat {
+			ext = formatJSON
+		}
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s.%s"`, job.Query.Dataset, job.ID, ext))
+		writeTable(ctx, w, format, table)
+	})
+}
+
+// getJob looks up a job, writing the error response if it cannot be found.
+// It returns false if the response is complete.
+func (api *API) getJob(ctx context.Context, w http.ResponseWriter, id string) (*jobs.Job, bool) {
+	if api.Jobs == nil {
+		WriteBody(ctx, w, SimpleEntity{Message: "query jobs are not enabled"}, http.StatusServiceUnavailable)
+		return nil, false
+	}
+
+	job, ok := api.Jobs.Get(id)
+	if !ok {
+		WriteBody(ctx, w, SimpleEntity{Message: jobs.ErrNotFound.Error()}, http.StatusNotFound)
+		return nil, false
+	}
+	return job, true
+}
+
+// RunQueryJob tabulates the query of a job as /v6/query/{dataset} would,
+// reporting each stage as its progress
+func (api *API) RunQueryJob(ctx context.Context, query jobs.Query, progress func(jobs.Progress)) (*cantabular.Table, error) {
+	codebook, err := api.Store.GetDatasetCodebook(ctx, query.Dataset)
+	if err != nil {
+		return nil, clientError(ctx, err)
+	}
+
+	querier := api.JobQuerier
+	if querier == nil {
+		querier = api.Store
+	}
+
+	table, err := api.tabulate(ctx, querier, codebook, query.Variables, query.Aggregate, query.Margins, query.Percent, func(stage string, step, steps int) {
+		progress(jobs.Progress{Stage: stage, Step: step, Steps: steps})
+	})
+	if err != nil {
+		return nil, clientError(ctx, err)
+	}
+	return table, nil
+}
+
+func newJobResponse(job *jobs.Job) *JobResponse {
+	self := absoluteURL("/v6/jobs/" + job.ID)
+	resp := &JobResponse{Job: *job, Links: JobLinks{Self: self}}
+
+	if job.Status == jobs.StatusSucceeded {
+		resp.Links.Results = make(map[string]string)
+		for format := range tableFormats {
+			resp.Links.Results[format] = self + "/result?format=" + format
+		}
+	}
+	return resp
+}
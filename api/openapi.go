@@ -0,0 +1,734 @@
+package api
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ONSdigital/dp-api-clients-go/dataset"
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/cantabular"
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/jobs"
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/openapi"
+	"github.com/ONSdigital/dp-code-list-api/models"
+	filterModel "github.com/ONSdigital/dp-filter-api/models"
+	hierarchy "github.com/ONSdigital/dp-hierarchy-api/models"
+	"github.com/ONSdigital/log.go/log"
+)
+
+const (
+	specVersion = "1.0.0"
+
+	securityScheme = "bearerAuth"
+
+	tagDatasets    = "datasets"
+	tagHierarchies = "hierarchies"
+	tagQueries     = "queries"
+	tagCMD         = "cmd"
+	tagCodeLists   = "code-lists"
+	tagFTB         = "ftb"
+	tagGraphQL     = "graphql"
+	tagBatch       = "batch"
+	tagJobs        = "jobs"
+	tagEvents      = "events"
+	tagDocs        = "docs"
+)
+
+// pathParameters describes the path parameters of the routes by name
+var pathParameters = map[string]string{
+	"dataset":   "Name of the dataset",
+	"name":      "Name of a dimension of the dataset",
+	"index":     "Position of the code in the dimension, from 0",
+	"code":      "Code of a category",
+	"id":        "Name of the dataset or, for a code list, of the census variable",
+	"edition":   "Edition, which is always " + cmdEdition,
+	"version":   "Version of the dataset, from 1 for the oldest codebook digest seen",
+	"dimension": "Name of a dimension of the version",
+	"job":       "ID of a query job",
+}
+
+// operation describes a route for the OpenAPI document
+type operation struct {
+	route       string
+	path        string
+	method      string
+	summary     string
+	description string
+	tag         string
+	params      []*openapi.Parameter
+	content     map[string]*openapi.MediaType
+	requestBody *openapi.RequestBody
+
+	// status is that of a successful response, if it is not 200
+	status int
+
+	// responses documents statuses other than 200 and the default error
+	responses map[string]*openapi.Response
+
+	// unconditional is set for routes that do not answer If-None-Match
+	unconditional bool
+	public        bool
+}
+
+// buildSpec documents the routes registered in Setup. Paths are read from the
+// router by route name so the document cannot drift from the routes it
+// describes; the FTB routes passed through by prefix are listed by path.
+func (api *API) buildSpec() *openapi.Document {
+	doc := openapi.New(openapi.Info{
+		Title:       "Census alpha API proxy",
+		Description: "Proxies the flexible table builder (FTB), adding hierarchies, queries, disclosure control and CMD compatible routes.",
+		Version:     specVersion,
+	})
+
+	doc.Servers = []openapi.Server{{URL: absoluteURL("")}}
+	doc.Tags = []openapi.Tag{
+		{Name: tagDatasets, Description: "Dimensions and codes of the codebook of a dataset"},
+		{Name: tagHierarchies, Description: "Hierarchies formed by the dimensions a dimension maps from"},
+		{Name: tagQueries, Description: "Counts for combinations of the categories of dimensions"},
+		{Name: tagCMD, Description: "Datasets in the shape of the CMD dataset API"},
+		{Name: tagCodeLists, Description: "Census variables in the shape of the CMD code-list API"},
+		{Name: tagFTB, Description: "Responses of the FTB passed through unchanged"},
+		{Name: tagGraphQL, Description: "Datasets, dimensions, codes, hierarchies and tables in one query"},
+		{Name: tagBatch, Description: "Several requests in one round trip"},
+		{Name: tagJobs, Description: "Queries run in the background, for tables too slow to build within a request"},
+		{Name: tagEvents, Description: "Live updates to datasets, the codebook cache and the FTB"},
+		{Name: tagDocs, Description: "This document"},
+	}
+	doc.Components.SecuritySchemes[securityScheme] = &openapi.SecurityScheme{
+		Type:        "http",
+		Scheme:      "bearer",
+		Description: "The token the proxy is configured with, as Authorization: Bearer <token>",
+	}
+
+	for _, op := range api.operations(doc) {
+		path := op.path
+		if op.route != "" {
+			tmpl, err := api.Router.Get(op.route).GetPathTemplate()
+			if err != nil {
+				log.Event(nil, "failed to document route", log.ERROR, log.Error(err), log.Data{"route": op.route})
+				continue
+			}
+			path = tmpl
+		}
+
+		method := op.method
+		if method == "" {
+			method = http.MethodGet
+		}
+		doc.Add(method, path, api.newOperation(doc, method, path, op))
+	}
+
+	patchDimensionSchema(doc)
+	return doc
+}
+
+func (api *API) newOperation(doc *openapi.Document, method, path string, op operation) *openapi.Operation {
+	id := op.route
+	if id == "" {
+		id = "ftb" + strings.Replace(strings.Replace(path, "/", "-", -1), "{", "", -1)
+		id = strings.Replace(id, "}", "", -1)
+	}
+	if method != http.MethodGet {
+		id += "-" + strings.ToLower(method)
+	}
+
+	status := op.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	o := &openapi.Operation{
+		OperationID: id,
+		Summary:     op.summary,
+		Description: op.description,
+		Tags:        []string{op.tag},
+		RequestBody: op.requestBody,
+		Responses: map[string]*openapi.Response{
+			strconv.Itoa(status): {Description: http.StatusText(status), Content: op.content},
+			"default": {
+				Description: "Error",
+				Content:     jsonContent(doc, SimpleEntity{}),
+			},
+		},
+	}
+
+	for _, segment := range strings.Split(path, "/") {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			name := segment[1 : len(segment)-1]
+			schema := &openapi.Schema{Type: "string"}
+			if name == "index" || name == "version" {
+				schema = &openapi.Schema{Type: "integer"}
+			}
+			o.Parameters = append(o.Parameters, &openapi.Parameter{
+				Name:        name,
+				In:          "path",
+				Description: pathParameters[name],
+				Required:    true,
+				Schema:      schema,
+			})
+		}
+	}
+	o.Parameters = append(o.Parameters, op.params...)
+
+	for status, resp := range op.responses {
+		o.Responses[status] = resp
+	}
+
+	if !op.unconditional {
+		o.Parameters = append(o.Parameters, &openapi.Parameter{
+			Name:        "If-None-Match",
+			In:          "header",
+			Description: "ETag of a previous response, answered with 304 if the codebook it was built from has not changed",
+			Schema:      &openapi.Schema{Type: "string"},
+		})
+		o.Responses["200"].Headers = map[string]*openapi.Header{
+			"ETag":          {Description: "Identifies the codebook and query the response was built from", Schema: &openapi.Schema{Type: "string"}},
+			"Cache-Control": {Description: "How long the response may be reused, from CACHE_MAX_AGE", Schema: &openapi.Schema{Type: "string"}},
+		}
+		o.Responses["304"] = &openapi.Response{Description: "Not modified"}
+	}
+
+	if !op.public {
+		o.Security = []openapi.SecurityRequirement{{securityScheme: {}}}
+	}
+
+	return o
+}
+
+// operations lists every route with what it takes and responds with
+func (api *API) operations(doc *openapi.Document) []operation {
+	branch := queryParam("branch", "Dimension the hierarchy runs through, for a dimension that maps from more than one; defaults to the first")
+	format := func(formats ...string) *openapi.Parameter {
+		p := queryParam("format", "Representation of the response, overriding the Accept header")
+		p.Schema.Enum = formats
+		return p
+	}
+
+	table := &openapi.Schema{AnyOf: []*openapi.Schema{doc.Schema(cantabular.Table{}), doc.Schema(jsonStatDataset{})}}
+
+	graphQLResult := map[string]*openapi.MediaType{mediaTypeJSON: {Schema: graphQLResultSchema()}}
+	graphQLResponses := map[string]*openapi.Response{
+		"400": {Description: "The query could not be parsed, is not valid against the schema or is over the depth or complexity limits", Content: graphQLResult},
+	}
+	graphQLDescription := "Query the schema at this path with introspection. Queries deeper than GRAPHQL_MAX_DEPTH or more complex than GRAPHQL_MAX_COMPLEXITY are rejected."
+
+	return []operation{
+		{
+			route:   "codebook",
+			summary: "Get the codebook of a dataset",
+			tag:     tagDatasets,
+			content: jsonContent(doc, cantabular.Codebook{}),
+		},
+		{
+			route:   "dimensions",
+			summary: "List the dimensions of a dataset",
+			tag:     tagDatasets,
+			content: jsonContent(doc, GetDimensionsResponse{}),
+		},
+		{
+			route:   "dimension",
+			summary: "Get a dimension of a dataset from its codebook",
+			tag:     tagDatasets,
+			content: jsonContent(doc, cantabular.Dimension{}),
+		},
+		{
+			route:   "codes",
+			summary: "List the codes of a dimension as a code list",
+			tag:     tagDatasets,
+			params:  pageParams(),
+			content: jsonContent(doc, models.CodeResults{}),
+		},
+		{
+			route:   "dimension-index",
+			summary: "Get the code at a position in a dimension",
+			tag:     tagDatasets,
+			content: jsonContent(doc, DimensionResponse{}),
+		},
+		{
+			route:   "filter-options",
+			summary: "List the codes of a dimension as filter options",
+			tag:     tagDatasets,
+			content: jsonContent(doc, []*filterModel.PublicDimensionOption{}),
+		},
+		{
+			route:       "validate",
+			summary:     "Check the codebook of a dataset is consistent",
+			description: "Reports problems with the lengths, codes, labels and mappings of the dimensions of the codebook.",
+			tag:         tagDatasets,
+			content:     jsonContent(doc, cantabular.ValidationReport{}),
+		},
+		{
+			route:       "codebook-diff",
+			summary:     "Compare two versions of the codebook of a dataset",
+			description: "Versions are identified by their digest. Without from and to, the current codebook is compared with the one before it.",
+			tag:         tagDatasets,
+			params: []*openapi.Parameter{
+				queryParam("from", "Digest of the earlier codebook; defaults to the one before to"),
+				queryParam("to", "Digest of the later codebook; defaults to the current one"),
+			},
+			content: jsonContent(doc, cantabular.CodebookDiff{}),
+		},
+		{
+			route:   "hierarchy",
+			summary: "List the codes of a dimension with the number of children each has in the dimension below",
+			tag:     tagHierarchies,
+			params:  []*openapi.Parameter{branch},
+			content: jsonContent(doc, hierarchy.Response{}),
+		},
+		{
+			route:       "hierarchy-full",
+			summary:     "Build the hierarchy below a dimension",
+			description: "Built in memory to depth levels as JSON, or streamed as it is walked with stream=true, depth=all, as NDJSON or when exported in another format.",
+			tag:         tagHierarchies,
+			params: []*openapi.Parameter{
+				branch,
+				queryParam("depth", "Number of levels to build, where 0 is the top level only, or all; defaults to 2 as JSON, or all when streamed or exported in another format"),
+				enumParam("stream", "Write the hierarchy as it is walked", "true", "false"),
+				format(formatJSON, formatJSONLD, formatTurtle, formatCSV, formatOutlineCSV, formatNDJSON),
+			},
+			content: map[string]*openapi.MediaType{
+				mediaTypeJSON:       {Schema: doc.Schema(cantabular.Hierarchy{})},
+				mediaTypeJSONLD:     {Schema: &openapi.Schema{Type: "object"}},
+				mediaTypeTurtle:     {},
+				mediaTypeCSV:        {},
+				mediaTypeOutlineCSV: {},
+				mediaTypeNDJSON:     {},
+			},
+		},
+		{
+			route:   "hierarchy-code",
+			summary: "Get a code of a dimension with its children in the dimension below",
+			tag:     tagHierarchies,
+			params:  []*openapi.Parameter{branch},
+			content: jsonContent(doc, hierarchy.Response{}),
+		},
+		{
+			route:       "hierarchy-parents",
+			summary:     "Get the parent and ancestors of a code in the coarser dimensions that map from it",
+			description: "parent and ancestors follow the nearest coarser dimension at each level, nearest first. paths holds a chain of ancestors, nearest first, for every way up through the dimensions that map from the code's, such as a local authority's country both through its region and directly.",
+			tag:         tagHierarchies,
+			content:     jsonContent(doc, ParentsResponse{}),
+		},
+		{
+			route:       "query",
+			summary:     "Count the combinations of the categories of dimensions",
+			description: "Without aggregate, margins, percent or disclosure control rules for the dataset, JSON is the FTB table passed through.",
+			tag:         tagQueries,
+			params: []*openapi.Parameter{
+				repeatedParam("v", "Dimension to query, repeated for each", true),
+				listParam("aggregate", "Coarser dimensions to roll a queried dimension up into"),
+				listParam("margins", "Totals to add: row, column or total", cantabular.MarginRow, cantabular.MarginColumn, cantabular.MarginTotal),
+				enumParam("percent", "Add the percentage of each count of its row, column or the total", cantabular.PercentRow, cantabular.PercentColumn, cantabular.PercentTotal),
+				format(formatJSON, formatCSV, formatJSONStat),
+			},
+			content: map[string]*openapi.MediaType{
+				mediaTypeJSON: {Schema: table},
+				mediaTypeCSV:  {},
+			},
+		},
+		{
+			route:       "compare",
+			summary:     "Compare the counts of the same query on two datasets",
+			description: "Categories are aligned by code. Differences are right minus left.",
+			tag:         tagQueries,
+			params: []*openapi.Parameter{
+				requiredParam("left", "Dataset to compare from"),
+				requiredParam("right", "Dataset to compare to"),
+				repeatedParam("v", "Dimension to query, repeated for each", true),
+			},
+			content: jsonContent(doc, cantabular.Comparison{}),
+		},
+		{
+			route:         "cmd-datasets",
+			summary:       "List the datasets",
+			tag:           tagCMD,
+			content:       cmdListContent(doc, dataset.DatasetDetails{}),
+			unconditional: true,
+		},
+		{
+			route:   "cmd-dataset",
+			summary: "Get a dataset",
+			tag:     tagCMD,
+			content: jsonContent(doc, dataset.DatasetDetails{}),
+		},
+		{
+			route:   "cmd-editions",
+			summary: "List the editions of a dataset",
+			tag:     tagCMD,
+			content: cmdListContent(doc, dataset.Edition{}),
+		},
+		{
+			route:   "cmd-edition",
+			summary: "Get an edition of a dataset",
+			tag:     tagCMD,
+			content: jsonContent(doc, dataset.Edition{}),
+		},
+		{
+			route:   "cmd-versions",
+			summary: "List the versions of a dataset, one for each codebook digest seen",
+			tag:     tagCMD,
+			content: cmdListContent(doc, dataset.Version{}),
+		},
+		{
+			route:   "cmd-version",
+			summary: "Get a version of a dataset",
+			tag:     tagCMD,
+			content: jsonContent(doc, dataset.Version{}),
+		},
+		{
+			route:   "cmd-metadata",
+			summary: "Get the metadata of a version of a dataset",
+			tag:     tagCMD,
+			content: jsonContent(doc, dataset.Metadata{}),
+		},
+		{
+			route:   "cmd-dimensions",
+			summary: "List the dimensions of a version of a dataset",
+			tag:     tagCMD,
+			content: jsonContent(doc, dataset.VersionDimensions{}),
+		},
+		{
+			route:   "cmd-options",
+			summary: "List the codes of a dimension of a version of a dataset",
+			tag:     tagCMD,
+			content: cmdListContent(doc, dataset.Option{}),
+		},
+		{
+			route:   "code-lists",
+			summary: "List the census variables as code lists",
+			tag:     tagCodeLists,
+			content: jsonContent(doc, models.CodeListResults{}),
+		},
+		{
+			route:   "code-list",
+			summary: "Get a code list",
+			tag:     tagCodeLists,
+			content: jsonContent(doc, models.CodeList{}),
+		},
+		{
+			route:   "code-list-editions",
+			summary: "List the editions of a code list",
+			tag:     tagCodeLists,
+			content: jsonContent(doc, models.Editions{}),
+		},
+		{
+			route:   "code-list-edition",
+			summary: "Get an edition of a code list",
+			tag:     tagCodeLists,
+			content: jsonContent(doc, models.Edition{}),
+		},
+		{
+			route:   "code-list-codes",
+			summary: "List the codes of a code list across every dataset",
+			tag:     tagCodeLists,
+			params:  pageParams(),
+			content: jsonContent(doc, models.CodeResults{}),
+		},
+		{
+			route:   "code-list-code",
+			summary: "Get a code of a code list",
+			tag:     tagCodeLists,
+			content: jsonContent(doc, models.Code{}),
+		},
+		{
+			route:   "code-datasets",
+			summary: "List the datasets with a code",
+			tag:     tagCodeLists,
+			content: jsonContent(doc, models.Datasets{}),
+		},
+		{
+			path:          "/v6/datasets",
+			summary:       "List the datasets of the FTB",
+			tag:           tagFTB,
+			content:       jsonContent(doc, cantabular.Datasets{}),
+			unconditional: true,
+		},
+		{
+			path:          "/v6/codebook/{dataset}",
+			summary:       "Get the codebook of a dataset from the FTB",
+			tag:           tagFTB,
+			content:       jsonContent(doc, cantabular.Codebook{}),
+			unconditional: true,
+		},
+		{
+			route:       "graphql",
+			summary:     "Run a GraphQL query given in the query parameters",
+			description: graphQLDescription,
+			tag:         tagGraphQL,
+			params: []*openapi.Parameter{
+				requiredParam("query", "The GraphQL query"),
+				queryParam("operationName", "Operation to run, for a query with more than one"),
+				queryParam("variables", "Values of the variables of the query as a JSON object"),
+			},
+			content:       graphQLResult,
+			responses:     graphQLResponses,
+			unconditional: true,
+		},
+		{
+			route:       "graphql",
+			method:      http.MethodPost,
+			summary:     "Run a GraphQL query",
+			description: graphQLDescription,
+			tag:         tagGraphQL,
+			requestBody: &openapi.RequestBody{
+				Required: true,
+				Content: map[string]*openapi.MediaType{mediaTypeJSON: {Schema: &openapi.Schema{
+					Type:     "object",
+					Required: []string{"query"},
+					Properties: map[string]*openapi.Schema{
+						"query":         {Type: "string"},
+						"operationName": {Type: "string", Nullable: true},
+						"variables":     {Type: "object", Nullable: true},
+					},
+				}}},
+			},
+			content:       graphQLResult,
+			responses:     graphQLResponses,
+			unconditional: true,
+		},
+		{
+			route:       "batch",
+			method:      http.MethodPost,
+			summary:     "Run several requests in one round trip",
+			description: "Each request is routed as if it had been made on its own, with the Authorization header of the batch, and the responses are returned in the same order. At most BATCH_MAX_REQUESTS can be batched and BATCH_CONCURRENCY are run at once. Streamed responses, /v6/events and ?stream=, cannot be batched.",
+			tag:         tagBatch,
+			requestBody: &openapi.RequestBody{
+				Required: true,
+				Content:  jsonContent(doc, []batchRequest{}),
+			},
+			content:       jsonContent(doc, []batchResponse{}),
+			unconditional: true,
+		},
+		{
+			route:       "jobs",
+			method:      http.MethodPost,
+			summary:     "Queue a query to run in the background",
+			description: "The query takes the options of /v6/query/{dataset} and is checked against the codebook before it is queued. The job is run by one of JOB_WORKERS and its result kept for JOB_RETENTION after it finishes.",
+			tag:         tagJobs,
+			requestBody: &openapi.RequestBody{
+				Required: true,
+				Content:  jsonContent(doc, jobs.Query{}),
+			},
+			status:  http.StatusAccepted,
+			content: jsonContent(doc, JobResponse{}),
+			responses: map[string]*openapi.Response{
+				"503": {Description: "The queue is full", Content: jsonContent(doc, SimpleEntity{})},
+			},
+			unconditional: true,
+		},
+		{
+			route:         "job",
+			summary:       "Get the status and progress of a query job",
+			tag:           tagJobs,
+			content:       jsonContent(doc, JobResponse{}),
+			unconditional: true,
+		},
+		{
+			route:   "job-result",
+			summary: "Download the table a query job produced",
+			tag:     tagJobs,
+			params: []*openapi.Parameter{
+				format(formatJSON, formatCSV, formatJSONStat),
+			},
+			content: map[string]*openapi.MediaType{
+				mediaTypeJSON: {Schema: table},
+				mediaTypeCSV:  {},
+			},
+			responses: map[string]*openapi.Response{
+				"409": {Description: "The job has not succeeded", Content: jsonContent(doc, SimpleEntity{})},
+			},
+			unconditional: true,
+		},
+		{
+			route:       "events",
+			summary:     "Stream dataset digest changes, cache refreshes and FTB health transitions",
+			description: "Server-sent events of type dataset.digest_changed, cache.refreshed and upstream.health_changed. The last EVENTS_BUFFER_SIZE are replayed to a client reconnecting with Last-Event-ID; a client with more than EVENTS_CLIENT_BUFFER waiting is disconnected.",
+			tag:         tagEvents,
+			params: []*openapi.Parameter{
+				{
+					Name:        "Last-Event-ID",
+					In:          "header",
+					Description: "ID of the last event received, to be sent those since that are still buffered",
+					Schema:      &openapi.Schema{Type: "string"},
+				},
+			},
+			content:       map[string]*openapi.MediaType{mediaTypeEventStream: {}},
+			unconditional: true,
+		},
+		{
+			route:         "openapi",
+			summary:       "Get this document",
+			tag:           tagDocs,
+			content:       map[string]*openapi.MediaType{mediaTypeJSON: {Schema: &openapi.Schema{Type: "object"}}},
+			public:        true,
+			unconditional: true,
+		},
+		{
+			route:         "docs",
+			summary:       "Browse this document with Swagger UI",
+			tag:           tagDocs,
+			content:       map[string]*openapi.MediaType{"text/html": {}},
+			public:        true,
+			unconditional: true,
+		},
+	}
+}
+
+// patchDimensionSchema describes mapFromCodes as Dimension.MarshalJSON writes
+// it: a list per branch when the dimension maps from more than one.
+func patchDimensionSchema(doc *openapi.Document) {
+	dim, ok := doc.Component(cantabular.Dimension{})
+	if !ok {
+		return
+	}
+
+	codes := &openapi.Schema{Type: "array", Items: &openapi.Schema{Type: "string"}}
+	dim.Properties["mapFromCodes"] = &openapi.Schema{
+		Nullable: true,
+		AnyOf:    []*openapi.Schema{codes, {Type: "array", Items: codes}},
+	}
+}
+
+// graphQLResultSchema describes a graphql.Result, whose data follows the
+// GraphQL schema rather than this document
+func graphQLResultSchema() *openapi.Schema {
+	return &openapi.Schema{
+		Type: "object",
+		Properties: map[string]*openapi.Schema{
+			"data": {Type: "object", Nullable: true},
+			"errors": {Type: "array", Items: &openapi.Schema{
+				Type:     "object",
+				Required: []string{"message"},
+				Properties: map[string]*openapi.Schema{
+					"message":   {Type: "string"},
+					"locations": {Type: "array", Items: &openapi.Schema{Type: "object"}},
+					"path":      {Type: "array", Items: &openapi.Schema{}},
+				},
+			}},
+		},
+	}
+}
+
+func jsonContent(doc *openapi.Document, v interface{}) map[string]*openapi.MediaType {
+	return map[string]*openapi.MediaType{mediaTypeJSON: {Schema: doc.Schema(v)}}
+}
+
+// cmdListContent describes a CMDList of items
+func cmdListContent(doc *openapi.Document, item interface{}) map[string]*openapi.MediaType {
+	integer := &openapi.Schema{Type: "integer"}
+	return map[string]*openapi.MediaType{mediaTypeJSON: {Schema: &openapi.Schema{
+		Type:     "object",
+		Required: []string{"count", "items", "limit", "offset", "total_count"},
+		Properties: map[string]*openapi.Schema{
+			"items":       {Type: "array", Items: doc.Schema(item)},
+			"count":       integer,
+			"offset":      integer,
+			"limit":       integer,
+			"total_count": integer,
+		},
+	}}}
+}
+
+func queryParam(name, description string) *openapi.Parameter {
+	return &openapi.Parameter{Name: name, In: "query", Description: description, Schema: &openapi.Schema{Type: "string"}}
+}
+
+func requiredParam(name, description string) *openapi.Parameter {
+	p := queryParam(name, description)
+	p.Required = true
+	return p
+}
+
+func enumParam(name, description string, values ...string) *openapi.Parameter {
+	p := queryParam(name, description)
+	p.Schema.Enum = values
+	return p
+}
+
+// pageParams are the offset and limit of a page of a list, read by getPage
+func pageParams() []*openapi.Parameter {
+	zero := 0.0
+	count := func(name, description string) *openapi.Parameter {
+		return &openapi.Parameter{Name: name, In: "query", Description: description, Schema: &openapi.Schema{Type: "integer", Minimum: &zero}}
+	}
+	return []*openapi.Parameter{
+		count("offset", "Number of items to skip"),
+		count("limit", "Maximum number of items to return; defaults to all"),
+	}
+}
+
+// repeatedParam is a query parameter given once for each value
+func repeatedParam(name, description string, required bool) *openapi.Parameter {
+	return &openapi.Parameter{
+		Name:        name,
+		In:          "query",
+		Description: description,
+		Required:    required,
+		Schema:      &openapi.Schema{Type: "array", Items: &openapi.Schema{Type: "string"}},
+	}
+}
+
+// listParam is a query parameter that is repeated or comma separated, as read
+// by queryList
+func listParam(name, description string, values ...string) *openapi.Parameter {
+	p := repeatedParam(name, description+", repeated or comma separated", false)
+	if len(values) > 0 {
+		p.Schema.Items.Pattern = fmt.Sprintf("^(%s)(,(%s))*$", strings.Join(values, "|"), strings.Join(values, "|"))
+	}
+	return p
+}
+
+// GetOpenAPI serves the OpenAPI document describing the API
+func (api *API) GetOpenAPI() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WriteBody(r.Context(), w, api.Spec, http.StatusOK)
+	})
+}
+
+var swaggerUI = template.Must(template.New("swagger-ui").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>{{.Title}}</title>
+  <link rel="stylesheet" href="{{.Assets}}/swagger-ui.css"{{with .CSSIntegrity}} integrity="{{.}}" crossorigin="anonymous"{{end}}>
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="{{.Assets}}/swagger-ui-bundle.js"{{with .JSIntegrity}} integrity="{{.}}" crossorigin="anonymous"{{end}}></script>
+  <script>
+    window.ui = SwaggerUIBundle({url: {{.Spec}}, dom_id: "#swagger-ui"});
+  </script>
+</body>
+</html>
+`))
+
+// GetSwaggerUI serves a page browsing the OpenAPI document with Swagger UI,
+// loaded from SWAGGER_UI_URL and checked against the subresource integrity
+// hashes in the config, if they are set
+func (api *API) GetSwaggerUI() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		spec, err := api.Router.Get("openapi").URL()
+		if err != nil {
+			errEntity, status := getErrorResponse(ctx, err)
+			WriteBody(ctx, w, errEntity, status)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusOK)
+
+		err = swaggerUI.Execute(w, map[string]string{
+			"Title":        api.Spec.Info.Title,
+			"Assets":       strings.TrimSuffix(api.Config.SwaggerUIURL, "/"),
+			"CSSIntegrity": api.Config.SwaggerUICSSIntegrity,
+			"JSIntegrity":  api.Config.SwaggerUIJSIntegrity,
+			"Spec":         spec.String(),
+		})
+		if err != nil {
+			log.Event(ctx, "failed to write swagger ui", log.Error(err), log.ERROR)
+		}
+	})
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/ONSdigital/dp-code-list-api/models"
+)
+
+// page is the part of a list asked for with the offset and limit query
+// parameters. A limit of 0 is the rest of the list.
+type page struct {
+	offset int
+	limit  int
+}
+
+// getPage reads the offset and limit query parameters, writing a bad request
+// if either is not a non-negative integer
+func getPage(ctx context.Context, w http.ResponseWriter, r *http.Request) (page, bool) {
+	offset, ok := getCount(ctx, w, r, "offset")
+	if !ok {
+		return page{}, false
+	}
+
+	limit, ok := getCount(ctx, w, r, "limit")
+	if !ok {
+		return page{}, false
+	}
+
+	return page{offset: offset, limit: limit}, true
+}
+
+func getCount(ctx context.Context, w http.ResponseWriter, r *http.Request, name string) (int, bool) {
+	param := r.URL.Query().Get(name)
+	if param == "" {
+		return 0, true
+	}
+
+	n, err := strconv.Atoi(param)
+	if err != nil || n < 0 {
+		WriteBody(ctx, w, SimpleEntity{"invalid " + name}, http.StatusBadRequest)
+		return 0, false
+	}
+	return n, true
+}
+
+// codes returns the page of a list of codes
+func (p page) codes(all []models.Code) *models.CodeResults {
+	start := p.offset
+	if start > len(all) {
+		start = len(all)
+	}
+
+	end := len(all)
+	if p.limit > 0 && start+p.limit < end {
+		end = start + p.limit
+	}
+
+	limit := p.limit
+	if limit == 0 {
+		limit = len(all)
+	}
+
+	items := all[start:end]
+	return &models.CodeResults{
+		Items:      items,
+		Count:      len(items),
+		Offset:     p.offset,
+		Limit:      limit,
+		TotalCount: len(all),
+	}
+}
@@ -0,0 +1,196 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/cantabular"
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/disclosure"
+	"github.com/ONSdigital/log.go/log"
+	"github.com/gorilla/mux"
+)
+
+// GetQuery passes a query through to the FTB, answering conditional requests
+// from the dataset digest without running the query again. Given aggregate
+// dimensions, the counts are rolled up the hierarchy into them on the proxy,
+// then any margins are added before the disclosure control rules for the
+// dataset are applied, and percentages are worked out from what remains.
+func (api *API) GetQuery() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		dataset := mux.Vars(r)["dataset"]
+
+		format, ok := negotiateTableFormat(r)
+		if !ok {
+			WriteBody(ctx, w, SimpleEntity{Message: "unsupported table format"}, http.StatusNotAcceptable)
+			return
+		}
+
+		codebook, ok := api.getCodebook(ctx, w, dataset)
+		if !ok {
+			return
+		}
+
+		if api.notModified(w, r, codebook.Dataset.Digest) {
+			return
+		}
+
+		targets := queryList(r, "aggregate")
+		margins := queryList(r, "margins")
+		percent := r.URL.Query().Get("percent")
+		_, rules := api.Disclosure.For(dataset)
+
+		if len(targets) == 0 && len(margins) == 0 && percent == "" && rules == nil && format == formatJSON {
+			entity, err := api.Store.GetData(ctx, r.URL.String())
+			if err != nil {
+				writeQueryError(ctx, w, err)
+				return
+			}
+
+			WriteBody(ctx, w, entity, http.StatusOK)
+			return
+		}
+
+		table, err := api.tabulate(ctx, api.Store, codebook, r.URL.Query()["v"], targets, margins, percent, nil)
+		if err != nil {
+			writeQueryError(ctx, w, err)
+			return
+		}
+
+		writeTable(ctx, w, format, table)
+	})
+}
+
+// Stages of a tabulation, as reported to a progressFunc
+const (
+	stageQuery       = "query"
+	stageAggregate   = "aggregate"
+	stageMargins     = "margins"
+	stageDisclosure  = "disclosure"
+	stagePercentages = "percentages"
+)
+
+// progressFunc is told of each stage of a tabulation as it starts, numbered
+// from 1 of steps
+type progressFunc func(stage string, step, steps int)
+
+// tabulate queries a dataset, then rolls the counts up into any target
+// dimensions, adds any margins, applies the disclosure control rules for the
+// dataset and works out any percentages from what remains. progress may be nil.
+func (api *API) tabulate(ctx context.Context, querier Querier, codebook *cantabular.Codebook, variables, targets, margins []string, percent string, progress progressFunc) (*cantabular.Table, error) {
+	dataset := codebook.Dataset.Name
+	rulesName, rules := api.Disclosure.For(dataset)
+
+	stages := []string{stageQuery}
+	if len(targets) > 0 {
+		stages = append(stages, stageAggregate)
+	}
+	if len(margins) > 0 {
+		stages = append(stages, stageMargins)
+	}
+	if rules != nil {
+		stages = append(stages, stageDisclosure)
+	}
+	if percent != "" {
+		stages = append(stages, stagePercentages)
+	}
+
+	step := 0
+	next := func() {
+		if progress != nil {
+			progress(stages[step], step+1, len(stages))
+		}
+		step++
+	}
+
+	next()
+	table, err := querier.Query(ctx, dataset, variables)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(targets) > 0 {
+		next()
+		if table, err = table.Aggregate(codebook, targets); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(margins) > 0 {
+		next()
+		if table, err = table.AddMargins(margins); err != nil {
+			return nil, err
+		}
+	}
+
+	if rules != nil {
+		next()
+		record, err := disclosure.Apply(table, rulesName, rules)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Event(ctx, "disclosure control applied", log.INFO, log.Data{
+			"dataset":   dataset,
+			"variables": variables,
+			"aggregate": targets,
+			"applied":   record,
+		})
+	}
+
+	if percent != "" {
+		next()
+		if err := table.AddPercentages(percent); err != nil {
+			return nil, err
+		}
+	}
+
+	return table, nil
+}
+
+// clientError returns an error whose message can be given to the client. Errors
+// in what was asked for are given as they are, while others are reported as
+// the REST routes would report them.
+func clientError(ctx context.Context, err error) error {
+	var aggErr cantabular.AggregateError
+	var optErr cantabular.OptionError
+	var branchErr cantabular.BranchNotFoundError
+	if errors.As(err, &aggErr) || errors.As(err, &optErr) || errors.As(err, &branchErr) || errors.Is(err, cantabular.ErrNoHierarchy) {
+		return err
+	}
+
+	entity, _ := getErrorResponse(ctx, err)
+	return errors.New(entity.Message)
+}
+
+// writeQueryError writes the response for a query that failed, which must not
+// carry the validators of a successful one.
+func writeQueryError(ctx context.Context, w http.ResponseWriter, err error) {
+	clearValidators(w)
+
+	var aggErr cantabular.AggregateError
+	var optErr cantabular.OptionError
+	if errors.As(err, &aggErr) || errors.As(err, &optErr) {
+		WriteBody(ctx, w, SimpleEntity{Message: err.Error()}, http.StatusBadRequest)
+		return
+	}
+
+	errEntity, status := getErrorResponse(ctx, err)
+	WriteBody(ctx, w, errEntity, status)
+}
+
+// queryList reads a query parameter given either repeatedly or as a comma
+// separated list.
+func queryList(r *http.Request, key string) []string {
+	values := make([]string, 0)
+	for _, v := range r.URL.Query()[key] {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				values = append(values, item)
+			}
+		}
+	}
+	return values
+}
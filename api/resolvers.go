@@ -0,0 +1,552 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/cantabular"
+	"github.com/graphql-go/graphql"
+)
+
+// dimensionNode is a dimension of a codebook as resolved by the GraphQL schema
+type dimensionNode struct {
+	codebook *cantabular.Codebook
+	dim      *cantabular.Dimension
+}
+
+// codeNode is a code of a dimension. Its children are followed through
+// branch, or the first dimension it maps from when that is empty.
+type codeNode struct {
+	codebook *cantabular.Codebook
+	dim      *cantabular.Dimension
+	index    int
+	branch   string
+}
+
+// hierarchyNode is the hierarchy below a dimension through one of its branches
+type hierarchyNode struct {
+	codebook *cantabular.Codebook
+	dim      *cantabular.Dimension
+	branch   *cantabular.Branch
+}
+
+// pageArgs returns args with the offset and limit of a list added. Every list
+// of objects takes them, as the complexity of a query is worked out from its
+// limits.
+func pageArgs(args graphql.FieldConfigArgument) graphql.FieldConfigArgument {
+	out := graphql.FieldConfigArgument{
+		"offset": {Type: graphql.Int, DefaultValue: 0, Description: "Number of items to skip"},
+		"limit":  {Type: graphql.Int, Description: fmt.Sprintf("Maximum number of items to return; defaults to %d, and at most %d", graphQLListSize, graphQLMaxListSize)},
+	}
+	for name, arg := range args {
+		out[name] = arg
+	}
+	return out
+}
+
+// listPage returns the range of a list of n items asked for with the offset
+// and limit arguments. Without a limit the list is cut to graphQLListSize,
+// the size the complexity of the query was worked out with.
+func listPage(p graphql.ResolveParams, n int) (int, int, error) {
+	offset, _ := p.Args["offset"].(int)
+	limit, limited := p.Args["limit"].(int)
+	if offset < 0 {
+		return 0, 0, errors.New("invalid offset")
+	}
+	if limited && limit < 0 {
+		return 0, 0, errors.New("invalid limit")
+	}
+
+	if !limited {
+		limit = graphQLListSize
+	}
+	if limit > graphQLMaxListSize {
+		limit = graphQLMaxListSize
+	}
+
+	start, end := offset, offset+limit
+	if start > n {
+		start = n
+	}
+	if end > n {
+		end = n
+	}
+	return start, end, nil
+}
+
+// newGraphQLSchema builds the schema served at /graphql. Types refer to each
+// other through thunks so a code can resolve its dimension, parent and
+// children.
+func (api *API) newGraphQLSchema() (graphql.Schema, error) {
+	var dimensionType, codeType *graphql.Object
+
+	branchArgs := graphql.FieldConfigArgument{
+		"branch": {Type: graphql.String, Description: "Dimension the children are found in, for a dimension that maps from more than one; defaults to the first"},
+	}
+
+	codeType = graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Code",
+		Description: "A category of a dimension",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"code": {
+					Type: graphql.NewNonNull(graphql.String),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						n := p.Source.(*codeNode)
+						return n.dim.Codes[n.index], nil
+					},
+				},
+				"label": {
+					Type: graphql.NewNonNull(graphql.String),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						n := p.Source.(*codeNode)
+						return n.dim.LabelAt(n.index), nil
+					},
+				},
+				"dimension": {
+					Type: graphql.NewNonNull(dimensionType),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						n := p.Source.(*codeNode)
+						return &dimensionNode{codebook: n.codebook, dim: n.dim}, nil
+					},
+				},
+				"parent": {
+					Type:        codeType,
+					Description: "The code this one is mapped into in the nearest coarser dimension",
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						ancestors := p.Source.(*codeNode).ancestors()
+						if len(ancestors) == 0 {
+							return nil, nil
+						}
+						return ancestors[0], nil
+					},
+				},
+				"ancestors": {
+					Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(codeType))),
+					Description: "The chain of codes this one is mapped into, nearest first",
+					Args:        pageArgs(nil),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						ancestors := p.Source.(*codeNode).ancestors()
+						start, end, err := listPage(p, len(ancestors))
+						if err != nil {
+							return nil, err
+						}
+						return ancestors[start:end], nil
+					},
+				},
+				"children": {
+					Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(codeType))),
+					Description: "The codes of the finer dimension mapped into this one",
+					Args:        pageArgs(branchArgs),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						branch, _ := p.Args["branch"].(string)
+						children, err := p.Source.(*codeNode).children(branch)
+						if err != nil {
+							return nil, clientError(p.Context, err)
+						}
+
+						start, end, err := listPage(p, len(children))
+						if err != nil {
+							return nil, err
+						}
+						return children[start:end], nil
+					},
+				},
+				"childCount": {
+					Type: graphql.NewNonNull(graphql.Int),
+					Args: branchArgs,
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						branch, _ := p.Args["branch"].(string)
+						children, err := p.Source.(*codeNode).children(branch)
+						if err != nil {
+							return nil, clientError(p.Context, err)
+						}
+						return len(children), nil
+					},
+				},
+			}
+		}),
+	})
+
+	dimensionType = graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Dimension",
+		Description: "A variable of the codebook of a dataset",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"name": {
+					Type: graphql.NewNonNull(graphql.String),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return p.Source.(*dimensionNode).dim.Name, nil
+					},
+				},
+				"label": {
+					Type: graphql.NewNonNull(graphql.String),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return p.Source.(*dimensionNode).dim.Label, nil
+					},
+				},
+				"mapFrom": {
+					Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String))),
+					Description: "The finer dimensions this one maps from",
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						if mapFrom := p.Source.(*dimensionNode).dim.MapFrom; mapFrom != nil {
+							return mapFrom, nil
+						}
+						return []string{}, nil
+					},
+				},
+				"codeCount": {
+					Type: graphql.NewNonNull(graphql.Int),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return len(p.Source.(*dimensionNode).dim.Codes), nil
+					},
+				},
+				"codes": {
+					Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(codeType))),
+					Args: pageArgs(nil),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						n := p.Source.(*dimensionNode)
+						start, end, err := listPage(p, len(n.dim.Codes))
+						if err != nil {
+							return nil, err
+						}
+
+						codes := make([]*codeNode, 0)
+						for i := start; i < end; i++ {
+							codes = append(codes, &codeNode{codebook: n.codebook, dim: n.dim, index: i})
+						}
+						return codes, nil
+					},
+				},
+				"code": {
+					Type: codeType,
+					Args: graphql.FieldConfigArgument{
+						"code": {Type: graphql.NewNonNull(graphql.String)},
+					},
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						n := p.Source.(*dimensionNode)
+						code := p.Args["code"].(string)
+						for i, c := range n.dim.Codes {
+							if c == code {
+								return &codeNode{codebook: n.codebook, dim: n.dim, index: i}, nil
+							}
+						}
+						return nil, nil
+					},
+				},
+			}
+		}),
+	})
+
+	hierarchyType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Hierarchy",
+		Description: "The codes of a dimension, whose children are found through one of the dimensions it maps from",
+		Fields: graphql.Fields{
+			"dimension": {
+				Type: graphql.NewNonNull(dimensionType),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					h := p.Source.(*hierarchyNode)
+					return &dimensionNode{codebook: h.codebook, dim: h.dim}, nil
+				},
+			},
+			"branch": {
+				Type: graphql.NewNonNull(graphql.String),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*hierarchyNode).branch.Child, nil
+				},
+			},
+			"branches": {
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String))),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*hierarchyNode).dim.MapFrom, nil
+				},
+			},
+			"codes": {
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(codeType))),
+				Args: pageArgs(nil),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					h := p.Source.(*hierarchyNode)
+					start, end, err := listPage(p, len(h.dim.Codes))
+					if err != nil {
+						return nil, err
+					}
+
+					codes := make([]*codeNode, 0, end-start)
+					for i := start; i < end; i++ {
+						codes = append(codes, &codeNode{codebook: h.codebook, dim: h.dim, index: i, branch: h.branch.Child})
+					}
+					return codes, nil
+				},
+			},
+		},
+	})
+
+	stringList := graphql.NewList(graphql.NewNonNull(graphql.String))
+
+	tableLevelType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "TableLevel",
+		Description: "A run of the categories of a table dimension taken from one dimension of the codebook",
+		Fields: graphql.Fields{
+			"name":    {Type: graphql.NewNonNull(graphql.String)},
+			"offset":  {Type: graphql.NewNonNull(graphql.Int)},
+			"count":   {Type: graphql.NewNonNull(graphql.Int)},
+			"derived": {Type: graphql.NewNonNull(graphql.Boolean)},
+			"margin":  {Type: graphql.NewNonNull(graphql.Boolean)},
+		},
+	})
+
+	tableDimensionType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "TableDimension",
+		Fields: graphql.Fields{
+			"name":   {Type: graphql.NewNonNull(graphql.String)},
+			"label":  {Type: graphql.NewNonNull(graphql.String)},
+			"codes":  {Type: graphql.NewNonNull(stringList)},
+			"labels": {Type: graphql.NewNonNull(stringList)},
+			"levels": {Type: graphql.NewList(graphql.NewNonNull(tableLevelType))},
+		},
+	})
+
+	disclosureControlType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "DisclosureControl",
+		Description: "The disclosure control rules applied to a table and how many cells they suppressed",
+		Fields: graphql.Fields{
+			"rules":                {Type: graphql.NewNonNull(graphql.String)},
+			"threshold":            {Type: graphql.Int},
+			"marker":               {Type: graphql.String},
+			"roundingBase":         {Type: graphql.Int},
+			"secondarySuppression": {Type: graphql.NewNonNull(graphql.Boolean)},
+			"suppressed":           {Type: graphql.NewNonNull(graphql.Int)},
+			"secondarySuppressed":  {Type: graphql.NewNonNull(graphql.Int)},
+		},
+	})
+
+	tableType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Table",
+		Description: "A count for every combination of the categories of the queried dimensions, with the last varying fastest",
+		Fields: graphql.Fields{
+			"dataset":           {Type: graphql.NewNonNull(graphql.String)},
+			"dimensions":        {Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(tableDimensionType)))},
+			"counts":            {Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.Int)))},
+			"status":            {Type: stringList, Description: "A marker for each count that has been withheld"},
+			"percentages":       {Type: graphql.NewList(graphql.Float), Description: "The percentage each count is of the total named by percentageOf"},
+			"percentageOf":      {Type: graphql.String},
+			"disclosureControl": {Type: disclosureControlType},
+		},
+	})
+
+	datasetType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Dataset",
+		Description: "A dataset of the flexible table builder",
+		Fields: graphql.Fields{
+			"name":        {Type: graphql.NewNonNull(graphql.String)},
+			"description": {Type: graphql.String},
+			"size":        {Type: graphql.Int},
+			"digest": {
+				Type:        graphql.NewNonNull(graphql.String),
+				Description: "Identifies the version of the codebook",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if d := p.Source.(*cantabular.Dataset); d.Digest != "" {
+						return d.Digest, nil
+					}
+					cb, err := loadCodebook(p)
+					if err != nil {
+						return nil, err
+					}
+					return cb.Dataset.Digest, nil
+				},
+			},
+			"dimensions": {
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(dimensionType))),
+				Args: pageArgs(nil),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					cb, err := loadCodebook(p)
+					if err != nil {
+						return nil, err
+					}
+					start, end, err := listPage(p, len(cb.CodeBook))
+					if err != nil {
+						return nil, err
+					}
+					dims := make([]*dimensionNode, 0, end-start)
+					for i := start; i < end; i++ {
+						dims = append(dims, &dimensionNode{codebook: cb, dim: &cb.CodeBook[i]})
+					}
+					return dims, nil
+				},
+			},
+			"dimension": {
+				Type: dimensionType,
+				Args: graphql.FieldConfigArgument{
+					"name": {Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					cb, err := loadCodebook(p)
+					if err != nil {
+						return nil, err
+					}
+					dim := cb.GetDimension(p.Args["name"].(string))
+					if dim == nil {
+						return nil, nil
+					}
+					return &dimensionNode{codebook: cb, dim: dim}, nil
+				},
+			},
+			"hierarchy": {
+				Type: hierarchyType,
+				Args: graphql.FieldConfigArgument{
+					"dimension": {Type: graphql.NewNonNull(graphql.String)},
+					"branch":    branchArgs["branch"],
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					cb, err := loadCodebook(p)
+					if err != nil {
+						return nil, err
+					}
+					dim := cb.GetDimension(p.Args["dimension"].(string))
+					if dim == nil {
+						return nil, nil
+					}
+					name, _ := p.Args["branch"].(string)
+					branch, err := dim.Branch(name)
+					if err != nil {
+						return nil, clientError(p.Context, err)
+					}
+					return &hierarchyNode{codebook: cb, dim: dim, branch: branch}, nil
+				},
+			},
+			"table": {
+				Type:        tableType,
+				Description: "Counts for the variables, with the disclosure control rules for the dataset applied",
+				Args: graphql.FieldConfigArgument{
+					"variables": {Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String)))},
+					"aggregate": {Type: stringList, Description: "Coarser dimensions to roll a queried dimension up into"},
+					"margins":   {Type: stringList, Description: "Totals to add: row, column or total"},
+					"percent":   {Type: graphql.String, Description: "Add the percentage of each count of its row, column or the total"},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					cb, err := loadCodebook(p)
+					if err != nil {
+						return nil, err
+					}
+					percent, _ := p.Args["percent"].(string)
+					table, err := api.tabulate(p.Context, api.Store, cb, stringArgs(p.Args["variables"]), stringArgs(p.Args["aggregate"]), stringArgs(p.Args["margins"]), percent, nil)
+					if err != nil {
+						return nil, clientError(p.Context, err)
+					}
+					return table, nil
+				},
+			},
+		},
+	})
+
+	queryType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"datasets": {
+				Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(datasetType))),
+				Args: pageArgs(nil),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					datasets, err := api.Store.GetDatasets(p.Context)
+					if err != nil {
+						return nil, clientError(p.Context, err)
+					}
+					start, end, err := listPage(p, len(datasets.Items))
+					if err != nil {
+						return nil, err
+					}
+					if datasets.Items == nil {
+						return []*cantabular.Dataset{}, nil
+					}
+					return datasets.Items[start:end], nil
+				},
+			},
+			"dataset": {
+				Type: datasetType,
+				Args: graphql.FieldConfigArgument{
+					"name": {Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					cb, err := loaderFrom(p.Context).get(p.Context, p.Args["name"].(string))
+					var ftbErr cantabular.Error
+					if errors.As(err, &ftbErr) && ftbErr.StatusCode == http.StatusNotFound {
+						return nil, nil
+					}
+					if err != nil {
+						return nil, clientError(p.Context, err)
+					}
+					return &cb.Dataset, nil
+				},
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{Query: queryType})
+}
+
+// ancestors returns the codes this one is mapped into, nearest first
+func (n *codeNode) ancestors() []*codeNode {
+	found, _ := n.codebook.GetAncestors(n.dim.Name, n.dim.Codes[n.index])
+
+	ancestors := make([]*codeNode, len(found))
+	for i, a := range found {
+		ancestors[i] = &codeNode{codebook: n.codebook, dim: a.Dimension, index: a.Index}
+	}
+	return ancestors
+}
+
+// children returns the codes of the finer dimension mapped into this one,
+// through the named branch or the node's own. A code of a dimension that
+// maps from no other has none.
+func (n *codeNode) children(name string) ([]*codeNode, error) {
+	if name == "" {
+		name = n.branch
+	}
+
+	children := make([]*codeNode, 0)
+
+	branch, err := n.dim.Branch(name)
+	if err == cantabular.ErrNoHierarchy {
+		return children, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	childDim := n.codebook.GetDimension(branch.Child)
+	if childDim == nil {
+		return nil, fmt.Errorf("dimension %s maps from %s, which is not in the codebook", n.dim.Name, branch.Child)
+	}
+
+	index, found := branch.GetDescendantCodeIndices(n.dim.Codes[n.index])
+	if !found {
+		return children, nil
+	}
+
+	for i := index.Start; i <= index.End && i < len(childDim.Codes); i++ {
+		children = append(children, &codeNode{codebook: n.codebook, dim: childDim, index: i})
+	}
+	return children, nil
+}
+
+// loadCodebook reads the codebook of the dataset being resolved
+func loadCodebook(p graphql.ResolveParams) (*cantabular.Codebook, error) {
+	cb, err := loaderFrom(p.Context).get(p.Context, p.Source.(*cantabular.Dataset).Name)
+	if err != nil {
+		return nil, clientError(p.Context, err)
+	}
+	return cb, nil
+}
+
+// stringArgs reads a list argument, which is nil when it was not given
+func stringArgs(arg interface{}) []string {
+	list, _ := arg.([]interface{})
+
+	values := make([]string, 0, len(list))
+	for _, v := range list {
+		if s, ok := v.(string); ok {
+			values = append(values, s)
+		}
+	}
+	return values
+}
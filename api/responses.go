@@ -1,5 +1,7 @@
 package api
 
+import hierarchy "github.com/ONSdigital/dp-hierarchy-api/models"
+
 type GetDimensionsResponse struct {
 	Dimensions []string `json:"dimensions,omitempty"`
 }
@@ -8,4 +10,23 @@ type DimensionResponse struct {
 	Index int    `json:"index"`
 	Name  string `json:"name"`
 	Code  string `json:"code"`
-}
\ No newline at end of file
+}
+
+// ParentsResponse gives the codes a code is mapped into. Parent and Ancestors
+// follow the nearest parent dimension at each level, while Paths holds every
+// chain up through each dimension that maps from it, nearest first.
+type ParentsResponse struct {
+	Dimension string             `json:"dimension"`
+	Code      string             `json:"code"`
+	Label     string             `json:"label"`
+	Parent    *HierarchyCode     `json:"parent,omitempty"`
+	Ancestors []*HierarchyCode   `json:"ancestors"`
+	Paths     [][]*HierarchyCode `json:"paths"`
+}
+
+type HierarchyCode struct {
+	Dimension string                    `json:"dimension"`
+	Code      string                    `json:"code"`
+	Label     string                    `json:"label"`
+	Links     map[string]hierarchy.Link `json:"links,omitempty"`
+}
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/cantabular"
+	"github.com/ONSdigital/log.go/log"
+)
+
+const (
+	formatNDJSON    = "ndjson"
+	mediaTypeNDJSON = "application/x-ndjson"
+
+	// streamFlushInterval is how many codes are written between flushes
+	streamFlushInterval = 500
+)
+
+// streamedNode is a line of the NDJSON hierarchy stream
+type streamedNode struct {
+	Type       string `json:"type"`
+	Name       string `json:"name"`
+	Code       string `json:"code"`
+	ParentType string `json:"parent_type,omitempty"`
+	ParentCode string `json:"parent_code,omitempty"`
+	Depth      int    `json:"depth"`
+}
+
+// streamHierarchy walks the hierarchy writing codes to the client as they are
+// visited, as NDJSON or as the same nested JSON BuildHierarchyFrom produces.
+func streamHierarchy(ctx context.Context, w http.ResponseWriter, format string, rootDim *cantabular.Dimension, branch *cantabular.Branch, cb *cantabular.Codebook, maxDepth int) {
+	contentType := mediaTypeJSON
+	if format == formatNDJSON {
+		contentType = mediaTypeNDJSON
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+
+	out := &flushWriter{Writer: bufio.NewWriter(w), w: w}
+
+	var err error
+	if format == formatNDJSON {
+		err = streamNDJSON(ctx, out, rootDim, branch, cb, maxDepth)
+	} else {
+		err = streamNestedJSON(ctx, out, rootDim, branch, cb, maxDepth)
+	}
+
+	if err != nil {
+		// the status has already been sent, so all that can be done is to stop
+		log.Event(ctx, "hierarchy stream ended early", log.WARN, log.Error(err), log.Data{"dimension": rootDim.Name})
+		return
+	}
+
+	out.Flush()
+}
+
+func streamNDJSON(ctx context.Context, out *flushWriter, rootDim *cantabular.Dimension, branch *cantabular.Branch, cb *cantabular.Codebook, maxDepth int) error {
+	enc := json.NewEncoder(out)
+
+	return cantabular.WalkHierarchy(ctx, rootDim, branch, cb, maxDepth, func(e *cantabular.WalkEvent) error {
+		if e.Leave {
+			return nil
+		}
+
+		if err := enc.Encode(streamedNode{
+			Type:       e.Type,
+			Name:       e.Name,
+			Code:       e.Code,
+			ParentType: e.ParentType,
+			ParentCode: e.ParentCode,
+			Depth:      e.Depth,
+		}); err != nil {
+			return err
+		}
+
+		return out.visited()
+	})
+}
+
+func streamNestedJSON(ctx context.Context, out *flushWriter, rootDim *cantabular.Dimension, branch *cantabular.Branch, cb *cantabular.Codebook, maxDepth int) error {
+	// the first code at each depth is written without a leading comma
+	first := []bool{true}
+
+	header := map[string]interface{}{
+		"Label":    rootDim.Name,
+		"Branch":   branch.Child,
+		"Branches": rootDim.MapFrom,
+	}
+
+	b, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+
+	// reopen the header object to append the streamed children to it
+	out.Write(b[:len(b)-1])
+	out.WriteString(`,"Children":[`)
+
+	err = cantabular.WalkHierarchy(ctx, rootDim, branch, cb, maxDepth, func(e *cantabular.WalkEvent) error {
+		if e.Leave {
+			first = first[:len(first)-1]
+			_, err := out.WriteString("]}")
+			return err
+		}
+
+		if !first[len(first)-1] {
+			out.WriteString(",")
+		}
+		first[len(first)-1] = false
+		first = append(first, true)
+
+		node, err := json.Marshal(struct {
+			Type string
+			Name string
+			Code string
+		}{e.Type, e.Name, e.Code})
+		if err != nil {
+			return err
+		}
+
+		out.Write(node[:len(node)-1])
+		if _, err := out.WriteString(`,"Children":[`); err != nil {
+			return err
+		}
+
+		return out.visited()
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = out.WriteString("]}\n")
+	return err
+}
+
+// flushWriter buffers output and flushes it to the client every
+// streamFlushInterval codes, so a slow client holds back the walk rather than
+// the walk filling memory.
+type flushWriter struct {
+	*bufio.Writer
+	w     http.ResponseWriter
+	count int
+}
+
+func (f *flushWriter) visited() error {
+	f.count++
+	if f.count%streamFlushInterval != 0 {
+		return nil
+	}
+	return f.Flush()
+}
+
+func (f *flushWriter) Flush() error {
+	if err := f.Writer.Flush(); err != nil {
+		return err
+	}
+	if fl, ok := f.w.(http.Flusher); ok {
+		fl.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,265 @@
+package api
+
+import (
+	"context"
+	"encoding/csv"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/cantabular"
+	"github.com/ONSdigital/log.go/log"
+)
+
+const (
+	formatJSONStat = "jsonstat"
+
+	jsonStatVersion = "2.0"
+)
+
+// tableFormats maps the format query parameter to the media type a query
+// table is served as. JSON-stat has no media type of its own.
+var tableFormats = map[string]string{
+	formatJSON:     mediaTypeJSON,
+	formatCSV:      mediaTypeCSV,
+	formatJSONStat: mediaTypeJSON,
+}
+
+// negotiateTableFormat picks the representation of a query table from the
+// format query parameter, or failing that the Accept header. It returns false
+// if no supported representation was asked for.
+func negotiateTableFormat(r *http.Request) (string, bool) {
+	if format := r.URL.Query().Get("format"); format != "" {
+		_, ok := tableFormats[format]
+		return format, ok
+	}
+
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return formatJSON, true
+	}
+
+	best := ""
+	bestQ := 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if v, err := strconv.ParseFloat(params["q"], 64); err == nil {
+			q = v
+		}
+
+		format := ""
+		switch mediaType {
+		case mediaTypeJSON, "application/*", "*/*":
+			format = formatJSON
+		case mediaTypeCSV, "text/*":
+			format = formatCSV
+		}
+
+		if format != "" && q > bestQ {
+			best = format
+			bestQ = q
+		}
+	}
+
+	return best, best != ""
+}
+
+// writeTable writes a query table in the negotiated format
+func writeTable(ctx context.Context, w http.ResponseWriter, format string, t *cantabular.Table) {
+	switch format {
+	case formatJSONStat:
+		WriteBody(ctx, w, toJSONStat(t), http.StatusOK)
+	case formatCSV:
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Content-Type", mediaTypeCSV)
+		w.WriteHeader(http.StatusOK)
+
+		if err := writeTableCSV(w, t); err != nil {
+			log.Event(ctx, "failed to write table to response body", log.Error(err), log.ERROR, log.Data{"format": format})
+		}
+	default:
+		WriteBody(ctx, w, t, http.StatusOK)
+	}
+}
+
+// writeTableCSV writes one row per cell with the code and label of each of
+// its categories. Suppressed counts are left blank, with their marker in the
+// status column.
+func writeTableCSV(w io.Writer, t *cantabular.Table) error {
+	header := make([]string, 0, 2*len(t.Dimensions)+3)
+	for _, d := range t.Dimensions {
+		header = append(header, d.Name+"_code", d.Name+"_label")
+	}
+	header = append(header, "count")
+	if t.Status != nil {
+		header = append(header, "status")
+	}
+	if t.Percentages != nil {
+		header = append(header, "percentage_of_"+t.PercentageOf)
+	}
+
+	out := csv.NewWriter(w)
+	if err := out.Write(header); err != nil {
+		return err
+	}
+
+	cell := make([]int, len(t.Dimensions))
+	for i, count := range t.Counts {
+		row := make([]string, 0, len(header))
+		for d, c := range cell {
+			dim := t.Dimensions[d]
+			label := ""
+			if c < len(dim.Labels) {
+				label = dim.Labels[c]
+			}
+			row = append(row, dim.Codes[c], label)
+		}
+
+		status := ""
+		if t.Status != nil {
+			status = t.Status[i]
+		}
+
+		if status != "" {
+			row = append(row, "")
+		} else {
+			row = append(row, strconv.Itoa(count))
+		}
+
+		if t.Status != nil {
+			row = append(row, status)
+		}
+
+		if t.Percentages != nil {
+			if p := t.Percentages[i]; p != nil {
+				row = append(row, strconv.FormatFloat(*p, 'f', -1, 64))
+			} else {
+				row = append(row, "")
+			}
+		}
+
+		if err := out.Write(row); err != nil {
+			return err
+		}
+
+		for d := len(cell) - 1; d >= 0; d-- {
+			cell[d]++
+			if cell[d] < len(t.Dimensions[d].Codes) {
+				break
+			}
+			cell[d] = 0
+		}
+	}
+
+	out.Flush()
+	return out.Error()
+}
+
+// jsonStatDataset is a JSON-stat 2.0 dataset
+type jsonStatDataset struct {
+	Version   string                        `json:"version"`
+	Class     string                        `json:"class"`
+	Label     string                        `json:"label,omitempty"`
+	ID        []string                      `json:"id"`
+	Size      []int                         `json:"size"`
+	Role      map[string][]string           `json:"role,omitempty"`
+	Dimension map[string]*jsonStatDimension `json:"dimension"`
+	Value     []*float64                    `json:"value"`
+	Status    map[string]string             `json:"status,omitempty"`
+	Extension map[string]interface{}        `json:"extension,omitempty"`
+}
+
+type jsonStatDimension struct {
+	Label    string           `json:"label,omitempty"`
+	Category jsonStatCategory `json:"category"`
+}
+
+type jsonStatCategory struct {
+	Index map[string]int    `json:"index"`
+	Label map[string]string `json:"label,omitempty"`
+}
+
+// toJSONStat converts a table to a JSON-stat dataset. Suppressed counts are
+// null with their marker in status. Percentages are given as a second
+// category of a metric dimension alongside the counts.
+func toJSONStat(t *cantabular.Table) *jsonStatDataset {
+	ds := &jsonStatDataset{
+		Version:   jsonStatVersion,
+		Class:     "dataset",
+		Label:     t.Dataset,
+		ID:        make([]string, 0, len(t.Dimensions)+1),
+		Size:      make([]int, 0, len(t.Dimensions)+1),
+		Dimension: make(map[string]*jsonStatDimension),
+	}
+
+	for _, d := range t.Dimensions {
+		dim := &jsonStatDimension{
+			Label: d.Label,
+			Category: jsonStatCategory{
+				Index: make(map[string]int, len(d.Codes)),
+				Label: make(map[string]string, len(d.Codes)),
+			},
+		}
+
+		for i, code := range d.Codes {
+			dim.Category.Index[code] = i
+			if i < len(d.Labels) {
+				dim.Category.Label[code] = d.Labels[i]
+			}
+		}
+
+		ds.ID = append(ds.ID, d.Name)
+		ds.Size = append(ds.Size, len(d.Codes))
+		ds.Dimension[d.Name] = dim
+	}
+
+	measures := 1
+	if t.Percentages != nil {
+		measures = 2
+		ds.ID = append(ds.ID, "measure")
+		ds.Size = append(ds.Size, measures)
+		ds.Role = map[string][]string{"metric": {"measure"}}
+		ds.Dimension["measure"] = &jsonStatDimension{
+			Label: "Measure",
+			Category: jsonStatCategory{
+				Index: map[string]int{"count": 0, "percentage": 1},
+				Label: map[string]string{"count": "Count", "percentage": "Percentage of " + t.PercentageOf},
+			},
+		}
+	}
+
+	ds.Value = make([]*float64, 0, len(t.Counts)*measures)
+	for i, count := range t.Counts {
+		suppressed := t.Status != nil && t.Status[i] != ""
+		if suppressed {
+			if ds.Status == nil {
+				ds.Status = make(map[string]string)
+			}
+			ds.Status[strconv.Itoa(i*measures)] = t.Status[i]
+			ds.Value = append(ds.Value, nil)
+		} else {
+			v := float64(count)
+			ds.Value = append(ds.Value, &v)
+		}
+
+		if t.Percentages != nil {
+			if suppressed {
+				ds.Status[strconv.Itoa(i*measures+1)] = t.Status[i]
+			}
+			ds.Value = append(ds.Value, t.Percentages[i])
+		}
+	}
+
+	if t.DisclosureControl != nil {
+		ds.Extension = map[string]interface{}{"disclosure_control": t.DisclosureControl}
+	}
+
+	return ds
+}
@@ -0,0 +1,33 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/ONSdigital/log.go/log"
+	"github.com/gorilla/mux"
+)
+
+// GetValidation checks the codebook of a dataset is laid out as the hierarchy
+// routes expect, returning the report whether or not problems were found.
+func (api *API) GetValidation() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		dataset := mux.Vars(r)["dataset"]
+
+		codebook, ok := api.getCodebook(ctx, w, dataset)
+		if !ok {
+			return
+		}
+
+		if api.notModified(w, r, codebook.Dataset.Digest) {
+			return
+		}
+
+		report := codebook.Validate()
+		if !report.Valid {
+			log.Event(ctx, "codebook failed validation", log.WARN, log.Data{"dataset": dataset, "errors": report.Errors, "warnings": report.Warnings})
+		}
+
+		WriteBody(ctx, w, report, http.StatusOK)
+	})
+}
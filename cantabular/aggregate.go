@@ -0,0 +1,282 @@
+package cantabular
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidTable is returned when the counts of a table do not match its dimensions
+var ErrInvalidTable = errors.New("table counts do not match its dimensions")
+
+// AggregateError is returned when a table cannot be aggregated to a dimension
+type AggregateError struct {
+	Dimension string
+	Message   string
+}
+
+func (e AggregateError) Error() string {
+	return fmt.Sprintf("cannot aggregate to %s: %s", e.Dimension, e.Message)
+}
+
+// TableLevel is a run of the categories of a table dimension taken from one
+// dimension of the codebook. Derived levels were summed by the proxy from the
+// queried level rather than returned by the FTB. From gives, for each
+// category of the queried level, the category of a derived level it was
+// summed into, or -1 if none.
+type TableLevel struct {
+	Name    string `json:"name"`
+	Offset  int    `json:"offset"`
+	Count   int    `json:"count"`
+	Derived bool   `json:"derived"`
+	Margin  bool   `json:"margin,omitempty"`
+	From    []int  `json:"-"`
+}
+
+// LevelRuns returns the runs of categories of the dimension that each add up
+// to the same total, which is all of them unless it has been aggregated or
+// given a margin.
+func (d *TableDimension) LevelRuns() []TableLevel {
+	if len(d.Levels) > 0 {
+		return d.Levels
+	}
+	return []TableLevel{{Name: d.Name, Count: len(d.Codes)}}
+}
+
+// extend prepares the dimension for categories to be appended, copying its
+// codes so those of the table it came from are left alone.
+func (d *TableDimension) extend() {
+	if len(d.Levels) > 0 {
+		return
+	}
+	d.Codes = append([]string(nil), d.Codes...)
+	d.Labels = append([]string(nil), d.Labels...)
+	d.Levels = []TableLevel{{Name: d.Name, Count: len(d.Codes)}}
+}
+
+// Aggregate rolls the counts of a table up into coarser dimensions of the
+// codebook. Each target must be an ancestor of one of the queried dimensions,
+// reached through the MapFrom relationships. The categories of the target are
+// appended to those of the queried dimension they are summed from, with
+// Levels recording which were queried and which derived.
+func (t *Table) Aggregate(cb *Codebook, targets []string) (*Table, error) {
+	if len(t.Counts) != t.Size() {
+		return nil, ErrInvalidTable
+	}
+
+	out := &Table{
+		Dataset:    t.Dataset,
+		Dimensions: make([]TableDimension, len(t.Dimensions)),
+	}
+
+	// contributions holds, for each category of each queried dimension, the
+	// categories of the aggregated dimension its counts are added to
+	contributions := make([][][]int, len(t.Dimensions))
+	for d, td := range t.Dimensions {
+		out.Dimensions[d] = td
+		contributions[d] = make([][]int, len(td.Codes))
+		for i := range td.Codes {
+			contributions[d][i] = []int{i}
+		}
+	}
+
+	for _, target := range targets {
+		if t.dimension(target) >= 0 {
+			return nil, AggregateError{Dimension: target, Message: "dimension was queried"}
+		}
+
+		d, path := t.aggregatePath(cb, target)
+		if path == nil {
+			return nil, AggregateError{Dimension: target, Message: "not mapped from any queried dimension"}
+		}
+
+		td := &out.Dimensions[d]
+		td.extend()
+
+		for _, l := range td.Levels {
+			if l.Name == target {
+				return nil, AggregateError{Dimension: target, Message: "dimension given more than once"}
+			}
+		}
+
+		// a code must name one category of the dimension, whichever level
+		// it is taken from
+		dim := cb.GetDimension(target)
+		seen := make(map[string]bool, len(td.Codes))
+		for _, code := range td.Codes {
+			seen[code] = true
+		}
+		for _, code := range dim.Codes {
+			if seen[code] {
+				return nil, AggregateError{Dimension: target, Message: fmt.Sprintf("code %s is already in dimension %s", code, td.Name)}
+			}
+		}
+
+		offset := len(td.Codes)
+		for i, code := range dim.Codes {
+			td.Codes = append(td.Codes, code)
+			td.Labels = append(td.Labels, dim.LabelAt(i))
+		}
+		from := mapThrough(path, len(t.Dimensions[d].Codes))
+		td.Levels = append(td.Levels, TableLevel{Name: target, Offset: offset, Count: len(dim.Codes), Derived: true, From: from})
+
+		for i, p := range from {
+			if p >= 0 {
+				contributions[d][i] = append(contributions[d][i], offset+p)
+			}
+		}
+	}
+
+	t.spread(out, contributions)
+	return out, nil
+}
+
+// spread fills in the counts of out by adding each count of t to every
+// combination of the categories of out its categories contribute to.
+func (t *Table) spread(out *Table, contributions [][][]int) {
+	out.Counts = make([]int, out.Size())
+	strides := out.Strides()
+
+	source := make([]int, len(t.Dimensions))
+	for _, count := range t.Counts {
+		addContributions(out.Counts, contributions, strides, source, 0, 0, count)
+
+		for d := len(source) - 1; d >= 0; d-- {
+			source[d]++
+			if source[d] < len(t.Dimensions[d].Codes) {
+				break
+			}
+			source[d] = 0
+		}
+	}
+}
+
+// addContributions adds count to every combination of the target categories
+// of the source cell, from dimension d onwards.
+func addContributions(counts []int, contributions [][][]int, strides, source []int, d, cell, count int) {
+	if d == len(source) {
+		counts[cell] += count
+		return
+	}
+
+	for _, target := range contributions[d][source[d]] {
+		addContributions(counts, contributions, strides, source, d+1, cell+target*strides[d], count)
+	}
+}
+
+// aggregatePath finds the queried dimension nearest to the target, returning
+// its position in the table and the branches from the target down to it.
+func (t *Table) aggregatePath(cb *Codebook, target string) (int, []*Branch) {
+	dim := cb.GetDimension(target)
+	if dim == nil {
+		return -1, nil
+	}
+
+	type step struct {
+		dim  *Dimension
+		path []*Branch
+	}
+
+	// breadth first, so the shortest route to a queried dimension is taken
+	queue := []step{{dim: dim}}
+	visited := map[string]bool{target: true}
+
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+
+		for _, b := range s.dim.Branches() {
+			if visited[b.Child] {
+				continue
+			}
+			visited[b.Child] = true
+
+			path := append(append([]*Branch(nil), s.path...), b)
+			if d := t.dimension(b.Child); d >= 0 {
+				return d, path
+			}
+
+			if child := cb.GetDimension(b.Child); child != nil {
+				queue = append(queue, step{dim: child, path: path})
+			}
+		}
+	}
+
+	return -1, nil
+}
+
+// mapThrough returns, for each of the n codes of the dimension at the bottom
+// of the path, the index of the code of the dimension at the top that it is
+// mapped into, or -1 if it is not mapped.
+func mapThrough(path []*Branch, n int) []int {
+	mapping := make([]int, n)
+	for i := range mapping {
+		mapping[i] = i
+	}
+
+	for i := len(path) - 1; i >= 0; i-- {
+		parents := path[i].parentIndices()
+		for j, m := range mapping {
+			if m >= 0 && m < len(parents) {
+				mapping[j] = parents[m]
+			} else {
+				mapping[j] = -1
+			}
+		}
+	}
+
+	return mapping
+}
+
+// parentIndices returns the index of the parent code of each child code in
+// a single pass over MapFromCodes, -1 where a child precedes any parent code.
+func (b *Branch) parentIndices() []int {
+	positions := make(map[string]int, len(b.Parent.Codes))
+	for i, code := range b.Parent.Codes {
+		positions[code] = i
+	}
+
+	parents := make([]int, len(b.MapFromCodes))
+	current := -1
+	for i, code := range b.MapFromCodes {
+		if code != "" {
+			current = -1
+			if p, ok := positions[code]; ok {
+				current = p
+			}
+		}
+		parents[i] = current
+	}
+
+	return parents
+}
+
+// dimension returns the position of the named dimension in the table, or -1
+func (t *Table) dimension(name string) int {
+	for i, d := range t.Dimensions {
+		if d.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Size returns the number of cells in the table
+func (t *Table) Size() int {
+	size := 1
+	for _, d := range t.Dimensions {
+		size *= len(d.Codes)
+	}
+	return size
+}
+
+// Strides returns how far apart in Counts consecutive categories of each
+// dimension are, the last dimension varying fastest.
+func (t *Table) Strides() []int {
+	strides := make([]int, len(t.Dimensions))
+	stride := 1
+	for d := len(t.Dimensions) - 1; d >= 0; d-- {
+		strides[d] = stride
+		stride *= len(t.Dimensions[d].Codes)
+	}
+	return strides
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 
 	dphttp "github.com/ONSdigital/dp-net/http"
 	"github.com/ONSdigital/log.go/log"
@@ -33,7 +34,7 @@ func (c *Client) GetData(ctx context.Context, url string) (Entity, error) {
 		return nil, err
 	}
 
-	resp, err := c.HttpCli.Do(ctx, outReq)
+	resp, err := c.do(ctx, outReq)
 	if err != nil {
 		return nil, err
 	}
@@ -72,8 +73,44 @@ func (c *Client) GetDatasetCodebook(ctx context.Context, dataset string) (*Codeb
 	return &codebookResp, err
 }
 
+// Query tabulates a dataset by the given variables
+func (c *Client) Query(ctx context.Context, dataset string, variables []string) (*Table, error) {
+	params := url.Values{"v": variables}
+	queryURL := fmt.Sprintf("%s/v6/query/%s?%s", c.Host, dataset, params.Encode())
+
+	req, err := http.NewRequest("GET", queryURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var table Table
+	err = c.execGet(ctx, req, &table)
+	if err != nil {
+		return nil, err
+	}
+
+	return &table, nil
+}
+
+func (c *Client) GetDatasets(ctx context.Context) (*Datasets, error) {
+	url := fmt.Sprintf("%s/v6/datasets", c.Host)
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var datasets Datasets
+	err = c.execGet(ctx, req, &datasets)
+	if err != nil {
+		return nil, err
+	}
+
+	return &datasets, nil
+}
+
 func (c *Client) execGet(ctx context.Context, r *http.Request, entity interface{}) error {
-	resp, err := c.HttpCli.Do(ctx, r)
+	resp, err := c.do(ctx, r)
 	if err != nil {
 		return err
 	}
@@ -92,6 +129,26 @@ func (c *Client) execGet(ctx context.Context, r *http.Request, entity interface{
 	return nil
 }
 
+// do sends the request asking the FTB for a compressed response body, and
+// replaces the body of the response with a reader that decompresses it.
+func (c *Client) do(ctx context.Context, r *http.Request) (*http.Response, error) {
+	r.Header.Set("Accept-Encoding", acceptEncoding)
+
+	resp, err := c.HttpCli.Do(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := decompress(resp)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+
+	resp.Body = body
+	return resp, nil
+}
+
 func handleErrorResponse(resp *http.Response) error {
 	b, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
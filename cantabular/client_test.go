@@ -0,0 +1,55 @@
+package cantabular_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/cantabular"
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/cantabular/fake"
+	dphttp "github.com/ONSdigital/dp-net/http"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestClientDecompression(t *testing.T) {
+	Convey("Given a fake FTB and a client of it", t, func() {
+		ftb := fake.New(fake.Example())
+		defer ftb.Close()
+
+		httpCli := dphttp.NewClient()
+		httpCli.SetMaxRetries(0)
+		client := &cantabular.Client{Host: ftb.URL, HttpCli: httpCli}
+
+		ctx := context.Background()
+
+		for _, encoding := range []string{"br", "gzip", ""} {
+			encoding := encoding
+			ftb.SetEncodings(encoding)
+
+			Convey("When the FTB responds with encoding '"+encoding+"'", func() {
+				req, err := http.NewRequest(http.MethodGet, ftb.URL+"/v6/codebook/Example", nil)
+				So(err, ShouldBeNil)
+				req.Header.Set("Accept-Encoding", "br, gzip")
+
+				resp, err := http.DefaultTransport.RoundTrip(req)
+				So(err, ShouldBeNil)
+				resp.Body.Close()
+				So(resp.Header.Get("Content-Encoding"), ShouldEqual, encoding)
+
+				Convey("Then the client decodes the codebook", func() {
+					codebook, err := client.GetDatasetCodebook(ctx, "Example")
+					So(err, ShouldBeNil)
+					So(codebook.Dataset.Name, ShouldEqual, "Example")
+					So(codebook.GetDimension("sex"), ShouldNotBeNil)
+				})
+
+				Convey("Then the client decodes a query", func() {
+					table, err := client.Query(ctx, "Example", []string{"sex"})
+					So(err, ShouldBeNil)
+					So(table.Dimensions, ShouldHaveLength, 1)
+					So(table.Counts, ShouldHaveLength, len(table.Dimensions[0].Codes))
+				})
+			})
+		}
+	})
+}
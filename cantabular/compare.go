@@ -0,0 +1,170 @@
+package cantabular
+
+import (
+	"errors"
+	"math"
+)
+
+// ErrMismatchedTables is returned when comparing tables of different variables
+var ErrMismatchedTables = errors.New("tables do not have the same dimensions")
+
+// Where a category of a comparison is found
+const (
+	PresentInBoth  = "both"
+	PresentInLeft  = "left"
+	PresentInRight = "right"
+)
+
+// Comparison sets the counts of the same query on two datasets side by side.
+// Categories are aligned by code, so each cell holds the count from each
+// dataset, which is null where the dataset does not have the category or the
+// count was suppressed, and the difference between them.
+type Comparison struct {
+	Left                  string                `json:"left"`
+	Right                 string                `json:"right"`
+	Dimensions            []ComparisonDimension `json:"dimensions"`
+	LeftCounts            []*int                `json:"left_counts"`
+	RightCounts           []*int                `json:"right_counts"`
+	Differences           []*int                `json:"differences"`
+	PercentageDifferences []*float64            `json:"percentage_differences"`
+}
+
+// ComparisonDimension holds the codes of a dimension in either dataset, with
+// Presence saying which of them each is found in.
+type ComparisonDimension struct {
+	Name     string   `json:"name"`
+	Label    string   `json:"label"`
+	Codes    []string `json:"codes"`
+	Labels   []string `json:"labels"`
+	Presence []string `json:"presence"`
+}
+
+// Compare aligns two tables of the same variables, in the same order, by
+// code. Codes are listed in the order of the left table followed by any only
+// found in the right. The difference is right minus left, and the percentage
+// difference is relative to the left count.
+func Compare(left, right *Table) (*Comparison, error) {
+	if len(left.Counts) != left.Size() || len(right.Counts) != right.Size() {
+		return nil, ErrInvalidTable
+	}
+
+	if len(left.Dimensions) != len(right.Dimensions) {
+		return nil, ErrMismatchedTables
+	}
+	for d := range left.Dimensions {
+		if left.Dimensions[d].Name != right.Dimensions[d].Name {
+			return nil, ErrMismatchedTables
+		}
+	}
+
+	c := &Comparison{
+		Left:       left.Dataset,
+		Right:      right.Dataset,
+		Dimensions: make([]ComparisonDimension, len(left.Dimensions)),
+	}
+
+	// leftIndex and rightIndex map each category of the comparison to the
+	// category of each table, or -1 if the table does not have it
+	leftIndex := make([][]int, len(left.Dimensions))
+	rightIndex := make([][]int, len(left.Dimensions))
+
+	for d := range left.Dimensions {
+		l, r := &left.Dimensions[d], &right.Dimensions[d]
+		cd := &c.Dimensions[d]
+		cd.Name = l.Name
+		cd.Label = l.Label
+
+		positions := make(map[string]int, len(r.Codes))
+		for i, code := range r.Codes {
+			positions[code] = i
+		}
+
+		matched := make(map[string]bool, len(l.Codes))
+		for i, code := range l.Codes {
+			cd.Codes = append(cd.Codes, code)
+			cd.Labels = append(cd.Labels, l.LabelAt(i))
+			leftIndex[d] = append(leftIndex[d], i)
+
+			if j, ok := positions[code]; ok {
+				matched[code] = true
+				cd.Presence = append(cd.Presence, PresentInBoth)
+				rightIndex[d] = append(rightIndex[d], j)
+			} else {
+				cd.Presence = append(cd.Presence, PresentInLeft)
+				rightIndex[d] = append(rightIndex[d], -1)
+			}
+		}
+
+		for j, code := range r.Codes {
+			if matched[code] {
+				continue
+			}
+			cd.Codes = append(cd.Codes, code)
+			cd.Labels = append(cd.Labels, r.LabelAt(j))
+			cd.Presence = append(cd.Presence, PresentInRight)
+			leftIndex[d] = append(leftIndex[d], -1)
+			rightIndex[d] = append(rightIndex[d], j)
+		}
+	}
+
+	size := 1
+	for _, d := range c.Dimensions {
+		size *= len(d.Codes)
+	}
+
+	c.LeftCounts = make([]*int, size)
+	c.RightCounts = make([]*int, size)
+	c.Differences = make([]*int, size)
+	c.PercentageDifferences = make([]*float64, size)
+
+	leftStrides := left.Strides()
+	rightStrides := right.Strides()
+
+	cell := make([]int, len(c.Dimensions))
+	for i := 0; i < size; i++ {
+		l := countAt(left, leftStrides, leftIndex, cell)
+		r := countAt(right, rightStrides, rightIndex, cell)
+		c.LeftCounts[i] = l
+		c.RightCounts[i] = r
+
+		if l != nil && r != nil {
+			diff := *r - *l
+			c.Differences[i] = &diff
+
+			if *l != 0 {
+				p := math.Round(float64(diff)*10000/float64(*l)) / 100
+				c.PercentageDifferences[i] = &p
+			}
+		}
+
+		for d := len(cell) - 1; d >= 0; d-- {
+			cell[d]++
+			if cell[d] < len(c.Dimensions[d].Codes) {
+				break
+			}
+			cell[d] = 0
+		}
+	}
+
+	return c, nil
+}
+
+// countAt returns the count of the table for a cell of the comparison, or nil
+// if the table does not have one of its categories or the count is suppressed.
+func countAt(t *Table, strides []int, index [][]int, cell []int) *int {
+	i := 0
+	for d, c := range cell {
+		category := index[d][c]
+		if category < 0 {
+			return nil
+		}
+		i += category * strides[d]
+	}
+
+	if t.suppressed(i) {
+		return nil
+	}
+
+	count := t.Counts[i]
+	return &count
+}
@@ -0,0 +1,56 @@
+package cantabular
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+const acceptEncoding = "br, gzip"
+
+// decompress returns a reader over the decoded response body according to the
+// Content-Encoding the FTB chose for it.
+func decompress(resp *http.Response) (io.ReadCloser, error) {
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+
+	switch encoding {
+	case "", "identity":
+		return resp.Body, nil
+	case "gzip":
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		resp.Header.Del("Content-Encoding")
+		resp.Header.Del("Content-Length")
+		return &decodedBody{Reader: gz, decoder: gz, body: resp.Body}, nil
+	case "br":
+		resp.Header.Del("Content-Encoding")
+		resp.Header.Del("Content-Length")
+		return &decodedBody{Reader: brotli.NewReader(resp.Body), body: resp.Body}, nil
+	default:
+		return nil, fmt.Errorf("unsupported content encoding from flexible table builder: %s", encoding)
+	}
+}
+
+// decodedBody closes both the decoder and the underlying response body.
+type decodedBody struct {
+	io.Reader
+	decoder io.Closer
+	body    io.ReadCloser
+}
+
+func (d *decodedBody) Close() error {
+	if d.decoder != nil {
+		d.decoder.Close()
+	}
+
+	// drain the body so the connection can be reused
+	io.Copy(ioutil.Discard, d.body)
+	return d.body.Close()
+}
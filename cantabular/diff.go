@@ -0,0 +1,210 @@
+package cantabular
+
+// CodebookDiff lists what changed between two versions of a dataset codebook
+type CodebookDiff struct {
+	Dataset           string           `json:"dataset"`
+	From              string           `json:"from"`
+	To                string           `json:"to"`
+	AddedDimensions   []string         `json:"added_dimensions"`
+	RemovedDimensions []string         `json:"removed_dimensions"`
+	ChangedDimensions []*DimensionDiff `json:"changed_dimensions"`
+}
+
+// DimensionDiff lists what changed in a dimension found in both versions.
+// MapFrom is set when the dimensions it maps from, or how its codes map from
+// them, have changed.
+type DimensionDiff struct {
+	Name         string         `json:"name"`
+	Label        *Relabel       `json:"label,omitempty"`
+	AddedCodes   []string       `json:"added_codes,omitempty"`
+	RemovedCodes []string       `json:"removed_codes,omitempty"`
+	Relabelled   []*CodeRelabel `json:"relabelled_codes,omitempty"`
+	MapFrom      *MapFromChange `json:"map_from,omitempty"`
+}
+
+// Relabel is a label that has changed
+type Relabel struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// CodeRelabel is a code whose label has changed
+type CodeRelabel struct {
+	Code string `json:"code"`
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// MapFromChange describes a change to the hierarchy below a dimension.
+// ChangedBranches lists the dimensions it maps from in both versions whose
+// mapping of codes has changed.
+type MapFromChange struct {
+	From            []string `json:"from"`
+	To              []string `json:"to"`
+	ChangedBranches []string `json:"changed_branches,omitempty"`
+}
+
+// DiffSummary counts the changes in a codebook diff
+type DiffSummary struct {
+	AddedDimensions   int `json:"added_dimensions"`
+	RemovedDimensions int `json:"removed_dimensions"`
+	ChangedDimensions int `json:"changed_dimensions"`
+	AddedCodes        int `json:"added_codes"`
+	RemovedCodes      int `json:"removed_codes"`
+	RelabelledCodes   int `json:"relabelled_codes"`
+	ChangedMapFrom    int `json:"changed_map_from"`
+}
+
+// Diff compares two versions of a codebook. Dimensions are listed in the
+// order of the codebook they are found in.
+func Diff(from, to *Codebook) *CodebookDiff {
+	diff := &CodebookDiff{
+		Dataset:           to.Dataset.Name,
+		From:              from.Dataset.Digest,
+		To:                to.Dataset.Digest,
+		AddedDimensions:   make([]string, 0),
+		RemovedDimensions: make([]string, 0),
+		ChangedDimensions: make([]*DimensionDiff, 0),
+	}
+
+	for i := range from.CodeBook {
+		if to.GetDimension(from.CodeBook[i].Name) == nil {
+			diff.RemovedDimensions = append(diff.RemovedDimensions, from.CodeBook[i].Name)
+		}
+	}
+
+	for i := range to.CodeBook {
+		next := &to.CodeBook[i]
+
+		prev := from.GetDimension(next.Name)
+		if prev == nil {
+			diff.AddedDimensions = append(diff.AddedDimensions, next.Name)
+			continue
+		}
+
+		if d := diffDimension(prev, next); d != nil {
+			diff.ChangedDimensions = append(diff.ChangedDimensions, d)
+		}
+	}
+
+	return diff
+}
+
+// Empty reports whether nothing changed between the two codebooks
+func (d *CodebookDiff) Empty() bool {
+	return len(d.AddedDimensions) == 0 && len(d.RemovedDimensions) == 0 && len(d.ChangedDimensions) == 0
+}
+
+// Summary counts the changes in the diff
+func (d *CodebookDiff) Summary() DiffSummary {
+	s := DiffSummary{
+		AddedDimensions:   len(d.AddedDimensions),
+		RemovedDimensions: len(d.RemovedDimensions),
+		ChangedDimensions: len(d.ChangedDimensions),
+	}
+
+	for _, dim := range d.ChangedDimensions {
+		s.AddedCodes += len(dim.AddedCodes)
+		s.RemovedCodes += len(dim.RemovedCodes)
+		s.RelabelledCodes += len(dim.Relabelled)
+		if dim.MapFrom != nil {
+			s.ChangedMapFrom++
+		}
+	}
+
+	return s
+}
+
+// diffDimension returns the changes to a dimension, or nil if there are none
+func diffDimension(prev, next *Dimension) *DimensionDiff {
+	d := &DimensionDiff{Name: next.Name}
+	changed := false
+
+	if prev.Label != next.Label {
+		d.Label = &Relabel{From: prev.Label, To: next.Label}
+		changed = true
+	}
+
+	prevLabels := make(map[string]string, len(prev.Codes))
+	for i, code := range prev.Codes {
+		prevLabels[code] = prev.LabelAt(i)
+	}
+
+	nextCodes := make(map[string]bool, len(next.Codes))
+	for i, code := range next.Codes {
+		nextCodes[code] = true
+
+		label, ok := prevLabels[code]
+		if !ok {
+			d.AddedCodes = append(d.AddedCodes, code)
+			changed = true
+			continue
+		}
+
+		if l := next.LabelAt(i); l != label {
+			d.Relabelled = append(d.Relabelled, &CodeRelabel{Code: code, From: label, To: l})
+			changed = true
+		}
+	}
+
+	for _, code := range prev.Codes {
+		if !nextCodes[code] {
+			d.RemovedCodes = append(d.RemovedCodes, code)
+			changed = true
+		}
+	}
+
+	if m := diffMapFrom(prev, next); m != nil {
+		d.MapFrom = m
+		changed = true
+	}
+
+	if !changed {
+		return nil
+	}
+	return d
+}
+
+// diffMapFrom compares the branches of the hierarchy below a dimension,
+// returning nil if they are unchanged.
+func diffMapFrom(prev, next *Dimension) *MapFromChange {
+	m := &MapFromChange{From: prev.MapFrom, To: next.MapFrom}
+	changed := !equalStrings(prev.MapFrom, next.MapFrom)
+
+	prevBranches := make(map[string]*Branch)
+	for _, b := range prev.Branches() {
+		prevBranches[b.Child] = b
+	}
+
+	for _, b := range next.Branches() {
+		p, ok := prevBranches[b.Child]
+		if ok && !equalStrings(p.MapFromCodes, b.MapFromCodes) {
+			m.ChangedBranches = append(m.ChangedBranches, b.Child)
+			changed = true
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+
+	if m.From == nil {
+		m.From = make([]string, 0)
+	}
+	if m.To == nil {
+		m.To = make([]string, 0)
+	}
+	return m
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,53 @@
+package fake
+
+import "github.com/ONSdigital/dp-census-alpha-api-proxy/cantabular"
+
+// Example returns a small dataset with a country > region > local authority
+// geography hierarchy and a sex variable. Country also maps directly from
+// local authority, as a second branch.
+func Example() *Dataset {
+	return &Dataset{
+		Codebook: &cantabular.Codebook{
+			Dataset: cantabular.Dataset{
+				Name:        "Example",
+				Description: "Example dataset for testing",
+				Size:        1000,
+				Digest:      "example-digest-1",
+			},
+			CodeBook: []cantabular.Dimension{
+				{
+					Name:         "country",
+					Label:        "Country",
+					Codes:        []string{"E92000001"},
+					Labels:       []string{"England"},
+					MapFrom:      []string{"region", "la"},
+					MapFromCodes: []string{"E92000001", ""},
+					BranchCodes: [][]string{
+						{"E92000001", ""},
+						{"E92000001", "", "", ""},
+					},
+				},
+				{
+					Name:         "region",
+					Label:        "Region",
+					Codes:        []string{"E12000001", "E12000002"},
+					Labels:       []string{"North East", "North West"},
+					MapFrom:      []string{"la"},
+					MapFromCodes: []string{"E12000001", "", "E12000002", ""},
+				},
+				{
+					Name:   "la",
+					Label:  "Local Authority",
+					Codes:  []string{"E06000001", "E06000002", "E06000008", "E06000009"},
+					Labels: []string{"Hartlepool", "Middlesbrough", "Blackburn with Darwen", "Blackpool"},
+				},
+				{
+					Name:   "sex",
+					Label:  "Sex",
+					Codes:  []string{"1", "2"},
+					Labels: []string{"Male", "Female"},
+				},
+			},
+		},
+	}
+}
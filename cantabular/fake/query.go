@@ -0,0 +1,181 @@
+package fake
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/cantabular"
+)
+
+// Query tabulates the dataset by the named dimensions. Counts are generated
+// for every combination of base dimension codes, the dimensions that are not
+// mapped from anything finer, and summed up into the queried categories, so
+// totals are consistent across levels of a hierarchy.
+func (d *Dataset) Query(variables []string) (*cantabular.Table, error) {
+	if len(variables) == 0 {
+		return nil, fmt.Errorf("no variables provided")
+	}
+
+	cb := d.Codebook
+	table := &cantabular.Table{Dataset: cb.Dataset.Name}
+
+	mappers := make([]func(map[string]string) int, 0, len(variables))
+	size := 1
+	seen := make(map[string]bool)
+
+	for _, v := range variables {
+		if seen[v] {
+			return nil, fmt.Errorf("variable %s requested more than once", v)
+		}
+		seen[v] = true
+
+		dim := cb.GetDimension(v)
+		if dim == nil {
+			return nil, fmt.Errorf("variable %s not found", v)
+		}
+
+		table.Dimensions = append(table.Dimensions, cantabular.TableDimension{
+			Name:   dim.Name,
+			Label:  dim.Label,
+			Codes:  dim.Codes,
+			Labels: dim.Labels,
+		})
+
+		mapper, err := indexFromBase(cb, dim)
+		if err != nil {
+			return nil, err
+		}
+
+		mappers = append(mappers, mapper)
+		size *= len(dim.Codes)
+	}
+
+	table.Counts = make([]int, size)
+
+	count := d.Count
+	if count == nil {
+		count = DefaultCount
+	}
+
+	forEachBaseCombination(baseDimensions(cb), func(codes map[string]string) {
+		cell := 0
+		for i, mapper := range mappers {
+			index := mapper(codes)
+			if index < 0 {
+				return
+			}
+			cell = cell*len(table.Dimensions[i].Codes) + index
+		}
+		table.Counts[cell] += count(codes)
+	})
+
+	return table, nil
+}
+
+// DefaultCount gives a small, stable, pseudo random count for a combination
+// of base dimension codes.
+func DefaultCount(codes map[string]string) int {
+	keys := make([]string, 0, len(codes))
+	for k := range codes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := fnv.New32a()
+	for _, k := range keys {
+		h.Write([]byte(k + "=" + codes[k] + ";"))
+	}
+
+	return int(h.Sum32() % 20)
+}
+
+func baseDimensions(cb *cantabular.Codebook) []cantabular.Dimension {
+	bases := make([]cantabular.Dimension, 0)
+	for _, dim := range cb.CodeBook {
+		if len(dim.MapFrom) == 0 {
+			bases = append(bases, dim)
+		}
+	}
+	return bases
+}
+
+func forEachBaseCombination(bases []cantabular.Dimension, fn func(map[string]string)) {
+	codes := make(map[string]string)
+
+	var walk func(i int)
+	walk = func(i int) {
+		if i == len(bases) {
+			fn(codes)
+			return
+		}
+		for _, code := range bases[i].Codes {
+			codes[bases[i].Name] = code
+			walk(i + 1)
+		}
+	}
+
+	walk(0)
+}
+
+// indexFromBase returns a function giving the index of the category of dim
+// that a combination of base codes falls into, following MapFrom down to the
+// base dimension dim is built from.
+func indexFromBase(cb *cantabular.Codebook, dim *cantabular.Dimension) (func(map[string]string) int, error) {
+	chain := []*cantabular.Dimension{dim}
+	for current := dim; len(current.MapFrom) > 0; {
+		child := cb.GetDimension(current.MapFrom[0])
+		if child == nil {
+			return nil, fmt.Errorf("variable %s maps from unknown variable %s", current.Name, current.MapFrom[0])
+		}
+		if len(chain) > len(cb.CodeBook) {
+			return nil, fmt.Errorf("variable %s has a cyclic mapping", dim.Name)
+		}
+		chain = append(chain, child)
+		current = child
+	}
+
+	base := chain[len(chain)-1]
+
+	// parent code for each code of the base, one level at a time
+	lookup := make(map[string]string)
+	for _, code := range base.Codes {
+		lookup[code] = code
+	}
+
+	for i := len(chain) - 2; i >= 0; i-- {
+		parents := parentCodes(chain[i], chain[i+1])
+		for baseCode, code := range lookup {
+			lookup[baseCode] = parents[code]
+		}
+	}
+
+	index := make(map[string]int)
+	for i, code := range dim.Codes {
+		index[code] = i
+	}
+
+	return func(codes map[string]string) int {
+		i, ok := index[lookup[codes[base.Name]]]
+		if !ok {
+			return -1
+		}
+		return i
+	}, nil
+}
+
+// parentCodes maps each code of child to the code of parent it belongs to,
+// where parent.MapFromCodes holds a parent code at the first of its children
+// followed by blanks for the rest.
+func parentCodes(parent, child *cantabular.Dimension) map[string]string {
+	parents := make(map[string]string)
+	current := ""
+	for i, code := range child.Codes {
+		if i < len(parent.MapFromCodes) && strings.TrimSpace(parent.MapFromCodes[i]) != "" {
+			current = parent.MapFromCodes[i]
+		}
+		parents[code] = current
+	}
+	return parents
+}
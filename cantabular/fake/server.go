@@ -0,0 +1,276 @@
+// Package fake provides an in-process emulator of the Cantabular flexible
+// table builder for tests. It serves datasets, codebooks and queries from
+// fixture codebooks and can be told to fail, slow down or return error
+// responses.
+package fake
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/cantabular"
+	"github.com/andybalholm/brotli"
+)
+
+// Fault describes a failure injected into requests whose path starts with
+// PathPrefix. A Fault applies to the next Times matching requests, or to all
+// of them if Times is zero.
+type Fault struct {
+	PathPrefix string
+	Times      int
+
+	// Latency delays the response
+	Latency time.Duration
+
+	// Status and Body replace the response when Status is set
+	Status int
+	Body   string
+
+	// Drop closes the connection without responding
+	Drop bool
+}
+
+// FTB is a fake flexible table builder listening on a local port
+type FTB struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	datasets map[string]*Dataset
+	faults   []*Fault
+	requests map[string]int
+
+	// encodings are the content encodings the fake compresses with, in
+	// order of preference, when the request accepts them
+	encodings []string
+}
+
+// Dataset is a fixture served by the fake: its codebook and a function giving
+// the count for each combination of base dimension codes.
+type Dataset struct {
+	Codebook *cantabular.Codebook
+	Count    func(codes map[string]string) int
+}
+
+// New starts a fake FTB serving the given datasets
+func New(datasets ...*Dataset) *FTB {
+	f := &FTB{
+		datasets:  make(map[string]*Dataset),
+		requests:  make(map[string]int),
+		encodings: []string{"br", "gzip"},
+	}
+
+	for _, d := range datasets {
+		f.datasets[d.Codebook.Dataset.Name] = d
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v6/datasets", f.listDatasets)
+	mux.HandleFunc("/v6/codebook/", f.getCodebook)
+	mux.HandleFunc("/v6/query/", f.query)
+
+	f.Server = httptest.NewServer(f.withFaults(mux))
+	return f
+}
+
+// AddDataset serves a new dataset or replaces an existing one, for example to
+// simulate a reload of the FTB with a new digest.
+func (f *FTB) AddDataset(d *Dataset) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.datasets[d.Codebook.Dataset.Name] = d
+}
+
+// RemoveDataset stops serving a dataset
+func (f *FTB) RemoveDataset(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.datasets, name)
+}
+
+// Inject adds a fault. Faults are checked in the order they were added.
+func (f *FTB) Inject(fault Fault) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults = append(f.faults, &fault)
+}
+
+// Reset removes all faults and clears the request counts
+func (f *FTB) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults = nil
+	f.requests = make(map[string]int)
+}
+
+// SetEncodings sets the content encodings the fake may compress responses
+// with, in order of preference. With none, responses are not compressed.
+func (f *FTB) SetEncodings(encodings ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.encodings = encodings
+}
+
+// Requests returns how many requests were received for a path
+func (f *FTB) Requests(path string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests[path]
+}
+
+func (f *FTB) withFaults(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fault := f.record(r.URL.Path)
+		if fault == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		if fault.Latency > 0 {
+			select {
+			case <-time.After(fault.Latency):
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		if fault.Drop {
+			if hj, ok := w.(http.Hijacker); ok {
+				conn, _, err := hj.Hijack()
+				if err == nil {
+					conn.Close()
+					return
+				}
+			}
+			panic(http.ErrAbortHandler)
+		}
+
+		if fault.Status != 0 {
+			w.WriteHeader(fault.Status)
+			w.Write([]byte(fault.Body))
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// record counts the request and returns the fault to apply to it, if any
+func (f *FTB) record(path string) *Fault {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.requests[path]++
+
+	for i, fault := range f.faults {
+		if !strings.HasPrefix(path, fault.PathPrefix) {
+			continue
+		}
+
+		if fault.Times > 0 {
+			fault.Times--
+			if fault.Times == 0 {
+				f.faults = append(f.faults[:i], f.faults[i+1:]...)
+			}
+		}
+		return fault
+	}
+
+	return nil
+}
+
+func (f *FTB) dataset(name string) *Dataset {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.datasets[name]
+}
+
+func (f *FTB) listDatasets(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	list := cantabular.Datasets{Items: make([]*cantabular.Dataset, 0, len(f.datasets))}
+	for _, d := range f.datasets {
+		ds := d.Codebook.Dataset
+		list.Items = append(list.Items, &ds)
+	}
+	f.mu.Unlock()
+
+	sort.Slice(list.Items, func(i, j int) bool {
+		return list.Items[i].Name < list.Items[j].Name
+	})
+
+	f.writeJSON(w, r, list)
+}
+
+func (f *FTB) getCodebook(w http.ResponseWriter, r *http.Request) {
+	d := f.dataset(strings.TrimPrefix(r.URL.Path, "/v6/codebook/"))
+	if d == nil {
+		http.Error(w, "dataset not found", http.StatusNotFound)
+		return
+	}
+
+	f.writeJSON(w, r, d.Codebook)
+}
+
+func (f *FTB) query(w http.ResponseWriter, r *http.Request) {
+	d := f.dataset(strings.TrimPrefix(r.URL.Path, "/v6/query/"))
+	if d == nil {
+		http.Error(w, "dataset not found", http.StatusNotFound)
+		return
+	}
+
+	table, err := d.Query(r.URL.Query()["v"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.writeJSON(w, r, table)
+}
+
+// writeJSON encodes the entity, compressing it with the first of the fake's
+// encodings that the request accepts
+func (f *FTB) writeJSON(w http.ResponseWriter, r *http.Request, entity interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var body io.Writer = w
+	switch f.negotiate(r.Header.Get("Accept-Encoding")) {
+	case "br":
+		w.Header().Set("Content-Encoding", "br")
+		br := brotli.NewWriter(w)
+		defer br.Close()
+		body = br
+	case "gzip":
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		body = gz
+	}
+
+	json.NewEncoder(body).Encode(entity)
+}
+
+// negotiate returns the encoding to compress a response with, or an empty
+// string to send it as is
+func (f *FTB) negotiate(accept string) string {
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(accept, ",") {
+		coding := strings.TrimSpace(strings.SplitN(part, ";", 2)[0])
+		accepted[strings.ToLower(coding)] = true
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, e := range f.encodings {
+		if accepted[e] {
+			return e
+		}
+	}
+	return ""
+}
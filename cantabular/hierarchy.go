@@ -1,7 +1,31 @@
 package cantabular
 
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrNoHierarchy is returned when a dimension is not mapped from any finer dimension
+var ErrNoHierarchy = errors.New("dimension is not mapped from any other dimension")
+
+// BranchNotFoundError is returned when a dimension does not map from the requested branch
+type BranchNotFoundError struct {
+	Dimension string
+	Branch    string
+	Branches  []string
+}
+
+func (e BranchNotFoundError) Error() string {
+	return fmt.Sprintf("dimension %s does not map from %s, available branches: %s",
+		e.Dimension, e.Branch, strings.Join(e.Branches, ", "))
+}
+
 type Hierarchy struct {
 	Label    string
+	Branch   string
+	Branches []string
 	Children []*Node
 }
 
@@ -18,18 +42,66 @@ type Index struct {
 	Count int
 }
 
-func BuildHierarchyFrom(rootDim *Dimension, cb *Codebook, maxDepth int) *Hierarchy {
+// Branch is one of the finer dimensions a dimension maps from. MapFromCodes
+// is aligned with the codes of the child dimension, holding a parent code at
+// the first of its children followed by blanks for the rest.
+type Branch struct {
+	Parent       *Dimension
+	Child        string
+	MapFromCodes []string
+}
+
+// Branches returns every dimension d maps from, in codebook order
+func (d *Dimension) Branches() []*Branch {
+	branches := make([]*Branch, 0, len(d.MapFrom))
+	for i, child := range d.MapFrom {
+		var codes []string
+		if i < len(d.BranchCodes) {
+			codes = d.BranchCodes[i]
+		} else if i == 0 {
+			codes = d.MapFromCodes
+		}
+
+		branches = append(branches, &Branch{Parent: d, Child: child, MapFromCodes: codes})
+	}
+	return branches
+}
+
+// Branch returns the branch of d mapping from the named child dimension, or
+// the first branch if child is empty.
+func (d *Dimension) Branch(child string) (*Branch, error) {
+	branches := d.Branches()
+	if len(branches) == 0 {
+		return nil, ErrNoHierarchy
+	}
+
+	if child == "" {
+		return branches[0], nil
+	}
+
+	for _, b := range branches {
+		if b.Child == child {
+			return b, nil
+		}
+	}
+
+	return nil, BranchNotFoundError{Dimension: d.Name, Branch: child, Branches: d.MapFrom}
+}
+
+func BuildHierarchyFrom(rootDim *Dimension, branch *Branch, cb *Codebook, maxDepth int) *Hierarchy {
 	h := &Hierarchy{
 		Label:    rootDim.Name,
+		Branch:   branch.Child,
+		Branches: rootDim.MapFrom,
 		Children: make([]*Node, 0),
 	}
 
 	for i, code := range rootDim.Codes {
 		n := &Node{
 			Type:     rootDim.Name,
-			Name:     rootDim.Labels[i],
+			Name:     rootDim.LabelAt(i),
 			Code:     code,
-			Children: rootDim.GetChildrenForOption(code, cb, maxDepth, 1),
+			Children: branch.GetChildrenForOption(code, cb, maxDepth, 1),
 		}
 
 		h.Children = append(h.Children, n)
@@ -38,40 +110,63 @@ func BuildHierarchyFrom(rootDim *Dimension, cb *Codebook, maxDepth int) *Hierarc
 	return h
 }
 
-func (d *Dimension) GetChildrenForOption(parentCode string, cb *Codebook, maxDepth, depth int) []*Node {
+// GetChildrenForOption builds the tree below a code of the parent dimension.
+// Levels below the branch follow the first branch of each child dimension. A
+// maxDepth of zero or less builds the tree down to the leaves.
+func (b *Branch) GetChildrenForOption(parentCode string, cb *Codebook, maxDepth, depth int) []*Node {
 	children := make([]*Node, 0)
-	if depth >= maxDepth {
+	if maxDepth > 0 && depth >= maxDepth {
 		return children
 	}
 
 	depth += 1
 
-	index, found := d.GetDescendantCodeIndices(parentCode)
+	index, found := b.GetDescendantCodeIndices(parentCode)
 	if !found {
 		return children
 	}
 
-	childDim := cb.GetDimension(d.MapFrom[0])
+	childDim := cb.GetDimension(b.Child)
+	if childDim == nil {
+		return children
+	}
 
-	for i := index.Start; i <= index.End; i++ {
-		children = append(children, &Node{
+	childBranch, err := childDim.Branch("")
+
+	for i := index.Start; i <= index.End && i < len(childDim.Codes); i++ {
+		n := &Node{
 			Type:     childDim.Name,
-			Name:     childDim.Labels[i],
+			Name:     childDim.LabelAt(i),
 			Code:     childDim.Codes[i],
-			Children: childDim.GetChildrenForOption(childDim.Codes[i], cb, maxDepth, depth),
-		})
+			Children: make([]*Node, 0),
+		}
+
+		if err == nil {
+			n.Children = childBranch.GetChildrenForOption(childDim.Codes[i], cb, maxDepth, depth)
+		}
+
+		children = append(children, n)
 	}
 	return children
 }
 
+// GetDescendantCodeIndices finds the children of a code through the first
+// dimension d maps from.
 func (d *Dimension) GetDescendantCodeIndices(parentCode string) (*Index, bool) {
+	b := &Branch{Parent: d, MapFromCodes: d.MapFromCodes}
+	return b.GetDescendantCodeIndices(parentCode)
+}
+
+// GetDescendantCodeIndices returns the range of codes of the child dimension
+// that are mapped into parentCode.
+func (b *Branch) GetDescendantCodeIndices(parentCode string) (*Index, bool) {
 	var index *Index
 
 	found := false
 	i := 0
 
-	for ; i < len(d.MapFromCodes); i++ {
-		code := d.MapFromCodes[i]
+	for ; i < len(b.MapFromCodes); i++ {
+		code := b.MapFromCodes[i]
 		if code == parentCode {
 			found = true
 			index = &Index{Start: i, End: i, Count: 1}
@@ -88,3 +183,223 @@ func (d *Dimension) GetDescendantCodeIndices(parentCode string) (*Index, bool) {
 
 	return index, found
 }
+
+// Indices returns the range of codes of the child dimension mapped into each
+// parent code, in a single pass over MapFromCodes, for callers looking up the
+// children of many codes.
+func (b *Branch) Indices() map[string]*Index {
+	indices := make(map[string]*Index)
+
+	var index *Index
+	for i, code := range b.MapFromCodes {
+		if code == "" {
+			if index != nil {
+				index.End = i
+				index.Count++
+			}
+			continue
+		}
+
+		// as with GetDescendantCodeIndices, only the first run of a parent
+		// code counts
+		if _, ok := indices[code]; ok {
+			index = nil
+			continue
+		}
+
+		index = &Index{Start: i, End: i, Count: 1}
+		indices[code] = index
+	}
+
+	return indices
+}
+
+// Ancestor is a code of a coarser dimension that a code is mapped into
+type Ancestor struct {
+	Dimension *Dimension
+	Index     int
+}
+
+// GetParentDimensions returns every dimension that maps from the named one
+func (c *Codebook) GetParentDimensions(name string) []*Dimension {
+	parents := make([]*Dimension, 0)
+	for i := range c.CodeBook {
+		for _, from := range c.CodeBook[i].MapFrom {
+			if from == name {
+				parents = append(parents, &c.CodeBook[i])
+				break
+			}
+		}
+	}
+	return parents
+}
+
+// GetParentDimension returns the nearest dimension that maps from the named
+// one. Where several do, such as a country mapping from both regions and
+// local authorities, the one with the longest chain of ancestors above it is
+// the nearest.
+func (c *Codebook) GetParentDimension(name string) *Dimension {
+	var nearest *Dimension
+	nearestDepth := -1
+
+	for _, p := range c.GetParentDimensions(name) {
+		if depth := c.ancestorDepth(p.Name, len(c.CodeBook)); depth > nearestDepth {
+			nearest = p
+			nearestDepth = depth
+		}
+	}
+
+	return nearest
+}
+
+// ancestorDepth returns the length of the longest chain of dimensions above
+// the named one, giving up after limit levels in case of cyclic mappings.
+func (c *Codebook) ancestorDepth(name string, limit int) int {
+	if limit == 0 {
+		return 0
+	}
+
+	depth := 0
+	for _, p := range c.GetParentDimensions(name) {
+		if d := 1 + c.ancestorDepth(p.Name, limit-1); d > depth {
+			depth = d
+		}
+	}
+	return depth
+}
+
+// GetParentCodeIndex returns the index of the code of the parent dimension
+// that the code of the child dimension at childIndex is mapped into. The
+// parent code appears in MapFromCodes at the first of its children, so it is
+// found by walking back over the blanks.
+func (b *Branch) GetParentCodeIndex(childIndex int) (int, bool) {
+	if childIndex < 0 || childIndex >= len(b.MapFromCodes) {
+		return 0, false
+	}
+
+	for i := childIndex; i >= 0; i-- {
+		code := b.MapFromCodes[i]
+		if code == "" {
+			continue
+		}
+
+		j := indexOf(b.Parent.Codes, code)
+		return j, j >= 0
+	}
+
+	return 0, false
+}
+
+// GetAncestors returns the chain of codes a code of the named dimension is
+// mapped into, nearest first, following the nearest parent dimension at each
+// level up to the root of the hierarchy.
+func (c *Codebook) GetAncestors(name, code string) ([]*Ancestor, bool) {
+	dim := c.GetDimension(name)
+	if dim == nil {
+		return nil, false
+	}
+
+	index := indexOf(dim.Codes, code)
+	if index < 0 {
+		return nil, false
+	}
+
+	ancestors := make([]*Ancestor, 0)
+	for len(ancestors) < len(c.CodeBook) {
+		parent := c.GetParentDimension(dim.Name)
+		if parent == nil {
+			break
+		}
+
+		branch, err := parent.Branch(dim.Name)
+		if err != nil {
+			break
+		}
+
+		parentIndex, found := branch.GetParentCodeIndex(index)
+		if !found {
+			break
+		}
+
+		ancestors = append(ancestors, &Ancestor{Dimension: parent, Index: parentIndex})
+		dim, index = parent, parentIndex
+	}
+
+	return ancestors, true
+}
+
+// GetAncestorPaths returns every chain of codes a code of the named dimension
+// is mapped into, nearest first, following each dimension that maps from it
+// through GetParentDimensions rather than only the nearest. A country mapping
+// from both regions and local authorities gives a local authority one path
+// through its region and another straight to its country. Parent dimensions
+// are followed nearest first, as GetParentDimension picks them, so the first
+// path is the one GetAncestors returns. A dimension already on a path is not
+// followed again so a cyclic mapping cannot make it endless.
+func (c *Codebook) GetAncestorPaths(name, code string) ([][]*Ancestor, bool) {
+	dim := c.GetDimension(name)
+	if dim == nil {
+		return nil, false
+	}
+
+	index := indexOf(dim.Codes, code)
+	if index < 0 {
+		return nil, false
+	}
+
+	paths := make([][]*Ancestor, 0)
+
+	var climb func(dim *Dimension, index int, path []*Ancestor)
+	climb = func(dim *Dimension, index int, path []*Ancestor) {
+		parents := c.GetParentDimensions(dim.Name)
+		sort.SliceStable(parents, func(i, j int) bool {
+			return c.ancestorDepth(parents[i].Name, len(c.CodeBook)) > c.ancestorDepth(parents[j].Name, len(c.CodeBook))
+		})
+
+		extended := false
+		for _, parent := range parents {
+			if parent.Name == name || onPath(path, parent.Name) {
+				continue
+			}
+
+			branch, err := parent.Branch(dim.Name)
+			if err != nil {
+				continue
+			}
+
+			parentIndex, found := branch.GetParentCodeIndex(index)
+			if !found {
+				continue
+			}
+
+			extended = true
+			next := append(append(make([]*Ancestor, 0, len(path)+1), path...), &Ancestor{Dimension: parent, Index: parentIndex})
+			climb(parent, parentIndex, next)
+		}
+
+		if !extended && len(path) > 0 {
+			paths = append(paths, path)
+		}
+	}
+	climb(dim, index, nil)
+
+	return paths, true
+}
+
+func onPath(path []*Ancestor, name string) bool {
+	for _, a := range path {
+		if a.Dimension.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func indexOf(codes []string, code string) int {
+	for i, c := range codes {
+		if c == code {
+			return i
+		}
+	}
+	return -1
+}
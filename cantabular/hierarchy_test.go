@@ -0,0 +1,90 @@
+package cantabular_test
+
+import (
+	"testing"
+
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/cantabular"
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/cantabular/fake"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBranchIndices(t *testing.T) {
+	Convey("Given the branches of the example codebook", t, func() {
+		codebook := fake.Example().Codebook
+
+		for _, d := range codebook.CodeBook {
+			dim := d
+			for _, branch := range dim.Branches() {
+				Convey("When the indices of "+dim.Name+" from "+branch.Child+" are built", func() {
+					indices := branch.Indices()
+
+					Convey("Then each code has the same range as when looked up alone", func() {
+						for _, code := range dim.Codes {
+							want, found := branch.GetDescendantCodeIndices(code)
+							got, ok := indices[code]
+							So(ok, ShouldEqual, found)
+							So(got, ShouldResemble, want)
+						}
+						So(indices, ShouldHaveLength, len(dim.Codes))
+					})
+				})
+			}
+		}
+	})
+}
+
+func TestGetAncestorPaths(t *testing.T) {
+	Convey("Given the example codebook, where country maps from both regions and local authorities", t, func() {
+		codebook := fake.Example().Codebook
+
+		codes := func(path []*cantabular.Ancestor) []string {
+			out := make([]string, 0, len(path))
+			for _, a := range path {
+				out = append(out, a.Dimension.Name+":"+a.Dimension.Codes[a.Index])
+			}
+			return out
+		}
+
+		Convey("When the paths of a local authority are found", func() {
+			paths, found := codebook.GetAncestorPaths("la", "E06000001")
+
+			Convey("Then there is one through its region and one straight to its country", func() {
+				So(found, ShouldBeTrue)
+				So(paths, ShouldHaveLength, 2)
+				So(codes(paths[0]), ShouldResemble, []string{"region:E12000001", "country:E92000001"})
+				So(codes(paths[1]), ShouldResemble, []string{"country:E92000001"})
+			})
+		})
+
+		Convey("When the paths of a root code are found", func() {
+			paths, found := codebook.GetAncestorPaths("country", "E92000001")
+
+			Convey("Then there are none", func() {
+				So(found, ShouldBeTrue)
+				So(paths, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When the paths of an unknown code are found", func() {
+			_, found := codebook.GetAncestorPaths("la", "W06000001")
+
+			Convey("Then it is not found", func() {
+				So(found, ShouldBeFalse)
+			})
+		})
+
+		Convey("When local authorities also map from regions, making a loop", func() {
+			codebook.CodeBook[2].MapFrom = []string{"region"}
+			codebook.CodeBook[2].MapFromCodes = []string{"E06000001", "E06000008"}
+
+			paths, found := codebook.GetAncestorPaths("region", "E12000001")
+
+			Convey("Then the loop is not followed back round", func() {
+				So(found, ShouldBeTrue)
+				So(paths, ShouldHaveLength, 2)
+				So(codes(paths[0]), ShouldResemble, []string{"la:E06000001", "country:E92000001"})
+				So(codes(paths[1]), ShouldResemble, []string{"country:E92000001"})
+			})
+		})
+	})
+}
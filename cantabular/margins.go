@@ -0,0 +1,307 @@
+package cantabular
+
+import (
+	"fmt"
+	"math"
+)
+
+// Margins and percentages that can be added to a table. Rows run along the
+// first dimension of a table and columns along the last, so row totals sum
+// over the last dimension and column totals over the first.
+const (
+	MarginRow    = "row"
+	MarginColumn = "column"
+	MarginTotal  = "total"
+
+	PercentRow    = "row"
+	PercentColumn = "column"
+	PercentTotal  = "total"
+
+	// MarginCode and MarginLabel identify the category holding the totals
+	// added to a dimension
+	MarginCode  = "total"
+	MarginLabel = "Total"
+)
+
+// OptionError is returned for an unsupported value of a table option
+type OptionError struct {
+	Option string
+	Value  string
+}
+
+func (e OptionError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Option, e.Value)
+}
+
+// CheckOptions returns an OptionError for the first margin or percentage that
+// AddMargins or AddPercentages would not accept, so a query can be rejected
+// before it is run. An empty percent asks for no percentages.
+func CheckOptions(margins []string, percent string) error {
+	for _, m := range margins {
+		switch m {
+		case MarginRow, MarginColumn, MarginTotal:
+		default:
+			return OptionError{Option: "margin", Value: m}
+		}
+	}
+
+	switch percent {
+	case "", PercentRow, PercentColumn, PercentTotal:
+	default:
+		return OptionError{Option: "percentage", Value: percent}
+	}
+
+	return nil
+}
+
+// AddMargins returns the table with a total category appended to dimensions
+// of the table: the last for row totals, the first for column totals, or
+// every dimension for a grand total along with every margin. The totals of an
+// aggregated dimension sum its queried level only. Margins must be added
+// before disclosure control so they are computed from the true counts.
+func (t *Table) AddMargins(margins []string) (*Table, error) {
+	if len(t.Counts) != t.Size() {
+		return nil, ErrInvalidTable
+	}
+
+	if len(t.Dimensions) == 0 || len(margins) == 0 {
+		return t, nil
+	}
+
+	last := len(t.Dimensions) - 1
+	dims := make(map[int]bool)
+	for _, m := range margins {
+		switch m {
+		case MarginRow:
+			dims[last] = true
+		case MarginColumn:
+			dims[0] = true
+		case MarginTotal:
+			for d := range t.Dimensions {
+				dims[d] = true
+			}
+		default:
+			return nil, OptionError{Option: "margin", Value: m}
+		}
+	}
+
+	out := &Table{
+		Dataset:    t.Dataset,
+		Dimensions: make([]TableDimension, len(t.Dimensions)),
+	}
+
+	contributions := make([][][]int, len(t.Dimensions))
+	for d, td := range t.Dimensions {
+		out.Dimensions[d] = td
+		contributions[d] = make([][]int, len(td.Codes))
+		for i := range td.Codes {
+			contributions[d][i] = []int{i}
+		}
+
+		if !dims[d] || hasMargin(&td) {
+			continue
+		}
+
+		od := &out.Dimensions[d]
+		od.extend()
+
+		offset := len(od.Codes)
+		od.Codes = append(od.Codes, MarginCode)
+		od.Labels = append(od.Labels, MarginLabel)
+		od.Levels = append(od.Levels, TableLevel{Name: MarginCode, Offset: offset, Count: 1, Derived: true, Margin: true})
+
+		queried := od.Levels[0]
+		for i := queried.Offset; i < queried.Offset+queried.Count; i++ {
+			contributions[d][i] = append(contributions[d][i], offset)
+		}
+	}
+
+	t.spread(out, contributions)
+	return out, nil
+}
+
+func hasMargin(d *TableDimension) bool {
+	for _, l := range d.Levels {
+		if l.Margin {
+			return true
+		}
+	}
+	return false
+}
+
+// AddPercentages sets the percentage each count is of its row, column or of
+// the grand total. Percentages are worked out from the counts as published,
+// so they are added after disclosure control and use a margin of the table
+// as the denominator where there is one. Suppressed cells, and cells whose
+// denominator cannot be worked out because a count it sums is suppressed,
+// have no percentage.
+func (t *Table) AddPercentages(of string) error {
+	if len(t.Counts) != t.Size() {
+		return ErrInvalidTable
+	}
+
+	if len(t.Dimensions) == 0 {
+		return nil
+	}
+
+	t.Percentages = make([]*float64, len(t.Counts))
+	t.PercentageOf = of
+
+	switch of {
+	case PercentRow:
+		t.percentagesAlong(len(t.Dimensions) - 1)
+	case PercentColumn:
+		t.percentagesAlong(0)
+	case PercentTotal:
+		t.percentagesOfTotal()
+	default:
+		t.Percentages = nil
+		t.PercentageOf = ""
+		return OptionError{Option: "percentage", Value: of}
+	}
+
+	return nil
+}
+
+// percentagesAlong sets the percentage of each cell of the total of its line
+// along dimension d, within the level of d the cell belongs to.
+func (t *Table) percentagesAlong(d int) {
+	dim := &t.Dimensions[d]
+	stride := t.Strides()[d]
+	n := len(dim.Codes)
+
+	margin := -1
+	for _, l := range dim.Levels {
+		if l.Margin {
+			margin = l.Offset
+		}
+	}
+
+	for start := range t.Counts {
+		if (start/stride)%n != 0 {
+			continue
+		}
+
+		for _, level := range dim.LevelRuns() {
+			first := start + level.Offset*stride
+
+			var total int
+			var ok bool
+			switch {
+			case level.Margin:
+				total, ok = t.published(first)
+			case margin >= 0:
+				total, ok = t.published(start + margin*stride)
+			default:
+				total, ok = t.sum(first, stride, level.Count)
+			}
+
+			if !ok {
+				continue
+			}
+
+			for k := 0; k < level.Count; k++ {
+				t.setPercentage(first+k*stride, total)
+			}
+		}
+	}
+}
+
+// percentagesOfTotal sets the percentage of each cell of the grand total,
+// which is the corner cell where every dimension has a margin, or otherwise
+// the sum of the cells in the queried level of every dimension.
+func (t *Table) percentagesOfTotal() {
+	strides := t.Strides()
+
+	corner := 0
+	for d := range t.Dimensions {
+		margin := -1
+		for _, l := range t.Dimensions[d].Levels {
+			if l.Margin {
+				margin = l.Offset
+			}
+		}
+
+		if margin < 0 {
+			corner = -1
+			break
+		}
+		corner += margin * strides[d]
+	}
+
+	var total int
+	var ok bool
+	if corner >= 0 {
+		total, ok = t.published(corner)
+	} else {
+		total, ok = t.sumQueried(strides)
+	}
+
+	if !ok {
+		return
+	}
+
+	for i := range t.Counts {
+		t.setPercentage(i, total)
+	}
+}
+
+// sumQueried adds up the cells in the queried level of every dimension,
+// returning false if any of them is suppressed.
+func (t *Table) sumQueried(strides []int) (int, bool) {
+	total := 0
+	for i, count := range t.Counts {
+		queried := true
+		for d := range t.Dimensions {
+			first := t.Dimensions[d].LevelRuns()[0]
+			if c := (i / strides[d]) % len(t.Dimensions[d].Codes); c < first.Offset || c >= first.Offset+first.Count {
+				queried = false
+				break
+			}
+		}
+
+		if !queried {
+			continue
+		}
+
+		if t.suppressed(i) {
+			return 0, false
+		}
+		total += count
+	}
+	return total, true
+}
+
+// sum adds up n cells stride apart, returning false if any is suppressed
+func (t *Table) sum(first, stride, n int) (int, bool) {
+	total := 0
+	for k := 0; k < n; k++ {
+		i := first + k*stride
+		if t.suppressed(i) {
+			return 0, false
+		}
+		total += t.Counts[i]
+	}
+	return total, true
+}
+
+// published returns the count of a cell, or false if it is suppressed
+func (t *Table) published(i int) (int, bool) {
+	if t.suppressed(i) {
+		return 0, false
+	}
+	return t.Counts[i], true
+}
+
+func (t *Table) suppressed(i int) bool {
+	return i < len(t.Status) && t.Status[i] != ""
+}
+
+func (t *Table) setPercentage(i, total int) {
+	if total <= 0 || t.suppressed(i) {
+		return
+	}
+
+	p := math.Round(float64(t.Counts[i])*10000/float64(total)) / 100
+	t.Percentages[i] = &p
+}
@@ -1,5 +1,10 @@
 package cantabular
 
+import (
+	"encoding/json"
+	"fmt"
+)
+
 type Datasets struct {
 	Items []*Dataset `json:"items,omitempty"`
 }
@@ -15,6 +20,10 @@ type Dataset struct {
 type Codebook struct {
 	Dataset  Dataset     `json:"dataset"`
 	CodeBook []Dimension `json:"codebook"`
+
+	// Stale is set when the codebook is a snapshot served because the FTB
+	// could not be reached
+	Stale bool `json:"-"`
 }
 
 type Dimension struct {
@@ -24,6 +33,67 @@ type Dimension struct {
 	Labels       []string `json:"labels"`
 	MapFrom      []string `json:"mapFrom"`
 	MapFromCodes []string `json:"mapFromCodes"`
+
+	// BranchCodes holds the mapFromCodes for each entry in MapFrom when the
+	// dimension maps from more than one finer dimension, in which case the
+	// codebook gives mapFromCodes as a list per branch
+	BranchCodes [][]string `json:"-"`
+}
+
+// UnmarshalJSON accepts mapFromCodes either as a single list for the first
+// branch or as a list per branch.
+func (d *Dimension) UnmarshalJSON(b []byte) error {
+	type dimension Dimension
+	aux := struct {
+		*dimension
+		MapFromCodes json.RawMessage `json:"mapFromCodes"`
+	}{dimension: (*dimension)(d)}
+
+	if err := json.Unmarshal(b, &aux); err != nil {
+		return err
+	}
+
+	d.MapFromCodes = nil
+	d.BranchCodes = nil
+
+	if len(aux.MapFromCodes) == 0 || string(aux.MapFromCodes) == "null" {
+		return nil
+	}
+
+	if err := json.Unmarshal(aux.MapFromCodes, &d.MapFromCodes); err == nil {
+		return nil
+	}
+
+	if err := json.Unmarshal(aux.MapFromCodes, &d.BranchCodes); err != nil {
+		return fmt.Errorf("invalid mapFromCodes for dimension %s: %w", d.Name, err)
+	}
+
+	if len(d.BranchCodes) > 0 {
+		d.MapFromCodes = d.BranchCodes[0]
+	}
+	return nil
+}
+
+// MarshalJSON writes mapFromCodes in the same shape it was read in
+func (d Dimension) MarshalJSON() ([]byte, error) {
+	type dimension Dimension
+	if len(d.BranchCodes) == 0 {
+		return json.Marshal(dimension(d))
+	}
+
+	return json.Marshal(struct {
+		dimension
+		MapFromCodes [][]string `json:"mapFromCodes"`
+	}{dimension: dimension(d), MapFromCodes: d.BranchCodes})
+}
+
+// LabelAt returns the label of the code at index i, or the code if it has
+// none
+func (d *Dimension) LabelAt(i int) string {
+	if i < len(d.Labels) {
+		return d.Labels[i]
+	}
+	return d.Codes[i]
 }
 
 func (c *Codebook) GetDimension(name string) *Dimension {
@@ -38,4 +108,57 @@ func (c *Codebook) GetDimension(name string) *Dimension {
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+
+// Table is the FTB response to a query: a count for every combination of the
+// categories of the queried dimensions, with the last dimension varying fastest.
+type Table struct {
+	Dataset    string           `json:"dataset"`
+	Dimensions []TableDimension `json:"dimensions"`
+	Counts     []int            `json:"counts"`
+
+	// Status is aligned with Counts and holds a marker for each cell whose
+	// count has been withheld, which is then given as zero
+	Status []string `json:"status,omitempty"`
+
+	// Percentages is aligned with Counts, giving the percentage each count is
+	// of the total named by PercentageOf, or null where it cannot be given
+	Percentages  []*float64 `json:"percentages,omitempty"`
+	PercentageOf string     `json:"percentage_of,omitempty"`
+
+	// DisclosureControl records what was done to the counts before they left
+	// the proxy
+	DisclosureControl *DisclosureControl `json:"disclosure_control,omitempty"`
+}
+
+// DisclosureControl records the disclosure control rules applied to a table
+// and how many cells they suppressed.
+type DisclosureControl struct {
+	Rules                string `json:"rules"`
+	Threshold            int    `json:"threshold,omitempty"`
+	Marker               string `json:"marker,omitempty"`
+	RoundingBase         int    `json:"rounding_base,omitempty"`
+	SecondarySuppression bool   `json:"secondary_suppression"`
+	Suppressed           int    `json:"suppressed"`
+	SecondarySuppressed  int    `json:"secondary_suppressed"`
+}
+
+type TableDimension struct {
+	Name   string   `json:"name"`
+	Label  string   `json:"label"`
+	Codes  []string `json:"codes"`
+	Labels []string `json:"labels"`
+
+	// Levels is set when the dimension has been aggregated, giving the runs
+	// of Codes taken from each dimension of the codebook
+	Levels []TableLevel `json:"levels,omitempty"`
+}
+
+// LabelAt returns the label of the code at index i, or the code if it has
+// none
+func (d *TableDimension) LabelAt(i int) string {
+	if i < len(d.Labels) {
+		return d.Labels[i]
+	}
+	return d.Codes[i]
+}
@@ -0,0 +1,281 @@
+package cantabular
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Checks made by Validate
+const (
+	CheckLengths   = "lengths"
+	CheckCodes     = "codes"
+	CheckLabels    = "labels"
+	CheckDuplicate = "duplicate-code"
+	CheckMapFrom   = "map-from"
+	CheckCoverage  = "coverage"
+	CheckOrphan    = "orphan"
+	CheckLayout    = "layout"
+)
+
+// Severities of a validation issue. Errors make the codebook unsafe to build
+// hierarchies from, warnings give trees that are incomplete but well formed.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// ValidationReport lists everything wrong with the layout of a codebook
+type ValidationReport struct {
+	Dataset    string             `json:"dataset"`
+	Digest     string             `json:"digest,omitempty"`
+	Valid      bool               `json:"valid"`
+	Dimensions int                `json:"dimensions"`
+	Errors     int                `json:"errors"`
+	Warnings   int                `json:"warnings"`
+	Issues     []*ValidationIssue `json:"issues"`
+}
+
+// ValidationIssue is one problem found in a dimension. Index is the position
+// in the codes or mapFromCodes the problem was found at, where there is one.
+type ValidationIssue struct {
+	Check     string `json:"check"`
+	Severity  string `json:"severity"`
+	Dimension string `json:"dimension"`
+	Branch    string `json:"branch,omitempty"`
+	Code      string `json:"code,omitempty"`
+	Index     *int   `json:"index,omitempty"`
+	Message   string `json:"message"`
+}
+
+// Validate checks the codebook holds what the hierarchy code assumes: codes
+// and labels of the same length without duplicates, and mapFromCodes aligned
+// with the codes of each child dimension, holding every parent code exactly
+// once at the start of a contiguous run of its children. MapFrom must not
+// loop back to a dimension, however many steps it takes.
+func (c *Codebook) Validate() *ValidationReport {
+	report := &ValidationReport{
+		Dataset:    c.Dataset.Name,
+		Digest:     c.Dataset.Digest,
+		Dimensions: len(c.CodeBook),
+		Issues:     make([]*ValidationIssue, 0),
+	}
+
+	for i := range c.CodeBook {
+		d := &c.CodeBook[i]
+		report.add(validateCodes(d)...)
+
+		if len(d.BranchCodes) > 0 && len(d.BranchCodes) != len(d.MapFrom) {
+			report.add(&ValidationIssue{
+				Check:     CheckLengths,
+				Severity:  SeverityError,
+				Dimension: d.Name,
+				Message:   fmt.Sprintf("%d lists of mapFromCodes for %d mapFrom dimensions", len(d.BranchCodes), len(d.MapFrom)),
+			})
+		}
+
+		for _, b := range d.Branches() {
+			report.add(c.validateBranch(b)...)
+		}
+	}
+
+	report.add(c.validateCycles()...)
+
+	report.Valid = report.Errors == 0
+	return report
+}
+
+func (r *ValidationReport) add(issues ...*ValidationIssue) {
+	for _, issue := range issues {
+		if issue.Severity == SeverityError {
+			r.Errors++
+		} else {
+			r.Warnings++
+		}
+		r.Issues = append(r.Issues, issue)
+	}
+}
+
+func validateCodes(d *Dimension) []*ValidationIssue {
+	issues := make([]*ValidationIssue, 0)
+
+	if len(d.Labels) != len(d.Codes) {
+		issues = append(issues, &ValidationIssue{
+			Check:     CheckLengths,
+			Severity:  SeverityError,
+			Dimension: d.Name,
+			Message:   fmt.Sprintf("%d labels for %d codes", len(d.Labels), len(d.Codes)),
+		})
+	}
+
+	seen := make(map[string]int)
+	for i, code := range d.Codes {
+		if code == "" {
+			issues = append(issues, &ValidationIssue{
+				Check:     CheckCodes,
+				Severity:  SeverityError,
+				Dimension: d.Name,
+				Index:     intPtr(i),
+				Message:   "blank code",
+			})
+			continue
+		}
+
+		if first, ok := seen[code]; ok {
+			issues = append(issues, &ValidationIssue{
+				Check:     CheckDuplicate,
+				Severity:  SeverityError,
+				Dimension: d.Name,
+				Code:      code,
+				Index:     intPtr(i),
+				Message:   fmt.Sprintf("code %s appears at %d and %d", code, first, i),
+			})
+			continue
+		}
+		seen[code] = i
+
+		if i < len(d.Labels) && d.Labels[i] == "" {
+			issues = append(issues, &ValidationIssue{
+				Check:     CheckLabels,
+				Severity:  SeverityWarning,
+				Dimension: d.Name,
+				Code:      code,
+				Index:     intPtr(i),
+				Message:   fmt.Sprintf("code %s has a blank label", code),
+			})
+		}
+	}
+
+	return issues
+}
+
+// validateBranch checks the mapFromCodes of a branch against the codes of
+// the parent and child dimensions.
+func (c *Codebook) validateBranch(b *Branch) []*ValidationIssue {
+	issues := make([]*ValidationIssue, 0)
+	issue := func(check, severity, code string, index *int, format string, args ...interface{}) {
+		issues = append(issues, &ValidationIssue{
+			Check:     check,
+			Severity:  severity,
+			Dimension: b.Parent.Name,
+			Branch:    b.Child,
+			Code:      code,
+			Index:     index,
+			Message:   fmt.Sprintf(format, args...),
+		})
+	}
+
+	child := c.GetDimension(b.Child)
+	if child == nil {
+		issue(CheckMapFrom, SeverityError, "", nil, "maps from %s which is not in the codebook", b.Child)
+		return issues
+	}
+
+	if child.Name == b.Parent.Name {
+		issue(CheckMapFrom, SeverityError, "", nil, "maps from itself")
+		return issues
+	}
+
+	if len(b.MapFromCodes) != len(child.Codes) {
+		issue(CheckLengths, SeverityError, "", nil, "%d mapFromCodes for %d codes of %s", len(b.MapFromCodes), len(child.Codes), child.Name)
+	}
+
+	parentCodes := make(map[string]bool, len(b.Parent.Codes))
+	for _, code := range b.Parent.Codes {
+		parentCodes[code] = true
+	}
+
+	mapped := make(map[string]int)
+	for i, code := range b.MapFromCodes {
+		if code == "" {
+			if i == 0 {
+				issue(CheckOrphan, SeverityError, childCode(child, i), intPtr(i), "code of %s precedes any code of %s", child.Name, b.Parent.Name)
+			}
+			continue
+		}
+
+		if !parentCodes[code] {
+			issue(CheckOrphan, SeverityError, code, intPtr(i), "mapFromCodes holds %s which is not a code of %s", code, b.Parent.Name)
+			continue
+		}
+
+		if first, ok := mapped[code]; ok {
+			issue(CheckLayout, SeverityError, code, intPtr(i), "code %s starts a run of children at %d and %d, only the first is used", code, first, i)
+			continue
+		}
+		mapped[code] = i
+	}
+
+	for i, code := range b.Parent.Codes {
+		if _, ok := mapped[code]; !ok && code != "" {
+			issue(CheckCoverage, SeverityWarning, code, intPtr(i), "code %s has no children in %s", code, child.Name)
+		}
+	}
+
+	return issues
+}
+
+// validateCycles finds loops in MapFrom longer than a dimension mapping from
+// itself, which validateBranch reports, by a depth first search from each
+// dimension in codebook order. Each loop is reported once, against the
+// dimension the search reached it from.
+func (c *Codebook) validateCycles() []*ValidationIssue {
+	const (
+		unvisited = iota
+		onPath
+		done
+	)
+
+	issues := make([]*ValidationIssue, 0)
+	state := make(map[string]int, len(c.CodeBook))
+	path := make([]string, 0)
+
+	var visit func(name string)
+	visit = func(name string) {
+		state[name] = onPath
+		path = append(path, name)
+
+		if d := c.GetDimension(name); d != nil {
+			for _, child := range d.MapFrom {
+				if child == name || c.GetDimension(child) == nil {
+					continue
+				}
+
+				switch state[child] {
+				case unvisited:
+					visit(child)
+				case onPath:
+					cycle := append([]string{}, path[indexOf(path, child):]...)
+					issues = append(issues, &ValidationIssue{
+						Check:     CheckMapFrom,
+						Severity:  SeverityError,
+						Dimension: name,
+						Branch:    child,
+						Message:   fmt.Sprintf("mapFrom loops back through %s", strings.Join(append(cycle, child), " -> ")),
+					})
+				}
+			}
+		}
+
+		path = path[:len(path)-1]
+		state[name] = done
+	}
+
+	for _, d := range c.CodeBook {
+		if state[d.Name] == unvisited {
+			visit(d.Name)
+		}
+	}
+
+	return issues
+}
+
+func childCode(d *Dimension, i int) string {
+	if i < len(d.Codes) {
+		return d.Codes[i]
+	}
+	return ""
+}
+
+func intPtr(i int) *int {
+	return &i
+}
@@ -0,0 +1,65 @@
+package cantabular_test
+
+import (
+	"testing"
+
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/cantabular"
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/cantabular/fake"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestValidateCycles(t *testing.T) {
+	Convey("Given the example codebook", t, func() {
+		codebook := fake.Example().Codebook
+
+		Convey("When it is validated as it is", func() {
+			report := codebook.Validate()
+
+			Convey("Then no loop is reported", func() {
+				So(report.Valid, ShouldBeTrue)
+			})
+		})
+
+		Convey("When local authorities are made to map from regions, which map from them", func() {
+			la := &codebook.CodeBook[2]
+			la.MapFrom = []string{"region"}
+			la.MapFromCodes = []string{"E06000001", "E06000008"}
+
+			report := codebook.Validate()
+
+			Convey("Then the loop is reported once with the dimensions in it", func() {
+				So(report.Valid, ShouldBeFalse)
+
+				var loops []*cantabular.ValidationIssue
+				for _, issue := range report.Issues {
+					if issue.Check == cantabular.CheckMapFrom {
+						loops = append(loops, issue)
+					}
+				}
+
+				So(loops, ShouldHaveLength, 1)
+				So(loops[0].Severity, ShouldEqual, cantabular.SeverityError)
+				So(loops[0].Dimension, ShouldEqual, "la")
+				So(loops[0].Branch, ShouldEqual, "region")
+				So(loops[0].Message, ShouldEqual, "mapFrom loops back through region -> la -> region")
+			})
+		})
+
+		Convey("When a dimension maps from itself", func() {
+			codebook.CodeBook[1].MapFrom = []string{"region"}
+			codebook.CodeBook[1].MapFromCodes = []string{"E12000001", "E12000002"}
+
+			report := codebook.Validate()
+
+			Convey("Then it is reported once, as mapping from itself", func() {
+				var messages []string
+				for _, issue := range report.Issues {
+					if issue.Check == cantabular.CheckMapFrom {
+						messages = append(messages, issue.Message)
+					}
+				}
+				So(messages, ShouldResemble, []string{"maps from itself"})
+			})
+		})
+	})
+}
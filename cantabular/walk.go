@@ -0,0 +1,175 @@
+package cantabular
+
+import "context"
+
+// WalkEvent is passed to a Walker for each code of the hierarchy, once when
+// the walk enters it and again, with Leave set, once all of its descendants
+// have been visited. ChildType and Children give the codes the walk will
+// visit below this one, empty at the leaves and at the maximum depth.
+type WalkEvent struct {
+	Type       string
+	Name       string
+	Code       string
+	ParentType string
+	ParentCode string
+	ChildType  string
+	Children   []string
+	Depth      int
+	Leave      bool
+}
+
+// Walker handles the events of a hierarchy walk. Returning an error stops the walk.
+type Walker func(e *WalkEvent) error
+
+// walkFrame is the position in the codes of one level of the hierarchy
+type walkFrame struct {
+	dim    *Dimension
+	branch *Branch
+	next   int
+	end    int
+	depth  int
+	parent *WalkEvent
+
+	// indices are the ranges of the children of each code through branch
+	indices map[string]*Index
+}
+
+// branchIndices caches the Indices of each branch walked, keyed by the names
+// of its parent and child dimensions, so that they are built once per walk
+// rather than once per code.
+type branchIndices map[[2]string]map[string]*Index
+
+func (bi branchIndices) get(b *Branch) map[string]*Index {
+	if b == nil {
+		return nil
+	}
+
+	key := [2]string{b.Parent.Name, b.Child}
+	indices, ok := bi[key]
+	if !ok {
+		indices = b.Indices()
+		bi[key] = indices
+	}
+	return indices
+}
+
+// WalkHierarchy visits every code below the root dimension depth first,
+// following the given branch at the root and the first branch of each level
+// below it. The walk keeps an explicit stack rather than building the tree,
+// so memory is bounded by the depth of the hierarchy. A maxDepth of zero or
+// less walks to the leaves. A dimension that maps, directly or not, from one
+// of its own ancestors in the walk is not entered again, so a cyclic mapping
+// cannot make the walk endless. The walk stops if the context is cancelled.
+func WalkHierarchy(ctx context.Context, rootDim *Dimension, branch *Branch, cb *Codebook, maxDepth int, fn Walker) error {
+	indices := make(branchIndices)
+	stack := []*walkFrame{{dim: rootDim, branch: branch, end: len(rootDim.Codes) - 1, depth: 1, indices: indices.get(branch)}}
+
+	for len(stack) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		top := stack[len(stack)-1]
+		if top.next > top.end || top.next >= len(top.dim.Codes) {
+			stack = stack[:len(stack)-1]
+			if top.parent != nil {
+				leave := *top.parent
+				leave.Leave = true
+				if err := fn(&leave); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		i := top.next
+		top.next++
+
+		e := &WalkEvent{
+			Type:  top.dim.Name,
+			Name:  top.dim.LabelAt(i),
+			Code:  top.dim.Codes[i],
+			Depth: top.depth,
+		}
+		if top.parent != nil {
+			e.ParentType = top.parent.Type
+			e.ParentCode = top.parent.Code
+		}
+
+		child := childFrame(stack, e, cb, maxDepth, indices)
+		if child != nil {
+			e.ChildType = child.dim.Name
+			e.Children = child.codes()
+		}
+
+		if err := fn(e); err != nil {
+			return err
+		}
+
+		if child == nil {
+			leave := *e
+			leave.Leave = true
+			if err := fn(&leave); err != nil {
+				return err
+			}
+			continue
+		}
+
+		stack = append(stack, child)
+	}
+
+	return nil
+}
+
+// childFrame returns the frame for the children of the code just entered, or
+// nil if it has none, the maximum depth has been reached or the child
+// dimension is already on the stack.
+func childFrame(stack []*walkFrame, e *WalkEvent, cb *Codebook, maxDepth int, indices branchIndices) *walkFrame {
+	top := stack[len(stack)-1]
+	if top.branch == nil || (maxDepth > 0 && top.depth >= maxDepth) {
+		return nil
+	}
+
+	index, found := top.indices[e.Code]
+	if !found {
+		return nil
+	}
+
+	childDim := cb.GetDimension(top.branch.Child)
+	if childDim == nil {
+		return nil
+	}
+
+	for _, f := range stack {
+		if f.dim.Name == childDim.Name {
+			return nil
+		}
+	}
+
+	childBranch, err := childDim.Branch("")
+	if err != nil {
+		childBranch = nil
+	}
+
+	return &walkFrame{
+		dim:     childDim,
+		branch:  childBranch,
+		next:    index.Start,
+		end:     index.End,
+		depth:   top.depth + 1,
+		parent:  e,
+		indices: indices.get(childBranch),
+	}
+}
+
+// codes returns the codes of the frame that are yet to be visited
+func (f *walkFrame) codes() []string {
+	end := f.end + 1
+	if end > len(f.dim.Codes) {
+		end = len(f.dim.Codes)
+	}
+	if f.next >= end {
+		return nil
+	}
+	return f.dim.Codes[f.next:end]
+}
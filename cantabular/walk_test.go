@@ -0,0 +1,56 @@
+package cantabular_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/cantabular"
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/cantabular/fake"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWalkHierarchyCancellation(t *testing.T) {
+	Convey("Given the country hierarchy of the example codebook", t, func() {
+		codebook := fake.Example().Codebook
+		root := codebook.GetDimension("country")
+		branch, err := root.Branch("")
+		So(err, ShouldBeNil)
+
+		Convey("When it is walked with a context that is already cancelled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			visited := 0
+			err := cantabular.WalkHierarchy(ctx, root, branch, codebook, 0, func(e *cantabular.WalkEvent) error {
+				visited++
+				return nil
+			})
+
+			Convey("Then nothing is visited", func() {
+				So(err, ShouldEqual, context.Canceled)
+				So(visited, ShouldEqual, 0)
+			})
+		})
+
+		Convey("When the context is cancelled partway through the walk", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			var visited []string
+			err := cantabular.WalkHierarchy(ctx, root, branch, codebook, 0, func(e *cantabular.WalkEvent) error {
+				if !e.Leave {
+					visited = append(visited, e.Code)
+				}
+				if len(visited) == 3 {
+					cancel()
+				}
+				return nil
+			})
+
+			Convey("Then the walk stops after the code it was visiting", func() {
+				So(err, ShouldEqual, context.Canceled)
+				So(visited, ShouldResemble, []string{"E92000001", "E12000001", "E06000001"})
+			})
+		})
+	})
+}
@@ -0,0 +1,381 @@
+// Package client calls the census alpha API proxy, returning the same models
+// the proxy serves.
+//
+// It covers the /v6 routes. The /datasets and /code-lists routes are shaped
+// like the CMD dataset and code-list APIs, so rather than being repeated here
+// they are called with the dataset and codelist clients of dp-api-clients-go
+// pointed at the proxy, giving the token as the service auth token:
+//
+//	datasets, err := dataset.NewAPIClient(host).GetDatasets(ctx, "", token, "")
+//	codes, err := codelist.New(host).GetCodes(ctx, "", token, "la", "2021")
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/api"
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/cantabular"
+	"github.com/ONSdigital/dp-code-list-api/models"
+	filterModel "github.com/ONSdigital/dp-filter-api/models"
+	hierarchy "github.com/ONSdigital/dp-hierarchy-api/models"
+	dphttp "github.com/ONSdigital/dp-net/http"
+)
+
+const (
+	FormatJSON     = "json"
+	FormatCSV      = "csv"
+	FormatJSONStat = "jsonstat"
+
+	// AllLevels builds a hierarchy down to its leaves
+	AllLevels = -1
+)
+
+// Client calls the proxy at Host, sending Token with every request. Requests
+// that fail or get a 5xx response are retried with exponential backoff by
+// HttpCli.
+type Client struct {
+	Host    string
+	Token   string
+	HttpCli dphttp.Clienter
+}
+
+// Error is a response from the proxy other than 200 OK
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e Error) Error() string {
+	return fmt.Sprintf("proxy returned %d: %s", e.StatusCode, e.Message)
+}
+
+// New returns a client of the proxy at host, retrying as dp-net does by default
+func New(host, token string) *Client {
+	return &Client{Host: strings.TrimSuffix(host, "/"), Token: token, HttpCli: dphttp.NewClient()}
+}
+
+// Page is the part of a list to return. A Limit of 0 is the rest of the list.
+type Page struct {
+	Offset int
+	Limit  int
+}
+
+// HierarchyOptions picks the part of a hierarchy to build
+type HierarchyOptions struct {
+	// Branch is the dimension the hierarchy runs through, for a dimension
+	// that maps from more than one
+	Branch string
+
+	// Depth is the number of levels to build: 0 for the proxy default of 2,
+	// or AllLevels
+	Depth int
+}
+
+// QueryOptions are the dimensions to count the combinations of and what to
+// do with the counts on the proxy
+type QueryOptions struct {
+	Variables []string
+	Aggregate []string
+	Margins   []string
+	Percent   string
+}
+
+// Datasets lists the datasets of the flexible table builder
+func (c *Client) Datasets(ctx context.Context) (*cantabular.Datasets, error) {
+	var datasets cantabular.Datasets
+	if err := c.get(ctx, "/v6/datasets", nil, &datasets); err != nil {
+		return nil, err
+	}
+	return &datasets, nil
+}
+
+// Codebook returns the codebook of a dataset
+func (c *Client) Codebook(ctx context.Context, dataset string) (*cantabular.Codebook, error) {
+	var codebook cantabular.Codebook
+	if err := c.get(ctx, urlPath("v6", "codebook", dataset), nil, &codebook); err != nil {
+		return nil, err
+	}
+	return &codebook, nil
+}
+
+// Dimensions lists the names of the dimensions of a dataset
+func (c *Client) Dimensions(ctx context.Context, dataset string) (*api.GetDimensionsResponse, error) {
+	var dimensions api.GetDimensionsResponse
+	if err := c.get(ctx, urlPath("v6", "datasets", dataset, "dimensions"), nil, &dimensions); err != nil {
+		return nil, err
+	}
+	return &dimensions, nil
+}
+
+// Dimension returns a dimension of a dataset from its codebook
+func (c *Client) Dimension(ctx context.Context, dataset, name string) (*cantabular.Dimension, error) {
+	var dimension cantabular.Dimension
+	if err := c.get(ctx, urlPath("v6", "datasets", dataset, "dimensions", name), nil, &dimension); err != nil {
+		return nil, err
+	}
+	return &dimension, nil
+}
+
+// DimensionByIndex returns the code at a position in a dimension
+func (c *Client) DimensionByIndex(ctx context.Context, dataset, name string, index int) (*api.DimensionResponse, error) {
+	var dimension api.DimensionResponse
+	if err := c.get(ctx, urlPath("v6", "datasets", dataset, "dimensions", name, "index", strconv.Itoa(index)), nil, &dimension); err != nil {
+		return nil, err
+	}
+	return &dimension, nil
+}
+
+// Codes returns a page of the codes of a dimension
+func (c *Client) Codes(ctx context.Context, dataset, name string, page Page) (*models.CodeResults, error) {
+	params := url.Values{}
+	if page.Offset > 0 {
+		params.Set("offset", strconv.Itoa(page.Offset))
+	}
+	if page.Limit > 0 {
+		params.Set("limit", strconv.Itoa(page.Limit))
+	}
+
+	var codes models.CodeResults
+	if err := c.get(ctx, urlPath("v6", "datasets", dataset, "dimensions", name, "codes"), params, &codes); err != nil {
+		return nil, err
+	}
+	return &codes, nil
+}
+
+// EachCode calls fn with each code of a dimension, fetching them pageSize at
+// a time, until fn returns an error
+func (c *Client) EachCode(ctx context.Context, dataset, name string, pageSize int, fn func(models.Code) error) error {
+	page := Page{Limit: pageSize}
+	for {
+		codes, err := c.Codes(ctx, dataset, name, page)
+		if err != nil {
+			return err
+		}
+
+		for _, code := range codes.Items {
+			if err := fn(code); err != nil {
+				return err
+			}
+		}
+
+		page.Offset += codes.Count
+		if codes.Count == 0 || page.Offset >= codes.TotalCount {
+			return nil
+		}
+	}
+}
+
+// FilterOptions lists the codes of a dimension as filter options
+func (c *Client) FilterOptions(ctx context.Context, dataset, name string) ([]*filterModel.PublicDimensionOption, error) {
+	var options []*filterModel.PublicDimensionOption
+	if err := c.get(ctx, urlPath("v6", "datasets", dataset, "filter", "dimensions", name, "options"), nil, &options); err != nil {
+		return nil, err
+	}
+	return options, nil
+}
+
+// Validate checks the codebook of a dataset is consistent
+func (c *Client) Validate(ctx context.Context, dataset string) (*cantabular.ValidationReport, error) {
+	var report cantabular.ValidationReport
+	if err := c.get(ctx, urlPath("v6", "datasets", dataset, "validate"), nil, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// CodebookDiff compares the codebooks of a dataset with the digests from and
+// to. Either may be empty, to compare the current codebook with the one
+// before it.
+func (c *Client) CodebookDiff(ctx context.Context, dataset, from, to string) (*cantabular.CodebookDiff, error) {
+	params := url.Values{}
+	if from != "" {
+		params.Set("from", from)
+	}
+	if to != "" {
+		params.Set("to", to)
+	}
+
+	var diff cantabular.CodebookDiff
+	if err := c.get(ctx, urlPath("v6", "datasets", dataset, "codebook", "diff"), params, &diff); err != nil {
+		return nil, err
+	}
+	return &diff, nil
+}
+
+// Hierarchy lists the codes of a dimension with the number of children each
+// has in the dimension below it in branch, which may be empty
+func (c *Client) Hierarchy(ctx context.Context, dataset, name, branch string) (*hierarchy.Response, error) {
+	var h hierarchy.Response
+	if err := c.get(ctx, urlPath("v6", "datasets", dataset, "hierarchies", name), branchParams(branch), &h); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// HierarchyCode returns a code of a dimension with its children in the
+// dimension below it in branch, which may be empty
+func (c *Client) HierarchyCode(ctx context.Context, dataset, name, code, branch string) (*hierarchy.Response, error) {
+	var h hierarchy.Response
+	if err := c.get(ctx, urlPath("v6", "datasets", dataset, "hierarchies", name, "code", code), branchParams(branch), &h); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// HierarchyParents returns the parent and ancestors of a code
+func (c *Client) HierarchyParents(ctx context.Context, dataset, name, code string) (*api.ParentsResponse, error) {
+	var parents api.ParentsResponse
+	if err := c.get(ctx, urlPath("v6", "datasets", dataset, "hierarchies", name, "code", code, "parents"), nil, &parents); err != nil {
+		return nil, err
+	}
+	return &parents, nil
+}
+
+// FullHierarchy builds the hierarchy below a dimension
+func (c *Client) FullHierarchy(ctx context.Context, dataset, name string, opts HierarchyOptions) (*cantabular.Hierarchy, error) {
+	params := branchParams(opts.Branch)
+	switch {
+	case opts.Depth == AllLevels:
+		params.Set("depth", "all")
+	case opts.Depth > 0:
+		params.Set("depth", strconv.Itoa(opts.Depth))
+	}
+
+	var h cantabular.Hierarchy
+	if err := c.get(ctx, urlPath("v6", "datasets", dataset, "hierarchies", name, "full"), params, &h); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// Query counts the combinations of the categories of dimensions of a dataset
+func (c *Client) Query(ctx context.Context, dataset string, opts QueryOptions) (*cantabular.Table, error) {
+	var table cantabular.Table
+	if err := c.get(ctx, urlPath("v6", "query", dataset), opts.params(), &table); err != nil {
+		return nil, err
+	}
+	return &table, nil
+}
+
+// Export returns the body of a query in one of the formats the proxy serves
+// tables in: FormatJSON, FormatCSV or FormatJSONStat
+func (c *Client) Export(ctx context.Context, dataset string, opts QueryOptions, format string) ([]byte, error) {
+	params := opts.params()
+	params.Set("format", format)
+
+	resp, err := c.do(ctx, urlPath("v6", "query", dataset), params)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+// Compare runs the same query on two datasets
+func (c *Client) Compare(ctx context.Context, left, right string, variables []string) (*cantabular.Comparison, error) {
+	params := url.Values{"left": {left}, "right": {right}, "v": variables}
+
+	var comparison cantabular.Comparison
+	if err := c.get(ctx, "/v6/compare", params, &comparison); err != nil {
+		return nil, err
+	}
+	return &comparison, nil
+}
+
+func (opts QueryOptions) params() url.Values {
+	params := url.Values{"v": opts.Variables}
+	if len(opts.Aggregate) > 0 {
+		params.Set("aggregate", strings.Join(opts.Aggregate, ","))
+	}
+	if len(opts.Margins) > 0 {
+		params.Set("margins", strings.Join(opts.Margins, ","))
+	}
+	if opts.Percent != "" {
+		params.Set("percent", opts.Percent)
+	}
+	return params
+}
+
+func branchParams(branch string) url.Values {
+	params := url.Values{}
+	if branch != "" {
+		params.Set("branch", branch)
+	}
+	return params
+}
+
+// urlPath joins escaped segments into an absolute path
+func urlPath(segments ...string) string {
+	escaped := make([]string, len(segments))
+	for i, s := range segments {
+		escaped[i] = url.PathEscape(s)
+	}
+	return "/" + strings.Join(escaped, "/")
+}
+
+func (c *Client) get(ctx context.Context, path string, params url.Values, entity interface{}) error {
+	resp, err := c.do(ctx, path, params)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return json.NewDecoder(resp.Body).Decode(entity)
+}
+
+// do sends an authorised GET request, returning the response if it is 200 OK
+// and an Error otherwise
+func (c *Client) do(ctx context.Context, path string, params url.Values) (*http.Response, error) {
+	u := c.Host + path
+	if len(params) > 0 {
+		u += "?" + params.Encode()
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Token != "" {
+		token := c.Token
+		if !strings.HasPrefix(token, "Bearer ") {
+			token = "Bearer " + token
+		}
+		req.Header.Set("Authorization", token)
+	}
+
+	resp, err := c.HttpCli.Do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, handleErrorResponse(resp)
+	}
+	return resp, nil
+}
+
+// handleErrorResponse reads the message the proxy writes with an error,
+// falling back to the body for errors from elsewhere
+func handleErrorResponse(resp *http.Response) error {
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	var entity api.SimpleEntity
+	if err := json.Unmarshal(b, &entity); err != nil || entity.Message == "" {
+		entity.Message = strings.TrimSpace(string(b))
+	}
+
+	return Error{StatusCode: resp.StatusCode, Message: entity.Message}
+}
@@ -0,0 +1,201 @@
+package client_test
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ONSdigital/dp-api-clients-go/codelist"
+	"github.com/ONSdigital/dp-api-clients-go/dataset"
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/api/apitest"
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/cantabular/fake"
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/client"
+	"github.com/ONSdigital/dp-code-list-api/models"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const testToken = apitest.Token
+
+func TestMain(m *testing.M) {
+	apitest.Main(m)
+}
+
+type proxy struct {
+	*apitest.Proxy
+	server *httptest.Server
+}
+
+// newProxy serves the api router the way main does, in front of a fake FTB
+// serving the example dataset
+func newProxy() *proxy {
+	p, err := apitest.New(0)
+	So(err, ShouldBeNil)
+
+	return &proxy{Proxy: p, server: httptest.NewServer(p.Handler())}
+}
+
+func (p *proxy) close() {
+	p.server.Close()
+	p.Proxy.Close()
+}
+
+func TestClient(t *testing.T) {
+	Convey("Given a client of a proxy in front of a flexible table builder", t, func() {
+		p := newProxy()
+		defer p.close()
+
+		ctx := context.Background()
+		c := client.New(p.server.URL, testToken)
+
+		Convey("When the datasets are listed", func() {
+			datasets, err := c.Datasets(ctx)
+
+			Convey("Then the FTB datasets are returned", func() {
+				So(err, ShouldBeNil)
+				So(datasets.Items, ShouldHaveLength, 1)
+				So(datasets.Items[0].Name, ShouldEqual, "Example")
+			})
+		})
+
+		Convey("When the dimensions of a dataset are requested", func() {
+			dimensions, err := c.Dimensions(ctx, "Example")
+
+			Convey("Then their names are returned", func() {
+				So(err, ShouldBeNil)
+				So(dimensions.Dimensions, ShouldContain, "Local Authority: la")
+			})
+		})
+
+		Convey("When a dimension is requested", func() {
+			dimension, err := c.Dimension(ctx, "Example", "region")
+
+			Convey("Then it is returned from the codebook", func() {
+				So(err, ShouldBeNil)
+				So(dimension.MapFrom, ShouldResemble, []string{"la"})
+			})
+		})
+
+		Convey("When a page of codes is requested", func() {
+			codes, err := c.Codes(ctx, "Example", "la", client.Page{Offset: 1, Limit: 2})
+
+			Convey("Then only the page is returned", func() {
+				So(err, ShouldBeNil)
+				So(codes.Items, ShouldHaveLength, 2)
+				So(codes.Items[0].ID, ShouldEqual, "E06000002")
+				So(codes.TotalCount, ShouldEqual, 4)
+			})
+		})
+
+		Convey("When every code is walked a page at a time", func() {
+			var ids []string
+			err := c.EachCode(ctx, "Example", "la", 3, func(code models.Code) error {
+				ids = append(ids, code.ID)
+				return nil
+			})
+
+			Convey("Then each code is seen once", func() {
+				So(err, ShouldBeNil)
+				So(ids, ShouldHaveLength, 4)
+				So(ids[3], ShouldEqual, "E06000009")
+			})
+		})
+
+		Convey("When the children of a code are requested", func() {
+			h, err := c.HierarchyCode(ctx, "Example", "country", "E92000001", "la")
+
+			Convey("Then they come from the branch asked for", func() {
+				So(err, ShouldBeNil)
+				So(h.NoOfChildren, ShouldEqual, 4)
+			})
+		})
+
+		Convey("When the parents of a code are requested", func() {
+			parents, err := c.HierarchyParents(ctx, "Example", "la", "E06000008")
+
+			Convey("Then its ancestors are returned", func() {
+				So(err, ShouldBeNil)
+				So(parents.Parent.Code, ShouldEqual, "E12000002")
+				So(parents.Ancestors, ShouldHaveLength, 2)
+			})
+		})
+
+		Convey("When a full hierarchy is requested", func() {
+			h, err := c.FullHierarchy(ctx, "Example", "country", client.HierarchyOptions{Branch: "region", Depth: client.AllLevels})
+
+			Convey("Then it is built down to the leaves", func() {
+				So(err, ShouldBeNil)
+				So(h.Branch, ShouldEqual, "region")
+				So(h.Children[0].Children[0].Children, ShouldNotBeEmpty)
+			})
+		})
+
+		Convey("When a dataset is queried", func() {
+			table, err := c.Query(ctx, "Example", client.QueryOptions{Variables: []string{"region", "sex"}, Margins: []string{"total"}})
+
+			Convey("Then the table is returned", func() {
+				So(err, ShouldBeNil)
+				So(table.Dimensions, ShouldHaveLength, 2)
+				So(table.Dimensions[0].Codes, ShouldContain, "total")
+			})
+		})
+
+		Convey("When a query is exported as CSV", func() {
+			b, err := c.Export(ctx, "Example", client.QueryOptions{Variables: []string{"region"}}, client.FormatCSV)
+
+			Convey("Then the CSV is returned", func() {
+				So(err, ShouldBeNil)
+
+				records, err := csv.NewReader(strings.NewReader(string(b))).ReadAll()
+				So(err, ShouldBeNil)
+				So(len(records), ShouldBeGreaterThan, 1)
+			})
+		})
+
+		Convey("When the CMD shaped routes are called with the dp-api-clients-go clients", func() {
+			datasets, datasetsErr := dataset.NewAPIClientWithMaxRetries(p.server.URL, 0).GetDatasets(ctx, "", testToken, "")
+			codes, codesErr := codelist.New(p.server.URL).GetCodes(ctx, "", testToken, "la", "2021")
+
+			Convey("Then they are answered as the CMD APIs would", func() {
+				So(datasetsErr, ShouldBeNil)
+				So(datasets.Items, ShouldHaveLength, 1)
+				So(datasets.Items[0].ID, ShouldEqual, "Example")
+
+				So(codesErr, ShouldBeNil)
+				So(codes.Items, ShouldHaveLength, 4)
+			})
+		})
+
+		Convey("When an unknown dataset is requested", func() {
+			_, err := c.Dimensions(ctx, "Unknown")
+
+			Convey("Then the error carries the status", func() {
+				var clientErr client.Error
+				So(errors.As(err, &clientErr), ShouldBeTrue)
+				So(clientErr.StatusCode, ShouldEqual, http.StatusNotFound)
+			})
+		})
+
+		Convey("When the token is wrong", func() {
+			_, err := client.New(p.server.URL, "wrong").Dimensions(ctx, "Example")
+
+			Convey("Then the proxy's message is returned", func() {
+				So(err, ShouldResemble, client.Error{StatusCode: http.StatusUnauthorized, Message: "unauthorized incorrect token provided"})
+			})
+		})
+
+		Convey("When the FTB fails once", func() {
+			p.FTB.Inject(fake.Fault{PathPrefix: "/v6/codebook", Status: http.StatusServiceUnavailable, Times: 1})
+			report, err := c.Validate(ctx, "Example")
+
+			Convey("Then the request is retried", func() {
+				So(err, ShouldBeNil)
+				So(report.Valid, ShouldBeTrue)
+				So(p.FTB.Requests("/v6/codebook/Example"), ShouldEqual, 2)
+			})
+		})
+	})
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	hierarchy "github.com/ONSdigital/dp-hierarchy-api/models"
+)
+
+// level is a list of codes shown while browsing a hierarchy
+type level struct {
+	title    string
+	elements []*hierarchy.Element
+}
+
+// browse lists the codes of a dimension and then the children of whichever
+// one is picked, reading choices from stdin until it is closed or q is given
+func (c *cli) browse(args []string) error {
+	flags := flag.NewFlagSet("browse", flag.ContinueOnError)
+	flags.SetOutput(c.stdout)
+	branch := flags.String("branch", "", "dimension the hierarchy runs through, for a dimension that maps from more than one")
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	if flags.NArg() != 2 {
+		return errUsage
+	}
+
+	ctx := context.Background()
+	dataset, dimension := flags.Arg(0), flags.Arg(1)
+
+	root, err := c.client.Hierarchy(ctx, dataset, dimension, *branch)
+	if err != nil {
+		return err
+	}
+
+	levels := []level{{title: root.Label, elements: root.Children}}
+	in := bufio.NewScanner(c.stdin)
+
+	for {
+		current := levels[len(levels)-1]
+		c.printLevel(levels, current)
+
+		if !in.Scan() {
+			return in.Err()
+		}
+
+		choice := strings.TrimSpace(in.Text())
+		switch choice {
+		case "q":
+			return nil
+		case "u":
+			if len(levels) > 1 {
+				levels = levels[:len(levels)-1]
+			}
+			continue
+		case "":
+			continue
+		}
+
+		n, err := strconv.Atoi(choice)
+		if err != nil || n < 1 || n > len(current.elements) {
+			fmt.Fprintf(c.stdout, "%q is not a number from the list\n", choice)
+			continue
+		}
+
+		el := current.elements[n-1]
+		if el.NoOfChildren == 0 {
+			fmt.Fprintf(c.stdout, "%s has no children\n", el.Label)
+			continue
+		}
+
+		link := el.Links["code"]
+		elBranch := ""
+		if len(levels) == 1 {
+			elBranch = *branch
+		}
+
+		h, err := c.client.HierarchyCode(ctx, dataset, linkDimension(link.HRef), link.ID, elBranch)
+		if err != nil {
+			return err
+		}
+
+		levels = append(levels, level{title: fmt.Sprintf("%s: %s", el.Label, h.Label), elements: h.Children})
+	}
+}
+
+func (c *cli) printLevel(levels []level, current level) {
+	titles := make([]string, len(levels))
+	for i, l := range levels {
+		titles[i] = l.title
+	}
+
+	fmt.Fprintf(c.stdout, "\n%s\n", strings.Join(titles, " > "))
+	for i, el := range current.elements {
+		children := ""
+		if el.NoOfChildren > 0 {
+			children = fmt.Sprintf(" [%d]", el.NoOfChildren)
+		}
+
+		// the codes of a level with no level below are labelled by code
+		name := el.Label
+		if code := el.Links["code"].ID; code != el.Label {
+			name = fmt.Sprintf("%s (%s)", el.Label, code)
+		}
+		fmt.Fprintf(c.stdout, "%4d. %s%s\n", i+1, name, children)
+	}
+	fmt.Fprint(c.stdout, "number to open, u to go up, q to quit: ")
+}
+
+// linkDimension reads the dimension of a code from the path of its link,
+// /v6/datasets/{dataset}/hierarchies/{dimension}/code/{code}
+func linkDimension(href string) string {
+	parts := strings.Split(strings.SplitN(href, "?", 2)[0], "/")
+	for i, p := range parts {
+		if p == "hierarchies" && i+1 < len(parts) {
+			return parts[i+1]
+		}
+	}
+	return ""
+}
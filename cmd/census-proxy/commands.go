@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/client"
+)
+
+// datasets lists the datasets of the FTB
+func (c *cli) datasets(args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	datasets, err := c.client.Datasets(context.Background())
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSIZE\tDESCRIPTION")
+	for _, d := range datasets.Items {
+		fmt.Fprintf(w, "%s\t%d\t%s\n", d.Name, d.Size, d.Description)
+	}
+	return w.Flush()
+}
+
+// codebook summarises each dimension of a dataset: its label, number of codes
+// and the finer dimensions it maps from
+func (c *cli) codebook(args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	codebook, err := c.client.Codebook(context.Background(), args[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(c.stdout, "%s (digest %s)\n\n", codebook.Dataset.Name, codebook.Dataset.Digest)
+
+	w := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "DIMENSION\tLABEL\tCODES\tMAPS FROM")
+	for _, dim := range codebook.CodeBook {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", dim.Name, dim.Label, len(dim.Codes), strings.Join(dim.MapFrom, ", "))
+	}
+	return w.Flush()
+}
+
+// search lists the codes of a dataset whose code or label contains the text,
+// ignoring case
+func (c *cli) search(args []string) error {
+	flags := flag.NewFlagSet("search", flag.ContinueOnError)
+	flags.SetOutput(c.stdout)
+	dimension := flags.String("dimension", "", "only search the codes of this dimension")
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	if flags.NArg() != 2 {
+		return errUsage
+	}
+
+	dataset, text := flags.Arg(0), strings.ToLower(flags.Arg(1))
+
+	codebook, err := c.client.Codebook(context.Background(), dataset)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "DIMENSION\tCODE\tLABEL")
+
+	found, searched := 0, false
+	for _, dim := range codebook.CodeBook {
+		if *dimension != "" && dim.Name != *dimension {
+			continue
+		}
+		searched = true
+
+		for i, code := range dim.Codes {
+			label := ""
+			if i < len(dim.Labels) {
+				label = dim.Labels[i]
+			}
+
+			if strings.Contains(strings.ToLower(code), text) || strings.Contains(strings.ToLower(label), text) {
+				fmt.Fprintf(w, "%s\t%s\t%s\n", dim.Name, code, label)
+				found++
+			}
+		}
+	}
+
+	if !searched {
+		return fmt.Errorf("dataset %s has no dimension %s", dataset, *dimension)
+	}
+	if found == 0 {
+		return fmt.Errorf("no codes match %q", flags.Arg(1))
+	}
+	return w.Flush()
+}
+
+// query runs a query, writing the table to a file or stdout
+func (c *cli) query(args []string) error {
+	flags := flag.NewFlagSet("query", flag.ContinueOnError)
+	flags.SetOutput(c.stdout)
+	format := flags.String("format", client.FormatCSV, "format of the table: csv or jsonstat")
+	output := flags.String("o", "", "file to write the table to (default stdout)")
+	aggregate := flags.String("aggregate", "", "comma separated coarser dimensions to roll a queried dimension up into")
+	margins := flags.String("margins", "", "comma separated totals to add: row, column or total")
+	percent := flags.String("percent", "", "add the percentage of each count of its row, column or the total")
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	if flags.NArg() < 2 {
+		return errUsage
+	}
+
+	if *format != client.FormatCSV && *format != client.FormatJSONStat {
+		return fmt.Errorf("unsupported format %q: use csv or jsonstat", *format)
+	}
+
+	opts := client.QueryOptions{
+		Variables: flags.Args()[1:],
+		Aggregate: list(*aggregate),
+		Margins:   list(*margins),
+		Percent:   *percent,
+	}
+
+	b, err := c.client.Export(context.Background(), flags.Arg(0), opts, *format)
+	if err != nil {
+		return err
+	}
+
+	if *output == "" {
+		_, err := c.stdout.Write(b)
+		return err
+	}
+
+	if err := ioutil.WriteFile(*output, b, 0644); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stdout, "wrote %s\n", *output)
+	return nil
+}
+
+func list(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
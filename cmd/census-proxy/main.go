@@ -0,0 +1,197 @@
+// Command census-proxy queries and exports census data through the proxy.
+//
+//	census-proxy [-config file] [-url url] <command> [arguments]
+//
+// The proxy URL and token are read from CENSUS_PROXY_URL and
+// CENSUS_PROXY_TOKEN, or AUTH_PROXY_TOKEN, falling back to a JSON config file
+// with "url" and "token" fields.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/client"
+)
+
+const defaultURL = "http://localhost:10100"
+
+// errUsage is returned by a command given arguments that do not match its usage
+var errUsage = errors.New("invalid arguments")
+
+// settings say where the proxy is and how to authenticate with it
+type settings struct {
+	URL   string `json:"url"`
+	Token string `json:"token"`
+
+	// file is where the settings were read from, named in error messages
+	file string
+}
+
+// cli runs a command with the streams and environment it is given, so it can
+// be driven from tests
+type cli struct {
+	stdin  io.Reader
+	stdout io.Writer
+	getenv func(string) string
+
+	settings settings
+	client   *client.Client
+}
+
+type command struct {
+	usage string
+	about string
+	run   func(c *cli, args []string) error
+}
+
+var commands = map[string]command{
+	"datasets": {"datasets", "list the datasets", (*cli).datasets},
+	"codebook": {"codebook <dataset>", "summarise the dimensions of a dataset", (*cli).codebook},
+	"browse":   {"browse [-branch dimension] <dataset> <dimension>", "browse the hierarchy below a dimension", (*cli).browse},
+	"search":   {"search [-dimension name] <dataset> <text>", "find codes whose code or label contains text", (*cli).search},
+	"query":    {"query [flags] <dataset> <dimension>...", "write a table to a CSV or JSON-stat file", (*cli).query},
+}
+
+func main() {
+	c := &cli{stdin: os.Stdin, stdout: os.Stdout, getenv: os.Getenv}
+	if err := c.run(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "census-proxy:", err)
+		os.Exit(1)
+	}
+}
+
+func (c *cli) run(args []string) error {
+	flags := flag.NewFlagSet("census-proxy", flag.ContinueOnError)
+	flags.SetOutput(c.stdout)
+	flags.Usage = func() { c.usage(flags) }
+
+	configFile := flags.String("config", "", "config file with the proxy url and token (default "+defaultConfigFile()+")")
+	proxyURL := flags.String("url", "", "URL of the proxy, overriding CENSUS_PROXY_URL and the config file")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errors.New("no command given")
+	}
+
+	cmd, ok := commands[flags.Arg(0)]
+	if !ok {
+		flags.Usage()
+		return fmt.Errorf("unknown command %q", flags.Arg(0))
+	}
+
+	s, err := c.loadSettings(*configFile)
+	if err != nil {
+		return err
+	}
+	if *proxyURL != "" {
+		s.URL = *proxyURL
+	}
+	if s.Token == "" {
+		return fmt.Errorf("no token: set CENSUS_PROXY_TOKEN or add \"token\" to %s", s.file)
+	}
+
+	c.settings = s
+	c.client = client.New(s.URL, s.Token)
+
+	err = cmd.run(c, flags.Args()[1:])
+	if err == errUsage {
+		return fmt.Errorf("usage: census-proxy %s", cmd.usage)
+	}
+	return c.explain(err)
+}
+
+func (c *cli) usage(flags *flag.FlagSet) {
+	fmt.Fprintln(c.stdout, "usage: census-proxy [-config file] [-url url] <command> [arguments]")
+	fmt.Fprintln(c.stdout)
+	fmt.Fprintln(c.stdout, "commands:")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(c.stdout, "  %-50s %s\n", commands[name].usage, commands[name].about)
+	}
+
+	fmt.Fprintln(c.stdout)
+	fmt.Fprintln(c.stdout, "flags:")
+	flags.PrintDefaults()
+}
+
+// loadSettings reads the config file, if there is one, then overrides it with
+// the environment. A missing file is only an error if it was named.
+func (c *cli) loadSettings(file string) (settings, error) {
+	s := settings{URL: defaultURL, file: file}
+	if s.file == "" {
+		s.file = c.getenv("CENSUS_PROXY_CONFIG")
+	}
+	named := s.file != ""
+	if !named {
+		s.file = defaultConfigFile()
+	}
+
+	b, err := ioutil.ReadFile(s.file)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(b, &s); err != nil {
+			return s, fmt.Errorf("invalid config file %s: %w", s.file, err)
+		}
+	case !os.IsNotExist(err) || named:
+		return s, fmt.Errorf("could not read config file: %w", err)
+	}
+
+	if v := c.getenv("CENSUS_PROXY_URL"); v != "" {
+		s.URL = v
+	}
+	for _, name := range []string{"CENSUS_PROXY_TOKEN", "AUTH_PROXY_TOKEN"} {
+		if v := c.getenv(name); v != "" {
+			s.Token = v
+			break
+		}
+	}
+
+	return s, nil
+}
+
+func defaultConfigFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "census-proxy.json"
+	}
+	return filepath.Join(dir, "census-proxy", "config.json")
+}
+
+// explain rewords the errors a user can do something about
+func (c *cli) explain(err error) error {
+	var proxyErr client.Error
+	if errors.As(err, &proxyErr) {
+		switch proxyErr.StatusCode {
+		case http.StatusUnauthorized:
+			return fmt.Errorf("the proxy at %s rejected the token (%s): check CENSUS_PROXY_TOKEN or the token in %s", c.settings.URL, proxyErr.Message, c.settings.file)
+		case http.StatusNotFound:
+			return fmt.Errorf("not found: %s", proxyErr.Message)
+		}
+		return err
+	}
+
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return fmt.Errorf("could not reach the proxy at %s: %v", c.settings.URL, urlErr.Err)
+	}
+
+	return err
+}
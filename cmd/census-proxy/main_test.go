@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/api/apitest"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const testToken = apitest.Token
+
+func TestMain(m *testing.M) {
+	apitest.Main(m)
+}
+
+// newProxy serves the api router in front of a fake FTB serving the example
+// dataset, returning the directory the test can write files to
+func newProxy() (*httptest.Server, string, func()) {
+	p, err := apitest.New(0)
+	So(err, ShouldBeNil)
+
+	server := httptest.NewServer(p.Handler())
+	return server, p.Dir, func() {
+		server.Close()
+		p.Close()
+	}
+}
+
+// runCLI runs census-proxy with the environment and stdin given, returning
+// what it wrote to stdout
+func runCLI(env map[string]string, stdin string, args ...string) (string, error) {
+	var out bytes.Buffer
+	c := &cli{
+		stdin:  strings.NewReader(stdin),
+		stdout: &out,
+		getenv: func(name string) string { return env[name] },
+	}
+	err := c.run(args)
+	return out.String(), err
+}
+
+func TestCLI(t *testing.T) {
+	Convey("Given a proxy in front of a flexible table builder", t, func() {
+		server, dir, closeProxy := newProxy()
+		defer closeProxy()
+
+		env := map[string]string{"CENSUS_PROXY_URL": server.URL, "CENSUS_PROXY_TOKEN": testToken}
+
+		Convey("When the datasets are listed", func() {
+			out, err := runCLI(env, "", "datasets")
+
+			Convey("Then each is written on a line", func() {
+				So(err, ShouldBeNil)
+				So(out, ShouldContainSubstring, "Example")
+			})
+		})
+
+		Convey("When the codebook of a dataset is summarised", func() {
+			out, err := runCLI(env, "", "codebook", "Example")
+
+			Convey("Then each dimension is listed with the number of its codes", func() {
+				So(err, ShouldBeNil)
+				So(out, ShouldContainSubstring, "example-digest-1")
+				So(out, ShouldContainSubstring, "Local Authority  4")
+			})
+		})
+
+		Convey("When codes are searched for", func() {
+			out, err := runCLI(env, "", "search", "Example", "black")
+
+			Convey("Then the codes with matching labels are listed", func() {
+				So(err, ShouldBeNil)
+				So(out, ShouldContainSubstring, "E06000008  Blackburn with Darwen")
+				So(out, ShouldContainSubstring, "E06000009  Blackpool")
+			})
+		})
+
+		Convey("When a hierarchy is browsed", func() {
+			out, err := runCLI(env, "1\n1\nu\nq\n", "browse", "-branch", "region", "Example", "country")
+
+			Convey("Then the children of each code picked are listed", func() {
+				So(err, ShouldBeNil)
+				So(out, ShouldContainSubstring, "England (E92000001) [2]")
+				So(out, ShouldContainSubstring, "Country > England: Region > North East: Local Authority")
+				So(out, ShouldContainSubstring, "   1. E06000001\n")
+			})
+		})
+
+		Convey("When a query is written to a file", func() {
+			file := filepath.Join(dir, "table.json")
+			_, err := runCLI(env, "", "query", "-format", "jsonstat", "-o", file, "Example", "region", "sex")
+
+			Convey("Then the file holds the JSON-stat dataset", func() {
+				So(err, ShouldBeNil)
+
+				b, err := ioutil.ReadFile(file)
+				So(err, ShouldBeNil)
+
+				var table map[string]interface{}
+				So(json.Unmarshal(b, &table), ShouldBeNil)
+				So(table["class"], ShouldEqual, "dataset")
+			})
+		})
+
+		Convey("When the token is read from a config file", func() {
+			file := filepath.Join(dir, "config.json")
+			So(ioutil.WriteFile(file, []byte(`{"url": "`+server.URL+`", "token": "wrong"}`), 0600), ShouldBeNil)
+
+			_, err := runCLI(nil, "", "-config", file, "datasets")
+
+			Convey("Then a rejected token is explained", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "rejected the token")
+				So(err.Error(), ShouldContainSubstring, file)
+			})
+		})
+
+		Convey("When there is no token", func() {
+			_, err := runCLI(map[string]string{"CENSUS_PROXY_CONFIG": filepath.Join(dir, "missing.json")}, "", "datasets")
+
+			Convey("Then the missing config file is reported", func() {
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "could not read config file")
+			})
+		})
+
+		Convey("When a command is given the wrong arguments", func() {
+			_, err := runCLI(env, "", "codebook")
+
+			Convey("Then its usage is returned", func() {
+				So(err.Error(), ShouldEqual, "usage: census-proxy codebook <dataset>")
+			})
+		})
+	})
+}
@@ -3,16 +3,47 @@ package config
 import (
 	"errors"
 	"strings"
+	"time"
 
 	"github.com/kelseyhightower/envconfig"
 )
 
 // Config represents service configuration for dp-census-alpha-api-proxy
 type Config struct {
-	BindAddr                string `envconfig:"BIND_ADDR"`
-	AuthToken               string `envconfig:"AUTH_TOKEN" json:"-"`
-	FlexibleTableBuilderURL string `envconfig:"FTB_URL"`
-	IPAddr                  string `envconfig:"IP_ADDR"`
+	BindAddr                string                   `envconfig:"BIND_ADDR"`
+	AuthToken               string                   `envconfig:"AUTH_TOKEN" json:"-"`
+	FlexibleTableBuilderURL string                   `envconfig:"FTB_URL"`
+	IPAddr                  string                   `envconfig:"IP_ADDR"`
+	CompressionMinSize      int                      `envconfig:"COMPRESSION_MIN_SIZE"`
+	CompressionContentTypes []string                 `envconfig:"COMPRESSION_CONTENT_TYPES"`
+	DefaultCacheMaxAge      time.Duration            `envconfig:"DEFAULT_CACHE_MAX_AGE"`
+	CacheMaxAge             map[string]time.Duration `envconfig:"CACHE_MAX_AGE"`
+	SnapshotDir             string                   `envconfig:"SNAPSHOT_DIR"`
+	CodebookCacheTTL        time.Duration            `envconfig:"CODEBOOK_CACHE_TTL"`
+	PrefetchDatasets        []string                 `envconfig:"PREFETCH_DATASETS"`
+	PrefetchConcurrency     int                      `envconfig:"PREFETCH_CONCURRENCY"`
+	RefreshInterval         time.Duration            `envconfig:"REFRESH_INTERVAL"`
+	DisclosureControlRules  string                   `envconfig:"DISCLOSURE_CONTROL_RULES"`
+	SwaggerUIURL            string                   `envconfig:"SWAGGER_UI_URL"`
+	SwaggerUICSSIntegrity   string                   `envconfig:"SWAGGER_UI_CSS_INTEGRITY"`
+	SwaggerUIJSIntegrity    string                   `envconfig:"SWAGGER_UI_JS_INTEGRITY"`
+	GraphQLMaxDepth         int                      `envconfig:"GRAPHQL_MAX_DEPTH"`
+	GraphQLMaxComplexity    int                      `envconfig:"GRAPHQL_MAX_COMPLEXITY"`
+	BatchMaxRequests        int                      `envconfig:"BATCH_MAX_REQUESTS"`
+	BatchConcurrency        int                      `envconfig:"BATCH_CONCURRENCY"`
+	JobsDir                 string                   `envconfig:"JOBS_DIR"`
+	JobWorkers              int                      `envconfig:"JOB_WORKERS"`
+	JobQueueSize            int                      `envconfig:"JOB_QUEUE_SIZE"`
+	JobRetention            time.Duration            `envconfig:"JOB_RETENTION"`
+	JobQueryTimeout         time.Duration            `envconfig:"JOB_QUERY_TIMEOUT"`
+	WebhookURLs             []string                 `envconfig:"WEBHOOK_URLS"`
+	WebhookSecret           string                   `envconfig:"WEBHOOK_SECRET" json:"-"`
+	WebhookMaxAttempts      int                      `envconfig:"WEBHOOK_MAX_ATTEMPTS"`
+	WebhookRetryBackoff     time.Duration            `envconfig:"WEBHOOK_RETRY_BACKOFF"`
+	WebhookDeadLetterLog    string                   `envconfig:"WEBHOOK_DEAD_LETTER_LOG"`
+	EventsBufferSize        int                      `envconfig:"EVENTS_BUFFER_SIZE"`
+	EventsClientBuffer      int                      `envconfig:"EVENTS_CLIENT_BUFFER"`
+	EventsHeartbeat         time.Duration            `envconfig:"EVENTS_HEARTBEAT"`
 }
 
 var cfg *Config
@@ -29,6 +60,36 @@ func Get() (*Config, error) {
 		AuthToken:               "",
 		FlexibleTableBuilderURL: "http://localhost:8491",
 		IPAddr:                  "127.0.0.1",
+		CompressionMinSize:      1024,
+		CompressionContentTypes: []string{"application/json", "application/x-ndjson", "text/csv", "text/plain"},
+		DefaultCacheMaxAge:      time.Minute,
+		CacheMaxAge:             map[string]time.Duration{},
+		SnapshotDir:             "snapshots",
+		CodebookCacheTTL:        30 * time.Second,
+		PrefetchDatasets:        []string{},
+		PrefetchConcurrency:     4,
+		RefreshInterval:         10 * time.Minute,
+		DisclosureControlRules:  "",
+		SwaggerUIURL:            "https://unpkg.com/swagger-ui-dist@3.52.5",
+		SwaggerUICSSIntegrity:   "",
+		SwaggerUIJSIntegrity:    "",
+		GraphQLMaxDepth:         10,
+		GraphQLMaxComplexity:    5000,
+		BatchMaxRequests:        20,
+		BatchConcurrency:        4,
+		JobsDir:                 "job-results",
+		JobWorkers:              2,
+		JobQueueSize:            100,
+		JobRetention:            24 * time.Hour,
+		JobQueryTimeout:         10 * time.Minute,
+		WebhookURLs:             []string{},
+		WebhookSecret:           "",
+		WebhookMaxAttempts:      5,
+		WebhookRetryBackoff:     time.Second,
+		WebhookDeadLetterLog:    "webhook-dead-letters.log",
+		EventsBufferSize:        256,
+		EventsClientBuffer:      64,
+		EventsHeartbeat:         30 * time.Second,
 	}
 
 	err := envconfig.Process("", cfg)
@@ -40,6 +101,10 @@ func Get() (*Config, error) {
 		return nil, errors.New("auth token cannot be empty")
 	}
 
+	if len(cfg.WebhookURLs) > 0 && len(cfg.WebhookSecret) == 0 {
+		return nil, errors.New("webhook secret cannot be empty when webhook urls are set")
+	}
+
 	return cfg, nil
 }
 
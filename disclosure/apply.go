@@ -0,0 +1,215 @@
+package disclosure
+
+import "github.com/ONSdigital/dp-census-alpha-api-proxy/cantabular"
+
+// Apply runs the disclosure control pipeline over the counts of a table in
+// place: cells below the threshold are suppressed, further cells are
+// suppressed so that none can be recovered by subtraction from a total, and
+// the remaining counts are rounded. Suppressed cells are given as zero with
+// the marker in Status. The record of what was done is set on the table and
+// returned.
+func Apply(t *cantabular.Table, name string, rules *Rules) (*cantabular.DisclosureControl, error) {
+	if len(t.Counts) != t.Size() {
+		return nil, cantabular.ErrInvalidTable
+	}
+
+	record := &cantabular.DisclosureControl{
+		Rules:                name,
+		Threshold:            rules.Threshold,
+		RoundingBase:         rules.RoundingBase,
+		SecondarySuppression: rules.SecondarySuppression,
+	}
+
+	suppressed := make([]bool, len(t.Counts))
+
+	if rules.Threshold > 0 {
+		for i, count := range t.Counts {
+			if count > 0 && count < rules.Threshold {
+				suppressed[i] = true
+				record.Suppressed++
+			}
+		}
+	}
+
+	if rules.SecondarySuppression && record.Suppressed > 0 {
+		record.SecondarySuppressed = suppressSecondary(t, suppressed)
+	}
+
+	if record.Suppressed+record.SecondarySuppressed > 0 {
+		record.Marker = rules.marker()
+		if t.Status == nil {
+			t.Status = make([]string, len(t.Counts))
+		}
+	}
+
+	for i, count := range t.Counts {
+		switch {
+		case suppressed[i]:
+			t.Counts[i] = 0
+			t.Status[i] = record.Marker
+		case rules.RoundingBase > 0:
+			t.Counts[i] = round(count, rules.RoundingBase)
+		}
+	}
+
+	t.DisclosureControl = record
+	return record, nil
+}
+
+// suppressSecondary suppresses the smallest remaining cell of every line of
+// the table holding a single suppressed cell, where a line runs along one
+// dimension with the others fixed, until no line is left with one. Where a
+// dimension has been aggregated each level is a line of its own, as each adds
+// up to its own total, and so is each derived category together with the
+// categories summed into it, as it is their subtotal. It returns the number
+// of cells it suppressed.
+func suppressSecondary(t *cantabular.Table, suppressed []bool) int {
+	strides := t.Strides()
+	added := 0
+
+	groups := make([][][]int, len(t.Dimensions))
+	for d := range t.Dimensions {
+		groups[d] = lineGroups(&t.Dimensions[d])
+	}
+
+	for changed := true; changed; {
+		changed = false
+
+		for d := range t.Dimensions {
+			stride := strides[d]
+			n := len(t.Dimensions[d].Codes)
+
+			for start := range t.Counts {
+				// visit each line once, from its first cell along d
+				if (start/stride)%n != 0 {
+					continue
+				}
+
+				for _, group := range groups[d] {
+					if suppressLine(t.Counts, suppressed, start, stride, group) {
+						added++
+						changed = true
+					}
+				}
+			}
+		}
+	}
+
+	return added
+}
+
+// lineGroups returns the groups of categories of a dimension that add up to a
+// total: each level, and each derived category with the categories of a finer
+// level summed into it. A category is given by its position in the dimension.
+func lineGroups(dim *cantabular.TableDimension) [][]int {
+	levels := dim.LevelRuns()
+
+	groups := make([][]int, 0, len(levels))
+	for _, level := range levels {
+		group := make([]int, level.Count)
+		for k := range group {
+			group[k] = level.Offset + k
+		}
+		groups = append(groups, group)
+	}
+
+	if len(levels) < 2 || levels[0].Derived {
+		return groups
+	}
+	queried := levels[0]
+
+	// into returns the category of a level the queried category i is summed
+	// into, or -1 if it is not or the level is not part of the hierarchy
+	into := func(l cantabular.TableLevel, i int) int {
+		switch {
+		case l.Offset == queried.Offset:
+			return i
+		case l.From != nil && i < len(l.From):
+			return l.From[i]
+		}
+		return -1
+	}
+
+	for _, parent := range levels[1:] {
+		if parent.From == nil {
+			continue
+		}
+
+		for _, child := range levels {
+			if child.Offset == parent.Offset {
+				continue
+			}
+			if children, ok := subtotals(queried.Count, child, parent, into); ok {
+				for p, members := range children {
+					if len(members) > 0 {
+						groups = append(groups, append(members, parent.Offset+p))
+					}
+				}
+			}
+		}
+	}
+
+	return groups
+}
+
+// subtotals returns the categories of the child level summed into each
+// category of the parent level, or false if the child does not nest inside
+// the parent, as each of its categories must fall within one of the parent's.
+func subtotals(n int, child, parent cantabular.TableLevel, into func(cantabular.TableLevel, int) int) ([][]int, bool) {
+	parentOf := make(map[int]int)
+	for i := 0; i < n; i++ {
+		c, p := into(child, i), into(parent, i)
+		if c < 0 || p < 0 {
+			continue
+		}
+		if seen, ok := parentOf[c]; ok && seen != p {
+			return nil, false
+		}
+		parentOf[c] = p
+	}
+
+	if len(parentOf) == 0 {
+		return nil, false
+	}
+
+	children := make([][]int, parent.Count)
+	for c := 0; c < child.Count; c++ {
+		if p, ok := parentOf[c]; ok {
+			children[p] = append(children[p], child.Offset+c)
+		}
+	}
+	return children, true
+}
+
+// suppressLine suppresses the smallest unsuppressed cell of a group of cells
+// along a line if exactly one is already suppressed, returning true if it did.
+func suppressLine(counts []int, suppressed []bool, first, stride int, group []int) bool {
+	if len(group) < 2 {
+		return false
+	}
+
+	found := 0
+	smallest := -1
+	for _, k := range group {
+		i := first + k*stride
+		if suppressed[i] {
+			found++
+			continue
+		}
+		if smallest < 0 || counts[i] < counts[smallest] {
+			smallest = i
+		}
+	}
+
+	if found != 1 || smallest < 0 {
+		return false
+	}
+
+	suppressed[smallest] = true
+	return true
+}
+
+// round rounds a count to the nearest multiple of base, halves rounding up
+func round(count, base int) int {
+	return (count + base/2) / base * base
+}
@@ -0,0 +1,88 @@
+package disclosure
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// DefaultMarker is written to the status of a suppressed cell when the rules
+// do not give one
+const DefaultMarker = "c"
+
+// Rules configure the disclosure control applied to the tables of a dataset.
+// A zero Threshold or RoundingBase turns that step off.
+type Rules struct {
+	Threshold            int    `json:"threshold"`
+	Marker               string `json:"marker"`
+	RoundingBase         int    `json:"rounding_base"`
+	SecondarySuppression bool   `json:"secondary_suppression"`
+}
+
+// RuleSet holds the rules for each dataset, with Default applied to datasets
+// that have none of their own. A nil Default leaves those datasets untouched.
+type RuleSet struct {
+	Default  *Rules            `json:"default"`
+	Datasets map[string]*Rules `json:"datasets"`
+}
+
+// Load reads a rule set from a JSON file
+func Load(path string) (*RuleSet, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rs RuleSet
+	if err := json.Unmarshal(b, &rs); err != nil {
+		return nil, fmt.Errorf("invalid disclosure control rules %s: %w", path, err)
+	}
+
+	if err := rs.Default.validate("default"); err != nil {
+		return nil, err
+	}
+	for dataset, rules := range rs.Datasets {
+		if err := rules.validate(dataset); err != nil {
+			return nil, err
+		}
+	}
+
+	return &rs, nil
+}
+
+// For returns the name and rules that apply to a dataset, or nil if none do.
+// It is safe to call on a nil rule set.
+func (rs *RuleSet) For(dataset string) (string, *Rules) {
+	if rs == nil {
+		return "", nil
+	}
+
+	if rules, ok := rs.Datasets[dataset]; ok && rules != nil {
+		return dataset, rules
+	}
+
+	if rs.Default != nil {
+		return "default", rs.Default
+	}
+
+	return "", nil
+}
+
+func (r *Rules) validate(name string) error {
+	if r == nil {
+		return nil
+	}
+
+	if r.Threshold < 0 || r.RoundingBase < 0 {
+		return fmt.Errorf("disclosure control rules for %s: threshold and rounding base cannot be negative", name)
+	}
+
+	return nil
+}
+
+func (r *Rules) marker() string {
+	if r.Marker == "" {
+		return DefaultMarker
+	}
+	return r.Marker
+}
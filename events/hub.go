@@ -0,0 +1,148 @@
+// Package events fans out what happens to the cached datasets and the FTB to
+// the clients of the /v6/events stream, keeping the most recent events so a
+// client that reconnects can catch up on those it missed.
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ONSdigital/log.go/log"
+)
+
+// ErrInvalidEventID is returned when a Last-Event-ID is not one the hub gave
+var ErrInvalidEventID = errors.New("invalid last event id")
+
+// Event is a message published to every subscriber. IDs count up from 1.
+type Event struct {
+	ID   uint64
+	Type string
+	Data json.RawMessage
+	Time time.Time
+}
+
+// WriteTo writes the event as a server-sent event
+func (e *Event) WriteTo(w io.Writer) (int64, error) {
+	n, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, e.Data)
+	return int64(n), err
+}
+
+// Subscription receives the events published after it was made on C. C is
+// closed if the subscriber falls so far behind that its buffer fills.
+type Subscription struct {
+	C <-chan *Event
+	c chan *Event
+}
+
+// Hub publishes events to its subscribers without ever waiting on them, and
+// holds the most recent in a ring for replay.
+type Hub struct {
+	clientBuffer int
+
+	mu          sync.Mutex
+	ring        []*Event
+	latest      uint64
+	count       int
+	subscribers map[*Subscription]struct{}
+}
+
+// NewHub returns a hub keeping the last size events for replay, and
+// disconnecting subscribers with more than clientBuffer events waiting
+func NewHub(size, clientBuffer int) *Hub {
+	if size < 0 {
+		size = 0
+	}
+	if clientBuffer < 1 {
+		clientBuffer = 1
+	}
+
+	return &Hub{
+		clientBuffer: clientBuffer,
+		ring:         make([]*Event, size),
+		subscribers:  make(map[*Subscription]struct{}),
+	}
+}
+
+// Publish sends an event with data encoded as JSON to every subscriber
+func (h *Hub) Publish(typ string, data interface{}) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		log.Event(nil, "failed to encode event", log.ERROR, log.Error(err), log.Data{"type": typ})
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.latest++
+	e := &Event{ID: h.latest, Type: typ, Data: b, Time: time.Now().UTC()}
+
+	if len(h.ring) > 0 {
+		h.ring[e.ID%uint64(len(h.ring))] = e
+		if h.count < len(h.ring) {
+			h.count++
+		}
+	}
+
+	for sub := range h.subscribers {
+		select {
+		case sub.c <- e:
+		default:
+			delete(h.subscribers, sub)
+			close(sub.c)
+			log.Event(nil, "disconnected slow event subscriber", log.WARN, log.Data{"event": e.ID, "buffer": h.clientBuffer})
+		}
+	}
+}
+
+// Subscribe starts a subscription, returning with it the buffered events
+// after lastEventID to replay first. If lastEventID is empty nothing is
+// replayed; if it is older than the buffer, or newer than any event, as it
+// would be from before a restart, everything buffered is.
+func (h *Hub) Subscribe(lastEventID string) (*Subscription, []*Event, error) {
+	var last uint64
+	if lastEventID != "" {
+		var err error
+		if last, err = strconv.ParseUint(lastEventID, 10, 64); err != nil {
+			return nil, nil, ErrInvalidEventID
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	replay := make([]*Event, 0)
+	if lastEventID != "" {
+		oldest := h.latest - uint64(h.count) + 1
+		from := last + 1
+		if from < oldest || last > h.latest {
+			from = oldest
+		}
+		for id := from; id <= h.latest; id++ {
+			replay = append(replay, h.ring[id%uint64(len(h.ring))])
+		}
+	}
+
+	c := make(chan *Event, h.clientBuffer)
+	sub := &Subscription{C: c, c: c}
+	h.subscribers[sub] = struct{}{}
+
+	return sub, replay, nil
+}
+
+// Unsubscribe ends a subscription, closing its channel if the hub has not
+// already done so
+func (h *Hub) Unsubscribe(sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.subscribers[sub]; ok {
+		delete(h.subscribers, sub)
+		close(sub.c)
+	}
+}
@@ -0,0 +1,119 @@
+package events_test
+
+import (
+	"testing"
+
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/events"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func ids(replay []*events.Event) []uint64 {
+	out := make([]uint64, 0, len(replay))
+	for _, e := range replay {
+		out = append(out, e.ID)
+	}
+	return out
+}
+
+func TestHub(t *testing.T) {
+	Convey("Given a hub keeping no events for replay", t, func() {
+		hub := events.NewHub(0, 4)
+
+		Convey("When a client reconnects after events were published", func() {
+			hub.Publish("test", 1)
+			hub.Publish("test", 2)
+
+			sub, replay, err := hub.Subscribe("1")
+			So(err, ShouldBeNil)
+			defer hub.Unsubscribe(sub)
+
+			Convey("Then nothing is replayed", func() {
+				So(replay, ShouldBeEmpty)
+			})
+
+			Convey("Then events published after are still sent", func() {
+				hub.Publish("test", 3)
+				So((<-sub.C).ID, ShouldEqual, 3)
+			})
+		})
+
+		Convey("When a client reconnects before any event was published", func() {
+			sub, replay, err := hub.Subscribe("7")
+			So(err, ShouldBeNil)
+			defer hub.Unsubscribe(sub)
+
+			Convey("Then nothing is replayed", func() {
+				So(replay, ShouldBeEmpty)
+			})
+		})
+	})
+
+	Convey("Given a hub keeping the last three events", t, func() {
+		hub := events.NewHub(3, 4)
+		for i := 1; i <= 5; i++ {
+			hub.Publish("test", i)
+		}
+
+		Convey("When a client reconnects with the ID of a buffered event", func() {
+			sub, replay, err := hub.Subscribe("3")
+			So(err, ShouldBeNil)
+			defer hub.Unsubscribe(sub)
+
+			Convey("Then the events after it are replayed", func() {
+				So(ids(replay), ShouldResemble, []uint64{4, 5})
+			})
+		})
+
+		Convey("When a client reconnects with the latest ID", func() {
+			sub, replay, err := hub.Subscribe("5")
+			So(err, ShouldBeNil)
+			defer hub.Unsubscribe(sub)
+
+			Convey("Then nothing is replayed", func() {
+				So(replay, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When a client reconnects with an ID newer than the latest, as after a restart", func() {
+			sub, replay, err := hub.Subscribe("42")
+			So(err, ShouldBeNil)
+			defer hub.Unsubscribe(sub)
+
+			Convey("Then everything buffered is replayed", func() {
+				So(ids(replay), ShouldResemble, []uint64{3, 4, 5})
+			})
+		})
+
+		Convey("When a client connects without an ID", func() {
+			sub, replay, err := hub.Subscribe("")
+			So(err, ShouldBeNil)
+			defer hub.Unsubscribe(sub)
+
+			Convey("Then nothing is replayed", func() {
+				So(replay, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When a client reconnects with an ID that is not a number", func() {
+			_, _, err := hub.Subscribe("abc")
+
+			Convey("Then it is rejected", func() {
+				So(err, ShouldEqual, events.ErrInvalidEventID)
+			})
+		})
+
+		Convey("When a subscription is ended twice", func() {
+			sub, _, err := hub.Subscribe("")
+			So(err, ShouldBeNil)
+
+			hub.Unsubscribe(sub)
+
+			Convey("Then its channel is closed once", func() {
+				So(func() { hub.Unsubscribe(sub) }, ShouldNotPanic)
+
+				_, open := <-sub.C
+				So(open, ShouldBeFalse)
+			})
+		})
+	})
+}
@@ -0,0 +1,68 @@
+package events
+
+import (
+	"context"
+
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/cantabular"
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/store"
+)
+
+// Types of the events published by the hub
+const (
+	TypeDigestChanged = "dataset.digest_changed"
+	TypeCacheRefresh  = "cache.refreshed"
+	TypeUpstreamState = "upstream.health_changed"
+)
+
+// DigestChange is the data of a TypeDigestChanged event
+type DigestChange struct {
+	Dataset   string                 `json:"dataset"`
+	OldDigest string                 `json:"old_digest"`
+	NewDigest string                 `json:"new_digest"`
+	Changes   cantabular.DiffSummary `json:"changes"`
+}
+
+// CacheRefresh is the data of a TypeCacheRefresh event
+type CacheRefresh struct {
+	Datasets int      `json:"datasets"`
+	Failed   []string `json:"failed"`
+	Duration string   `json:"duration"`
+}
+
+// UpstreamState is the data of a TypeUpstreamState event
+type UpstreamState struct {
+	Upstream  string `json:"upstream"`
+	Available bool   `json:"available"`
+	Error     string `json:"error,omitempty"`
+}
+
+// DigestChanged publishes a change of digest, and can be given to
+// store.Codebooks.OnDigestChange
+func (h *Hub) DigestChanged(ctx context.Context, diff *cantabular.CodebookDiff) {
+	h.Publish(TypeDigestChanged, &DigestChange{
+		Dataset:   diff.Dataset,
+		OldDigest: diff.From,
+		NewDigest: diff.To,
+		Changes:   diff.Summary(),
+	})
+}
+
+// HealthChanged publishes the FTB becoming unavailable or recovering, and can
+// be given to store.Codebooks.OnHealthChange
+func (h *Hub) HealthChanged(ctx context.Context, available bool, err error) {
+	state := &UpstreamState{Upstream: "ftb", Available: available}
+	if err != nil {
+		state.Error = err.Error()
+	}
+	h.Publish(TypeUpstreamState, state)
+}
+
+// Refreshed publishes a refresh of the cached codebooks, and can be set as
+// store.Refresher.OnRefresh
+func (h *Hub) Refreshed(ctx context.Context, report store.RefreshReport) {
+	h.Publish(TypeCacheRefresh, &CacheRefresh{
+		Datasets: len(report.Datasets),
+		Failed:   report.Failed,
+		Duration: report.Duration.String(),
+	})
+}
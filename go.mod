@@ -3,6 +3,7 @@ module github.com/ONSdigital/dp-census-alpha-api-proxy
 go 1.13
 
 require (
+	github.com/ONSdigital/dp-api-clients-go v1.9.0
 	github.com/ONSdigital/dp-code-list-api v0.0.0-20200518150918-07bfa87e6c6c
 	github.com/ONSdigital/dp-filter-api v0.0.0-20200521142607-bc24317b3df9
 	github.com/ONSdigital/dp-healthcheck v1.0.4 // indirect
@@ -10,7 +11,10 @@ require (
 	github.com/ONSdigital/dp-net v1.0.3
 	github.com/ONSdigital/go-ns v0.0.0-20200511161740-afc39066ee62
 	github.com/ONSdigital/log.go v1.0.0
+	github.com/andybalholm/brotli v1.0.6
 	github.com/gorilla/mux v1.7.4
+	github.com/graphql-go/graphql v0.8.1
 	github.com/justinas/alice v1.2.0
 	github.com/kelseyhightower/envconfig v1.4.0
+	github.com/smartystreets/goconvey v1.6.4
 )
@@ -0,0 +1,62 @@
+// Package jobs runs queries too slow to answer within a request in a bounded
+// pool of workers, keeping their results on disk for a retention period.
+package jobs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// Status is where a job is in its life
+type Status string
+
+const (
+	StatusQueued    Status = "queued"
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+)
+
+// Query is the tabulation a job runs, with the options of /v6/query/{dataset}
+type Query struct {
+	Dataset   string   `json:"dataset"`
+	Variables []string `json:"variables"`
+	Aggregate []string `json:"aggregate,omitempty"`
+	Margins   []string `json:"margins,omitempty"`
+	Percent   string   `json:"percent,omitempty"`
+}
+
+// Progress is the stage a running job has reached, numbered from 1 of Steps
+type Progress struct {
+	Stage string `json:"stage,omitempty"`
+	Step  int    `json:"step"`
+	Steps int    `json:"steps"`
+}
+
+// Job is a query and what has become of it. Expires is set once it has
+// finished, after which it is removed along with its result.
+type Job struct {
+	ID       string     `json:"id"`
+	Query    Query      `json:"query"`
+	Status   Status     `json:"status"`
+	Progress Progress   `json:"progress"`
+	Error    string     `json:"error,omitempty"`
+	Created  time.Time  `json:"created"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Expires  *time.Time `json:"expires,omitempty"`
+}
+
+// Done reports whether the job has finished, successfully or not
+func (j *Job) Done() bool {
+	return j.Status == StatusSucceeded || j.Status == StatusFailed
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,265 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/cantabular"
+	"github.com/ONSdigital/log.go/log"
+)
+
+// pruneInterval is the time between removals of expired jobs
+const pruneInterval = time.Minute
+
+var (
+	// ErrQueueFull is returned when a job is submitted while the queue is full
+	ErrQueueFull = errors.New("job queue is full")
+
+	// ErrNotReady is returned for the result of a job that has not succeeded
+	ErrNotReady = errors.New("job has not succeeded")
+)
+
+// Runner runs the query of a job, reporting its progress as it goes. The
+// message of an error it returns is given to the client.
+type Runner func(ctx context.Context, query Query, progress func(Progress)) (*cantabular.Table, error)
+
+// Queue runs jobs in a pool of workers, holding up to a fixed number waiting
+// for one. Jobs are kept in memory and written through to the store.
+type Queue struct {
+	store     *Store
+	run       Runner
+	workers   int
+	retention time.Duration
+	pending   chan string
+
+	mu   sync.Mutex
+	jobs map[string]*Job
+}
+
+// NewQueue returns a queue running jobs with run in up to workers at once,
+// with up to size waiting, and keeping results for retention. Jobs in the
+// store are loaded, and any that were left unfinished by a restart are failed.
+func NewQueue(store *Store, run Runner, workers, size int, retention time.Duration) (*Queue, error) {
+	if workers < 1 {
+		workers = 1
+	}
+	if size < 0 {
+		size = 0
+	}
+
+	q := &Queue{
+		store:     store,
+		run:       run,
+		workers:   workers,
+		retention: retention,
+		pending:   make(chan string, size),
+		jobs:      make(map[string]*Job),
+	}
+
+	stored, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, job := range stored {
+		if !job.Done() {
+			q.finish(job, errors.New("interrupted by a restart"))
+			if err := store.Save(job); err != nil {
+				return nil, err
+			}
+		}
+		q.jobs[job.ID] = job
+	}
+
+	return q, nil
+}
+
+// Run starts the workers and removes expired jobs until the context is
+// cancelled
+func (q *Queue) Run(ctx context.Context) {
+	for i := 0; i < q.workers; i++ {
+		go q.work(ctx)
+	}
+
+	q.Prune(time.Now())
+
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			q.Prune(now)
+		}
+	}
+}
+
+// Submit queues a query, returning the job that will run it
+func (q *Queue) Submit(ctx context.Context, query Query) (*Job, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+
+	job := &Job{ID: id, Query: query, Status: StatusQueued, Created: time.Now().UTC()}
+	if err := q.store.Save(job); err != nil {
+		return nil, err
+	}
+
+	q.mu.Lock()
+	q.jobs[id] = job
+	copied := *job
+	q.mu.Unlock()
+
+	select {
+	case q.pending <- id:
+	default:
+		q.mu.Lock()
+		delete(q.jobs, id)
+		q.mu.Unlock()
+
+		if err := q.store.Delete(id); err != nil {
+			log.Event(ctx, "failed to remove rejected job", log.WARN, log.Error(err), log.Data{"job": id})
+		}
+		return nil, ErrQueueFull
+	}
+
+	log.Event(ctx, "job queued", log.INFO, log.Data{"job": id, "dataset": query.Dataset, "variables": query.Variables})
+	return &copied, nil
+}
+
+// Get returns a copy of a job, or false if there is none with the ID
+func (q *Queue) Get(id string) (*Job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, ok := q.jobs[id]
+	if !ok {
+		return nil, false
+	}
+	copied := *job
+	return &copied, true
+}
+
+// Result returns the table a job produced, ErrNotFound if there is no such
+// job or ErrNotReady if it has not succeeded
+func (q *Queue) Result(id string) (*cantabular.Table, error) {
+	job, ok := q.Get(id)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if job.Status != StatusSucceeded {
+		return nil, ErrNotReady
+	}
+	return q.store.LoadResult(id)
+}
+
+// Prune removes the jobs that expired before now, with their results
+func (q *Queue) Prune(now time.Time) {
+	q.mu.Lock()
+	expired := make([]string, 0)
+	for id, job := range q.jobs {
+		if job.Expires != nil && job.Expires.Before(now) {
+			expired = append(expired, id)
+			delete(q.jobs, id)
+		}
+	}
+	q.mu.Unlock()
+
+	for _, id := range expired {
+		if err := q.store.Delete(id); err != nil {
+			log.Event(nil, "failed to remove expired job", log.WARN, log.Error(err), log.Data{"job": id})
+		}
+	}
+
+	if len(expired) > 0 {
+		log.Event(nil, "removed expired jobs", log.INFO, log.Data{"jobs": len(expired)})
+	}
+}
+
+func (q *Queue) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case id := <-q.pending:
+			q.process(ctx, id)
+		}
+	}
+}
+
+// process runs a job, saving its result and recording how it ended
+func (q *Queue) process(ctx context.Context, id string) {
+	var query Query
+	ok := q.update(id, func(job *Job) {
+		now := time.Now().UTC()
+		job.Status = StatusRunning
+		job.Started = &now
+		query = job.Query
+	})
+	if !ok {
+		return
+	}
+
+	log.Event(ctx, "job started", log.INFO, log.Data{"job": id})
+
+	table, err := q.run(ctx, query, func(p Progress) {
+		q.update(id, func(job *Job) { job.Progress = p })
+	})
+	if err == nil {
+		if err = q.store.SaveResult(id, table); err != nil {
+			log.Event(ctx, "failed to save job result", log.ERROR, log.Error(err), log.Data{"job": id})
+			err = errors.New("the result could not be saved")
+		}
+	}
+
+	q.update(id, func(job *Job) { q.finish(job, err) })
+
+	data := log.Data{"job": id}
+	if err != nil {
+		log.Event(ctx, "job failed", log.WARN, log.Error(err), data)
+		return
+	}
+	log.Event(ctx, "job succeeded", log.INFO, data)
+}
+
+// finish records the end of a job, which failed if err is set
+func (q *Queue) finish(job *Job, err error) {
+	now := time.Now().UTC()
+	expires := now.Add(q.retention)
+
+	job.Finished = &now
+	job.Expires = &expires
+
+	if err != nil {
+		job.Status = StatusFailed
+		job.Error = err.Error()
+		return
+	}
+
+	job.Status = StatusSucceeded
+	job.Progress.Step = job.Progress.Steps
+}
+
+// update changes a job and writes it to the store, returning false if it has
+// been removed
+func (q *Queue) update(id string, fn func(job *Job)) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, ok := q.jobs[id]
+	if !ok {
+		return false
+	}
+
+	fn(job)
+
+	if err := q.store.Save(job); err != nil {
+		log.Event(nil, "failed to save job", log.ERROR, log.Error(err), log.Data{"job": id})
+	}
+	return true
+}
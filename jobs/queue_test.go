@@ -0,0 +1,112 @@
+package jobs_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/cantabular"
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/jobs"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func runTable(ctx context.Context, query jobs.Query, progress func(jobs.Progress)) (*cantabular.Table, error) {
+	progress(jobs.Progress{Stage: "query", Step: 1, Steps: 1})
+	return &cantabular.Table{Dataset: query.Dataset, Counts: []int{1}}, nil
+}
+
+// waitFor polls a job until it has finished
+func waitFor(q *jobs.Queue, id string) *jobs.Job {
+	for i := 0; i < 200; i++ {
+		job, ok := q.Get(id)
+		So(ok, ShouldBeTrue)
+		if job.Done() {
+			return job
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	job, _ := q.Get(id)
+	So(job.Done(), ShouldBeTrue)
+	return job
+}
+
+func TestQueuePruning(t *testing.T) {
+	Convey("Given a queue keeping finished jobs for an hour", t, func() {
+		dir, err := ioutil.TempDir("", "jobs")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		store, err := jobs.NewStore(dir)
+		So(err, ShouldBeNil)
+
+		q, err := jobs.NewQueue(store, runTable, 1, 10, time.Hour)
+		So(err, ShouldBeNil)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go q.Run(ctx)
+
+		submitted, err := q.Submit(ctx, jobs.Query{Dataset: "Example", Variables: []string{"sex"}})
+		So(err, ShouldBeNil)
+
+		job := waitFor(q, submitted.ID)
+		So(job.Status, ShouldEqual, jobs.StatusSucceeded)
+		So(job.Expires, ShouldNotBeNil)
+
+		Convey("When jobs are pruned before it expires", func() {
+			q.Prune(job.Expires.Add(-time.Minute))
+
+			Convey("Then it and its result are kept", func() {
+				_, ok := q.Get(job.ID)
+				So(ok, ShouldBeTrue)
+
+				table, err := q.Result(job.ID)
+				So(err, ShouldBeNil)
+				So(table.Counts, ShouldResemble, []int{1})
+			})
+		})
+
+		Convey("When jobs are pruned after it expires", func() {
+			q.Prune(job.Expires.Add(time.Minute))
+
+			Convey("Then it is removed from memory and from the store with its result", func() {
+				_, ok := q.Get(job.ID)
+				So(ok, ShouldBeFalse)
+
+				_, err := q.Result(job.ID)
+				So(err, ShouldEqual, jobs.ErrNotFound)
+
+				stored, err := store.List()
+				So(err, ShouldBeNil)
+				So(stored, ShouldBeEmpty)
+
+				files, err := ioutil.ReadDir(dir)
+				So(err, ShouldBeNil)
+				So(files, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When a job is still queued", func() {
+			idle, err := jobs.NewQueue(store, runTable, 1, 10, time.Hour)
+			So(err, ShouldBeNil)
+
+			queued, err := idle.Submit(ctx, jobs.Query{Dataset: "Example", Variables: []string{"la"}})
+			So(err, ShouldBeNil)
+
+			idle.Prune(time.Now().Add(24 * time.Hour))
+
+			Convey("Then it is never pruned, as it has no expiry", func() {
+				got, ok := idle.Get(queued.ID)
+				So(ok, ShouldBeTrue)
+				So(got.Status, ShouldEqual, jobs.StatusQueued)
+
+				_, err := os.Stat(filepath.Join(dir, queued.ID+".json"))
+				So(err, ShouldBeNil)
+			})
+		})
+	})
+}
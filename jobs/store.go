@@ -0,0 +1,133 @@
+package jobs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/cantabular"
+)
+
+const (
+	jobExt    = ".json"
+	resultExt = ".table.json"
+)
+
+// ErrNotFound is returned for a job that does not exist or has expired
+var ErrNotFound = errors.New("job not found")
+
+// Store persists jobs and their results to a local directory, so finished
+// jobs can be downloaded after a restart until they expire.
+type Store struct {
+	Dir string
+}
+
+// NewStore returns a job store rooted at dir, creating it if needed
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Store{Dir: dir}, nil
+}
+
+// Save writes the state of a job
+func (s *Store) Save(job *Job) error {
+	b, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return writeFile(s.path(job.ID, jobExt), b)
+}
+
+// SaveResult writes the table a job produced
+func (s *Store) SaveResult(id string, table *cantabular.Table) error {
+	b, err := json.Marshal(table)
+	if err != nil {
+		return err
+	}
+	return writeFile(s.path(id, resultExt), b)
+}
+
+// LoadResult reads the table a job produced
+func (s *Store) LoadResult(id string) (*cantabular.Table, error) {
+	b, err := ioutil.ReadFile(s.path(id, resultExt))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var table cantabular.Table
+	if err := json.Unmarshal(b, &table); err != nil {
+		return nil, fmt.Errorf("corrupt result for job %s: %w", id, err)
+	}
+	return &table, nil
+}
+
+// List reads every job in the store. Files that cannot be read are skipped.
+func (s *Store) List() ([]*Job, error) {
+	entries, err := ioutil.ReadDir(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]*Job, 0)
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, jobExt) || strings.HasSuffix(name, resultExt) {
+			continue
+		}
+
+		b, err := ioutil.ReadFile(filepath.Join(s.Dir, name))
+		if err != nil {
+			continue
+		}
+
+		var job Job
+		if err := json.Unmarshal(b, &job); err != nil || job.ID == "" {
+			continue
+		}
+		jobs = append(jobs, &job)
+	}
+	return jobs, nil
+}
+
+// Delete removes a job and its result
+func (s *Store) Delete(id string) error {
+	for _, ext := range []string{resultExt, jobExt} {
+		if err := os.Remove(s.path(id, ext)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Store) path(id, ext string) string {
+	return filepath.Join(s.Dir, id+ext)
+}
+
+// writeFile replaces the file atomically so a crash never leaves a partial job
+func writeFile(path string, b []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
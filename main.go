@@ -1,13 +1,20 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"net/http"
 	"os"
 
 	"github.com/ONSdigital/dp-census-alpha-api-proxy/api"
 	"github.com/ONSdigital/dp-census-alpha-api-proxy/cantabular"
 	"github.com/ONSdigital/dp-census-alpha-api-proxy/config"
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/disclosure"
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/events"
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/jobs"
 	"github.com/ONSdigital/dp-census-alpha-api-proxy/middleware"
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/store"
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/webhooks"
 	dphttp "github.com/ONSdigital/dp-net/http"
 	"github.com/ONSdigital/log.go/log"
 	"github.com/gorilla/mux"
@@ -19,6 +26,14 @@ const serviceName = "dp-census-alpha-api-proxy"
 func main() {
 	log.Namespace = serviceName
 
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		if err := runValidate(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	if err := run(); err != nil {
 		log.Event(nil, "fatal runtime error", log.Error(err), log.FATAL)
 		os.Exit(1)
@@ -33,16 +48,86 @@ func run() error {
 
 	log.Event(nil, "application configuration", log.INFO, log.Data{"values": cfg})
 
-	datastore := &cantabular.Client{
+	if cfg.SwaggerUICSSIntegrity == "" || cfg.SwaggerUIJSIntegrity == "" {
+		log.Event(nil, "swagger ui assets will be loaded without integrity checks", log.WARN, log.Data{"url": cfg.SwaggerUIURL})
+	}
+
+	ctx := context.Background()
+
+	client := &cantabular.Client{
 		Host:    cfg.FlexibleTableBuilderURL,
 		HttpCli: dphttp.NewClient(),
 	}
 
+	snapshots, err := store.NewSnapshots(cfg.SnapshotDir)
+	if err != nil {
+		return err
+	}
+
+	datastore := store.NewCodebooks(client, snapshots, cfg.CodebookCacheTTL)
+	if err := datastore.Load(ctx); err != nil {
+		return err
+	}
+
+	hub := events.NewHub(cfg.EventsBufferSize, cfg.EventsClientBuffer)
+	datastore.OnDigestChange(hub.DigestChanged)
+	datastore.OnHealthChange(hub.HealthChanged)
+
+	if len(cfg.WebhookURLs) > 0 {
+		notifier := webhooks.NewNotifier(cfg.WebhookURLs, cfg.WebhookSecret)
+		notifier.MaxAttempts = cfg.WebhookMaxAttempts
+		notifier.Backoff = cfg.WebhookRetryBackoff
+		notifier.DeadLetters = cfg.WebhookDeadLetterLog
+
+		datastore.OnDigestChange(notifier.Notify)
+		go notifier.Run(ctx)
+	}
+
+	refresher := &store.Refresher{
+		Codebooks:   datastore,
+		Lister:      client,
+		Datasets:    cfg.PrefetchDatasets,
+		Concurrency: cfg.PrefetchConcurrency,
+		Interval:    cfg.RefreshInterval,
+		OnRefresh:   hub.Refreshed,
+	}
+	go refresher.Run(ctx)
+
 	r := mux.NewRouter()
 	authToken := cfg.GetAuthToken()
 
-	app := api.Setup(nil, r, middleware.Auth(authToken), datastore)
-	withMiddleware := alice.New(middleware.RequestID).Then(app.Router)
+	app := api.Setup(nil, r, cfg, middleware.Auth(authToken), datastore)
+	app.Events = hub
+
+	if cfg.DisclosureControlRules != "" {
+		if app.Disclosure, err = disclosure.Load(cfg.DisclosureControlRules); err != nil {
+			return err
+		}
+	}
+
+	jobStore, err := jobs.NewStore(cfg.JobsDir)
+	if err != nil {
+		return err
+	}
+	// a job is not retried, as a query that timed out once would again,
+	// and is given longer than a request would be
+	jobHTTPCli := dphttp.NewClient()
+	jobHTTPCli.SetMaxRetries(0)
+	jobHTTPCli.SetTimeout(cfg.JobQueryTimeout)
+	app.JobQuerier = &cantabular.Client{
+		Host:    cfg.FlexibleTableBuilderURL,
+		HttpCli: jobHTTPCli,
+	}
+
+	if app.Jobs, err = jobs.NewQueue(jobStore, app.RunQueryJob, cfg.JobWorkers, cfg.JobQueueSize, cfg.JobRetention); err != nil {
+		return err
+	}
+	go app.Jobs.Run(ctx)
+
+	withMiddleware := alice.New(
+		middleware.RequestID,
+		middleware.Compress(cfg.CompressionMinSize, cfg.CompressionContentTypes),
+	).Then(app.Router)
 
 	log.Event(nil, "starting ftb proxy api", log.INFO, log.Data{"port": cfg.BindAddr})
 	return http.ListenAndServe(cfg.BindAddr, withMiddleware)
@@ -0,0 +1,312 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+const (
+	acceptEncodingHeader  = "Accept-Encoding"
+	contentEncodingHeader = "Content-Encoding"
+
+	encodingGzip   = "gzip"
+	encodingBrotli = "br"
+)
+
+// supportedEncodings in order of preference when the client rates them equally
+var supportedEncodings = []string{encodingBrotli, encodingGzip}
+
+// Compress returns middleware that compresses response bodies with gzip or
+// brotli, negotiated from the request Accept-Encoding header. Responses
+// smaller than minSize bytes, or with a content type not in contentTypes, are
+// written unchanged.
+func Compress(minSize int, contentTypes []string) func(http.Handler) http.Handler {
+	allowed := make(map[string]bool)
+	for _, t := range contentTypes {
+		allowed[strings.ToLower(strings.TrimSpace(t))] = true
+	}
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", acceptEncodingHeader)
+
+			encoding := NegotiateEncoding(r.Header.Get(acceptEncodingHeader))
+			if encoding == "" || r.Method == http.MethodHead {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			inm := r.Header.Get("If-None-Match")
+			if inm != "" {
+				r.Header.Set("If-None-Match", stripEncodingSuffixes(inm))
+			}
+
+			cw := &compressWriter{
+				ResponseWriter: w,
+				encoding:       encoding,
+				minSize:        minSize,
+				contentTypes:   allowed,
+				ifNoneMatch:    inm,
+			}
+			defer cw.Close()
+
+			h.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// NegotiateEncoding returns the supported content coding the client most
+// prefers from the value of an Accept-Encoding header, or an empty string if
+// the response should not be compressed.
+func NegotiateEncoding(acceptEncoding string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+
+	weights := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, q := parseQualityValue(part)
+		if coding == "" {
+			continue
+		}
+		weights[coding] = q
+	}
+
+	best := ""
+	bestQ := 0.0
+	for _, enc := range supportedEncodings {
+		q, ok := weights[enc]
+		if !ok {
+			q, ok = weights["*"]
+		}
+
+		if ok && q > bestQ {
+			best = enc
+			bestQ = q
+		}
+	}
+
+	return best
+}
+
+// stripEncodingSuffixes removes the content coding suffix added to ETags of
+// compressed responses, so handlers can compare them with their own tags.
+func stripEncodingSuffixes(ifNoneMatch string) string {
+	for _, enc := range supportedEncodings {
+		ifNoneMatch = strings.Replace(ifNoneMatch, "-"+enc+`"`, `"`, -1)
+	}
+	return ifNoneMatch
+}
+
+// encodedETag suffixes a strong ETag with a content coding
+func encodedETag(etag, encoding string) string {
+	return strings.TrimSuffix(etag, `"`) + "-" + encoding + `"`
+}
+
+func parseQualityValue(part string) (string, float64) {
+	fields := strings.Split(part, ";")
+	coding := strings.ToLower(strings.TrimSpace(fields[0]))
+	q := 1.0
+
+	for _, param := range fields[1:] {
+		param = strings.TrimSpace(param)
+		if !strings.HasPrefix(param, "q=") {
+			continue
+		}
+
+		v, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64)
+		if err == nil {
+			q = v
+		}
+	}
+
+	return coding, q
+}
+
+// compressWriter buffers the start of a response until it knows whether the
+// body is large enough and of a suitable type to be worth compressing.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding     string
+	minSize      int
+	contentTypes map[string]bool
+	ifNoneMatch  string
+
+	status      int
+	wroteHeader bool
+	decided     bool
+	buf         bytes.Buffer
+	encoder     io.WriteCloser
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+	cw.status = status
+
+	if status == http.StatusNotModified {
+		cw.notModifiedETag()
+	}
+
+	if !bodyAllowed(status) || cw.Header().Get(contentEncodingHeader) != "" {
+		cw.decided = true
+		cw.ResponseWriter.WriteHeader(status)
+	}
+}
+
+// notModifiedETag suffixes the ETag of a 304 with the content coding when the
+// client validated the compressed response, so the tag matches the one the
+// 200 carried.
+func (cw *compressWriter) notModifiedETag() {
+	etag := cw.Header().Get("ETag")
+	if !strings.HasPrefix(etag, `"`) {
+		return
+	}
+
+	encoded := encodedETag(etag, cw.encoding)
+	if strings.Contains(cw.ifNoneMatch, encoded) {
+		cw.Header().Set("ETag", encoded)
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	if cw.decided {
+		if cw.encoder != nil {
+			return cw.encoder.Write(b)
+		}
+		return cw.ResponseWriter.Write(b)
+	}
+
+	n, _ := cw.buf.Write(b)
+	if cw.buf.Len() >= cw.minSize {
+		if err := cw.decide(cw.compressible()); err != nil {
+			return 0, err
+		}
+	}
+
+	return n, nil
+}
+
+// Flush sends any buffered data to the client. A response that is flushed
+// before reaching the size threshold is a stream, so it is compressed
+// regardless of its size as long as its content type allows it.
+func (cw *compressWriter) Flush() {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	if !cw.decided {
+		if err := cw.decide(cw.typeAllowed()); err != nil {
+			return
+		}
+	}
+
+	if f, ok := cw.encoder.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack allows websocket style handlers to take over the connection.
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := cw.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, errors.New("response writer does not support hijacking")
+}
+
+// Close writes out anything still buffered and finishes the compressed stream.
+func (cw *compressWriter) Close() error {
+	if !cw.wroteHeader {
+		return nil
+	}
+
+	if !cw.decided {
+		if err := cw.decide(cw.compressible()); err != nil {
+			return err
+		}
+	}
+
+	if cw.encoder != nil {
+		return cw.encoder.Close()
+	}
+	return nil
+}
+
+func (cw *compressWriter) compressible() bool {
+	return cw.buf.Len() >= cw.minSize && cw.typeAllowed()
+}
+
+func (cw *compressWriter) typeAllowed() bool {
+	mediaType, _, err := mime.ParseMediaType(cw.Header().Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	return cw.contentTypes[strings.ToLower(mediaType)]
+}
+
+func (cw *compressWriter) decide(compress bool) error {
+	cw.decided = true
+
+	if compress {
+		cw.Header().Set(contentEncodingHeader, cw.encoding)
+		cw.Header().Del("Content-Length")
+		if etag := cw.Header().Get("ETag"); strings.HasPrefix(etag, `"`) {
+			// a strong ETag has to differ between content codings
+			cw.Header().Set("ETag", encodedETag(etag, cw.encoding))
+		}
+		cw.encoder = newEncoder(cw.encoding, cw.ResponseWriter)
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	if cw.buf.Len() == 0 {
+		return nil
+	}
+
+	var err error
+	if cw.encoder != nil {
+		_, err = cw.encoder.Write(cw.buf.Bytes())
+	} else {
+		_, err = cw.ResponseWriter.Write(cw.buf.Bytes())
+	}
+	cw.buf.Reset()
+
+	return err
+}
+
+func newEncoder(encoding string, w io.Writer) io.WriteCloser {
+	if encoding == encodingBrotli {
+		return brotli.NewWriterLevel(w, brotli.DefaultCompression)
+	}
+	return gzip.NewWriter(w)
+}
+
+func bodyAllowed(status int) bool {
+	switch {
+	case status >= 100 && status <= 199:
+		return false
+	case status == http.StatusNoContent, status == http.StatusNotModified:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/openapi"
+)
+
+// Validate returns middleware that checks requests and responses against an
+// OpenAPI document, passing each mismatch to report as an
+// *openapi.RequestError or *openapi.ResponseError. Requests are served
+// whether or not they are valid. Responses are buffered to be checked, so it
+// is meant for tests and debugging rather than serving streams to clients,
+// and belongs inside Compress so it sees bodies as the handlers write them.
+func Validate(doc *openapi.Document, report func(*http.Request, error)) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodOptions {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			op, err := doc.ValidateRequest(r)
+			if err != nil {
+				report(r, err)
+			}
+
+			if op == nil {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			rec := &recordingWriter{ResponseWriter: w, status: http.StatusOK}
+			h.ServeHTTP(rec, r)
+
+			if err := doc.ValidateResponse(r, op, rec.status, w.Header(), rec.body.Bytes()); err != nil {
+				report(r, err)
+			}
+
+			w.WriteHeader(rec.status)
+			w.Write(rec.body.Bytes())
+		})
+	}
+}
+
+// recordingWriter holds the status and body of a response until it has been
+// validated
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rw *recordingWriter) WriteHeader(status int) {
+	rw.status = status
+}
+
+func (rw *recordingWriter) Write(b []byte) (int, error) {
+	return rw.body.Write(b)
+}
+
+// Flush does nothing, as the response is written once it has been validated
+func (rw *recordingWriter) Flush() {}
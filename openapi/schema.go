@@ -0,0 +1,302 @@
+package openapi
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const componentsPrefix = "#/components/schemas/"
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Schema returns the schema of the JSON encoding of v. Named struct types are
+// added to the components of the document and referred to, so a type is
+// described once however many responses use it. A type named the same as one
+// already registered from another package is prefixed with its package.
+//
+// Types with their own MarshalJSON are described by their fields, so any
+// difference in their encoding is for the caller to patch into the component.
+// The exception is time.Time, described as the date-time string it encodes as.
+func (d *Document) Schema(v interface{}) *Schema {
+	return d.schemaOf(reflect.TypeOf(v))
+}
+
+// Component returns the component a Go type was registered as, if it was
+func (d *Document) Component(v interface{}) (*Schema, bool) {
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	name, ok := d.names[t]
+	if !ok {
+		return nil, false
+	}
+	return d.Components.Schemas[name], true
+}
+
+func (d *Document) schemaOf(t reflect.Type) *Schema {
+	if t == nil {
+		return &Schema{}
+	}
+	if t == timeType {
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return nullable(d.schemaOf(t.Elem()))
+	case reflect.Interface:
+		return &Schema{}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte", Nullable: true}
+		}
+		return &Schema{Type: "array", Items: d.schemaOf(t.Elem()), Nullable: true}
+	case reflect.Array:
+		return &Schema{Type: "array", Items: d.schemaOf(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: d.schemaOf(t.Elem()), Nullable: true}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return d.structSchema(t)
+		}
+		return &Schema{Ref: componentsPrefix + d.register(t)}
+	}
+
+	return &Schema{}
+}
+
+// register adds a named struct to the components, returning its name
+func (d *Document) register(t reflect.Type) string {
+	if name, ok := d.names[t]; ok {
+		return name
+	}
+
+	name := exported(t.Name())
+	if _, taken := d.Components.Schemas[name]; taken {
+		name = qualifier(t.PkgPath()) + name
+	}
+
+	// the name is reserved before the fields are described so recursive
+	// types refer to themselves
+	d.names[t] = name
+	d.Components.Schemas[name] = &Schema{}
+	*d.Components.Schemas[name] = *d.structSchema(t)
+	return name
+}
+
+func (d *Document) structSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+
+	for _, f := range jsonFields(t) {
+		fs := d.schemaOf(f.typ)
+		if f.omitEmpty {
+			fs = notNullable(fs)
+		} else {
+			s.Required = append(s.Required, f.name)
+		}
+		s.Properties[f.name] = fs
+	}
+
+	sort.Strings(s.Required)
+	return s
+}
+
+// nullable allows null as well as the schema, which for a reference means
+// wrapping it as siblings of $ref are ignored
+func nullable(s *Schema) *Schema {
+	if s.Nullable || (s.Type == "" && s.Ref == "" && len(s.AllOf) == 0) {
+		return s
+	}
+	if s.Ref != "" {
+		return &Schema{AllOf: []*Schema{s}, Nullable: true}
+	}
+
+	n := *s
+	n.Nullable = true
+	return &n
+}
+
+// notNullable removes the null a schema allows, for a field that is omitted
+// rather than encoded as null
+func notNullable(s *Schema) *Schema {
+	if !s.Nullable {
+		return s
+	}
+	if len(s.AllOf) == 1 && s.Type == "" {
+		return s.AllOf[0]
+	}
+
+	n := *s
+	n.Nullable = false
+	return &n
+}
+
+// field is a field of the JSON encoding of a struct
+type field struct {
+	name      string
+	typ       reflect.Type
+	omitEmpty bool
+	tagged    bool
+	depth     int
+}
+
+// jsonFields lists the fields encoding/json writes for a struct, in order,
+// following the fields of embedded structs the same way it does: the
+// shallowest field of a name wins, then the only tagged one, and a name
+// still ambiguous is left out.
+func jsonFields(t reflect.Type) []field {
+	var all []field
+	collectFields(t, 0, map[reflect.Type]bool{}, &all)
+
+	byName := make(map[string][]field)
+	var order []string
+	for _, f := range all {
+		if _, seen := byName[f.name]; !seen {
+			order = append(order, f.name)
+		}
+		byName[f.name] = append(byName[f.name], f)
+	}
+
+	fields := make([]field, 0, len(order))
+	for _, name := range order {
+		if f, ok := dominantField(byName[name]); ok {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+func collectFields(t reflect.Type, depth int, visited map[reflect.Type]bool, out *[]field) {
+	if visited[t] {
+		return
+	}
+	visited[t] = true
+
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+
+		tag := sf.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name, opts := tag, ""
+		if i := strings.Index(tag, ","); i >= 0 {
+			name, opts = tag[:i], tag[i+1:]
+		}
+
+		ft := sf.Type
+		if sf.Anonymous && name == "" {
+			et := ft
+			if et.Kind() == reflect.Ptr {
+				et = et.Elem()
+			}
+			if et.Kind() == reflect.Struct {
+				collectFields(et, depth+1, visited, out)
+				continue
+			}
+		}
+
+		if sf.PkgPath != "" {
+			continue
+		}
+
+		tagged := name != ""
+		if !tagged {
+			name = sf.Name
+		}
+
+		*out = append(*out, field{
+			name:      name,
+			typ:       ft,
+			omitEmpty: hasOption(opts, "omitempty"),
+			tagged:    tagged,
+			depth:     depth,
+		})
+	}
+}
+
+func dominantField(fields []field) (field, bool) {
+	depth := fields[0].depth
+	for _, f := range fields {
+		if f.depth < depth {
+			depth = f.depth
+		}
+	}
+
+	var shallowest []field
+	for _, f := range fields {
+		if f.depth == depth {
+			shallowest = append(shallowest, f)
+		}
+	}
+
+	if len(shallowest) == 1 {
+		return shallowest[0], true
+	}
+
+	var tagged []field
+	for _, f := range shallowest {
+		if f.tagged {
+			tagged = append(tagged, f)
+		}
+	}
+	if len(tagged) == 1 {
+		return tagged[0], true
+	}
+	return field{}, false
+}
+
+func hasOption(opts, option string) bool {
+	for _, o := range strings.Split(opts, ",") {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
+
+// qualifier names the package a type is from: its last path element, or the
+// module it belongs to for a package named models, without the dp- and -api
+// that the names of ONS modules carry.
+func qualifier(pkgPath string) string {
+	parts := strings.Split(pkgPath, "/")
+	name := parts[len(parts)-1]
+	if name == "models" && len(parts) > 1 {
+		name = parts[len(parts)-2]
+	}
+
+	name = strings.TrimSuffix(strings.TrimPrefix(name, "dp-"), "-api")
+
+	var b strings.Builder
+	for _, word := range strings.FieldsFunc(name, func(r rune) bool { return r == '-' || r == '_' || r == '.' }) {
+		b.WriteString(exported(word))
+	}
+	return b.String()
+}
+
+func exported(name string) string {
+	if name == "" {
+		return name
+	}
+	r := []rune(name)
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
@@ -0,0 +1,362 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// RequestError is a request that does not match the document
+type RequestError struct {
+	Method string
+	Path   string
+	Reason string
+}
+
+func (e *RequestError) Error() string {
+	return fmt.Sprintf("request %s %s: %s", e.Method, e.Path, e.Reason)
+}
+
+// ResponseError is a response that does not match the document
+type ResponseError struct {
+	Method string
+	Path   string
+	Status int
+	Reason string
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("response %d to %s %s: %s", e.Status, e.Method, e.Path, e.Reason)
+}
+
+// ValidateRequest checks a request has an operation documented for it and
+// the parameters it requires, each with a valid value. It returns the
+// operation so its response can be checked, which is nil when there is none.
+func (d *Document) ValidateRequest(r *http.Request) (*Operation, error) {
+	op, pathParams, ok := d.Find(r.Method, r.URL.Path)
+	if !ok {
+		return nil, &RequestError{Method: r.Method, Path: r.URL.Path, Reason: "no operation documented"}
+	}
+
+	fail := func(format string, args ...interface{}) error {
+		return &RequestError{Method: r.Method, Path: r.URL.Path, Reason: fmt.Sprintf(format, args...)}
+	}
+
+	query := r.URL.Query()
+	for _, p := range op.Parameters {
+		var values []string
+		switch p.In {
+		case "path":
+			if v, ok := pathParams[p.Name]; ok {
+				values = []string{v}
+			}
+		case "query":
+			values = query[p.Name]
+		case "header":
+			if v := r.Header.Get(p.Name); v != "" {
+				values = []string{v}
+			}
+		}
+
+		if len(values) == 0 {
+			if p.Required {
+				return op, fail("missing required %s parameter %s", p.In, p.Name)
+			}
+			continue
+		}
+
+		if err := d.validateParameter(p, values); err != nil {
+			return op, fail("%s parameter %s: %s", p.In, p.Name, err)
+		}
+	}
+
+	if len(op.Security) > 0 && r.Header.Get("Authorization") == "" {
+		return op, fail("missing Authorization header")
+	}
+
+	return op, nil
+}
+
+func (d *Document) validateParameter(p *Parameter, values []string) error {
+	s := d.resolve(p.Schema)
+
+	if s.Type != "array" {
+		if len(values) > 1 {
+			return fmt.Errorf("given %d times", len(values))
+		}
+		return d.validateValue(s, values[0])
+	}
+
+	for _, v := range values {
+		if err := d.validateValue(d.resolve(s.Items), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateValue checks the string value of a parameter against its schema
+func (d *Document) validateValue(s *Schema, value string) error {
+	var v interface{} = value
+	switch s.Type {
+	case "integer":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		v = json.Number(strconv.FormatInt(n, 10))
+	case "number":
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		v = json.Number(value)
+	case "boolean":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", value)
+		}
+		v = b
+	}
+	return d.Validate(s, v)
+}
+
+// ValidateResponse checks the status, media type and, for JSON, the body of
+// a response are documented for the operation
+func (d *Document) ValidateResponse(r *http.Request, op *Operation, status int, header http.Header, body []byte) error {
+	fail := func(format string, args ...interface{}) error {
+		return &ResponseError{Method: r.Method, Path: r.URL.Path, Status: status, Reason: fmt.Sprintf(format, args...)}
+	}
+
+	resp, ok := op.Responses[strconv.Itoa(status)]
+	if !ok {
+		resp, ok = op.Responses["default"]
+	}
+	if !ok {
+		return fail("status not documented")
+	}
+
+	if len(resp.Content) == 0 {
+		if len(bytes.TrimSpace(body)) > 0 {
+			return fail("body given for a response documented without one")
+		}
+		return nil
+	}
+
+	contentType := header.Get("Content-Type")
+	media, ok := resp.Content[contentType]
+	if !ok {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return fail("invalid Content-Type %q", contentType)
+		}
+		if media, ok = resp.Content[mediaType]; !ok {
+			return fail("Content-Type %s not documented", contentType)
+		}
+		contentType = mediaType
+	}
+
+	if media.Schema == nil || !IsJSON(contentType) {
+		return nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return fail("invalid JSON body: %s", err)
+	}
+
+	if err := d.Validate(media.Schema, v); err != nil {
+		return fail("%s", err)
+	}
+	return nil
+}
+
+// Validate checks a value decoded from JSON, with numbers as json.Number,
+// against a schema
+func (d *Document) Validate(s *Schema, v interface{}) error {
+	return d.validate(s, v, "$")
+}
+
+func (d *Document) validate(s *Schema, v interface{}, path string) error {
+	s = d.resolve(s)
+
+	if v == nil {
+		if s.Nullable || isAny(s) {
+			return nil
+		}
+		return fmt.Errorf("%s: null is not allowed", path)
+	}
+
+	for _, sub := range s.AllOf {
+		if err := d.validate(sub, v, path); err != nil {
+			return err
+		}
+	}
+
+	if len(s.AnyOf) > 0 {
+		var errs []string
+		for _, sub := range s.AnyOf {
+			err := d.validate(sub, v, path)
+			if err == nil {
+				errs = nil
+				break
+			}
+			errs = append(errs, err.Error())
+		}
+		if errs != nil {
+			return fmt.Errorf("%s: matches none of the allowed schemas (%s)", path, strings.Join(errs, "; "))
+		}
+	}
+
+	switch s.Type {
+	case "":
+		return nil
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return typeError(path, s.Type, v)
+		}
+		return d.validateObject(s, obj, path)
+	case "array":
+		arr, ok := v.([]interface{})
+		if !ok {
+			return typeError(path, s.Type, v)
+		}
+		for i, item := range arr {
+			if err := d.validate(s.Items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return typeError(path, s.Type, v)
+		}
+		if err := validateEnum(s, str, path); err != nil {
+			return err
+		}
+		return validatePattern(s, str, path)
+	case "integer":
+		n, ok := v.(json.Number)
+		if !ok {
+			return typeError(path, s.Type, v)
+		}
+		if _, err := n.Int64(); err != nil {
+			return typeError(path, s.Type, v)
+		}
+		return validateMinimum(s, n, path)
+	case "number":
+		n, ok := v.(json.Number)
+		if !ok {
+			return typeError(path, s.Type, v)
+		}
+		return validateMinimum(s, n, path)
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return typeError(path, s.Type, v)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("%s: unsupported schema type %s", path, s.Type)
+}
+
+func (d *Document) validateObject(s *Schema, obj map[string]interface{}, path string) error {
+	for _, name := range s.Required {
+		if _, ok := obj[name]; !ok {
+			return fmt.Errorf("%s: missing required property %s", path, name)
+		}
+	}
+
+	for name, value := range obj {
+		ps, ok := s.Properties[name]
+		if !ok {
+			ps = s.AdditionalProperties
+		}
+		if ps == nil {
+			continue
+		}
+
+		if err := d.validate(ps, value, path+"."+name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolve follows a reference to its component
+func (d *Document) resolve(s *Schema) *Schema {
+	for s != nil && s.Ref != "" {
+		s = d.Components.Schemas[strings.TrimPrefix(s.Ref, componentsPrefix)]
+	}
+	if s == nil {
+		return &Schema{}
+	}
+	return s
+}
+
+func isAny(s *Schema) bool {
+	return s.Type == "" && len(s.AllOf) == 0 && len(s.AnyOf) == 0
+}
+
+func validateEnum(s *Schema, v, path string) error {
+	if len(s.Enum) == 0 {
+		return nil
+	}
+	for _, e := range s.Enum {
+		if e == v {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s: %q is not one of %s", path, v, strings.Join(s.Enum, ", "))
+}
+
+func validatePattern(s *Schema, v, path string) error {
+	if s.Pattern == "" {
+		return nil
+	}
+	re, err := regexp.Compile(s.Pattern)
+	if err != nil {
+		return fmt.Errorf("%s: invalid pattern %s: %s", path, s.Pattern, err)
+	}
+	if !re.MatchString(v) {
+		return fmt.Errorf("%s: %q does not match %s", path, v, s.Pattern)
+	}
+	return nil
+}
+
+func validateMinimum(s *Schema, n json.Number, path string) error {
+	if s.Minimum == nil {
+		return nil
+	}
+	f, err := n.Float64()
+	if err != nil || f < *s.Minimum {
+		return fmt.Errorf("%s: %s is less than %v", path, n, *s.Minimum)
+	}
+	return nil
+}
+
+func typeError(path, want string, v interface{}) error {
+	got := "unknown"
+	switch v.(type) {
+	case map[string]interface{}:
+		got = "object"
+	case []interface{}:
+		got = "array"
+	case string:
+		got = "string"
+	case json.Number:
+		got = "number"
+	case bool:
+		got = "boolean"
+	}
+	return fmt.Errorf("%s: expected %s, got %s", path, want, got)
+}
@@ -0,0 +1,327 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/cantabular"
+	"github.com/ONSdigital/log.go/log"
+)
+
+// Upstream is the source of truth for codebooks, normally a cantabular.Client
+type Upstream interface {
+	GetData(ctx context.Context, url string) (cantabular.Entity, error)
+	Query(ctx context.Context, dataset string, variables []string) (*cantabular.Table, error)
+	GetDatasetCodebook(ctx context.Context, dataset string) (*cantabular.Codebook, error)
+	GetDatasets(ctx context.Context) (*cantabular.Datasets, error)
+}
+
+// Codebooks caches codebooks in memory, persisting every version it sees as
+// a snapshot. When the FTB cannot be reached the last known snapshot of a
+// dataset is served with Stale set.
+type Codebooks struct {
+	Upstream  Upstream
+	Snapshots *Snapshots
+	TTL       time.Duration
+
+	mu              sync.RWMutex
+	entries         map[string]*entry
+	listeners       []DigestListener
+	healthListeners []HealthListener
+	unavailable     bool
+}
+
+// DigestListener is told what changed when a new digest is seen for a
+// dataset. It is called from the request or refresh that fetched the new
+// codebook, so it must not block.
+type DigestListener func(ctx context.Context, diff *cantabular.CodebookDiff)
+
+// HealthListener is told when fetching codebooks from the FTB starts or stops
+// failing because it cannot answer, with the error that made it unavailable.
+// Like a DigestListener it must not block.
+type HealthListener func(ctx context.Context, available bool, err error)
+
+type entry struct {
+	codebook *cantabular.Codebook
+	checked  time.Time
+	stale    bool
+}
+
+// NewCodebooks returns a codebook cache in front of upstream. snapshots may be
+// nil, in which case codebooks are only held in memory.
+func NewCodebooks(upstream Upstream, snapshots *Snapshots, ttl time.Duration) *Codebooks {
+	return &Codebooks{
+		Upstream:  upstream,
+		Snapshots: snapshots,
+		TTL:       ttl,
+		entries:   make(map[string]*entry),
+	}
+}
+
+// GetData passes the request straight through to the FTB
+func (c *Codebooks) GetData(ctx context.Context, url string) (cantabular.Entity, error) {
+	return c.Upstream.GetData(ctx, url)
+}
+
+// GetDatasets passes the request for the list of datasets straight through
+// to the FTB
+func (c *Codebooks) GetDatasets(ctx context.Context) (*cantabular.Datasets, error) {
+	return c.Upstream.GetDatasets(ctx)
+}
+
+// Query passes the query straight through to the FTB
+func (c *Codebooks) Query(ctx context.Context, dataset string, variables []string) (*cantabular.Table, error) {
+	return c.Upstream.Query(ctx, dataset, variables)
+}
+
+// GetDatasetCodebook returns the codebook for a dataset from memory if it was
+// checked within the TTL, otherwise from the FTB, falling back to the last
+// known snapshot if the FTB is unavailable.
+func (c *Codebooks) GetDatasetCodebook(ctx context.Context, dataset string) (*cantabular.Codebook, error) {
+	c.mu.RLock()
+	e, ok := c.entries[dataset]
+	c.mu.RUnlock()
+
+	if ok && time.Since(e.checked) < c.TTL {
+		return e.get(), nil
+	}
+
+	return c.Refresh(ctx, dataset)
+}
+
+// Refresh fetches the codebook for a dataset from the FTB, regardless of the
+// age of the cached copy.
+func (c *Codebooks) Refresh(ctx context.Context, dataset string) (*cantabular.Codebook, error) {
+	cb, err := c.Upstream.GetDatasetCodebook(ctx, dataset)
+	if err == nil {
+		c.setAvailable(ctx, true, nil)
+		c.store(ctx, dataset, cb)
+		return cb, nil
+	}
+
+	if ctx.Err() != nil {
+		// a caller giving up says nothing about the FTB, so the cached
+		// codebook is left as it is
+		return nil, ctx.Err()
+	}
+
+	if !unavailable(err) {
+		c.setAvailable(ctx, true, nil)
+		return nil, err
+	}
+	c.setAvailable(ctx, false, err)
+
+	stale := c.fallback(dataset)
+	if stale == nil {
+		return nil, err
+	}
+
+	log.Event(ctx, "flexible table builder unavailable serving stale codebook", log.WARN, log.Error(err), log.Data{
+		"dataset": dataset,
+		"digest":  stale.Dataset.Digest,
+	})
+
+	c.mu.Lock()
+	// back off from the FTB for a TTL rather than retrying on every request
+	c.entries[dataset] = &entry{codebook: stale, checked: time.Now(), stale: true}
+	c.mu.Unlock()
+
+	return withStale(stale), nil
+}
+
+// Load reads the latest snapshot of every dataset into memory. They are
+// treated as stale until they have been revalidated against the FTB.
+func (c *Codebooks) Load(ctx context.Context) error {
+	if c.Snapshots == nil {
+		return nil
+	}
+
+	datasets, err := c.Snapshots.Datasets()
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, dataset := range datasets {
+		cb, err := c.Snapshots.Latest(dataset)
+		if err != nil {
+			log.Event(ctx, "failed to load codebook snapshot", log.WARN, log.Error(err), log.Data{"dataset": dataset})
+			continue
+		}
+
+		c.entries[dataset] = &entry{codebook: cb, stale: true}
+	}
+
+	log.Event(ctx, "loaded codebook snapshots", log.INFO, log.Data{"datasets": len(c.entries)})
+	return nil
+}
+
+// Datasets lists the datasets with a codebook held in memory
+func (c *Codebooks) Datasets() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	datasets := make([]string, 0, len(c.entries))
+	for dataset := range c.entries {
+		datasets = append(datasets, dataset)
+	}
+	return datasets
+}
+
+// Digests lists the digests of the codebook versions seen for a dataset,
+// oldest first
+func (c *Codebooks) Digests(ctx context.Context, dataset string) ([]string, error) {
+	if c.Snapshots == nil {
+		return nil, ErrSnapshotNotFound
+	}
+	return c.Snapshots.Digests(dataset)
+}
+
+// Snapshot returns the version of a dataset codebook with the given digest,
+// from memory if it is the current one, otherwise from the snapshot history.
+func (c *Codebooks) Snapshot(ctx context.Context, dataset, digest string) (*cantabular.Codebook, error) {
+	c.mu.RLock()
+	e, ok := c.entries[dataset]
+	c.mu.RUnlock()
+
+	if ok && e.codebook.Dataset.Digest == digest {
+		return e.codebook, nil
+	}
+
+	if c.Snapshots == nil {
+		return nil, ErrSnapshotNotFound
+	}
+	return c.Snapshots.Load(dataset, digest)
+}
+
+func (c *Codebooks) store(ctx context.Context, dataset string, cb *cantabular.Codebook) {
+	c.mu.Lock()
+	previous := c.entries[dataset]
+	c.entries[dataset] = &entry{codebook: cb, checked: time.Now()}
+	c.mu.Unlock()
+
+	if previous != nil && previous.codebook.Dataset.Digest == cb.Dataset.Digest && len(cb.Dataset.Digest) > 0 {
+		return
+	}
+
+	if previous != nil {
+		diff := cantabular.Diff(previous.codebook, cb)
+		log.Event(ctx, "dataset digest changed", log.INFO, log.Data{
+			"dataset": dataset,
+			"from":    previous.codebook.Dataset.Digest,
+			"to":      cb.Dataset.Digest,
+			"changes": diff.Summary(),
+		})
+
+		if diff.From != diff.To {
+			c.notify(ctx, diff)
+		}
+	}
+
+	if c.Snapshots == nil {
+		return
+	}
+
+	if err := c.Snapshots.Save(cb); err != nil {
+		log.Event(ctx, "failed to save codebook snapshot", log.ERROR, log.Error(err), log.Data{"dataset": dataset})
+	}
+}
+
+// OnDigestChange adds a listener told whenever the digest of a dataset
+// changes from the one last seen, including one loaded from a snapshot
+func (c *Codebooks) OnDigestChange(l DigestListener) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.listeners = append(c.listeners, l)
+}
+
+func (c *Codebooks) notify(ctx context.Context, diff *cantabular.CodebookDiff) {
+	c.mu.RLock()
+	listeners := c.listeners
+	c.mu.RUnlock()
+
+	for _, l := range listeners {
+		l(ctx, diff)
+	}
+}
+
+// OnHealthChange adds a listener told whenever the FTB becomes unavailable or
+// recovers. The FTB is taken to be available until a fetch shows otherwise.
+func (c *Codebooks) OnHealthChange(l HealthListener) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.healthListeners = append(c.healthListeners, l)
+}
+
+// setAvailable records whether the FTB answered a fetch, telling the health
+// listeners if that has changed
+func (c *Codebooks) setAvailable(ctx context.Context, available bool, err error) {
+	c.mu.Lock()
+	if c.unavailable == !available {
+		c.mu.Unlock()
+		return
+	}
+	c.unavailable = !available
+	listeners := c.healthListeners
+	c.mu.Unlock()
+
+	if available {
+		log.Event(ctx, "flexible table builder available again", log.INFO)
+	} else {
+		log.Event(ctx, "flexible table builder unavailable", log.WARN, log.Error(err))
+	}
+
+	for _, l := range listeners {
+		l(ctx, available, err)
+	}
+}
+
+func (c *Codebooks) fallback(dataset string) *cantabular.Codebook {
+	c.mu.RLock()
+	e, ok := c.entries[dataset]
+	c.mu.RUnlock()
+
+	if ok {
+		return e.codebook
+	}
+
+	if c.Snapshots == nil {
+		return nil
+	}
+
+	cb, err := c.Snapshots.Latest(dataset)
+	if err != nil {
+		return nil
+	}
+	return cb
+}
+
+func (e *entry) get() *cantabular.Codebook {
+	if e.stale {
+		return withStale(e.codebook)
+	}
+	return e.codebook
+}
+
+// withStale returns a shallow copy of the codebook flagged as stale, leaving
+// the cached value untouched.
+func withStale(cb *cantabular.Codebook) *cantabular.Codebook {
+	stale := *cb
+	stale.Stale = true
+	return &stale
+}
+
+// unavailable reports whether an error means the FTB could not answer, as
+// opposed to it answering that the dataset does not exist.
+func unavailable(err error) bool {
+	var ftbErr cantabular.Error
+	if errors.As(err, &ftbErr) {
+		return ftbErr.StatusCode >= http.StatusInternalServerError
+	}
+	return true
+}
@@ -0,0 +1,151 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/cantabular"
+	"github.com/ONSdigital/log.go/log"
+)
+
+// DatasetLister lists the datasets the FTB serves
+type DatasetLister interface {
+	GetDatasets(ctx context.Context) (*cantabular.Datasets, error)
+}
+
+// Refresher prefetches codebooks into the cache on startup and refreshes
+// them on a schedule, so digest changes are picked up without waiting for a
+// user request.
+type Refresher struct {
+	Codebooks   *Codebooks
+	Lister      DatasetLister
+	Datasets    []string
+	Concurrency int
+	Interval    time.Duration
+
+	// OnRefresh, if set, is told of each refresh of every target dataset
+	// that runs to completion
+	OnRefresh func(ctx context.Context, report RefreshReport)
+}
+
+// RefreshReport describes a refresh of the target datasets
+type RefreshReport struct {
+	Datasets []string
+	Failed   []string
+	Duration time.Duration
+}
+
+// Run warms the cache and then refreshes it every Interval until the context
+// is cancelled. An Interval of zero only warms the cache.
+func (r *Refresher) Run(ctx context.Context) {
+	r.RefreshAll(ctx)
+
+	if r.Interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.RefreshAll(ctx)
+		}
+	}
+}
+
+// RefreshAll fetches the codebook of every target dataset from the FTB with
+// at most Concurrency requests in flight.
+func (r *Refresher) RefreshAll(ctx context.Context) {
+	datasets := r.targets(ctx)
+	start := time.Now()
+
+	concurrency := r.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	var mu sync.Mutex
+	failed := make([]string, 0)
+
+	for _, dataset := range datasets {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case sem <- struct{}{}:
+		}
+
+		wg.Add(1)
+		go func(dataset string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			if _, err := r.Codebooks.Refresh(ctx, dataset); err != nil {
+				log.Event(ctx, "failed to refresh codebook", log.WARN, log.Error(err), log.Data{"dataset": dataset})
+
+				mu.Lock()
+				failed = append(failed, dataset)
+				mu.Unlock()
+			}
+		}(dataset)
+	}
+
+	wg.Wait()
+	duration := time.Since(start)
+	log.Event(ctx, "refreshed codebooks", log.INFO, log.Data{
+		"datasets": len(datasets),
+		"failed":   len(failed),
+		"duration": duration.String(),
+	})
+
+	if r.OnRefresh != nil {
+		sort.Strings(failed)
+		r.OnRefresh(ctx, RefreshReport{Datasets: datasets, Failed: failed, Duration: duration})
+	}
+}
+
+// targets returns the configured datasets along with those already in the
+// cache, so a dataset a request brought in is not left to go stale, or every
+// dataset the FTB lists. If the FTB cannot be listed, the datasets already in
+// the cache are revalidated.
+func (r *Refresher) targets(ctx context.Context) []string {
+	if len(r.Datasets) > 0 {
+		datasets := append([]string{}, r.Datasets...)
+		configured := make(map[string]bool, len(r.Datasets))
+		for _, d := range r.Datasets {
+			configured[d] = true
+		}
+
+		cached := r.Codebooks.Datasets()
+		sort.Strings(cached)
+		for _, d := range cached {
+			if !configured[d] {
+				datasets = append(datasets, d)
+			}
+		}
+		return datasets
+	}
+
+	list, err := r.Lister.GetDatasets(ctx)
+	if err != nil {
+		log.Event(ctx, "failed to list datasets from flexible table builder", log.WARN, log.Error(err))
+		return r.Codebooks.Datasets()
+	}
+
+	datasets := make([]string, 0, len(list.Items))
+	for _, d := range list.Items {
+		datasets = append(datasets, d.Name)
+	}
+	return datasets
+}
@@ -0,0 +1,211 @@
+package store
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/cantabular"
+)
+
+const (
+	snapshotExt = ".json"
+	latestFile  = "latest"
+)
+
+// ErrSnapshotNotFound is returned when no snapshot is stored for a dataset or digest
+var ErrSnapshotNotFound = errors.New("codebook snapshot not found")
+
+// Snapshots persists codebooks to a local directory, one file per dataset
+// digest, so they survive restarts and outages of the FTB.
+type Snapshots struct {
+	Dir string
+}
+
+// NewSnapshots returns a snapshot store rooted at dir, creating it if needed
+func NewSnapshots(dir string) (*Snapshots, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Snapshots{Dir: dir}, nil
+}
+
+// Key returns the key a codebook is stored under, which is its digest, or a
+// hash of its content if the FTB did not provide one.
+func Key(cb *cantabular.Codebook) (string, error) {
+	if len(cb.Dataset.Digest) > 0 {
+		return cb.Dataset.Digest, nil
+	}
+
+	b, err := json.Marshal(cb)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Save writes the codebook snapshot and marks it as the latest for its dataset
+func (s *Snapshots) Save(cb *cantabular.Codebook) error {
+	key, err := Key(cb)
+	if err != nil {
+		return err
+	}
+
+	dir, err := s.datasetDir(cb.Dataset.Name)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(cb)
+	if err != nil {
+		return err
+	}
+
+	if err := writeFile(filepath.Join(dir, url.PathEscape(key)+snapshotExt), b); err != nil {
+		return err
+	}
+
+	return writeFile(filepath.Join(dir, latestFile), []byte(key))
+}
+
+// Load returns the snapshot of a dataset stored under the given digest
+func (s *Snapshots) Load(dataset, digest string) (*cantabular.Codebook, error) {
+	dir, err := s.datasetDir(dataset)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, url.PathEscape(digest)+snapshotExt))
+	if os.IsNotExist(err) {
+		return nil, ErrSnapshotNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var cb cantabular.Codebook
+	if err := json.Unmarshal(b, &cb); err != nil {
+		return nil, fmt.Errorf("corrupt codebook snapshot for %s/%s: %w", dataset, digest, err)
+	}
+
+	return &cb, nil
+}
+
+// Latest returns the most recently saved snapshot of a dataset
+func (s *Snapshots) Latest(dataset string) (*cantabular.Codebook, error) {
+	dir, err := s.datasetDir(dataset)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, latestFile))
+	if os.IsNotExist(err) {
+		return nil, ErrSnapshotNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Load(dataset, strings.TrimSpace(string(b)))
+}
+
+// Datasets lists the names of all datasets with at least one snapshot
+func (s *Snapshots) Datasets() ([]string, error) {
+	entries, err := ioutil.ReadDir(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	datasets := make([]string, 0)
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+
+		name, err := url.PathUnescape(e.Name())
+		if err != nil {
+			continue
+		}
+		datasets = append(datasets, name)
+	}
+
+	sort.Strings(datasets)
+	return datasets, nil
+}
+
+// Digests lists the digests stored for a dataset, oldest first
+func (s *Snapshots) Digests(dataset string) ([]string, error) {
+	dir, err := s.datasetDir(dataset)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, ErrSnapshotNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ModTime().Before(entries[j].ModTime())
+	})
+
+	digests := make([]string, 0)
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), snapshotExt) {
+			continue
+		}
+
+		digest, err := url.PathUnescape(strings.TrimSuffix(e.Name(), snapshotExt))
+		if err != nil {
+			continue
+		}
+		digests = append(digests, digest)
+	}
+
+	return digests, nil
+}
+
+func (s *Snapshots) datasetDir(dataset string) (string, error) {
+	if dataset == "" || dataset == "." || dataset == ".." {
+		return "", fmt.Errorf("invalid dataset name for snapshot: %q", dataset)
+	}
+	return filepath.Join(s.Dir, url.PathEscape(dataset)), nil
+}
+
+// writeFile replaces the file atomically so a crash never leaves a partial snapshot
+func writeFile(path string, b []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
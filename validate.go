@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/cantabular"
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/config"
+	dphttp "github.com/ONSdigital/dp-net/http"
+)
+
+var errInvalidCodebook = errors.New("codebook failed validation")
+
+// runValidate checks the codebooks of the named datasets, fetched from the
+// FTB, or of a codebook file given with -file, writing a report for each to
+// stdout. It returns errInvalidCodebook if any codebook has errors.
+//
+//	dp-census-alpha-api-proxy validate [-file codebook.json] [dataset ...]
+func runValidate(args []string) error {
+	flags := flag.NewFlagSet("validate", flag.ContinueOnError)
+	file := flags.String("file", "", "validate a codebook read from a file rather than the FTB")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	codebooks := make([]*cantabular.Codebook, 0)
+
+	if *file != "" {
+		cb, err := readCodebook(*file)
+		if err != nil {
+			return err
+		}
+		codebooks = append(codebooks, cb)
+	}
+
+	if flags.NArg() > 0 {
+		cfg, err := config.Get()
+		if err != nil {
+			return err
+		}
+
+		client := &cantabular.Client{
+			Host:    cfg.FlexibleTableBuilderURL,
+			HttpCli: dphttp.NewClient(),
+		}
+
+		for _, dataset := range flags.Args() {
+			cb, err := client.GetDatasetCodebook(context.Background(), dataset)
+			if err != nil {
+				return fmt.Errorf("failed to get codebook for %s: %w", dataset, err)
+			}
+			codebooks = append(codebooks, cb)
+		}
+	}
+
+	if len(codebooks) == 0 {
+		flags.Usage()
+		return errors.New("no dataset or codebook file given")
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+
+	valid := true
+	for _, cb := range codebooks {
+		report := cb.Validate()
+		valid = valid && report.Valid
+		if err := enc.Encode(report); err != nil {
+			return err
+		}
+	}
+
+	if !valid {
+		return errInvalidCodebook
+	}
+	return nil
+}
+
+func readCodebook(path string) (*cantabular.Codebook, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cb cantabular.Codebook
+	if err := json.Unmarshal(b, &cb); err != nil {
+		return nil, fmt.Errorf("invalid codebook %s: %w", path, err)
+	}
+	return &cb, nil
+}
@@ -0,0 +1,94 @@
+// Package webhooks tells downstream services when the data behind a dataset
+// is reloaded, POSTing a signed event to each registered URL whenever the
+// digest of its codebook changes.
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/cantabular"
+)
+
+const (
+	// EventDigestChanged is the type of the event sent when the digest of a
+	// dataset changes
+	EventDigestChanged = "dataset.digest_changed"
+
+	// SignatureHeader holds the HMAC-SHA256 of the body of a delivery, keyed
+	// with the shared secret, as sha256=<hex>
+	SignatureHeader = "X-Signature-256"
+
+	// EventTypeHeader and EventIDHeader repeat the type and ID of the event,
+	// which stay the same across retries of a delivery
+	EventTypeHeader = "X-Event-Type"
+	EventIDHeader   = "X-Event-ID"
+
+	signaturePrefix = "sha256="
+)
+
+// Event is the body POSTed to a webhook. Changes counts what the diff between
+// the two codebooks found, and the dimension lists name them; the full diff
+// can be fetched from /v6/datasets/{dataset}/codebook/diff.
+type Event struct {
+	ID                string                 `json:"id"`
+	Type              string                 `json:"type"`
+	Created           time.Time              `json:"created"`
+	Dataset           string                 `json:"dataset"`
+	OldDigest         string                 `json:"old_digest"`
+	NewDigest         string                 `json:"new_digest"`
+	Changes           cantabular.DiffSummary `json:"changes"`
+	AddedDimensions   []string               `json:"added_dimensions"`
+	RemovedDimensions []string               `json:"removed_dimensions"`
+	ChangedDimensions []string               `json:"changed_dimensions"`
+}
+
+// NewEvent describes the change of digest a codebook diff records
+func NewEvent(diff *cantabular.CodebookDiff) (*Event, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+
+	changed := make([]string, 0, len(diff.ChangedDimensions))
+	for _, d := range diff.ChangedDimensions {
+		changed = append(changed, d.Name)
+	}
+
+	return &Event{
+		ID:                id,
+		Type:              EventDigestChanged,
+		Created:           time.Now().UTC(),
+		Dataset:           diff.Dataset,
+		OldDigest:         diff.From,
+		NewDigest:         diff.To,
+		Changes:           diff.Summary(),
+		AddedDimensions:   diff.AddedDimensions,
+		RemovedDimensions: diff.RemovedDimensions,
+		ChangedDimensions: changed,
+	}, nil
+}
+
+// Sign returns the signature of a body for the SignatureHeader
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether a signature from the SignatureHeader matches the
+// body, for receivers to check a delivery came from the proxy
+func Verify(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,275 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/cantabular"
+	"github.com/ONSdigital/log.go/log"
+)
+
+const (
+	defaultMaxAttempts = 5
+	defaultBackoff     = time.Second
+	defaultConcurrency = 4
+	defaultQueueSize   = 100
+	defaultTimeout     = 10 * time.Second
+)
+
+var (
+	// errQueueFull is recorded for events dropped because too many were waiting
+	errQueueFull = errors.New("webhook queue is full")
+
+	// errStopped is recorded for events still queued when the notifier stops
+	errStopped = errors.New("webhook notifier stopped before delivery")
+)
+
+// DeadLetter records a delivery given up on, written as a line of JSON to the
+// dead-letter log so it can be inspected or replayed by hand
+type DeadLetter struct {
+	URL      string    `json:"url"`
+	Event    *Event    `json:"event"`
+	Attempts int       `json:"attempts"`
+	Error    string    `json:"error"`
+	Failed   time.Time `json:"failed"`
+}
+
+// Notifier delivers events to every URL, retrying failed deliveries with
+// exponential backoff. Those that still fail after MaxAttempts, or are
+// rejected with a client error other than 408 or 429, go to the dead-letter
+// log. The exported fields may be changed before Run.
+type Notifier struct {
+	URLs   []string
+	Secret string
+	Client *http.Client
+
+	// MaxAttempts is the number of times a delivery is tried, and Backoff
+	// the wait before the first retry, doubling for each after
+	MaxAttempts int
+	Backoff     time.Duration
+
+	// Concurrency is the most deliveries in flight at once
+	Concurrency int
+
+	// DeadLetters is the path of the dead-letter log. Without one, deliveries
+	// given up on are only logged.
+	DeadLetters string
+
+	events chan *Event
+	mu     sync.Mutex
+}
+
+// NewNotifier returns a notifier signing events for urls with secret
+func NewNotifier(urls []string, secret string) *Notifier {
+	return &Notifier{
+		URLs:        urls,
+		Secret:      secret,
+		Client:      &http.Client{Timeout: defaultTimeout},
+		MaxAttempts: defaultMaxAttempts,
+		Backoff:     defaultBackoff,
+		Concurrency: defaultConcurrency,
+		events:      make(chan *Event, defaultQueueSize),
+	}
+}
+
+// Notify queues an event for a change of digest without blocking, so it can
+// be given to store.Codebooks.OnDigestChange. If the queue is full the event
+// goes straight to the dead-letter log.
+func (n *Notifier) Notify(ctx context.Context, diff *cantabular.CodebookDiff) {
+	event, err := NewEvent(diff)
+	if err != nil {
+		log.Event(ctx, "failed to create webhook event", log.ERROR, log.Error(err), log.Data{"dataset": diff.Dataset})
+		return
+	}
+
+	select {
+	case n.events <- event:
+	default:
+		for _, url := range n.URLs {
+			n.deadLetter(ctx, url, event, 0, errQueueFull)
+		}
+	}
+}
+
+// Run delivers queued events until the context is cancelled. Deliveries
+// still being retried then, and events still queued, are abandoned to the
+// dead-letter log.
+func (n *Notifier) Run(ctx context.Context) {
+	concurrency := n.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		// a stopped notifier delivers nothing more, even with events ready
+		if ctx.Err() != nil {
+			n.drain(ctx)
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			n.drain(ctx)
+			return
+		case event := <-n.events:
+			body, err := json.Marshal(event)
+			if err != nil {
+				log.Event(ctx, "failed to encode webhook event", log.ERROR, log.Error(err), log.Data{"event": event.ID})
+				continue
+			}
+
+			for i, url := range n.URLs {
+				select {
+				case <-ctx.Done():
+					for _, url := range n.URLs[i:] {
+						n.deadLetter(ctx, url, event, 0, errStopped)
+					}
+					n.drain(ctx)
+					return
+				case sem <- struct{}{}:
+				}
+
+				wg.Add(1)
+				go func(url string) {
+					defer func() {
+						<-sem
+						wg.Done()
+					}()
+
+					n.deliver(ctx, url, event, body)
+				}(url)
+			}
+		}
+	}
+}
+
+// drain abandons the events left in the queue to the dead-letter log
+func (n *Notifier) drain(ctx context.Context) {
+	for {
+		select {
+		case event := <-n.events:
+			for _, url := range n.URLs {
+				n.deadLetter(ctx, url, event, 0, errStopped)
+			}
+		default:
+			return
+		}
+	}
+}
+
+// deliver POSTs an event to a URL until it is accepted, it is rejected
+// outright or the attempts run out
+func (n *Notifier) deliver(ctx context.Context, url string, event *Event, body []byte) {
+	backoff := n.Backoff
+
+	attempt := 0
+	for {
+		attempt++
+		data := log.Data{"url": url, "event": event.ID, "dataset": event.Dataset, "attempt": attempt}
+
+		retry, err := n.post(ctx, url, event, body)
+		if err == nil {
+			log.Event(ctx, "webhook delivered", log.INFO, data)
+			return
+		}
+
+		if !retry || attempt >= n.MaxAttempts {
+			n.deadLetter(ctx, url, event, attempt, err)
+			return
+		}
+
+		data["backoff"] = backoff.String()
+		log.Event(ctx, "webhook delivery failed, retrying", log.WARN, log.Error(err), data)
+
+		select {
+		case <-ctx.Done():
+			n.deadLetter(ctx, url, event, attempt, err)
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// post makes one delivery, returning whether a failure is worth retrying
+func (n *Notifier) post(ctx context.Context, url string, event *Event, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventTypeHeader, event.Type)
+	req.Header.Set(EventIDHeader, event.ID)
+	req.Header.Set(SignatureHeader, Sign(n.Secret, body))
+
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+
+	retry := resp.StatusCode >= http.StatusInternalServerError ||
+		resp.StatusCode == http.StatusRequestTimeout ||
+		resp.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("webhook responded with %d", resp.StatusCode)
+}
+
+// deadLetter logs a delivery given up on, appending it to the dead-letter log
+func (n *Notifier) deadLetter(ctx context.Context, url string, event *Event, attempts int, cause error) {
+	log.Event(ctx, "webhook delivery abandoned", log.ERROR, log.Error(cause), log.Data{
+		"url":      url,
+		"event":    event.ID,
+		"dataset":  event.Dataset,
+		"attempts": attempts,
+	})
+
+	if n.DeadLetters == "" {
+		return
+	}
+
+	b, err := json.Marshal(&DeadLetter{
+		URL:      url,
+		Event:    event,
+		Attempts: attempts,
+		Error:    cause.Error(),
+		Failed:   time.Now().UTC(),
+	})
+	if err != nil {
+		log.Event(ctx, "failed to encode dead letter", log.ERROR, log.Error(err), log.Data{"event": event.ID})
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	f, err := os.OpenFile(n.DeadLetters, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Event(ctx, "failed to open dead-letter log", log.ERROR, log.Error(err), log.Data{"path": n.DeadLetters})
+		return
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		log.Event(ctx, "failed to write dead letter", log.ERROR, log.Error(err), log.Data{"path": n.DeadLetters})
+	}
+}
@@ -0,0 +1,241 @@
+package webhooks_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/cantabular"
+	"github.com/ONSdigital/dp-census-alpha-api-proxy/webhooks"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const testSecret = "test-secret"
+
+// receiver is a webhook endpoint answering each delivery with the next of
+// its statuses, then 200
+type receiver struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	statuses []int
+	times    []time.Time
+	verified []bool
+}
+
+func newReceiver(statuses ...int) *receiver {
+	rc := &receiver{statuses: statuses}
+	rc.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		rc.mu.Lock()
+		defer rc.mu.Unlock()
+
+		rc.times = append(rc.times, time.Now())
+		rc.verified = append(rc.verified, webhooks.Verify(testSecret, body, r.Header.Get(webhooks.SignatureHeader)))
+
+		status := http.StatusOK
+		if len(rc.statuses) > 0 {
+			status, rc.statuses = rc.statuses[0], rc.statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	return rc
+}
+
+func (rc *receiver) deliveries() ([]time.Time, []bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return append([]time.Time{}, rc.times...), append([]bool{}, rc.verified...)
+}
+
+// waitForDeliveries polls until the receiver has had n deliveries
+func (rc *receiver) waitForDeliveries(n int) []time.Time {
+	for i := 0; i < 200; i++ {
+		if times, _ := rc.deliveries(); len(times) >= n {
+			return times
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	times, _ := rc.deliveries()
+	So(times, ShouldHaveLength, n)
+	return times
+}
+
+// readDeadLetters waits for n lines of the dead-letter log and decodes them
+func readDeadLetters(path string, n int) []webhooks.DeadLetter {
+	var letters []webhooks.DeadLetter
+	for i := 0; i < 200; i++ {
+		letters = nil
+		if f, err := os.Open(path); err == nil {
+			scanner := bufio.NewScanner(f)
+			for scanner.Scan() {
+				var letter webhooks.DeadLetter
+				So(json.Unmarshal(scanner.Bytes(), &letter), ShouldBeNil)
+				letters = append(letters, letter)
+			}
+			f.Close()
+		}
+		if len(letters) >= n {
+			return letters
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	So(letters, ShouldHaveLength, n)
+	return letters
+}
+
+func diff() *cantabular.CodebookDiff {
+	return &cantabular.CodebookDiff{Dataset: "Example", From: "example-digest-1", To: "example-digest-2"}
+}
+
+func TestNotifier(t *testing.T) {
+	Convey("Given a notifier with a dead-letter log", t, func() {
+		dir, err := ioutil.TempDir("", "webhooks")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		newNotifier := func(url string) *webhooks.Notifier {
+			n := webhooks.NewNotifier([]string{url}, testSecret)
+			n.MaxAttempts = 3
+			n.Backoff = 20 * time.Millisecond
+			n.DeadLetters = filepath.Join(dir, "dead-letters.log")
+			return n
+		}
+
+		Convey("When a delivery fails with server errors before it is accepted", func() {
+			rc := newReceiver(http.StatusInternalServerError, http.StatusServiceUnavailable)
+			defer rc.Close()
+
+			n := newNotifier(rc.URL)
+			go n.Run(ctx)
+			n.Notify(ctx, diff())
+
+			times := rc.waitForDeliveries(3)
+
+			Convey("Then it is retried after a backoff that doubles each time", func() {
+				So(times[1].Sub(times[0]), ShouldBeGreaterThanOrEqualTo, 20*time.Millisecond)
+				So(times[2].Sub(times[1]), ShouldBeGreaterThanOrEqualTo, 40*time.Millisecond)
+
+				_, verified := rc.deliveries()
+				So(verified, ShouldResemble, []bool{true, true, true})
+			})
+
+			Convey("Then nothing is dead-lettered", func() {
+				time.Sleep(20 * time.Millisecond)
+				_, err := os.Stat(n.DeadLetters)
+				So(os.IsNotExist(err), ShouldBeTrue)
+			})
+		})
+
+		Convey("When a delivery fails every attempt", func() {
+			rc := newReceiver(http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway)
+			defer rc.Close()
+
+			n := newNotifier(rc.URL)
+			go n.Run(ctx)
+			n.Notify(ctx, diff())
+
+			letters := readDeadLetters(n.DeadLetters, 1)
+
+			Convey("Then it is dead-lettered after the last attempt", func() {
+				So(rc.waitForDeliveries(3), ShouldHaveLength, 3)
+				So(letters[0].URL, ShouldEqual, rc.URL)
+				So(letters[0].Attempts, ShouldEqual, 3)
+				So(letters[0].Error, ShouldEqual, "webhook responded with 502")
+				So(letters[0].Event.Dataset, ShouldEqual, "Example")
+				So(letters[0].Event.NewDigest, ShouldEqual, "example-digest-2")
+			})
+		})
+
+		Convey("When a delivery is rejected with a client error", func() {
+			rc := newReceiver(http.StatusNotFound)
+			defer rc.Close()
+
+			n := newNotifier(rc.URL)
+			go n.Run(ctx)
+			n.Notify(ctx, diff())
+
+			letters := readDeadLetters(n.DeadLetters, 1)
+
+			Convey("Then it is dead-lettered without a retry", func() {
+				So(letters[0].Attempts, ShouldEqual, 1)
+				So(letters[0].Error, ShouldEqual, "webhook responded with 404")
+
+				time.Sleep(50 * time.Millisecond)
+				times, _ := rc.deliveries()
+				So(times, ShouldHaveLength, 1)
+			})
+		})
+
+		Convey("When a delivery is rate limited", func() {
+			rc := newReceiver(http.StatusTooManyRequests)
+			defer rc.Close()
+
+			n := newNotifier(rc.URL)
+			go n.Run(ctx)
+			n.Notify(ctx, diff())
+
+			Convey("Then it is retried", func() {
+				So(rc.waitForDeliveries(2), ShouldHaveLength, 2)
+			})
+		})
+
+		Convey("When the notifier stops with events still queued", func() {
+			rc := newReceiver()
+			defer rc.Close()
+
+			n := newNotifier(rc.URL)
+			for i := 0; i < 3; i++ {
+				n.Notify(ctx, diff())
+			}
+
+			stopped, stop := context.WithCancel(ctx)
+			stop()
+			n.Run(stopped)
+
+			Convey("Then each is dead-lettered without an attempt", func() {
+				letters := readDeadLetters(n.DeadLetters, 3)
+				So(letters, ShouldHaveLength, 3)
+				for _, letter := range letters {
+					So(letter.URL, ShouldEqual, rc.URL)
+					So(letter.Attempts, ShouldEqual, 0)
+					So(letter.Error, ShouldEqual, "webhook notifier stopped before delivery")
+				}
+
+				times, _ := rc.deliveries()
+				So(times, ShouldBeEmpty)
+			})
+		})
+
+		Convey("When more events are notified than can be queued", func() {
+			rc := newReceiver()
+			defer rc.Close()
+
+			n := newNotifier(rc.URL)
+			for i := 0; i < 101; i++ {
+				n.Notify(ctx, diff())
+			}
+
+			Convey("Then those that do not fit are dead-lettered without an attempt", func() {
+				letters := readDeadLetters(n.DeadLetters, 1)
+				So(letters, ShouldHaveLength, 1)
+				So(letters[0].Attempts, ShouldEqual, 0)
+				So(letters[0].Error, ShouldEqual, "webhook queue is full")
+			})
+		})
+	})
+}